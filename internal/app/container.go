@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/controllers"
+	"go-postgres-api/internal/database"
+	"go-postgres-api/internal/health"
+	"go-postgres-api/internal/middleware"
+	"go-postgres-api/internal/ratelimit"
+	"go-postgres-api/internal/repositories"
+	"go-postgres-api/internal/services"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Container holds the application's dependencies. It is built once in main
+// and wires every component through its constructor, so nothing reaches for
+// package globals or the environment on its own.
+type Container struct {
+	Config   *config.Config
+	DB       *gorm.DB
+	Migrator *database.Migrator
+
+	// Repositories
+	UserRepository *repositories.UserRepository
+
+	// Services
+	EmailService    *services.EmailService
+	AuthService     *services.AuthService
+	WebAuthnService *services.WebAuthnService
+
+	// Controllers
+	AuthController      *controllers.AuthController
+	PasskeyController   *controllers.PasskeyController
+	HealthController    *controllers.HealthController
+	CSPReportController *controllers.CSPReportController
+
+	// RateLimiter enforces the per-route rate limits; nil when disabled
+	RateLimiter *ratelimit.Limiter
+
+	// Background workers; nil when disabled
+	TokenCleanupService *services.TokenCleanupService
+
+	// Health checks behind /livez and /readyz
+	Liveness  *health.Registry
+	Readiness *health.Registry
+
+	workers     sync.WaitGroup
+	stopWorkers context.CancelFunc
+}
+
+// NewContainer builds the application's dependencies from the configuration and database connection
+func NewContainer(cfg *config.Config, db *gorm.DB) (*Container, error) {
+	c := &Container{
+		Config: cfg,
+		DB:     db,
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if c.Migrator, err = database.NewMigrator(sqlDB, cfg.DBDriver); err != nil {
+		return nil, err
+	}
+
+	// Repositories
+	c.UserRepository = repositories.NewUserRepository(db)
+
+	// Services
+	c.EmailService = services.NewEmailService(cfg)
+	passwordHasher, err := services.NewPasswordHasher(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.AuthService = services.NewAuthService(cfg, c.UserRepository, c.EmailService, services.NewPasswordPolicy(cfg), passwordHasher)
+
+	webAuthnService, err := services.NewWebAuthnService(cfg, c.UserRepository, c.AuthService)
+	if err != nil {
+		return nil, err
+	}
+	c.WebAuthnService = webAuthnService
+
+	// Rate limiting
+	if cfg.RateLimitEnabled {
+		var store ratelimit.Store = ratelimit.NewMemoryStore()
+		if cfg.RateLimitStore == config.RateLimitStoreDatabase {
+			store = ratelimit.NewSQLStore(db)
+		}
+		c.RateLimiter = ratelimit.New(store, cfg.RateLimits)
+	}
+
+	// Background workers
+	if cfg.TokenCleanupInterval > 0 {
+		c.TokenCleanupService = services.NewTokenCleanupService(c.UserRepository, cfg.TokenCleanupInterval)
+	}
+
+	// Health checks. Liveness only covers this process, so a database outage
+	// takes the instance out of rotation rather than restarting it.
+	c.Liveness = health.NewRegistry(cfg.HealthCheckTimeout, cfg.HealthCacheTTL)
+	if c.TokenCleanupService != nil {
+		c.Liveness.Register("token_cleanup_worker", health.HeartbeatCheck(c.TokenCleanupService.Heartbeat, 2*c.TokenCleanupService.Interval()), true)
+	}
+
+	c.Readiness = health.NewRegistry(cfg.HealthCheckTimeout, cfg.HealthCacheTTL)
+	c.Readiness.Register("database", health.DatabaseCheck(sqlDB), true)
+	c.Readiness.Register("migrations", health.MigrationCheck(c.Migrator), true)
+	if addr := c.EmailService.SMTPAddress(); addr != "" {
+		// Emails failing doesn't stop logins, so the mailer only degrades readiness
+		c.Readiness.Register("mailer", health.TCPCheck(addr), false)
+	}
+
+	// Controllers
+	authCookies := middleware.NewAuthCookies(cfg)
+	c.AuthController = controllers.NewAuthController(c.AuthService, authCookies)
+	c.PasskeyController = controllers.NewPasskeyController(c.WebAuthnService, authCookies)
+	c.HealthController = controllers.NewHealthController(c.Liveness, c.Readiness)
+	c.CSPReportController = controllers.NewCSPReportController()
+
+	return c, nil
+}
@@ -0,0 +1,47 @@
+package app
+
+import "context"
+
+// StartWorkers starts the background workers. They run until Shutdown.
+func (c *Container) StartWorkers() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.stopWorkers = cancel
+
+	if c.TokenCleanupService != nil {
+		c.workers.Add(1)
+		go func() {
+			defer c.workers.Done()
+			c.TokenCleanupService.Run(ctx)
+		}()
+	}
+}
+
+// Shutdown stops the background workers, waiting for them until ctx is
+// done, and then closes the database pool
+func (c *Container) Shutdown(ctx context.Context) error {
+	if c.stopWorkers != nil {
+		c.stopWorkers()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		c.workers.Wait()
+		close(stopped)
+	}()
+
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	sqlDB, dbErr := c.DB.DB()
+	if dbErr != nil {
+		return dbErr
+	}
+	if dbErr := sqlDB.Close(); dbErr != nil {
+		return dbErr
+	}
+	return err
+}
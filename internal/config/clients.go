@@ -0,0 +1,191 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// DefaultClientID is the client of logins that don't name one. It is
+// registered with the global token settings unless the config file defines it.
+const DefaultClientID = "default"
+
+// Grant types a client may be allowed to use
+const (
+	GrantPassword     = "password"
+	GrantPasskey      = "passkey"
+	GrantRefreshToken = "refresh_token"
+)
+
+// Refresh token rotation policies
+const (
+	RotationRotate = "rotate" // every refresh retires the refresh token and issues a new one
+	RotationReuse  = "reuse"  // a refresh token stays valid until it expires
+)
+
+// How a client receives its tokens
+const (
+	TokenDeliveryBody   = "body"   // in the JSON response, for clients that store them themselves
+	TokenDeliveryCookie = "cookie" // in HttpOnly cookies, for browser apps
+)
+
+// grantTypes lists every grant type; clients that don't list theirs may use all of them
+var grantTypes = []string{GrantPassword, GrantPasskey, GrantRefreshToken}
+
+// clientIDPattern limits client IDs to what fits the refresh_tokens.client_id column
+var clientIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Client is a registered client application with its own token policy
+type Client struct {
+	ID              string
+	Name            string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	GrantTypes      []string
+	RefreshRotation string // rotate or reuse
+	TokenDelivery   string // body or cookie
+}
+
+// Allows reports whether the client may use the grant type
+func (c Client) Allows(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+// parseClients reads the clients list of the config file
+func parseClients(value any) ([]Client, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, errors.New("clients must be a list")
+	}
+
+	var errs []error
+	clients := make([]Client, 0, len(items))
+	for i, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("clients[%d] must be a table of settings", i))
+			continue
+		}
+
+		var client Client
+		for key, value := range fields {
+			name := fmt.Sprintf("clients[%d].%s", i, key)
+			var err error
+			switch key {
+			case "id":
+				client.ID = fmt.Sprint(value)
+			case "name":
+				client.Name = fmt.Sprint(value)
+			case "access_token_ttl":
+				client.AccessTokenTTL, err = parseDuration(name, fmt.Sprint(value))
+			case "refresh_token_ttl":
+				client.RefreshTokenTTL, err = parseDuration(name, fmt.Sprint(value))
+			case "grant_types":
+				client.GrantTypes, err = parseStringList(name, value)
+			case "refresh_rotation":
+				client.RefreshRotation = fmt.Sprint(value)
+			case "token_delivery":
+				client.TokenDelivery = fmt.Sprint(value)
+			default:
+				err = fmt.Errorf("unknown setting %q", name)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		clients = append(clients, client)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// parseStringList accepts a list or a comma-separated string
+func parseStringList(key string, value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return splitList(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("invalid %s: must be a list", key)
+}
+
+// applyClientDefaults fills in what clients leave out from the global token
+// settings and registers the default client if the file doesn't
+func applyClientDefaults(c *Config) {
+	for i := range c.Clients {
+		client := &c.Clients[i]
+		if client.AccessTokenTTL == 0 {
+			client.AccessTokenTTL = c.AccessTokenTTL
+		}
+		if client.RefreshTokenTTL == 0 {
+			client.RefreshTokenTTL = c.RefreshTokenTTL
+		}
+		if client.GrantTypes == nil {
+			client.GrantTypes = slices.Clone(grantTypes)
+		}
+		if client.RefreshRotation == "" {
+			client.RefreshRotation = RotationRotate
+		}
+		if client.TokenDelivery == "" {
+			client.TokenDelivery = TokenDeliveryBody
+		}
+	}
+
+	if !slices.ContainsFunc(c.Clients, func(client Client) bool { return client.ID == DefaultClientID }) {
+		c.Clients = append(c.Clients, Client{
+			ID:              DefaultClientID,
+			AccessTokenTTL:  c.AccessTokenTTL,
+			RefreshTokenTTL: c.RefreshTokenTTL,
+			GrantTypes:      slices.Clone(grantTypes),
+			RefreshRotation: RotationRotate,
+			TokenDelivery:   TokenDeliveryBody,
+		})
+	}
+}
+
+// validateClients checks the registered clients
+func (c *Config) validateClients() []error {
+	var errs []error
+	seen := make(map[string]bool, len(c.Clients))
+	for _, client := range c.Clients {
+		if !clientIDPattern.MatchString(client.ID) {
+			errs = append(errs, fmt.Errorf("invalid client id %q: must be 1 to 64 letters, digits, '.', '_' or '-'", client.ID))
+			continue
+		}
+		if seen[client.ID] {
+			errs = append(errs, fmt.Errorf("client %q is registered twice", client.ID))
+		}
+		seen[client.ID] = true
+
+		if client.AccessTokenTTL <= 0 {
+			errs = append(errs, fmt.Errorf("client %q: access_token_ttl must be positive", client.ID))
+		}
+		if client.RefreshTokenTTL <= client.AccessTokenTTL {
+			errs = append(errs, fmt.Errorf("client %q: refresh_token_ttl must be longer than access_token_ttl", client.ID))
+		}
+		for _, grantType := range client.GrantTypes {
+			if !slices.Contains(grantTypes, grantType) {
+				errs = append(errs, fmt.Errorf("client %q: invalid grant type %q: must be one of %v", client.ID, grantType, grantTypes))
+			}
+		}
+		if client.RefreshRotation != RotationRotate && client.RefreshRotation != RotationReuse {
+			errs = append(errs, fmt.Errorf("client %q: invalid refresh_rotation %q: must be one of %v", client.ID, client.RefreshRotation,
+				[]string{RotationRotate, RotationReuse}))
+		}
+		if client.TokenDelivery != TokenDeliveryBody && client.TokenDelivery != TokenDeliveryCookie {
+			errs = append(errs, fmt.Errorf("client %q: invalid token_delivery %q: must be one of %v", client.ID, client.TokenDelivery,
+				[]string{TokenDeliveryBody, TokenDeliveryCookie}))
+		}
+	}
+	return errs
+}
@@ -1,22 +1,155 @@
 package config
 
 import (
-	"os"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// devJWTSecret signs tokens when JWT_SECRET isn't set; for development only
+const devJWTSecret = "your-fallback-secret-key"
+
+// Environments
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
 )
 
 // Config holds all configuration for the application
 type Config struct {
+	// Environment is development or production. Production refuses to start
+	// with missing or weak secrets.
+	Environment string
+
+	// Logging; LogLevel is debug, info, warn or error and LogFormat json or text
+	LogLevel  string
+	LogFormat string
+
+	// Tracing; TracingExporter is none, otlp, stdout or file. An empty
+	// TracingOTLPEndpoint leaves it to OTEL_EXPORTER_OTLP_ENDPOINT, which
+	// defaults to http://localhost:4318.
+	TracingExporter     string
+	TracingOTLPEndpoint string
+	TracingFile         string
+	TracingSampleRatio  float64 // fraction of traces sampled unless the caller decided
+	TracingServiceName  string
+
 	// Database Configuration
+	DBDriver   string // postgres, mysql or sqlite
 	DBHost     string
 	DBPort     string
 	DBUser     string
 	DBPassword string
-	DBName     string
+	DBName     string // database name, or the file path for sqlite
+
+	// DBSSLMode is one of disable, prefer, require, verify-ca or verify-full
+	DBSSLMode     string
+	DBSSLRootCert string
+
+	// AutoMigrate applies pending migrations when the server starts
+	AutoMigrate bool
+
+	// SlowQueryThreshold is the duration above which queries are logged as
+	// slow; zero disables it
+	SlowQueryThreshold time.Duration
 
 	// Server Configuration
 	ServerHost string
 	ServerPort string
 
+	// PublicBaseURL is the address clients reach the API at, such as
+	// https://api.example.com; the links in emails are built on it
+	PublicBaseURL string
+
+	// Request deadlines per route group; zero disables the deadline.
+	// The auth and users groups default to RequestTimeout.
+	RequestTimeout      time.Duration
+	AuthRequestTimeout  time.Duration
+	UsersRequestTimeout time.Duration
+
+	// HTTP server limits; zero disables a timeout
+	ServerReadTimeout       time.Duration
+	ServerReadHeaderTimeout time.Duration
+	ServerWriteTimeout      time.Duration
+	ServerIdleTimeout       time.Duration
+	ServerMaxHeaderBytes    int
+
+	// ShutdownDrainPeriod is how long the server keeps serving with readiness
+	// failing before it stops accepting connections; ShutdownTimeout bounds
+	// how long in-flight requests and workers get to finish afterwards.
+	ShutdownDrainPeriod time.Duration
+	ShutdownTimeout     time.Duration
+
+	// TrustedProxies are the addresses or CIDRs of the proxies whose
+	// X-Forwarded-For and X-Real-IP headers are believed; without any the
+	// client IP is the connection's remote address
+	TrustedProxies []string
+
+	// Rate limiting; RateLimitStore is memory or database. RateLimits are the
+	// per-route policies, which can only be set in the config file; without
+	// them DefaultRateLimits apply.
+	RateLimitEnabled bool
+	RateLimitStore   string
+	RateLimits       []RateLimit
+
+	// CORS policy of cross-origin browser requests. CORSAllowedOrigins
+	// defaults to * in development and to none in production. CORSOverrides
+	// replace it for the routes under a path prefix and can only be set in
+	// the config file.
+	CORSAllowedOrigins   []string
+	CORSAllowCredentials bool
+	CORSAllowedHeaders   []string
+	CORSExposedHeaders   []string
+	CORSMaxAge           time.Duration
+	CORSOverrides        []CORSOverride
+
+	// Security headers of every response. HSTSMaxAge zero disables HSTS.
+	// CSPReporting points the policy at the /csp-reports endpoint.
+	// SecurityHeadersOverrides replace the policies for the routes under a
+	// path prefix and can only be set in the config file.
+	SecurityHeadersEnabled   bool
+	HSTSMaxAge               time.Duration
+	HSTSIncludeSubdomains    bool
+	HSTSPreload              bool
+	ContentSecurityPolicy    string
+	CSPFrameAncestors        string
+	CSPReportOnly            bool
+	CSPReporting             bool
+	ReferrerPolicy           string
+	PermissionsPolicy        string
+	SecurityHeadersOverrides []SecurityHeadersOverride
+
+	// TLS Configuration; the server speaks HTTPS and HTTP/2 when a certificate
+	// and key are set. Both files are reloaded when they change on disk.
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSMinVersion     string   // 1.2 or 1.3
+	TLSCipherSuites   []string // TLS 1.2 cipher suite names; empty uses Go's defaults
+	TLSClientCAFile   string   // CA bundle for verifying client certificates (mTLS)
+	TLSClientAuth     string   // none, request, require, verify-if-given or require-and-verify
+	TLSReloadInterval time.Duration
+
+	// HTTPRedirectPort, when set with TLS enabled, serves plain HTTP on this
+	// port that redirects every request to HTTPS
+	HTTPRedirectPort string
+
+	// /metrics is served unless MetricsEnabled is off: on its own listener at
+	// MetricsAddress when set, else on the API listener. With MetricsToken,
+	// scrapers must send it as a bearer token.
+	MetricsEnabled bool
+	MetricsAddress string
+	MetricsToken   string
+
+	// HealthCheckTimeout bounds each dependency check of the health probes,
+	// whose reports are reused for HealthCacheTTL
+	HealthCheckTimeout time.Duration
+	HealthCacheTTL     time.Duration
+
+	// TokenCleanupInterval is how often expired tokens are deleted; zero disables the worker
+	TokenCleanupInterval time.Duration
+
 	// OAuth Configuration
 	Auth0Domain       string
 	Auth0ClientID     string
@@ -25,44 +158,196 @@ type Config struct {
 
 	// JWT Configuration
 	JWTSecret string
+
+	// Token lifetimes
+	AccessTokenTTL            time.Duration
+	RefreshTokenTTL           time.Duration
+	EmailVerificationTokenTTL time.Duration
+	EmailChangeTokenTTL       time.Duration
+
+	// BlacklistCacheTTL is how long a token found not to be blacklisted is
+	// trusted without asking the database again; zero disables caching it
+	BlacklistCacheTTL time.Duration
+
+	// Clients are the registered client applications, each with its own
+	// token lifetimes, grant types and refresh rotation. They can only be
+	// set in the config file; the default client is always registered.
+	Clients []Client
+
+	// Cookies of the clients with cookie token delivery. AuthCookieSameSite
+	// is strict, lax or none; none needs AuthCookieSecure.
+	AuthCookieDomain   string
+	AuthCookieSecure   bool
+	AuthCookieSameSite string
+
+	// SMTP Configuration; emails are printed to the console when unset
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	FromEmail    string
+
+	// WebAuthn (passkey) Configuration
+	WebAuthnRPID          string
+	WebAuthnRPDisplayName string
+	WebAuthnRPOrigins     []string
+
+	// Password policy
+	PasswordMinLength          int
+	PasswordMaxLength          int // bytes; at most 72 with bcrypt, which ignores anything longer
+	PasswordRequireLowercase   bool
+	PasswordRequireUppercase   bool
+	PasswordRequireDigit       bool
+	PasswordRequireSymbol      bool
+	PasswordRejectPersonalInfo bool
+	BreachedPasswordsPath      string
+
+	// Password hashing
+	PasswordHashAlgorithm string // argon2id or bcrypt
+	Argon2MemoryKB        int
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	BcryptCost            int
 }
 
-// LoadConfig loads configuration from environment variables
-func LoadConfig() (*Config, error) {
-	config := &Config{
-		// Database
-		DBHost:     os.Getenv("DB_HOST"),
-		DBPort:     os.Getenv("DB_PORT"),
-		DBUser:     os.Getenv("DB_USER"),
-		DBPassword: os.Getenv("DB_PASSWORD"),
-		DBName:     os.Getenv("DB_NAME"),
+// LoadConfig loads the configuration from, in increasing order of precedence,
+// the defaults, the config file named by --config or CONFIG_FILE, environment
+// variables and command-line flags in args, and then validates it
+func LoadConfig(args []string) (*Config, error) {
+	config := &Config{}
+	provided, err := load(config, args)
+	if err != nil {
+		return nil, err
+	}
 
-		// Server
-		ServerHost: os.Getenv("HOST"),
-		ServerPort: os.Getenv("PORT"),
+	applyDerivedDefaults(config, provided)
+	applyClientDefaults(config)
+	applyRateLimitDefaults(config)
+	applyCORSDefaults(config)
+	applySecurityHeadersDefaults(config)
 
-		// OAuth
-		Auth0Domain:       os.Getenv("AUTH0_DOMAIN"),
-		Auth0ClientID:     os.Getenv("AUTH0_CLIENT_ID"),
-		Auth0ClientSecret: os.Getenv("AUTH0_CLIENT_SECRET"),
-		Auth0CallbackURL:  os.Getenv("AUTH0_CALLBACK_URL"),
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
-		// JWT
-		JWTSecret: os.Getenv("JWT_SECRET"),
+	if config.JWTSecret == "" {
+		slog.Warn("JWT_SECRET is not set, using an insecure development secret")
+		config.JWTSecret = devJWTSecret
 	}
 
-	// Set default values if not provided
-	if config.ServerPort == "" {
-		config.ServerPort = "8080"
+	return config, nil
+}
+
+// applyDerivedDefaults fills in settings whose default depends on other
+// settings, unless a source provided them
+func applyDerivedDefaults(c *Config, provided map[string]bool) {
+	if !provided["DB_PORT"] {
+		switch c.DBDriver {
+		case "postgres":
+			c.DBPort = "5432"
+		case "mysql":
+			c.DBPort = "3306"
+		}
 	}
 
-	if config.DBHost == "" {
-		config.DBHost = "localhost"
+	if !provided["DB_SSLMODE"] {
+		c.DBSSLMode = "disable"
+		if c.DBDriver == "postgres" {
+			c.DBSSLMode = "prefer"
+		}
 	}
 
-	if config.DBPort == "" {
-		config.DBPort = "3306"
+	// bcrypt ignores anything past 72 bytes; argon2id takes long passphrases
+	if !provided["PASSWORD_MAX_LENGTH"] {
+		c.PasswordMaxLength = 72
+		if c.PasswordHashAlgorithm == "argon2id" {
+			c.PasswordMaxLength = 256
+		}
 	}
 
-	return config, nil
+	if !provided["AUTH_REQUEST_TIMEOUT"] {
+		c.AuthRequestTimeout = c.RequestTimeout
+	}
+	if !provided["USERS_REQUEST_TIMEOUT"] {
+		c.UsersRequestTimeout = c.RequestTimeout
+	}
+
+	if !provided["TLS_CLIENT_AUTH"] {
+		c.TLSClientAuth = "none"
+		if c.TLSClientCAFile != "" {
+			c.TLSClientAuth = "require-and-verify"
+		}
+	}
+
+	if !provided["CORS_ALLOWED_ORIGINS"] && !c.IsProduction() {
+		c.CORSAllowedOrigins = []string{CORSAllowAllOrigins}
+	}
+
+	scheme := "http"
+	if c.TLSEnabled() {
+		scheme = "https"
+	}
+	if !provided["PUBLIC_BASE_URL"] {
+		c.PublicBaseURL = scheme + "://localhost:" + c.ServerPort
+	}
+	if !provided["WEBAUTHN_RP_ORIGINS"] {
+		c.WebAuthnRPOrigins = []string{scheme + "://localhost:" + c.ServerPort}
+	}
+}
+
+// IsProduction reports whether the application runs in production
+func (c *Config) IsProduction() bool {
+	return c.Environment == EnvProduction
+}
+
+// TLSEnabled reports whether the server terminates TLS itself
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// parseDuration parses a duration such as "5s", rejecting negative values
+func parseDuration(key, value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a duration such as 5s", key, value)
+	}
+	return duration, nil
+}
+
+// parseInt parses a non-negative integer
+func parseInt(key, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", key, value)
+	}
+	return n, nil
+}
+
+// parseFloat parses a non-negative number
+func parseFloat(key, value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative number", key, value)
+	}
+	return f, nil
+}
+
+// parseBool parses true/false, 1/0 and the other forms strconv accepts
+func parseBool(key, value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be true or false", key, value)
+	}
+	return b, nil
+}
+
+// splitList splits a comma-separated value into trimmed, non-empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
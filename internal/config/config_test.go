@@ -0,0 +1,353 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicBaseURL(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"default", nil, "http://localhost:8080"},
+		{"default follows the port", []string{"--server-port", "9000"}, "http://localhost:9000"},
+		{"set", []string{"--server-public-base-url", "https://api.example.com/auth"}, "https://api.example.com/auth"},
+	}
+	for _, tt := range tests {
+		cfg, err := LoadConfig(tt.args)
+		if err != nil {
+			t.Fatalf("%s: LoadConfig: %v", tt.name, err)
+		}
+		if cfg.PublicBaseURL != tt.want {
+			t.Errorf("%s: PublicBaseURL = %q, want %q", tt.name, cfg.PublicBaseURL, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"api.example.com", "ftp://api.example.com", "https://", "https://api.example.com/?a=b", "https://api.example.com/#top"} {
+		_, err := LoadConfig([]string{"--server-public-base-url", invalid})
+		if err == nil || !strings.Contains(err.Error(), "PUBLIC_BASE_URL") {
+			t.Errorf("LoadConfig(%q) = %v, want a PUBLIC_BASE_URL error", invalid, err)
+		}
+	}
+}
+
+func TestMetricsExposure(t *testing.T) {
+	t.Setenv("JWT_SECRET", "0123456789abcdefghijklmnopqrstuvwxyz")
+	t.Setenv("DB_PASSWORD", "secret")
+	production := []string{"--app-environment", "production"}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{"public in development", nil, false},
+		{"public in production", production, true},
+		{"disabled in production", append(production, "--metrics-enabled", "false"), false},
+		{"own listener in production", append(production, "--metrics-address", "127.0.0.1:9090"), false},
+		{"invalid address", []string{"--metrics-address", "9090"}, true},
+		{"invalid port", []string{"--metrics-address", "127.0.0.1:http-alt"}, true},
+	}
+	for _, tt := range tests {
+		_, err := LoadConfig(tt.args)
+		if gotErr := err != nil && strings.Contains(err.Error(), "METRICS_"); gotErr != tt.wantErr {
+			t.Errorf("%s: LoadConfig = %v, want a METRICS_ error: %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	t.Setenv("METRICS_TOKEN", "scraper-token")
+	if _, err := LoadConfig(production); err != nil {
+		t.Errorf("token in production: LoadConfig = %v", err)
+	}
+}
+
+func TestPasswordMaxLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    int
+		wantErr bool
+	}{
+		{"argon2id default", nil, 256, false},
+		{"bcrypt default", []string{"--password-hashing-algorithm", "bcrypt"}, 72, false},
+		{"argon2id long", []string{"--password-policy-max-length", "1024"}, 1024, false},
+		{"bcrypt too long", []string{"--password-hashing-algorithm", "bcrypt", "--password-policy-max-length", "100"}, 0, true},
+	}
+	for _, tt := range tests {
+		cfg, err := LoadConfig(tt.args)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "PASSWORD_MAX_LENGTH") {
+				t.Errorf("%s: LoadConfig = %v, want a PASSWORD_MAX_LENGTH error", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: LoadConfig: %v", tt.name, err)
+		}
+		if cfg.PasswordMaxLength != tt.want {
+			t.Errorf("%s: PasswordMaxLength = %d, want %d", tt.name, cfg.PasswordMaxLength, tt.want)
+		}
+	}
+}
+
+// writeFile writes content to name in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "log:\n  level: warn\nserver:\n  port: 8081\n  request_timeout: 20s\n")
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		wantLevel   string
+		wantPort    string
+		wantTimeout time.Duration
+	}{
+		{"defaults", nil, nil, "info", "8080", 10 * time.Second},
+		{"file over defaults", nil, []string{"--config", file}, "warn", "8081", 20 * time.Second},
+		{"file from CONFIG_FILE", map[string]string{"CONFIG_FILE": file}, nil, "warn", "8081", 20 * time.Second},
+		{"env over file", map[string]string{"PORT": "8082"}, []string{"--config", file}, "warn", "8082", 20 * time.Second},
+		{"empty env is unset", map[string]string{"PORT": ""}, []string{"--config", file}, "warn", "8081", 20 * time.Second},
+		{"flags over env", map[string]string{"PORT": "8082", "LOG_LEVEL": "debug"}, []string{"--config", file, "--server-port", "8083"}, "debug", "8083", 20 * time.Second},
+		{"flags over defaults", nil, []string{"--server-request-timeout", "5s"}, "info", "8080", 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"CONFIG_FILE", "PORT", "LOG_LEVEL", "REQUEST_TIMEOUT"} {
+				t.Setenv(key, tt.env[key])
+			}
+			cfg, err := LoadConfig(tt.args)
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if cfg.LogLevel != tt.wantLevel || cfg.ServerPort != tt.wantPort || cfg.RequestTimeout != tt.wantTimeout {
+				t.Errorf("LogLevel %q, ServerPort %q, RequestTimeout %s, want %q, %q, %s",
+					cfg.LogLevel, cfg.ServerPort, cfg.RequestTimeout, tt.wantLevel, tt.wantPort, tt.wantTimeout)
+			}
+			// Derived defaults follow the winning value
+			if cfg.AuthRequestTimeout != tt.wantTimeout {
+				t.Errorf("AuthRequestTimeout = %s, want %s", cfg.AuthRequestTimeout, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "server:\n  port: 9000\n  trusted_proxies: [10.0.0.0/8, 192.168.0.1]\nrate_limit:\n  enabled: false\n",
+		},
+		{
+			name:    "yml",
+			file:    "config.yml",
+			content: "server:\n  port: 9000\n  trusted_proxies:\n    - 10.0.0.0/8\n    - 192.168.0.1\nrate_limit:\n  enabled: false\n",
+		},
+		{
+			name:    "toml",
+			file:    "config.toml",
+			content: "[server]\nport = 9000\ntrusted_proxies = [\"10.0.0.0/8\", \"192.168.0.1\"]\n\n[rate_limit]\nenabled = false\n",
+		},
+		{"unknown yaml key", "config.yaml", "server:\n  prot: 9000\n", `unknown setting "server.prot"`},
+		{"unknown toml section", "config.toml", "[sever]\nport = 9000\n", `unknown setting "sever.port"`},
+		{"file suffix on a setting that is not secret", "config.yaml", "server:\n  port_file: /run/port\n", `unknown setting "server.port_file"`},
+		{"invalid value", "config.yaml", "server:\n  request_timeout: soon\n", "REQUEST_TIMEOUT"},
+		{"invalid yaml", "config.yaml", "server: [\n", "failed to parse config file"},
+		{"unsupported format", "config.json", `{"server": {"port": 9000}}`, "unsupported config file format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig([]string{"--config", writeFile(t, tt.file, tt.content)})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if cfg.ServerPort != "9000" || cfg.RateLimitEnabled {
+				t.Errorf("ServerPort %q, RateLimitEnabled %v, want 9000 and false", cfg.ServerPort, cfg.RateLimitEnabled)
+			}
+			if want := []string{"10.0.0.0/8", "192.168.0.1"}; !slices.Equal(cfg.TrustedProxies, want) {
+				t.Errorf("TrustedProxies = %q, want %q", cfg.TrustedProxies, want)
+			}
+		})
+	}
+
+	if _, err := LoadConfig([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Error("LoadConfig with a missing file succeeded")
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	secret := "file-secret-0123456789abcdefghijklmnop"
+	secretFile := writeFile(t, "jwt_secret", secret+"\n")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		file    string
+		want    string
+		wantErr string
+	}{
+		{"env", map[string]string{"JWT_SECRET": "env-secret"}, "", "env-secret", ""},
+		{"env file", map[string]string{"JWT_SECRET_FILE": secretFile}, "", secret, ""},
+		{"config file", nil, "jwt:\n  secret_file: " + secretFile + "\n", secret, ""},
+		{"env over config file", map[string]string{"JWT_SECRET": "env-secret"}, "jwt:\n  secret_file: " + secretFile + "\n", "env-secret", ""},
+		{"both set", map[string]string{"JWT_SECRET": "env-secret", "JWT_SECRET_FILE": secretFile}, "", "", "JWT_SECRET and JWT_SECRET_FILE are both set"},
+		{"missing file", map[string]string{"JWT_SECRET_FILE": filepath.Join(t.TempDir(), "missing")}, "", "", "failed to read JWT_SECRET from file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"CONFIG_FILE", "JWT_SECRET", "JWT_SECRET_FILE"} {
+				t.Setenv(key, tt.env[key])
+			}
+			var args []string
+			if tt.file != "" {
+				args = []string{"--config", writeFile(t, "config.yaml", tt.file)}
+			}
+			cfg, err := LoadConfig(args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if cfg.JWTSecret != tt.want {
+				t.Errorf("JWTSecret = %q, want %q", cfg.JWTSecret, tt.want)
+			}
+		})
+	}
+
+	// Secrets have no flags, which would show in the process list
+	if _, err := LoadConfig([]string{"--jwt-secret", "flag-secret"}); err == nil {
+		t.Error("LoadConfig accepted a secret as a flag")
+	}
+}
+
+func TestProductionJWTSecret(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("JWT_SECRET_FILE", "")
+	t.Setenv("DB_PASSWORD", "secret")
+	t.Setenv("METRICS_TOKEN", "scraper-token")
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr string
+	}{
+		{"missing", "", "JWT_SECRET is required in production"},
+		{"development secret", devJWTSecret, "JWT_SECRET must not be the development secret"},
+		{"short", "too-short", "JWT_SECRET must be at least"},
+		{"one byte short", strings.Repeat("x", minProductionSecretLength-1), "JWT_SECRET must be at least"},
+		{"long enough", strings.Repeat("x", minProductionSecretLength), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_SECRET", tt.secret)
+			cfg, err := LoadConfig([]string{"--app-environment", "production"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadConfig = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if cfg.JWTSecret != tt.secret {
+				t.Errorf("JWTSecret = %q, want the configured secret", cfg.JWTSecret)
+			}
+		})
+	}
+
+	// Outside production a missing secret falls back to the development one
+	t.Setenv("JWT_SECRET", "")
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("development: LoadConfig: %v", err)
+	}
+	if cfg.JWTSecret != devJWTSecret {
+		t.Errorf("development: JWTSecret = %q, want the development secret", cfg.JWTSecret)
+	}
+}
+
+func TestPrintRedacted(t *testing.T) {
+	secrets := map[string]string{
+		"JWT_SECRET":          "jwt-secret-0123456789abcdefghijklmnop",
+		"DB_PASSWORD":         "db-password-value",
+		"METRICS_TOKEN":       "metrics-token-value",
+		"AUTH0_CLIENT_SECRET": "auth0-client-secret-value",
+		"SMTP_PASSWORD":       "smtp-password-value",
+	}
+	t.Setenv("CONFIG_FILE", "")
+	for key, value := range secrets {
+		t.Setenv(key, value)
+		t.Setenv(key+"_FILE", "")
+	}
+	cfg, err := LoadConfig([]string{"--server-port", "9000"})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	var redacted bytes.Buffer
+	if err := Print(&redacted, cfg, true); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range secrets {
+		if strings.Contains(redacted.String(), value) {
+			t.Errorf("redacted output contains %s", key)
+		}
+	}
+	if got := strings.Count(redacted.String(), redactedValue); got != len(secrets) {
+		t.Errorf("redacted output has %d placeholders, want %d", got, len(secrets))
+	}
+
+	var full bytes.Buffer
+	if err := Print(&full, cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range secrets {
+		if !strings.Contains(full.String(), value) {
+			t.Errorf("output without redaction is missing %s", key)
+		}
+	}
+
+	// The output is a config file that loads back to the same settings
+	t.Setenv("JWT_SECRET", "")
+	printed, err := LoadConfig([]string{"--config", writeFile(t, "config.yaml", full.String())})
+	if err != nil {
+		t.Fatalf("LoadConfig of the printed config: %v", err)
+	}
+	if printed.ServerPort != "9000" || printed.JWTSecret != secrets["JWT_SECRET"] {
+		t.Errorf("printed config loads ServerPort %q, JWTSecret %q", printed.ServerPort, printed.JWTSecret)
+	}
+}
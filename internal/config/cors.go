@@ -0,0 +1,189 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CORSAllowAllOrigins allows every origin; browsers refuse it with credentials
+const CORSAllowAllOrigins = "*"
+
+// CORSPolicy is what cross-origin browser requests may do
+type CORSPolicy struct {
+	// AllowedOrigins are origins such as https://app.example.com, patterns
+	// such as https://*.example.com matching any subdomain, or * for all
+	AllowedOrigins   []string
+	AllowCredentials bool
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	MaxAge           time.Duration // how long browsers may cache a preflight response
+}
+
+// CORSOverride replaces the default CORS policy for the routes under PathPrefix
+type CORSOverride struct {
+	PathPrefix string
+	CORSPolicy
+}
+
+// DefaultCORSPolicy returns the policy of the routes no override covers
+func (c *Config) DefaultCORSPolicy() CORSPolicy {
+	return CORSPolicy{
+		AllowedOrigins:   c.CORSAllowedOrigins,
+		AllowCredentials: c.CORSAllowCredentials,
+		AllowedHeaders:   c.CORSAllowedHeaders,
+		ExposedHeaders:   c.CORSExposedHeaders,
+		MaxAge:           c.CORSMaxAge,
+	}
+}
+
+// AllowsAllOrigins reports whether the policy allows every origin
+func (p CORSPolicy) AllowsAllOrigins() bool {
+	return slices.Contains(p.AllowedOrigins, CORSAllowAllOrigins)
+}
+
+// AllowsOrigin reports whether the policy allows the origin of a request.
+// Origins compare case-insensitively and patterns need at least one label
+// in place of the *.
+func (p CORSPolicy) AllowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == CORSAllowAllOrigins || allowed == origin {
+			return true
+		}
+		scheme, domain, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		if subdomain, ok := strings.CutPrefix(origin, scheme+"://"); ok {
+			subdomain, ok = strings.CutSuffix(subdomain, "."+domain)
+			if ok && subdomain != "" && !strings.ContainsAny(subdomain, "/:@") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseCORSOverrides reads the cors_overrides list of the config file
+func parseCORSOverrides(value any) ([]CORSOverride, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, errors.New("cors_overrides must be a list")
+	}
+
+	var errs []error
+	overrides := make([]CORSOverride, 0, len(items))
+	for i, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("cors_overrides[%d] must be a table of settings", i))
+			continue
+		}
+
+		var override CORSOverride
+		for key, value := range fields {
+			name := fmt.Sprintf("cors_overrides[%d].%s", i, key)
+			var err error
+			switch key {
+			case "path_prefix":
+				override.PathPrefix = fmt.Sprint(value)
+			case "allowed_origins":
+				override.AllowedOrigins, err = parseStringList(name, value)
+				if override.AllowedOrigins == nil && err == nil {
+					override.AllowedOrigins = []string{} // set, even if to nothing
+				}
+			case "allow_credentials":
+				override.AllowCredentials, err = parseBool(name, fmt.Sprint(value))
+			case "allowed_headers":
+				override.AllowedHeaders, err = parseStringList(name, value)
+			case "exposed_headers":
+				override.ExposedHeaders, err = parseStringList(name, value)
+			case "max_age":
+				override.MaxAge, err = parseDuration(name, fmt.Sprint(value))
+			default:
+				err = fmt.Errorf("unknown setting %q", name)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		overrides = append(overrides, override)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// applyCORSDefaults fills in the headers and max age that overrides leave
+// out from the default policy. Origins and credentials are never inherited,
+// as an override exists to say whom its routes trust.
+func applyCORSDefaults(c *Config) {
+	for i := range c.CORSOverrides {
+		override := &c.CORSOverrides[i]
+		if override.AllowedHeaders == nil {
+			override.AllowedHeaders = c.CORSAllowedHeaders
+		}
+		if override.ExposedHeaders == nil {
+			override.ExposedHeaders = c.CORSExposedHeaders
+		}
+		if override.MaxAge == 0 {
+			override.MaxAge = c.CORSMaxAge
+		}
+	}
+}
+
+// validateCORS checks the default CORS policy and its overrides
+func (c *Config) validateCORS() []error {
+	errs := validateCORSPolicy("CORS_ALLOWED_ORIGINS", "CORS_ALLOW_CREDENTIALS", c.DefaultCORSPolicy())
+
+	seen := make(map[string]bool, len(c.CORSOverrides))
+	for i, override := range c.CORSOverrides {
+		name := fmt.Sprintf("cors_overrides[%d]", i)
+		if !strings.HasPrefix(override.PathPrefix, "/") {
+			errs = append(errs, fmt.Errorf("%s: path_prefix %q must be a path such as /api/v1/auth", name, override.PathPrefix))
+		}
+		if seen[override.PathPrefix] {
+			errs = append(errs, fmt.Errorf("%s: %s already has a CORS override", name, override.PathPrefix))
+		}
+		seen[override.PathPrefix] = true
+		if override.AllowedOrigins == nil {
+			errs = append(errs, fmt.Errorf("%s: allowed_origins is required", name))
+		}
+		errs = append(errs, validateCORSPolicy(name+".allowed_origins", name+".allow_credentials", override.CORSPolicy)...)
+	}
+	return errs
+}
+
+// validateCORSPolicy checks the origins of a policy, naming them and its
+// credentials setting as given in errors
+func validateCORSPolicy(originsName, credentialsName string, p CORSPolicy) []error {
+	var errs []error
+	for _, origin := range p.AllowedOrigins {
+		if origin != CORSAllowAllOrigins && !isOriginPattern(origin) {
+			errs = append(errs, fmt.Errorf("invalid %s entry %q: must be *, an origin such as https://app.example.com or a pattern such as https://*.example.com",
+				originsName, origin))
+		}
+	}
+	if p.AllowCredentials && p.AllowsAllOrigins() {
+		errs = append(errs, fmt.Errorf("%s requires %s to list origins rather than *", credentialsName, originsName))
+	}
+	return errs
+}
+
+// isOriginPattern reports whether s is an http or https origin, whose host
+// may start with *. to match any subdomain
+func isOriginPattern(s string) bool {
+	s = strings.Replace(s, "://*.", "://wildcard.", 1)
+	if strings.Contains(s, "*") {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Scheme+"://"+u.Host == s
+}
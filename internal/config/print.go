@@ -0,0 +1,150 @@
+package config
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces set secrets in redacted output
+const redactedValue = "[REDACTED]"
+
+// Print writes the effective configuration in the config file format, so the
+// output can be used as a starting config file. With redacted, secrets that
+// are set are replaced by a placeholder.
+func Print(w io.Writer, c *Config, redacted bool) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := make(map[string]*yaml.Node)
+
+	for _, s := range settings(c) {
+		sectionName, key, _ := strings.Cut(s.file, ".")
+		section, ok := sections[sectionName]
+		if !ok {
+			section = &yaml.Node{Kind: yaml.MappingNode}
+			sections[sectionName] = section
+			root.Content = append(root.Content, scalarNode("!!str", sectionName), section)
+		}
+
+		value := valueNode(s.value)
+		if s.secret && redacted && value.Value != "" {
+			value = scalarNode("!!str", redactedValue)
+		}
+		section.Content = append(section.Content, scalarNode("!!str", key), value)
+	}
+
+	clients := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, client := range c.Clients {
+		clients.Content = append(clients.Content, clientNode(client))
+	}
+	root.Content = append(root.Content, scalarNode("!!str", "clients"), clients)
+
+	rateLimits := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, limit := range c.RateLimits {
+		rateLimits.Content = append(rateLimits.Content, rateLimitNode(limit))
+	}
+	root.Content = append(root.Content, scalarNode("!!str", "rate_limits"), rateLimits)
+
+	corsOverrides := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, override := range c.CORSOverrides {
+		corsOverrides.Content = append(corsOverrides.Content, corsOverrideNode(override))
+	}
+	root.Content = append(root.Content, scalarNode("!!str", "cors_overrides"), corsOverrides)
+
+	securityHeadersOverrides := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, override := range c.SecurityHeadersOverrides {
+		securityHeadersOverrides.Content = append(securityHeadersOverrides.Content, securityHeadersOverrideNode(override))
+	}
+	root.Content = append(root.Content, scalarNode("!!str", "security_headers_overrides"), securityHeadersOverrides)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// clientNode renders a registered client the way the config file expects it
+func clientNode(client Client) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, value *yaml.Node) {
+		node.Content = append(node.Content, scalarNode("!!str", key), value)
+	}
+	add("id", valueNode(&client.ID))
+	if client.Name != "" {
+		add("name", valueNode(&client.Name))
+	}
+	add("access_token_ttl", valueNode(&client.AccessTokenTTL))
+	add("refresh_token_ttl", valueNode(&client.RefreshTokenTTL))
+	add("grant_types", valueNode(&client.GrantTypes))
+	add("refresh_rotation", valueNode(&client.RefreshRotation))
+	add("token_delivery", valueNode(&client.TokenDelivery))
+	return node
+}
+
+// rateLimitNode renders a rate limit the way the config file expects it
+func rateLimitNode(limit RateLimit) *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		scalarNode("!!str", "route"), valueNode(&limit.Route),
+		scalarNode("!!str", "key"), valueNode(&limit.Key),
+		scalarNode("!!str", "limit"), valueNode(&limit.Limit),
+		scalarNode("!!str", "period"), valueNode(&limit.Period),
+	}}
+}
+
+// corsOverrideNode renders a CORS override the way the config file expects it
+func corsOverrideNode(override CORSOverride) *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		scalarNode("!!str", "path_prefix"), valueNode(&override.PathPrefix),
+		scalarNode("!!str", "allowed_origins"), valueNode(&override.AllowedOrigins),
+		scalarNode("!!str", "allow_credentials"), valueNode(&override.AllowCredentials),
+		scalarNode("!!str", "allowed_headers"), valueNode(&override.AllowedHeaders),
+		scalarNode("!!str", "exposed_headers"), valueNode(&override.ExposedHeaders),
+		scalarNode("!!str", "max_age"), valueNode(&override.MaxAge),
+	}}
+}
+
+// securityHeadersOverrideNode renders a security headers override the way the config file expects it
+func securityHeadersOverrideNode(override SecurityHeadersOverride) *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		scalarNode("!!str", "path_prefix"), valueNode(&override.PathPrefix),
+		scalarNode("!!str", "content_security_policy"), valueNode(&override.ContentSecurityPolicy),
+		scalarNode("!!str", "frame_ancestors"), valueNode(&override.FrameAncestors),
+		scalarNode("!!str", "referrer_policy"), valueNode(&override.ReferrerPolicy),
+		scalarNode("!!str", "permissions_policy"), valueNode(&override.PermissionsPolicy),
+	}}
+}
+
+// valueNode renders a Config field the way the config file expects it
+func valueNode(value any) *yaml.Node {
+	switch field := value.(type) {
+	case *string:
+		return scalarNode("!!str", *field)
+	case *[]string:
+		list := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, item := range *field {
+			list.Content = append(list.Content, scalarNode("!!str", item))
+		}
+		return list
+	case *bool:
+		return scalarNode("!!bool", strconv.FormatBool(*field))
+	case *int:
+		return scalarNode("!!int", strconv.Itoa(*field))
+	case *float64:
+		value := strconv.FormatFloat(*field, 'f', -1, 64)
+		if !strings.Contains(value, ".") {
+			value += ".0" // 1 would read back as an integer
+		}
+		return scalarNode("!!float", value)
+	case *time.Duration:
+		return scalarNode("!!str", field.String())
+	}
+	return scalarNode("!!null", "")
+}
+
+func scalarNode(tag, value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+}
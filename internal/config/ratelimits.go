@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Rate limit stores
+const (
+	RateLimitStoreMemory   = "memory"   // per instance
+	RateLimitStoreDatabase = "database" // shared by every instance
+)
+
+// What a rate limit counts requests by
+const (
+	RateLimitByIP    = "ip"
+	RateLimitByEmail = "email" // the email field of the JSON body
+	RateLimitByUser  = "user"  // the authenticated user
+)
+
+// rateLimitKeys lists every key a rate limit can count by
+var rateLimitKeys = []string{RateLimitByIP, RateLimitByEmail, RateLimitByUser}
+
+// RateLimit allows Limit requests to a route per Period for each IP, email
+// or user
+type RateLimit struct {
+	Route  string // route template such as /api/v1/auth/login
+	Key    string // ip, email or user
+	Limit  int
+	Period time.Duration
+}
+
+// DefaultRateLimits protect the endpoints that can be used to guess
+// passwords, create accounts in bulk, send email to any address or flood
+// the logs
+func DefaultRateLimits() []RateLimit {
+	return []RateLimit{
+		{Route: "/api/v1/auth/login", Key: RateLimitByIP, Limit: 20, Period: time.Minute},
+		{Route: "/api/v1/auth/login", Key: RateLimitByEmail, Limit: 10, Period: 15 * time.Minute},
+		{Route: "/api/v1/auth/register", Key: RateLimitByIP, Limit: 10, Period: time.Hour},
+		{Route: "/api/v1/auth/resend-verification", Key: RateLimitByIP, Limit: 10, Period: time.Hour},
+		{Route: "/api/v1/auth/resend-verification", Key: RateLimitByEmail, Limit: 3, Period: time.Hour},
+		{Route: "/api/v1/auth/refresh-token", Key: RateLimitByIP, Limit: 60, Period: time.Minute},
+		{Route: "/csp-reports", Key: RateLimitByIP, Limit: 60, Period: time.Minute},
+	}
+}
+
+// parseRateLimits reads the rate_limits list of the config file. An empty
+// list is kept apart from a missing one, as it disables the defaults.
+func parseRateLimits(value any) ([]RateLimit, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, errors.New("rate_limits must be a list")
+	}
+
+	var errs []error
+	limits := make([]RateLimit, 0, len(items))
+	for i, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("rate_limits[%d] must be a table of settings", i))
+			continue
+		}
+
+		var limit RateLimit
+		for key, value := range fields {
+			name := fmt.Sprintf("rate_limits[%d].%s", i, key)
+			var err error
+			switch key {
+			case "route":
+				limit.Route = fmt.Sprint(value)
+			case "key":
+				limit.Key = fmt.Sprint(value)
+			case "limit":
+				limit.Limit, err = parseInt(name, fmt.Sprint(value))
+			case "period":
+				limit.Period, err = parseDuration(name, fmt.Sprint(value))
+			default:
+				err = fmt.Errorf("unknown setting %q", name)
+			}
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		limits = append(limits, limit)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// applyRateLimitDefaults uses the default rate limits if the file sets none
+func applyRateLimitDefaults(c *Config) {
+	if c.RateLimits == nil {
+		c.RateLimits = DefaultRateLimits()
+	}
+}
+
+// validateRateLimits checks the rate limits
+func (c *Config) validateRateLimits() []error {
+	var errs []error
+	type routeKey struct{ route, key string }
+	seen := make(map[routeKey]bool, len(c.RateLimits))
+	for i, limit := range c.RateLimits {
+		name := fmt.Sprintf("rate_limits[%d]", i)
+		if !strings.HasPrefix(limit.Route, "/") {
+			errs = append(errs, fmt.Errorf("%s: route %q must be a path such as /api/v1/auth/login", name, limit.Route))
+		}
+		if !slices.Contains(rateLimitKeys, limit.Key) {
+			errs = append(errs, fmt.Errorf("%s: invalid key %q: must be one of %v", name, limit.Key, rateLimitKeys))
+		}
+		if limit.Limit <= 0 {
+			errs = append(errs, fmt.Errorf("%s: limit must be positive", name))
+		}
+		if limit.Period < time.Second {
+			errs = append(errs, fmt.Errorf("%s: period must be at least 1s", name))
+		}
+		if seen[routeKey{limit.Route, limit.Key}] {
+			errs = append(errs, fmt.Errorf("%s: %s already has a rate limit by %s", name, limit.Route, limit.Key))
+		}
+		seen[routeKey{limit.Route, limit.Key}] = true
+	}
+	return errs
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// referrerPolicies lists the values of the Referrer-Policy header
+var referrerPolicies = []string{
+	"no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin",
+	"same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url",
+}
+
+// hstsPreloadMinMaxAge is the shortest HSTS max-age browsers accept for preloading (one year)
+const hstsPreloadMinMaxAge = 365 * 24 * 60 * 60
+
+// SecurityHeaders are the policies sent with every response of a route group
+type SecurityHeaders struct {
+	// ContentSecurityPolicy is the policy without frame-ancestors and the
+	// reporting directives, which are added from their own settings
+	ContentSecurityPolicy string
+	FrameAncestors        string // sources that may embed the responses, or 'none'
+	ReferrerPolicy        string
+	PermissionsPolicy     string
+}
+
+// SecurityHeadersOverride replaces the default security headers for the
+// routes under PathPrefix
+type SecurityHeadersOverride struct {
+	PathPrefix string
+	SecurityHeaders
+}
+
+// DefaultSecurityHeaders returns the headers of the routes no override covers
+func (c *Config) DefaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		ContentSecurityPolicy: c.ContentSecurityPolicy,
+		FrameAncestors:        c.CSPFrameAncestors,
+		ReferrerPolicy:        c.ReferrerPolicy,
+		PermissionsPolicy:     c.PermissionsPolicy,
+	}
+}
+
+// parseSecurityHeadersOverrides reads the security_headers_overrides list of the config file
+func parseSecurityHeadersOverrides(value any) ([]SecurityHeadersOverride, error) {
+	items, ok := value.([]any)
+	if !ok {
+		return nil, errors.New("security_headers_overrides must be a list")
+	}
+
+	var errs []error
+	overrides := make([]SecurityHeadersOverride, 0, len(items))
+	for i, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("security_headers_overrides[%d] must be a table of settings", i))
+			continue
+		}
+
+		var override SecurityHeadersOverride
+		for key, value := range fields {
+			switch key {
+			case "path_prefix":
+				override.PathPrefix = fmt.Sprint(value)
+			case "content_security_policy":
+				override.ContentSecurityPolicy = fmt.Sprint(value)
+			case "frame_ancestors":
+				override.FrameAncestors = fmt.Sprint(value)
+			case "referrer_policy":
+				override.ReferrerPolicy = fmt.Sprint(value)
+			case "permissions_policy":
+				override.PermissionsPolicy = fmt.Sprint(value)
+			default:
+				errs = append(errs, fmt.Errorf("unknown setting %q", fmt.Sprintf("security_headers_overrides[%d].%s", i, key)))
+			}
+		}
+		overrides = append(overrides, override)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// applySecurityHeadersDefaults fills in what overrides leave out from the
+// default security headers
+func applySecurityHeadersDefaults(c *Config) {
+	for i := range c.SecurityHeadersOverrides {
+		override := &c.SecurityHeadersOverrides[i]
+		if override.ContentSecurityPolicy == "" {
+			override.ContentSecurityPolicy = c.ContentSecurityPolicy
+		}
+		if override.FrameAncestors == "" {
+			override.FrameAncestors = c.CSPFrameAncestors
+		}
+		if override.ReferrerPolicy == "" {
+			override.ReferrerPolicy = c.ReferrerPolicy
+		}
+		if override.PermissionsPolicy == "" {
+			override.PermissionsPolicy = c.PermissionsPolicy
+		}
+	}
+}
+
+// validateSecurityHeaders checks the HSTS settings, the default security
+// headers and their overrides
+func (c *Config) validateSecurityHeaders() []error {
+	var errs []error
+	if c.HSTSPreload && (!c.HSTSIncludeSubdomains || c.HSTSMaxAge.Seconds() < hstsPreloadMinMaxAge) {
+		errs = append(errs, errors.New("HSTS_PRELOAD requires HSTS_INCLUDE_SUBDOMAINS and an HSTS_MAX_AGE of at least 8760h"))
+	}
+	errs = append(errs, validateSecurityHeaders("CONTENT_SECURITY_POLICY", "REFERRER_POLICY", c.DefaultSecurityHeaders())...)
+
+	seen := make(map[string]bool, len(c.SecurityHeadersOverrides))
+	for i, override := range c.SecurityHeadersOverrides {
+		name := fmt.Sprintf("security_headers_overrides[%d]", i)
+		if !strings.HasPrefix(override.PathPrefix, "/") {
+			errs = append(errs, fmt.Errorf("%s: path_prefix %q must be a path such as /api/v1/auth", name, override.PathPrefix))
+		}
+		if seen[override.PathPrefix] {
+			errs = append(errs, fmt.Errorf("%s: %s already has a security headers override", name, override.PathPrefix))
+		}
+		seen[override.PathPrefix] = true
+		errs = append(errs, validateSecurityHeaders(name+".content_security_policy", name+".referrer_policy", override.SecurityHeaders)...)
+	}
+	return errs
+}
+
+// validateSecurityHeaders checks the policies of a route group, naming them
+// as given in errors
+func validateSecurityHeaders(cspName, referrerName string, h SecurityHeaders) []error {
+	var errs []error
+	for _, directive := range strings.Split(h.ContentSecurityPolicy, ";") {
+		name, _, _ := strings.Cut(strings.TrimSpace(directive), " ")
+		switch strings.ToLower(name) {
+		case "frame-ancestors", "report-uri", "report-to":
+			errs = append(errs, fmt.Errorf("%s must not contain %s, which has its own setting", cspName, name))
+		}
+	}
+	if h.ReferrerPolicy != "" && !slices.Contains(referrerPolicies, h.ReferrerPolicy) {
+		errs = append(errs, fmt.Errorf("invalid %s %q: must be one of %v", referrerName, h.ReferrerPolicy, referrerPolicies))
+	}
+	return errs
+}
@@ -0,0 +1,379 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// setting describes one configuration value and the sources it can come from
+type setting struct {
+	env    string // environment variable, also used to name the setting in errors
+	file   string // dotted key in the config file; the flag is its kebab-case form
+	def    string // default, written like the environment variable
+	secret bool   // redacted when printed, never a flag, and readable from <env>_FILE or <file>_file
+	value  any    // pointer to the Config field
+	usage  string
+}
+
+// flagName returns the command-line flag of the setting, e.g. --database-driver
+func (s setting) flagName() string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(s.file)
+}
+
+// settings lists every setting bound to the fields of c. Settings without a
+// default may get one derived from other settings in applyDerivedDefaults.
+func settings(c *Config) []setting {
+	return []setting{
+		{env: "APP_ENV", file: "app.environment", def: EnvDevelopment, value: &c.Environment, usage: "development or production"},
+		{env: "LOG_LEVEL", file: "log.level", def: "info", value: &c.LogLevel, usage: "debug, info, warn or error"},
+		{env: "LOG_FORMAT", file: "log.format", def: "json", value: &c.LogFormat, usage: "json or text"},
+
+		{env: "TRACING_EXPORTER", file: "tracing.exporter", def: "none", value: &c.TracingExporter, usage: "none, otlp, stdout or file"},
+		{env: "TRACING_OTLP_ENDPOINT", file: "tracing.otlp_endpoint", value: &c.TracingOTLPEndpoint, usage: "OTLP/HTTP collector URL"},
+		{env: "TRACING_FILE", file: "tracing.file", value: &c.TracingFile, usage: "file the file exporter appends spans to"},
+		{env: "TRACING_SAMPLE_RATIO", file: "tracing.sample_ratio", def: "1", value: &c.TracingSampleRatio, usage: "fraction of new traces sampled"},
+		{env: "TRACING_SERVICE_NAME", file: "tracing.service_name", def: "go-postgres-api", value: &c.TracingServiceName, usage: "service name of the spans"},
+
+		{env: "HOST", file: "server.host", def: "0.0.0.0", value: &c.ServerHost, usage: "interface to listen on"},
+		{env: "PORT", file: "server.port", def: "8080", value: &c.ServerPort, usage: "port to listen on"},
+		{env: "PUBLIC_BASE_URL", file: "server.public_base_url", value: &c.PublicBaseURL, usage: "URL clients reach the API at, used in email links"},
+		{env: "REQUEST_TIMEOUT", file: "server.request_timeout", def: "10s", value: &c.RequestTimeout, usage: "default request deadline"},
+		{env: "AUTH_REQUEST_TIMEOUT", file: "server.auth_request_timeout", value: &c.AuthRequestTimeout, usage: "deadline for /api/v1/auth routes"},
+		{env: "USERS_REQUEST_TIMEOUT", file: "server.users_request_timeout", value: &c.UsersRequestTimeout, usage: "deadline for /api/v1/users routes"},
+		{env: "SERVER_READ_TIMEOUT", file: "server.read_timeout", def: "15s", value: &c.ServerReadTimeout, usage: "time to read a request"},
+		{env: "SERVER_READ_HEADER_TIMEOUT", file: "server.read_header_timeout", def: "5s", value: &c.ServerReadHeaderTimeout, usage: "time to read request headers"},
+		{env: "SERVER_WRITE_TIMEOUT", file: "server.write_timeout", def: "30s", value: &c.ServerWriteTimeout, usage: "time to write a response"},
+		{env: "SERVER_IDLE_TIMEOUT", file: "server.idle_timeout", def: "60s", value: &c.ServerIdleTimeout, usage: "keep-alive idle timeout"},
+		{env: "SERVER_MAX_HEADER_BYTES", file: "server.max_header_bytes", def: "1048576", value: &c.ServerMaxHeaderBytes, usage: "maximum request header size"},
+		{env: "SHUTDOWN_DRAIN_PERIOD", file: "server.shutdown_drain_period", def: "5s", value: &c.ShutdownDrainPeriod, usage: "time to keep serving after readiness fails"},
+		{env: "SHUTDOWN_TIMEOUT", file: "server.shutdown_timeout", def: "30s", value: &c.ShutdownTimeout, usage: "time for in-flight requests to finish"},
+		{env: "TRUSTED_PROXIES", file: "server.trusted_proxies", value: &c.TrustedProxies, usage: "proxies whose forwarding headers are trusted"},
+
+		{env: "RATE_LIMIT_ENABLED", file: "rate_limit.enabled", def: "true", value: &c.RateLimitEnabled, usage: "throttle the rate-limited routes"},
+		{env: "RATE_LIMIT_STORE", file: "rate_limit.store", def: "memory", value: &c.RateLimitStore, usage: "memory or database"},
+
+		{env: "CORS_ALLOWED_ORIGINS", file: "cors.allowed_origins", value: &c.CORSAllowedOrigins, usage: "origins, *.domain patterns or *"},
+		{env: "CORS_ALLOW_CREDENTIALS", file: "cors.allow_credentials", def: "false", value: &c.CORSAllowCredentials, usage: "let browsers send cookies cross-origin"},
+		{env: "CORS_ALLOWED_HEADERS", file: "cors.allowed_headers", def: "Origin,Content-Type,Content-Length,Accept,Accept-Encoding,Authorization,Cache-Control,X-Requested-With,X-CSRF-Token,X-Request-ID,traceparent,tracestate",
+			value: &c.CORSAllowedHeaders, usage: "request headers cross-origin requests may send"},
+		{env: "CORS_EXPOSED_HEADERS", file: "cors.exposed_headers", def: "Content-Length,Content-Type,X-Request-ID,X-Total-Count,Link,RateLimit-Policy,RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,Retry-After",
+			value: &c.CORSExposedHeaders, usage: "response headers scripts may read"},
+		{env: "CORS_MAX_AGE", file: "cors.max_age", def: "12h", value: &c.CORSMaxAge, usage: "how long preflight responses are cached"},
+
+		{env: "SECURITY_HEADERS_ENABLED", file: "security_headers.enabled", def: "true", value: &c.SecurityHeadersEnabled, usage: "send the security headers"},
+		{env: "HSTS_MAX_AGE", file: "security_headers.hsts_max_age", def: "8760h", value: &c.HSTSMaxAge, usage: "how long browsers insist on HTTPS; 0 disables HSTS"},
+		{env: "HSTS_INCLUDE_SUBDOMAINS", file: "security_headers.hsts_include_subdomains", def: "true", value: &c.HSTSIncludeSubdomains, usage: "apply HSTS to subdomains"},
+		{env: "HSTS_PRELOAD", file: "security_headers.hsts_preload", def: "false", value: &c.HSTSPreload, usage: "allow browser HSTS preload lists"},
+		{env: "CONTENT_SECURITY_POLICY", file: "security_headers.content_security_policy", def: "default-src 'none'; base-uri 'none'; form-action 'none'",
+			value: &c.ContentSecurityPolicy, usage: "Content-Security-Policy without frame-ancestors"},
+		{env: "CSP_FRAME_ANCESTORS", file: "security_headers.frame_ancestors", def: "'none'", value: &c.CSPFrameAncestors, usage: "sources that may frame responses"},
+		{env: "CSP_REPORT_ONLY", file: "security_headers.csp_report_only", def: "false", value: &c.CSPReportOnly, usage: "report CSP violations without blocking"},
+		{env: "CSP_REPORTING", file: "security_headers.csp_reporting", def: "true", value: &c.CSPReporting, usage: "send CSP violation reports to /csp-reports"},
+		{env: "REFERRER_POLICY", file: "security_headers.referrer_policy", def: "no-referrer", value: &c.ReferrerPolicy, usage: "Referrer-Policy"},
+		{env: "PERMISSIONS_POLICY", file: "security_headers.permissions_policy", def: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+			value: &c.PermissionsPolicy, usage: "Permissions-Policy"},
+
+		{env: "TLS_CERT_FILE", file: "tls.cert_file", value: &c.TLSCertFile, usage: "PEM certificate chain"},
+		{env: "TLS_KEY_FILE", file: "tls.key_file", value: &c.TLSKeyFile, usage: "PEM private key"},
+		{env: "TLS_MIN_VERSION", file: "tls.min_version", def: "1.2", value: &c.TLSMinVersion, usage: "1.2 or 1.3"},
+		{env: "TLS_CIPHER_SUITES", file: "tls.cipher_suites", value: &c.TLSCipherSuites, usage: "TLS 1.2 cipher suites"},
+		{env: "TLS_CLIENT_CA_FILE", file: "tls.client_ca_file", value: &c.TLSClientCAFile, usage: "CA bundle for client certificates"},
+		{env: "TLS_CLIENT_AUTH", file: "tls.client_auth", value: &c.TLSClientAuth, usage: "client certificate policy"},
+		{env: "TLS_RELOAD_INTERVAL", file: "tls.reload_interval", def: "30s", value: &c.TLSReloadInterval, usage: "how often certificate files are checked"},
+		{env: "HTTP_REDIRECT_PORT", file: "tls.http_redirect_port", value: &c.HTTPRedirectPort, usage: "plain HTTP port redirecting to HTTPS"},
+
+		{env: "DB_DRIVER", file: "database.driver", def: "mysql", value: &c.DBDriver, usage: "postgres, mysql or sqlite"},
+		{env: "DB_HOST", file: "database.host", def: "localhost", value: &c.DBHost, usage: "database host"},
+		{env: "DB_PORT", file: "database.port", value: &c.DBPort, usage: "database port"},
+		{env: "DB_USER", file: "database.user", value: &c.DBUser, usage: "database user"},
+		{env: "DB_PASSWORD", file: "database.password", secret: true, value: &c.DBPassword},
+		{env: "DB_NAME", file: "database.name", value: &c.DBName, usage: "database name, or file path for sqlite"},
+		{env: "DB_SSLMODE", file: "database.sslmode", value: &c.DBSSLMode, usage: "disable, prefer, require, verify-ca or verify-full"},
+		{env: "DB_SSLROOTCERT", file: "database.sslrootcert", value: &c.DBSSLRootCert, usage: "CA bundle for the database server"},
+		{env: "AUTO_MIGRATE", file: "database.auto_migrate", def: "true", value: &c.AutoMigrate, usage: "apply pending migrations on startup"},
+		{env: "DB_SLOW_QUERY_THRESHOLD", file: "database.slow_query_threshold", def: "200ms", value: &c.SlowQueryThreshold, usage: "queries slower than this are logged"},
+
+		{env: "METRICS_ENABLED", file: "metrics.enabled", def: "true", value: &c.MetricsEnabled, usage: "serve the Prometheus metrics"},
+		{env: "METRICS_ADDRESS", file: "metrics.address", value: &c.MetricsAddress, usage: "host:port of a separate /metrics listener"},
+		{env: "METRICS_TOKEN", file: "metrics.token", secret: true, value: &c.MetricsToken},
+
+		{env: "HEALTH_CHECK_TIMEOUT", file: "health.check_timeout", def: "2s", value: &c.HealthCheckTimeout, usage: "timeout of each health check"},
+		{env: "HEALTH_CACHE_TTL", file: "health.cache_ttl", def: "1s", value: &c.HealthCacheTTL, usage: "how long a probe report is reused"},
+		{env: "TOKEN_CLEANUP_INTERVAL", file: "workers.token_cleanup_interval", def: "1h", value: &c.TokenCleanupInterval, usage: "how often expired tokens are deleted"},
+
+		{env: "AUTH0_DOMAIN", file: "auth0.domain", value: &c.Auth0Domain, usage: "Auth0 domain"},
+		{env: "AUTH0_CLIENT_ID", file: "auth0.client_id", value: &c.Auth0ClientID, usage: "Auth0 client ID"},
+		{env: "AUTH0_CLIENT_SECRET", file: "auth0.client_secret", secret: true, value: &c.Auth0ClientSecret},
+		{env: "AUTH0_CALLBACK_URL", file: "auth0.callback_url", value: &c.Auth0CallbackURL, usage: "Auth0 callback URL"},
+
+		{env: "JWT_SECRET", file: "jwt.secret", secret: true, value: &c.JWTSecret},
+		{env: "ACCESS_TOKEN_TTL", file: "tokens.access_token_ttl", def: "15m", value: &c.AccessTokenTTL, usage: "access token lifetime"},
+		{env: "REFRESH_TOKEN_TTL", file: "tokens.refresh_token_ttl", def: "168h", value: &c.RefreshTokenTTL, usage: "refresh token lifetime"},
+		{env: "EMAIL_VERIFICATION_TOKEN_TTL", file: "tokens.email_verification_token_ttl", def: "24h", value: &c.EmailVerificationTokenTTL, usage: "email verification link lifetime"},
+		{env: "EMAIL_CHANGE_TOKEN_TTL", file: "tokens.email_change_token_ttl", def: "24h", value: &c.EmailChangeTokenTTL, usage: "email change link lifetime"},
+		{env: "BLACKLIST_CACHE_TTL", file: "tokens.blacklist_cache_ttl", def: "5s", value: &c.BlacklistCacheTTL, usage: "how long unrevoked tokens are cached"},
+
+		{env: "AUTH_COOKIE_DOMAIN", file: "cookies.domain", value: &c.AuthCookieDomain, usage: "domain of the token cookies; empty for the API host only"},
+		{env: "AUTH_COOKIE_SECURE", file: "cookies.secure", def: "true", value: &c.AuthCookieSecure, usage: "send the token cookies over HTTPS only"},
+		{env: "AUTH_COOKIE_SAME_SITE", file: "cookies.same_site", def: "lax", value: &c.AuthCookieSameSite, usage: "strict, lax or none"},
+
+		{env: "SMTP_HOST", file: "smtp.host", value: &c.SMTPHost, usage: "SMTP server host"},
+		{env: "SMTP_PORT", file: "smtp.port", value: &c.SMTPPort, usage: "SMTP server port"},
+		{env: "SMTP_USERNAME", file: "smtp.username", value: &c.SMTPUsername, usage: "SMTP user"},
+		{env: "SMTP_PASSWORD", file: "smtp.password", secret: true, value: &c.SMTPPassword},
+		{env: "FROM_EMAIL", file: "smtp.from_email", value: &c.FromEmail, usage: "sender address"},
+
+		{env: "WEBAUTHN_RP_ID", file: "webauthn.rp_id", def: "localhost", value: &c.WebAuthnRPID, usage: "relying party ID"},
+		{env: "WEBAUTHN_RP_DISPLAY_NAME", file: "webauthn.rp_display_name", def: "Go Auth API", value: &c.WebAuthnRPDisplayName, usage: "relying party name"},
+		{env: "WEBAUTHN_RP_ORIGINS", file: "webauthn.rp_origins", value: &c.WebAuthnRPOrigins, usage: "allowed origins"},
+
+		{env: "PASSWORD_MIN_LENGTH", file: "password_policy.min_length", def: "8", value: &c.PasswordMinLength, usage: "minimum password length in characters"},
+		{env: "PASSWORD_MAX_LENGTH", file: "password_policy.max_length", value: &c.PasswordMaxLength, usage: "maximum password length in bytes"},
+		{env: "PASSWORD_REQUIRE_LOWERCASE", file: "password_policy.require_lowercase", def: "false", value: &c.PasswordRequireLowercase, usage: "require a lowercase letter"},
+		{env: "PASSWORD_REQUIRE_UPPERCASE", file: "password_policy.require_uppercase", def: "false", value: &c.PasswordRequireUppercase, usage: "require an uppercase letter"},
+		{env: "PASSWORD_REQUIRE_DIGIT", file: "password_policy.require_digit", def: "false", value: &c.PasswordRequireDigit, usage: "require a digit"},
+		{env: "PASSWORD_REQUIRE_SYMBOL", file: "password_policy.require_symbol", def: "false", value: &c.PasswordRequireSymbol, usage: "require a symbol"},
+		{env: "PASSWORD_REJECT_PERSONAL_INFO", file: "password_policy.reject_personal_info", def: "true", value: &c.PasswordRejectPersonalInfo, usage: "reject passwords containing the email or name"},
+		{env: "BREACHED_PASSWORDS_PATH", file: "password_policy.breached_passwords_path", value: &c.BreachedPasswordsPath, usage: "local breached-password list"},
+
+		{env: "PASSWORD_HASH_ALGORITHM", file: "password_hashing.algorithm", def: "argon2id", value: &c.PasswordHashAlgorithm, usage: "argon2id or bcrypt"},
+		{env: "ARGON2_MEMORY_KB", file: "password_hashing.argon2_memory_kb", def: "19456", value: &c.Argon2MemoryKB, usage: "argon2id memory in KiB"},
+		{env: "ARGON2_ITERATIONS", file: "password_hashing.argon2_iterations", def: "2", value: &c.Argon2Iterations, usage: "argon2id iterations"},
+		{env: "ARGON2_PARALLELISM", file: "password_hashing.argon2_parallelism", def: "1", value: &c.Argon2Parallelism, usage: "argon2id parallelism"},
+		{env: "BCRYPT_COST", file: "password_hashing.bcrypt_cost", def: "10", value: &c.BcryptCost, usage: "bcrypt cost"},
+	}
+}
+
+// load fills c from the defaults, the config file, the environment and the
+// flags in args, and returns the environment names of the settings that any
+// source other than the defaults provided
+func load(c *Config, args []string) (map[string]bool, error) {
+	all := settings(c)
+
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	byFlag := make(map[string]setting)
+	for _, s := range all {
+		if s.secret {
+			continue // Secrets on the command line would leak through the process list
+		}
+		flags.String(s.flagName(), s.def, s.usage+" ($"+s.env+")")
+		byFlag[s.flagName()] = s
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", flags.Arg(0))
+	}
+
+	raw := make(map[string]string, len(all))
+	provided := make(map[string]bool)
+	for _, s := range all {
+		raw[s.env] = s.def
+	}
+
+	// Config file
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		values, err := readConfigFile(path, c)
+		if err != nil {
+			return nil, err
+		}
+		byFile := make(map[string]setting, len(all))
+		for _, s := range all {
+			byFile[s.file] = s
+		}
+		for key, value := range values {
+			if s, ok := byFile[key]; ok {
+				raw[s.env] = value
+				provided[s.env] = true
+				continue
+			}
+			if s, ok := byFile[strings.TrimSuffix(key, "_file")]; ok && s.secret {
+				if raw[s.env], err = readSecretFile(s.env, value); err != nil {
+					return nil, err
+				}
+				provided[s.env] = true
+				continue
+			}
+			return nil, fmt.Errorf("unknown setting %q in %s", key, path)
+		}
+	}
+
+	// Environment; empty variables count as unset
+	for _, s := range all {
+		value := os.Getenv(s.env)
+		if s.secret {
+			if secretPath := os.Getenv(s.env + "_FILE"); secretPath != "" {
+				if value != "" {
+					return nil, fmt.Errorf("%s and %s_FILE are both set", s.env, s.env)
+				}
+				secret, err := readSecretFile(s.env, secretPath)
+				if err != nil {
+					return nil, err
+				}
+				value = secret
+			}
+		}
+		if value != "" {
+			raw[s.env] = value
+			provided[s.env] = true
+		}
+	}
+
+	// Flags
+	flags.Visit(func(f *flag.Flag) {
+		if s, ok := byFlag[f.Name]; ok {
+			raw[s.env] = f.Value.String()
+			provided[s.env] = true
+		}
+	})
+
+	var errs []error
+	for _, s := range all {
+		if err := assign(s, raw[s.env]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return provided, nil
+}
+
+// assign parses value into the setting's Config field
+func assign(s setting, value string) error {
+	var err error
+	switch field := s.value.(type) {
+	case *string:
+		*field = value
+	case *[]string:
+		*field = splitList(value)
+	case *bool:
+		if value == "" {
+			*field = false
+			return nil
+		}
+		*field, err = parseBool(s.env, value)
+	case *int:
+		if value == "" {
+			*field = 0
+			return nil
+		}
+		*field, err = parseInt(s.env, value)
+	case *float64:
+		if value == "" {
+			*field = 0
+			return nil
+		}
+		*field, err = parseFloat(s.env, value)
+	case *time.Duration:
+		if value == "" {
+			*field = 0
+			return nil
+		}
+		*field, err = parseDuration(s.env, value)
+	default:
+		panic(fmt.Sprintf("config: unsupported type %T for %s", s.value, s.env))
+	}
+	return err
+}
+
+// readSecretFile reads a secret from a file such as a mounted Docker or
+// Kubernetes secret, dropping the trailing newline
+func readSecretFile(key, path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from file: %w", key, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// readConfigFile reads a YAML or TOML file, chosen by extension, into
+// dotted keys such as database.driver. The lists of the file, the registered
+// clients, the rate limits and the header overrides, are set on c directly.
+func readConfigFile(path string, c *Config) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var document map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &document)
+	case ".toml":
+		err = toml.Unmarshal(content, &document)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q: use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	// Clients, rate limits and header overrides are lists of tables rather than single settings
+	if value, ok := document["clients"]; ok {
+		delete(document, "clients")
+		if c.Clients, err = parseClients(value); err != nil {
+			return nil, fmt.Errorf("invalid clients in %s: %w", path, err)
+		}
+	}
+	if value, ok := document["rate_limits"]; ok {
+		delete(document, "rate_limits")
+		if c.RateLimits, err = parseRateLimits(value); err != nil {
+			return nil, fmt.Errorf("invalid rate_limits in %s: %w", path, err)
+		}
+	}
+	if value, ok := document["cors_overrides"]; ok {
+		delete(document, "cors_overrides")
+		if c.CORSOverrides, err = parseCORSOverrides(value); err != nil {
+			return nil, fmt.Errorf("invalid cors_overrides in %s: %w", path, err)
+		}
+	}
+	if value, ok := document["security_headers_overrides"]; ok {
+		delete(document, "security_headers_overrides")
+		if c.SecurityHeadersOverrides, err = parseSecurityHeadersOverrides(value); err != nil {
+			return nil, fmt.Errorf("invalid security_headers_overrides in %s: %w", path, err)
+		}
+	}
+
+	values := make(map[string]string)
+	flatten("", document, values)
+	return values, nil
+}
+
+// flatten turns nested sections into dotted keys; lists become comma-separated values
+func flatten(prefix string, section map[string]any, values map[string]string) {
+	for key, value := range section {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(key, v, values)
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
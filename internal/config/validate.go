@@ -0,0 +1,164 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// minProductionSecretLength is the shortest JWT secret accepted in production (256 bits)
+const minProductionSecretLength = 32
+
+// Validate checks the configuration and reports every problem at once. In
+// production it also refuses missing and weak secrets.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		check(slices.Contains(allowed, value), "invalid %s %q: must be one of %v", key, value, allowed)
+	}
+	port := func(key, value string, optional bool) {
+		if value == "" && optional {
+			return
+		}
+		n, err := strconv.Atoi(value)
+		check(err == nil && n > 0 && n <= 65535, "invalid %s %q: must be a port number", key, value)
+	}
+
+	oneOf("APP_ENV", c.Environment, EnvDevelopment, EnvProduction)
+	oneOf("LOG_LEVEL", c.LogLevel, "debug", "info", "warn", "error")
+	oneOf("LOG_FORMAT", c.LogFormat, "json", "text")
+
+	// Tracing
+	oneOf("TRACING_EXPORTER", c.TracingExporter, "none", "otlp", "stdout", "file")
+	check(c.TracingExporter != "file" || c.TracingFile != "", "TRACING_EXPORTER file requires TRACING_FILE")
+	if c.TracingOTLPEndpoint != "" {
+		u, err := url.Parse(c.TracingOTLPEndpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"invalid TRACING_OTLP_ENDPOINT %q: must be an http or https URL", c.TracingOTLPEndpoint)
+	}
+	check(c.TracingSampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+	check(c.TracingServiceName != "", "TRACING_SERVICE_NAME must not be empty")
+
+	// Server
+	port("PORT", c.ServerPort, false)
+	baseURL, err := url.Parse(c.PublicBaseURL)
+	check(err == nil && (baseURL.Scheme == "http" || baseURL.Scheme == "https") && baseURL.Host != "" && baseURL.RawQuery == "" && baseURL.Fragment == "",
+		"invalid PUBLIC_BASE_URL %q: must be an http or https URL without a query", c.PublicBaseURL)
+	check(c.ServerMaxHeaderBytes > 0, "SERVER_MAX_HEADER_BYTES must be positive")
+	for _, proxy := range c.TrustedProxies {
+		check(isIPOrCIDR(proxy), "invalid TRUSTED_PROXIES entry %q: must be an IP address or CIDR", proxy)
+	}
+
+	// Rate limiting
+	oneOf("RATE_LIMIT_STORE", c.RateLimitStore, RateLimitStoreMemory, RateLimitStoreDatabase)
+	errs = append(errs, c.validateRateLimits()...)
+
+	// CORS
+	errs = append(errs, c.validateCORS()...)
+
+	// Security headers
+	errs = append(errs, c.validateSecurityHeaders()...)
+
+	// TLS
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	oneOf("TLS_MIN_VERSION", c.TLSMinVersion, "1.2", "1.3")
+	oneOf("TLS_CLIENT_AUTH", c.TLSClientAuth, "none", "request", "require", "verify-if-given", "require-and-verify")
+	if c.TLSClientAuth == "verify-if-given" || c.TLSClientAuth == "require-and-verify" {
+		check(c.TLSClientCAFile != "", "TLS_CLIENT_AUTH %s requires TLS_CLIENT_CA_FILE", c.TLSClientAuth)
+	}
+	port("HTTP_REDIRECT_PORT", c.HTTPRedirectPort, true)
+	check(c.HTTPRedirectPort == "" || c.TLSEnabled(), "HTTP_REDIRECT_PORT requires TLS to be enabled")
+
+	// Metrics
+	if c.MetricsAddress != "" {
+		_, metricsPort, err := net.SplitHostPort(c.MetricsAddress)
+		check(err == nil, "invalid METRICS_ADDRESS %q: must be host:port", c.MetricsAddress)
+		if err == nil {
+			port("METRICS_ADDRESS", metricsPort, false)
+		}
+	}
+
+	// Database
+	oneOf("DB_DRIVER", c.DBDriver, "postgres", "mysql", "sqlite")
+	oneOf("DB_SSLMODE", c.DBSSLMode, "disable", "prefer", "require", "verify-ca", "verify-full")
+	if c.DBDriver != "sqlite" {
+		port("DB_PORT", c.DBPort, false)
+	}
+
+	// Tokens
+	check(c.AccessTokenTTL > 0, "ACCESS_TOKEN_TTL must be positive")
+	check(c.RefreshTokenTTL > c.AccessTokenTTL, "REFRESH_TOKEN_TTL must be longer than ACCESS_TOKEN_TTL")
+	check(c.EmailVerificationTokenTTL > 0, "EMAIL_VERIFICATION_TOKEN_TTL must be positive")
+	check(c.EmailChangeTokenTTL > 0, "EMAIL_CHANGE_TOKEN_TTL must be positive")
+	errs = append(errs, c.validateClients()...)
+
+	// Cookies
+	oneOf("AUTH_COOKIE_SAME_SITE", c.AuthCookieSameSite, "strict", "lax", "none")
+	check(c.AuthCookieSameSite != "none" || c.AuthCookieSecure, "AUTH_COOKIE_SAME_SITE none requires AUTH_COOKIE_SECURE, as browsers drop the cookies otherwise")
+
+	// SMTP
+	port("SMTP_PORT", c.SMTPPort, true)
+
+	// Passwords
+	check(c.PasswordMinLength > 0, "PASSWORD_MIN_LENGTH must be positive")
+	check(c.PasswordHashAlgorithm != "bcrypt" || c.PasswordMaxLength <= 72, "PASSWORD_MAX_LENGTH must be at most 72 with bcrypt, as bcrypt ignores anything longer")
+	check(c.PasswordMaxLength >= c.PasswordMinLength, "PASSWORD_MAX_LENGTH must not be less than PASSWORD_MIN_LENGTH")
+	oneOf("PASSWORD_HASH_ALGORITHM", c.PasswordHashAlgorithm, "argon2id", "bcrypt")
+
+	if c.IsProduction() {
+		errs = append(errs, c.validateProductionSecrets()...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// isIPOrCIDR reports whether s is an IP address or a CIDR block
+func isIPOrCIDR(s string) bool {
+	if _, err := netip.ParseAddr(s); err == nil {
+		return true
+	}
+	_, err := netip.ParsePrefix(s)
+	return err == nil
+}
+
+// validateProductionSecrets rejects missing, default and short secrets
+func (c *Config) validateProductionSecrets() []error {
+	var errs []error
+
+	switch {
+	case c.JWTSecret == "":
+		errs = append(errs, errors.New("JWT_SECRET is required in production"))
+	case c.JWTSecret == devJWTSecret:
+		errs = append(errs, errors.New("JWT_SECRET must not be the development secret in production"))
+	case len(c.JWTSecret) < minProductionSecretLength:
+		errs = append(errs, fmt.Errorf("JWT_SECRET must be at least %d bytes in production", minProductionSecretLength))
+	}
+
+	if c.DBDriver != "sqlite" && c.DBPassword == "" {
+		errs = append(errs, errors.New("DB_PASSWORD is required in production"))
+	}
+
+	if c.Auth0Domain != "" && c.Auth0ClientSecret == "" {
+		errs = append(errs, errors.New("AUTH0_CLIENT_SECRET is required in production when AUTH0_DOMAIN is set"))
+	}
+
+	if c.SMTPHost != "" && c.SMTPPassword == "" {
+		errs = append(errs, errors.New("SMTP_PASSWORD is required in production when SMTP_HOST is set"))
+	}
+
+	// Metrics on the API listener would be public
+	if c.MetricsEnabled && c.MetricsAddress == "" && c.MetricsToken == "" {
+		errs = append(errs, errors.New("METRICS_ADDRESS or METRICS_TOKEN is required in production when METRICS_ENABLED is true"))
+	}
+
+	return errs
+}
@@ -1,24 +1,43 @@
 package controllers
 
 import (
-	"go-postgres-api/internal/config"
+	"context"
+	"errors"
+	"go-postgres-api/internal/middleware"
 	"go-postgres-api/internal/models"
 	"go-postgres-api/internal/services"
+	"io"
 	"net/http"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// AuthService is the authentication logic used by AuthController
+type AuthService interface {
+	Register(ctx context.Context, req *models.RegisterRequest, ipAddress, userAgent string) (*models.SuccessResponse, error)
+	Login(ctx context.Context, req *models.LoginRequest, ipAddress, userAgent string) (*models.AuthResponse, error)
+	Logout(ctx context.Context, tokenString string, userID uint) error
+	GetUserByID(ctx context.Context, userID uint) (*models.User, error)
+	VerifyEmail(ctx context.Context, token, ipAddress, userAgent string) (*models.SuccessResponse, error)
+	ResendVerificationEmail(ctx context.Context, email string) (*models.SuccessResponse, error)
+	RefreshAccessToken(ctx context.Context, refreshTokenString, ipAddress, userAgent string) (*models.AuthResponse, error)
+	RequestEmailChange(ctx context.Context, userID uint, req *models.ChangeEmailRequest, ipAddress, userAgent string) (*models.SuccessResponse, error)
+	ConfirmEmailChange(ctx context.Context, token, ipAddress, userAgent string) (*models.SuccessResponse, error)
+	CancelEmailChange(ctx context.Context, token, ipAddress, userAgent string) (*models.SuccessResponse, error)
+	ChangePassword(ctx context.Context, claims *services.AccessClaims, req *models.ChangePasswordRequest, ipAddress, userAgent string) (*models.SuccessResponse, error)
+}
+
 // AuthController handles authentication requests
 type AuthController struct {
-	authService *services.AuthService
+	authService AuthService
+	cookies     *middleware.AuthCookies
 }
 
 // NewAuthController creates a new authentication controller
-func NewAuthController(cfg *config.Config) *AuthController {
+func NewAuthController(authService AuthService, cookies *middleware.AuthCookies) *AuthController {
 	return &AuthController{
-		authService: services.NewAuthService(),
+		authService: authService,
+		cookies:     cookies,
 	}
 }
 
@@ -26,13 +45,13 @@ func NewAuthController(cfg *config.Config) *AuthController {
 func (c *AuthController) Register(ctx *gin.Context) {
 	var req models.RegisterRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
-	response, err := c.authService.Register(&req)
+	response, err := c.authService.Register(ctx.Request.Context(), &req, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
@@ -43,54 +62,46 @@ func (c *AuthController) Register(ctx *gin.Context) {
 func (c *AuthController) Login(ctx *gin.Context) {
 	var req models.LoginRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
 	ipAddress := ctx.ClientIP()
 	userAgent := ctx.GetHeader("User-Agent")
 
-	response, err := c.authService.Login(&req, ipAddress, userAgent)
+	response, err := c.authService.Login(ctx.Request.Context(), &req, ipAddress, userAgent)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, response)
+	respondTokens(ctx, c.cookies, response)
 }
 
 // Logout handles user logout
 func (c *AuthController) Logout(ctx *gin.Context) {
-	// Get token from Authorization header
-	authHeader := ctx.GetHeader("Authorization")
-	if authHeader == "" {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "authorization header is required"})
-		return
-	}
-
-	// Extract token from "Bearer <token>"
-	tokenParts := strings.Split(authHeader, " ")
-	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "invalid authorization header format"})
+	// Get token from Authorization header or cookie
+	tokenString, err := middleware.AccessToken(ctx)
+	if err != nil {
+		middleware.RespondError(ctx, err)
 		return
 	}
 
-	tokenString := tokenParts[1]
-
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("userID")
 	if !exists {
-		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "user ID not found in context"})
+		middleware.RespondError(ctx, errors.New("user ID not found in context"))
 		return
 	}
 
 	// Blacklist token
-	err := c.authService.Logout(tokenString, userID.(uint))
+	err = c.authService.Logout(ctx.Request.Context(), tokenString, userID.(uint))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
+	c.cookies.Clear(ctx)
 	ctx.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
 }
 
@@ -99,14 +110,14 @@ func (c *AuthController) GetProfile(ctx *gin.Context) {
 	// Get user ID from context (set by auth middleware)
 	userID, exists := ctx.Get("userID")
 	if !exists {
-		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "user ID not found in context"})
+		middleware.RespondError(ctx, errors.New("user ID not found in context"))
 		return
 	}
 
 	// Get user from database
-	user, err := c.authService.GetUserByID(userID.(uint))
+	user, err := c.authService.GetUserByID(ctx.Request.Context(), userID.(uint))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
@@ -117,13 +128,13 @@ func (c *AuthController) GetProfile(ctx *gin.Context) {
 func (c *AuthController) VerifyEmail(ctx *gin.Context) {
 	token := ctx.Query("token")
 	if token == "" {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "verification token is required"})
+		middleware.AbortWithFieldError(ctx, "token", "required", "verification token is required")
 		return
 	}
 
-	response, err := c.authService.VerifyEmail(token)
+	response, err := c.authService.VerifyEmail(ctx.Request.Context(), token, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
@@ -134,32 +145,132 @@ func (c *AuthController) VerifyEmail(ctx *gin.Context) {
 func (c *AuthController) ResendVerificationEmail(ctx *gin.Context) {
 	var req models.ResendVerificationRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
-	response, err := c.authService.ResendVerificationEmail(req.Email)
+	response, err := c.authService.ResendVerificationEmail(ctx.Request.Context(), req.Email)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, response)
 }
 
-// RefreshToken handles token refresh
+// RefreshToken handles token refresh. The refresh token comes from the body
+// or, for cookie-delivery clients, from its cookie.
 func (c *AuthController) RefreshToken(ctx *gin.Context) {
 	var req models.RefreshTokenRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		middleware.RespondError(ctx, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken, _ = ctx.Cookie(middleware.RefreshTokenCookie)
+	}
+	if req.RefreshToken == "" {
+		middleware.AbortWithFieldError(ctx, "refresh_token", "required", "refresh token is required")
+		return
+	}
+
+	response, err := c.authService.RefreshAccessToken(ctx.Request.Context(), req.RefreshToken, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	respondTokens(ctx, c.cookies, response)
+}
+
+// RequestEmailChange handles a request to change the user's email address
+func (c *AuthController) RequestEmailChange(ctx *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		middleware.RespondError(ctx, errors.New("user ID not found in context"))
+		return
+	}
+
+	var req models.ChangeEmailRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	response, err := c.authService.RequestEmailChange(ctx.Request.Context(), userID.(uint), &req, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// ConfirmEmailChange handles the confirmation link sent to the new email address
+func (c *AuthController) ConfirmEmailChange(ctx *gin.Context) {
+	token := ctx.Query("token")
+	if token == "" {
+		middleware.AbortWithFieldError(ctx, "token", "required", "email change token is required")
+		return
+	}
+
+	response, err := c.authService.ConfirmEmailChange(ctx.Request.Context(), token, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// CancelEmailChange handles the cancel link sent to the old email address
+func (c *AuthController) CancelEmailChange(ctx *gin.Context) {
+	token := ctx.Query("token")
+	if token == "" {
+		middleware.AbortWithFieldError(ctx, "token", "required", "cancel token is required")
+		return
+	}
+
+	response, err := c.authService.CancelEmailChange(ctx.Request.Context(), token, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// ChangePassword handles a password change by a logged-in user
+func (c *AuthController) ChangePassword(ctx *gin.Context) {
+	// Get token claims from context (set by auth middleware)
+	claims, exists := ctx.Get("tokenClaims")
+	if !exists {
+		middleware.RespondError(ctx, errors.New("token claims not found in context"))
+		return
+	}
+
+	var req models.ChangePasswordRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
-	response, err := c.authService.RefreshAccessToken(req.RefreshToken)
+	response, err := c.authService.ChangePassword(ctx.Request.Context(), claims.(*services.AccessClaims), &req, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: err.Error()})
+		middleware.RespondError(ctx, err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, response)
 }
+
+// respondTokens writes the tokens of a login or refresh, in cookies for the
+// clients with cookie token delivery
+func respondTokens(ctx *gin.Context, cookies *middleware.AuthCookies, response *models.AuthResponse) {
+	if err := cookies.SetTokens(ctx, response); err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/middleware"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/services"
+	"go-postgres-api/internal/validation"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func TestMain(m *testing.M) {
+	// As in main: request binding knows the custom validators
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		if err := validation.Register(v); err != nil {
+			panic(err)
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// fakeAuthService issues numbered tokens, rotating the refresh token on
+// every refresh. The AuthService methods it doesn't implement panic.
+type fakeAuthService struct {
+	AuthService
+	delivery     string
+	issued       int
+	accessToken  string
+	refreshToken string
+	loggedOut    string
+}
+
+func (f *fakeAuthService) issue() *models.AuthResponse {
+	f.issued++
+	f.accessToken = fmt.Sprintf("access-%d", f.issued)
+	f.refreshToken = fmt.Sprintf("refresh-%d", f.issued)
+	return &models.AuthResponse{
+		AccessToken:           f.accessToken,
+		RefreshToken:          f.refreshToken,
+		ExpiresIn:             900,
+		User:                  models.User{ID: 1, Email: "alice@example.com"},
+		TokenDelivery:         f.delivery,
+		RefreshTokenExpiresIn: 3600,
+	}
+}
+
+func (f *fakeAuthService) Login(ctx context.Context, req *models.LoginRequest, ipAddress, userAgent string) (*models.AuthResponse, error) {
+	if req.Password != "secret" {
+		return nil, services.ErrInvalidCredentials
+	}
+	return f.issue(), nil
+}
+
+func (f *fakeAuthService) RefreshAccessToken(ctx context.Context, refreshTokenString, ipAddress, userAgent string) (*models.AuthResponse, error) {
+	if refreshTokenString == "" || refreshTokenString != f.refreshToken {
+		return nil, services.ErrInvalidToken
+	}
+	return f.issue(), nil
+}
+
+func (f *fakeAuthService) Logout(ctx context.Context, tokenString string, userID uint) error {
+	f.loggedOut = tokenString
+	return nil
+}
+
+func (f *fakeAuthService) ValidateToken(ctx context.Context, tokenString string) (*services.AccessClaims, error) {
+	if tokenString == "" || tokenString != f.accessToken {
+		return nil, services.ErrInvalidToken
+	}
+	return &services.AccessClaims{UserID: 1, JTI: tokenString}, nil
+}
+
+// newCookieTestServer serves the login, refresh and logout routes the way
+// the API does, and returns a client that keeps cookies like a browser
+func newCookieTestServer(t *testing.T, service *fakeAuthService) (*httptest.Server, *http.Client) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	controller := NewAuthController(service, middleware.NewAuthCookies(&config.Config{AuthCookieSameSite: "strict"}))
+
+	v1 := router.Group("/api/v1", middleware.CSRFMiddleware())
+	v1.POST("/auth/login", controller.Login)
+	v1.POST("/auth/refresh-token", controller.RefreshToken)
+	v1.POST("/auth/logout", middleware.AuthMiddleware(service), controller.Logout)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, &http.Client{Jar: jar}
+}
+
+// post sends a JSON body, and the CSRF token when set
+func post(t *testing.T, client *http.Client, url, body, csrfToken string) (*http.Response, models.AuthResponse) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if csrfToken != "" {
+		req.Header.Set(middleware.CSRFTokenHeader, csrfToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var auth models.AuthResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, auth
+}
+
+// cookies returns the cookies set by resp, by name
+func cookies(resp *http.Response) map[string]*http.Cookie {
+	byName := make(map[string]*http.Cookie)
+	for _, cookie := range resp.Cookies() {
+		byName[cookie.Name] = cookie
+	}
+	return byName
+}
+
+func TestCookieLoginAndRefresh(t *testing.T) {
+	service := &fakeAuthService{delivery: config.TokenDeliveryCookie}
+	server, client := newCookieTestServer(t, service)
+	base := server.URL + "/api/v1/auth"
+
+	resp, auth := post(t, client, base+"/login", `{"email":"alice@example.com","password":"secret"}`, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login: status %d", resp.StatusCode)
+	}
+	if auth.AccessToken != "" || auth.RefreshToken != "" || auth.CSRFToken == "" {
+		t.Errorf("login body has access token %q, refresh token %q, CSRF token %q, want only the CSRF token", auth.AccessToken, auth.RefreshToken, auth.CSRFToken)
+	}
+
+	set := cookies(resp)
+	tests := []struct {
+		name     string
+		value    string
+		path     string
+		maxAge   int
+		httpOnly bool
+	}{
+		{middleware.AccessTokenCookie, "access-1", "/", 900, true},
+		{middleware.RefreshTokenCookie, "refresh-1", middleware.RefreshTokenCookiePath, 3600, true},
+		{middleware.CSRFTokenCookie, auth.CSRFToken, "/", 3600, false},
+	}
+	for _, tt := range tests {
+		cookie, ok := set[tt.name]
+		if !ok {
+			t.Errorf("login did not set the %s cookie", tt.name)
+			continue
+		}
+		if cookie.Value != tt.value || cookie.Path != tt.path || cookie.MaxAge != tt.maxAge || cookie.HttpOnly != tt.httpOnly || cookie.SameSite != http.SameSiteStrictMode {
+			t.Errorf("%s cookie = %+v, want value %q, path %s, max age %d, HttpOnly %v, SameSite strict", tt.name, cookie, tt.value, tt.path, tt.maxAge, tt.httpOnly)
+		}
+	}
+	if got := middleware.RefreshTokenCookiePath; got != "/api/v1/auth/refresh-token" {
+		t.Errorf("RefreshTokenCookiePath = %q, want the refresh route", got)
+	}
+
+	// The refresh token comes from its cookie; without the CSRF header the request is refused
+	if resp, _ := post(t, client, base+"/refresh-token", "", ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("refresh without CSRF token: status %d, want 403", resp.StatusCode)
+	}
+	resp, refreshed := post(t, client, base+"/refresh-token", "", auth.CSRFToken)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("refresh: status %d", resp.StatusCode)
+	}
+	if set := cookies(resp); set[middleware.AccessTokenCookie] == nil || set[middleware.AccessTokenCookie].Value != "access-2" ||
+		set[middleware.RefreshTokenCookie] == nil || set[middleware.RefreshTokenCookie].Value != "refresh-2" {
+		t.Errorf("refresh set cookies %v, want the rotated tokens", resp.Cookies())
+	}
+	if refreshed.CSRFToken == "" || refreshed.CSRFToken == auth.CSRFToken {
+		t.Errorf("refresh CSRF token = %q, want a new one", refreshed.CSRFToken)
+	}
+
+	// The old CSRF token no longer matches the cookie
+	if resp, _ := post(t, client, base+"/logout", "", auth.CSRFToken); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("logout with the old CSRF token: status %d, want 403", resp.StatusCode)
+	}
+
+	// The browser sends the refresh token to the refresh route only
+	serverURL, _ := url.Parse(server.URL)
+	for _, path := range []string{"/api/v1/auth/logout", "/api/v1/users/me"} {
+		for _, cookie := range client.Jar.Cookies(serverURL.JoinPath(path)) {
+			if cookie.Name == middleware.RefreshTokenCookie {
+				t.Errorf("the refresh token cookie is sent to %s", path)
+			}
+		}
+	}
+
+	resp, _ = post(t, client, base+"/logout", "", refreshed.CSRFToken)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("logout: status %d", resp.StatusCode)
+	}
+	if service.loggedOut != "access-2" {
+		t.Errorf("logged out token %q, want the access token cookie", service.loggedOut)
+	}
+	for name, cookie := range cookies(resp) {
+		if cookie.MaxAge >= 0 {
+			t.Errorf("logout left the %s cookie: %+v", name, cookie)
+		}
+	}
+	if got := client.Jar.Cookies(serverURL.JoinPath(middleware.RefreshTokenCookiePath)); len(got) != 0 {
+		t.Errorf("cookies left after logout: %v", got)
+	}
+}
+
+func TestBodyLoginSetsNoCookies(t *testing.T) {
+	service := &fakeAuthService{delivery: config.TokenDeliveryBody}
+	server, client := newCookieTestServer(t, service)
+	base := server.URL + "/api/v1/auth"
+
+	resp, auth := post(t, client, base+"/login", `{"email":"alice@example.com","password":"secret"}`, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login: status %d", resp.StatusCode)
+	}
+	if len(resp.Cookies()) != 0 {
+		t.Errorf("login set cookies %v for a body-delivery client", resp.Cookies())
+	}
+	if auth.AccessToken != "access-1" || auth.RefreshToken != "refresh-1" || auth.CSRFToken != "" {
+		t.Errorf("login body = %+v, want the tokens and no CSRF token", auth)
+	}
+
+	// Without cookies, neither the body refresh nor the bearer logout needs a CSRF token
+	resp, refreshed := post(t, client, base+"/refresh-token", `{"refresh_token":"refresh-1"}`, "")
+	if resp.StatusCode != http.StatusOK || refreshed.RefreshToken != "refresh-2" {
+		t.Fatalf("refresh: status %d, refresh token %q", resp.StatusCode, refreshed.RefreshToken)
+	}
+	req, _ := http.NewRequest(http.MethodPost, base+"/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+refreshed.AccessToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || service.loggedOut != "access-2" {
+		t.Errorf("bearer logout: status %d, logged out %q", resp.StatusCode, service.loggedOut)
+	}
+
+	// A refresh with neither a body nor a cookie is a validation error
+	if resp, _ := post(t, client, base+"/refresh-token", "", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("refresh without a token: status %d, want 400", resp.StatusCode)
+	}
+}
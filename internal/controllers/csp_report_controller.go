@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/metrics"
+	"go-postgres-api/internal/middleware"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCSPReportBytes bounds the body of a violation report
+const maxCSPReportBytes = 64 << 10
+
+// cspDirectives are the directives a violation is counted by; anything else
+// a browser reports is counted as "other" to keep the metric bounded
+var cspDirectives = []string{
+	"base-uri", "child-src", "connect-src", "default-src", "font-src", "form-action", "frame-ancestors",
+	"frame-src", "img-src", "manifest-src", "media-src", "object-src", "script-src", "script-src-attr",
+	"script-src-elem", "style-src", "style-src-attr", "style-src-elem", "worker-src",
+	"require-trusted-types-for", "trusted-types",
+}
+
+// cspViolation is a violation in the format of the Reporting API (report-to)
+type cspViolation struct {
+	DocumentURL        string `json:"documentURL"`
+	BlockedURL         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+}
+
+// legacyCSPViolation is a violation in the format of report-uri
+type legacyCSPViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	BlockedURI         string `json:"blocked-uri"`
+	EffectiveDirective string `json:"effective-directive"`
+	ViolatedDirective  string `json:"violated-directive"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+}
+
+// CSPReportController records the Content Security Policy violations browsers report
+type CSPReportController struct{}
+
+// NewCSPReportController creates a new CSP report controller
+func NewCSPReportController() *CSPReportController {
+	return &CSPReportController{}
+}
+
+// Report records the violations of a report, sent either by report-uri as a
+// single csp-report object or by report-to as a list of reports
+func (c *CSPReportController) Report(ctx *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxCSPReportBytes))
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	violations, err := parseCSPReport(body)
+	if err != nil {
+		middleware.AbortWithProblem(ctx, http.StatusBadRequest, middleware.CodeInvalidRequest, "request body is not a CSP violation report")
+		return
+	}
+
+	reqCtx := ctx.Request.Context()
+	for _, v := range violations {
+		directive := v.EffectiveDirective
+		if !slices.Contains(cspDirectives, directive) {
+			directive = "other"
+		}
+		disposition := v.Disposition
+		if disposition != "report" {
+			disposition = "enforce"
+		}
+		metrics.CSPViolations.WithLabelValues(directive, disposition).Inc()
+
+		logging.FromContext(reqCtx).WarnContext(reqCtx, "content security policy violation",
+			"directive", v.EffectiveDirective,
+			"disposition", disposition,
+			"document_url", stripQuery(v.DocumentURL),
+			"blocked_url", stripQuery(v.BlockedURL),
+			"source_file", stripQuery(v.SourceFile),
+			"line", v.LineNumber)
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// parseCSPReport reads the violations of a report in either format, skipping
+// the reports of the Reporting API that aren't CSP violations
+func parseCSPReport(body []byte) ([]cspViolation, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		var reports []struct {
+			Type string       `json:"type"`
+			Body cspViolation `json:"body"`
+		}
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, err
+		}
+		var violations []cspViolation
+		for _, report := range reports {
+			if report.Type == "csp-violation" {
+				violations = append(violations, report.Body)
+			}
+		}
+		return violations, nil
+	}
+
+	var report struct {
+		CSPReport *legacyCSPViolation `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, err
+	}
+	if report.CSPReport == nil {
+		return nil, errors.New("not a CSP violation report")
+	}
+	v := report.CSPReport
+	directive := v.EffectiveDirective
+	if directive == "" {
+		// Older browsers only send the violated directive, with its sources
+		directive, _, _ = strings.Cut(v.ViolatedDirective, " ")
+	}
+	return []cspViolation{{
+		DocumentURL:        v.DocumentURI,
+		BlockedURL:         v.BlockedURI,
+		EffectiveDirective: directive,
+		Disposition:        v.Disposition,
+		SourceFile:         v.SourceFile,
+		LineNumber:         v.LineNumber,
+	}}, nil
+}
+
+// stripQuery drops the query, fragment and credentials of a reported URL,
+// which may hold tokens or personal data. Keywords such as "inline" are kept.
+func stripQuery(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		raw, _, _ = strings.Cut(raw, "?")
+		return raw
+	}
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.User = nil
+	return u.String()
+}
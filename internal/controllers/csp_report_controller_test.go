@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"go-postgres-api/internal/logging"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseCSPReport(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []cspViolation
+		wantErr bool
+	}{
+		{
+			name: "report-uri",
+			body: `{"csp-report": {"document-uri": "https://app.example.com/page?token=x", "blocked-uri": "inline",
+				"effective-directive": "script-src-elem", "violated-directive": "script-src 'self'",
+				"disposition": "report", "source-file": "https://app.example.com/app.js", "line-number": 12}}`,
+			want: []cspViolation{{
+				DocumentURL: "https://app.example.com/page?token=x", BlockedURL: "inline", EffectiveDirective: "script-src-elem",
+				Disposition: "report", SourceFile: "https://app.example.com/app.js", LineNumber: 12,
+			}},
+		},
+		{
+			name: "report-uri with the violated directive only",
+			body: `{"csp-report": {"document-uri": "https://app.example.com/", "violated-directive": "img-src 'self' data:"}}`,
+			want: []cspViolation{{DocumentURL: "https://app.example.com/", EffectiveDirective: "img-src"}},
+		},
+		{
+			name: "report-to",
+			body: ` [{"type": "csp-violation", "url": "https://app.example.com/", "body": {"documentURL": "https://app.example.com/",
+				"blockedURL": "https://cdn.example.net/x.js", "effectiveDirective": "script-src-elem", "disposition": "enforce", "lineNumber": 3}},
+				{"type": "csp-violation", "body": {"documentURL": "https://app.example.com/", "effectiveDirective": "frame-ancestors"}}]`,
+			want: []cspViolation{
+				{DocumentURL: "https://app.example.com/", BlockedURL: "https://cdn.example.net/x.js", EffectiveDirective: "script-src-elem", Disposition: "enforce", LineNumber: 3},
+				{DocumentURL: "https://app.example.com/", EffectiveDirective: "frame-ancestors"},
+			},
+		},
+		{
+			name: "report-to with other report types",
+			body: `[{"type": "deprecation", "body": {"id": "X", "message": "old"}},
+				{"type": "csp-violation", "body": {"effectiveDirective": "style-src"}},
+				{"type": "intervention", "body": {"id": "Y"}}]`,
+			want: []cspViolation{{EffectiveDirective: "style-src"}},
+		},
+		{"report-to without CSP violations", `[{"type": "deprecation", "body": {}}]`, nil, false},
+		{"empty list", `[]`, nil, false},
+		{"other object", `{"type": "csp-violation"}`, nil, true},
+		{"invalid JSON", `{"csp-report": `, nil, true},
+		{"invalid list", `[{"type": 1}]`, nil, true},
+		{"empty body", ``, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCSPReport([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseCSPReport = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCSPReport: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCSPReport = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var logs strings.Builder
+	router.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), slog.New(slog.NewTextHandler(&logs, nil))))
+	})
+	router.POST("/csp-reports", NewCSPReportController().Report)
+
+	report := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/csp-reports", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/csp-report")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	body := `{"csp-report": {"document-uri": "https://user:pw@app.example.com/reset?token=secret#top",
+		"blocked-uri": "https://cdn.example.net/x.js?session=abc", "effective-directive": "script-src-elem"}}`
+	if status := report(body); status != http.StatusNoContent {
+		t.Fatalf("report: status %d, want 204", status)
+	}
+	// Reported URLs are logged without queries, fragments or credentials
+	for _, leaked := range []string{"secret", "session=abc", "pw@", "#top"} {
+		if strings.Contains(logs.String(), leaked) {
+			t.Errorf("log contains %q: %s", leaked, logs.String())
+		}
+	}
+	if !strings.Contains(logs.String(), "document_url=https://app.example.com/reset") {
+		t.Errorf("log misses the document URL: %s", logs.String())
+	}
+
+	if status := report(`[{"type": "deprecation", "body": {}}]`); status != http.StatusNoContent {
+		t.Errorf("non-CSP reports: status %d, want 204", status)
+	}
+	if status := report(`{"not": "a report"}`); status != http.StatusBadRequest {
+		t.Errorf("other JSON: status %d, want 400", status)
+	}
+
+	oversized := `[{"type": "csp-violation", "body": {"sourceFile": "` + strings.Repeat("x", maxCSPReportBytes) + `"}}]`
+	if status := report(oversized); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized report: status %d, want 413", status)
+	}
+	if status := report(strings.Repeat(" ", maxCSPReportBytes-2) + "[]"); status != http.StatusNoContent {
+		t.Errorf("report of the largest size: status %d, want 204", status)
+	}
+}
+
+func TestStripQuery(t *testing.T) {
+	tests := map[string]string{
+		"https://app.example.com/a?b=c#d":      "https://app.example.com/a",
+		"https://user:pw@app.example.com/a":    "https://app.example.com/a",
+		"https://app.example.com/a?":           "https://app.example.com/a",
+		"inline":                               "inline",
+		"eval":                                 "eval",
+		"/relative/path?token=x":               "/relative/path",
+		"":                                     "",
+		"data:image/png;base64,AAAA":           "data:image/png;base64,AAAA",
+		"chrome-extension://abc/script.js?x=1": "chrome-extension://abc/script.js",
+	}
+	for raw, want := range tests {
+		if got := stripQuery(raw); got != want {
+			t.Errorf("stripQuery(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
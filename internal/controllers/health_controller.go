@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"context"
+	"go-postgres-api/internal/health"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthChecker runs the checks behind a probe
+type HealthChecker interface {
+	Run(ctx context.Context) *health.Report
+}
+
+// HealthController serves the liveness and readiness probes
+type HealthController struct {
+	liveness  HealthChecker
+	readiness HealthChecker
+}
+
+// NewHealthController creates a new health controller
+func NewHealthController(liveness, readiness HealthChecker) *HealthController {
+	return &HealthController{
+		liveness:  liveness,
+		readiness: readiness,
+	}
+}
+
+// Livez reports whether the process is healthy and shouldn't be restarted
+func (c *HealthController) Livez(ctx *gin.Context) {
+	respondReport(ctx, c.liveness.Run(ctx.Request.Context()))
+}
+
+// Readyz reports whether the instance can serve traffic
+func (c *HealthController) Readyz(ctx *gin.Context) {
+	respondReport(ctx, c.readiness.Run(ctx.Request.Context()))
+}
+
+// respondReport writes a health report, with 503 when the probe fails
+func respondReport(ctx *gin.Context, report *health.Report) {
+	status := http.StatusOK
+	if !report.Healthy() {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, report)
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"go-postgres-api/internal/middleware"
+	"go-postgres-api/internal/models"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PasskeyService is the passkey logic used by PasskeyController
+type PasskeyService interface {
+	BeginRegistration(ctx context.Context, userID uint) (*models.PasskeyBeginResponse, error)
+	FinishRegistration(ctx context.Context, userID uint, req *models.PasskeyFinishRequest, ipAddress, userAgent string) (*models.WebAuthnCredential, error)
+	BeginLogin(ctx context.Context, email string) (*models.PasskeyBeginResponse, error)
+	FinishLogin(ctx context.Context, req *models.PasskeyFinishRequest, ipAddress, userAgent string) (*models.AuthResponse, error)
+	ListCredentials(ctx context.Context, userID uint) ([]models.WebAuthnCredential, error)
+	DeleteCredential(ctx context.Context, userID, credentialID uint) error
+}
+
+// PasskeyController handles WebAuthn passkey requests
+type PasskeyController struct {
+	webAuthnService PasskeyService
+	cookies         *middleware.AuthCookies
+}
+
+// NewPasskeyController creates a new passkey controller
+func NewPasskeyController(webAuthnService PasskeyService, cookies *middleware.AuthCookies) *PasskeyController {
+	return &PasskeyController{
+		webAuthnService: webAuthnService,
+		cookies:         cookies,
+	}
+}
+
+// BeginRegistration returns the creation options for a new passkey
+func (c *PasskeyController) BeginRegistration(ctx *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		middleware.RespondError(ctx, errors.New("user ID not found in context"))
+		return
+	}
+
+	response, err := c.webAuthnService.BeginRegistration(ctx.Request.Context(), userID.(uint))
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// FinishRegistration verifies and stores a new passkey
+func (c *PasskeyController) FinishRegistration(ctx *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		middleware.RespondError(ctx, errors.New("user ID not found in context"))
+		return
+	}
+
+	var req models.PasskeyFinishRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	credential, err := c.webAuthnService.FinishRegistration(ctx.Request.Context(), userID.(uint), &req, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, credential)
+}
+
+// BeginLogin returns the request options for a passkey login
+func (c *PasskeyController) BeginLogin(ctx *gin.Context) {
+	var req models.PasskeyLoginBeginRequest
+	// An empty body starts a discoverable login
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	response, err := c.webAuthnService.BeginLogin(ctx.Request.Context(), req.Email)
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// FinishLogin verifies a passkey assertion and returns JWT tokens
+func (c *PasskeyController) FinishLogin(ctx *gin.Context) {
+	var req models.PasskeyFinishRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	response, err := c.webAuthnService.FinishLogin(ctx.Request.Context(), &req, ctx.ClientIP(), ctx.GetHeader("User-Agent"))
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	respondTokens(ctx, c.cookies, response)
+}
+
+// ListPasskeys returns the authenticated user's passkeys
+func (c *PasskeyController) ListPasskeys(ctx *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		middleware.RespondError(ctx, errors.New("user ID not found in context"))
+		return
+	}
+
+	credentials, err := c.webAuthnService.ListCredentials(ctx.Request.Context(), userID.(uint))
+	if err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, credentials)
+}
+
+// DeletePasskey removes one of the authenticated user's passkeys
+func (c *PasskeyController) DeletePasskey(ctx *gin.Context) {
+	// Get user ID from context (set by auth middleware)
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		middleware.RespondError(ctx, errors.New("user ID not found in context"))
+		return
+	}
+
+	credentialID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		middleware.AbortWithFieldError(ctx, "id", "uint", "invalid passkey ID")
+		return
+	}
+
+	if err := c.webAuthnService.DeleteCredential(ctx.Request.Context(), userID.(uint), uint(credentialID)); err != nil {
+		middleware.RespondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, models.SuccessResponse{Message: "passkey deleted successfully"})
+}
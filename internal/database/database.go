@@ -1,76 +1,187 @@
 package database
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"log"
+	"go-postgres-api/internal/config"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"time"
 
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-// DB is the database connection
-var DB *gorm.DB
-
-// Connect establishes a connection to the MySQL database
-func Connect() (*gorm.DB, error) {
-	// Get database connection details from environment variables
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-
-	// Check if required environment variables are set
-	if dbHost == "" || dbUser == "" || dbName == "" || dbPort == "" {
-		log.Println("[error] Database connection parameters are missing")
-		log.Printf("DB_HOST=%s, DB_USER=%s, DB_NAME=%s, DB_PORT=%s", dbHost, dbUser, dbName, dbPort)
-		return nil, fmt.Errorf("database connection parameters are missing")
-	}
+// Supported database drivers
+const (
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+	DriverSQLite   = "sqlite"
+)
 
-	// Construct the DSN (Data Source Name) for MySQL
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		dbUser, dbPassword, dbHost, dbPort, dbName)
+// mysqlTLSConfigName is the name the custom CA configuration is registered under
+const mysqlTLSConfigName = "custom"
 
-	// Configure GORM logger
-	gormLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags),
-		logger.Config{
-			LogLevel:                  logger.Info,
-			IgnoreRecordNotFoundError: true,
-			Colorful:                  true,
-		},
-	)
+// Connect establishes a connection to the configured database
+func Connect(cfg *config.Config) (*gorm.DB, error) {
+	dialector, err := Dialector(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	// Open connection to the database
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: gormLogger,
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: gormLogger{slowThreshold: cfg.SlowQueryThreshold},
+		// Report unique violations as gorm.ErrDuplicatedKey regardless of the driver
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	// Set the global DB variable
-	DB = db
+	// Trace every statement as a child of the caller's span
+	if err := db.Use(tracingPlugin{system: cfg.DBDriver}); err != nil {
+		return nil, fmt.Errorf("failed to set up query tracing: %w", err)
+	}
 
-	log.Println("Connected to MySQL database")
+	slog.Info("connected to database", "driver", cfg.DBDriver)
 	return db, nil
 }
 
-// GetDB returns the database connection
-func GetDB() *gorm.DB {
-	return DB
+// Dialector returns the gorm dialector for the configured driver
+func Dialector(cfg *config.Config) (gorm.Dialector, error) {
+	switch cfg.DBDriver {
+	case DriverSQLite:
+		if cfg.DBName == "" {
+			return nil, fmt.Errorf("database connection parameters are missing: DB_NAME must be the sqlite file path")
+		}
+		return sqlite.Open(SQLiteDSN(cfg.DBName)), nil
+
+	case DriverPostgres, DriverMySQL:
+		// Check if required parameters are set
+		if cfg.DBHost == "" || cfg.DBUser == "" || cfg.DBName == "" || cfg.DBPort == "" {
+			slog.Error("database connection parameters are missing",
+				"host", cfg.DBHost, "user", cfg.DBUser, "name", cfg.DBName, "port", cfg.DBPort)
+			return nil, fmt.Errorf("database connection parameters are missing")
+		}
+
+		if cfg.DBDriver == DriverPostgres {
+			return postgres.Open(PostgresDSN(cfg)), nil
+		}
+
+		dsn, err := MySQLDSN(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return mysql.Open(dsn), nil
+	}
+
+	return nil, fmt.Errorf("unsupported database driver %q", cfg.DBDriver)
 }
 
-// Close closes the database connection
-func Close() error {
-	if DB != nil {
-		sqlDB, err := DB.DB()
+// PostgresDSN builds a postgres:// connection URL
+func PostgresDSN(cfg *config.Config) string {
+	query := url.Values{}
+	query.Set("sslmode", cfg.DBSSLMode)
+	if cfg.DBSSLRootCert != "" {
+		query.Set("sslrootcert", cfg.DBSSLRootCert)
+	}
+	query.Set("TimeZone", "UTC")
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.DBUser, cfg.DBPassword),
+		Host:     net.JoinHostPort(cfg.DBHost, cfg.DBPort),
+		Path:     "/" + cfg.DBName,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
+// MySQLDSN builds a MySQL DSN, mapping the postgres-style SSL modes onto the
+// driver's tls parameter
+func MySQLDSN(cfg *config.Config) (string, error) {
+	dsn := mysqldriver.NewConfig()
+	dsn.User = cfg.DBUser
+	dsn.Passwd = cfg.DBPassword
+	dsn.Net = "tcp"
+	dsn.Addr = net.JoinHostPort(cfg.DBHost, cfg.DBPort)
+	dsn.DBName = cfg.DBName
+	dsn.ParseTime = true
+	// DATETIME columns carry no zone; read and write them in UTC like the
+	// postgres connection, whatever the host's zone
+	dsn.Loc = time.UTC
+	dsn.Params = map[string]string{"charset": "utf8mb4"}
+
+	switch cfg.DBSSLMode {
+	case "disable":
+		dsn.TLSConfig = "false"
+	case "prefer":
+		dsn.TLSConfig = "preferred"
+	case "require":
+		dsn.TLSConfig = "skip-verify"
+	case "verify-ca", "verify-full":
+		dsn.TLSConfig = "true"
+	default:
+		return "", fmt.Errorf("unsupported DB_SSLMODE %q", cfg.DBSSLMode)
+	}
+
+	// Verify the server against a custom CA
+	if cfg.DBSSLRootCert != "" && dsn.TLSConfig == "true" {
+		pem, err := os.ReadFile(cfg.DBSSLRootCert)
 		if err != nil {
-			return err
+			return "", fmt.Errorf("failed to read DB_SSLROOTCERT: %w", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return "", fmt.Errorf("DB_SSLROOTCERT contains no certificates")
+		}
+		tlsConfig := &tls.Config{RootCAs: rootCAs, ServerName: cfg.DBHost}
+		if cfg.DBSSLMode == "verify-ca" {
+			// Check the chain but not the host name
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyPeerCertificate = verifyChainOnly(rootCAs)
+		}
+		if err := mysqldriver.RegisterTLSConfig(mysqlTLSConfigName, tlsConfig); err != nil {
+			return "", err
+		}
+		dsn.TLSConfig = mysqlTLSConfigName
+	}
+
+	return dsn.FormatDSN(), nil
+}
+
+// SQLiteDSN builds a SQLite DSN with foreign keys enforced and a busy timeout
+// so concurrent writers wait instead of failing
+func SQLiteDSN(path string) string {
+	return path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+}
+
+// verifyChainOnly verifies the server certificate chain against rootCAs without checking the host name
+func verifyChainOnly(rootCAs *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server presented no certificate")
+		}
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
 		}
-		return sqlDB.Close()
+		_, err := certs[0].Verify(x509.VerifyOptions{Roots: rootCAs, Intermediates: intermediates})
+		return err
 	}
-	return nil
 }
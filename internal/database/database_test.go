@@ -0,0 +1,76 @@
+package database
+
+import (
+	"go-postgres-api/internal/config"
+	"net/url"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+func testDBConfig(sslMode string) *config.Config {
+	return &config.Config{
+		DBHost:     "db.internal",
+		DBPort:     "3306",
+		DBUser:     "api",
+		DBPassword: "p@ss:word/",
+		DBName:     "auth",
+		DBSSLMode:  sslMode,
+	}
+}
+
+func TestMySQLDSN(t *testing.T) {
+	tests := map[string]string{
+		"disable":   "false",
+		"prefer":    "preferred",
+		"require":   "skip-verify",
+		"verify-ca": "true",
+	}
+	for sslMode, wantTLS := range tests {
+		dsn, err := MySQLDSN(testDBConfig(sslMode))
+		if err != nil {
+			t.Fatalf("MySQLDSN(%s): %v", sslMode, err)
+		}
+		parsed, err := mysqldriver.ParseDSN(dsn)
+		if err != nil {
+			t.Fatalf("ParseDSN(%s): %v", dsn, err)
+		}
+
+		if parsed.Loc != time.UTC {
+			t.Errorf("%s: loc = %v, want UTC", sslMode, parsed.Loc)
+		}
+		if !parsed.ParseTime {
+			t.Errorf("%s: parseTime is off", sslMode)
+		}
+		if parsed.TLSConfig != wantTLS {
+			t.Errorf("%s: tls = %q, want %q", sslMode, parsed.TLSConfig, wantTLS)
+		}
+		if parsed.User != "api" || parsed.Passwd != "p@ss:word/" || parsed.Addr != "db.internal:3306" || parsed.DBName != "auth" {
+			t.Errorf("%s: parsed %+v, want the configured connection", sslMode, parsed)
+		}
+	}
+
+	if _, err := MySQLDSN(testDBConfig("sometimes")); err == nil {
+		t.Error("MySQLDSN accepted an unknown SSL mode")
+	}
+}
+
+func TestPostgresDSN(t *testing.T) {
+	cfg := testDBConfig("verify-full")
+	cfg.DBPort = "5432"
+	cfg.DBSSLRootCert = "/etc/ssl/ca.pem"
+
+	parsed, err := url.Parse(PostgresDSN(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := parsed.User.Password()
+	if parsed.Host != "db.internal:5432" || parsed.Path != "/auth" || parsed.User.Username() != "api" || password != "p@ss:word/" {
+		t.Errorf("PostgresDSN = %s, want the configured connection", parsed)
+	}
+	query := parsed.Query()
+	if query.Get("TimeZone") != "UTC" || query.Get("sslmode") != "verify-full" || query.Get("sslrootcert") != "/etc/ssl/ca.pem" {
+		t.Errorf("PostgresDSN query = %v", query)
+	}
+}
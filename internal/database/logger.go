@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-postgres-api/internal/logging"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// gormLogger writes gorm's logs to the logger of the query's context, so they
+// carry the request ID. Failed and slow queries are logged; every statement
+// only at debug level. Statements keep their placeholders, as the bound values
+// include password hashes and tokens.
+type gormLogger struct {
+	slowThreshold time.Duration // zero disables slow query logging
+}
+
+var (
+	_ logger.Interface  = gormLogger{}
+	_ gorm.ParamsFilter = gormLogger{}
+)
+
+// LogMode is a no-op; the level is the one of the slog logger
+func (l gormLogger) LogMode(logger.LogLevel) logger.Interface {
+	return l
+}
+
+// Info logs a gorm message at info level
+func (l gormLogger) Info(ctx context.Context, msg string, data ...any) {
+	logging.FromContext(ctx).InfoContext(ctx, fmt.Sprintf(msg, data...))
+}
+
+// Warn logs a gorm message at warn level
+func (l gormLogger) Warn(ctx context.Context, msg string, data ...any) {
+	logging.FromContext(ctx).WarnContext(ctx, fmt.Sprintf(msg, data...))
+}
+
+// Error logs a gorm message at error level
+func (l gormLogger) Error(ctx context.Context, msg string, data ...any) {
+	logging.FromContext(ctx).ErrorContext(ctx, fmt.Sprintf(msg, data...))
+}
+
+// Trace logs a finished statement
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	log := logging.FromContext(ctx)
+	elapsed := time.Since(begin)
+
+	// Missing records and unique violations are expected and handled by the repositories
+	expected := errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, gorm.ErrDuplicatedKey)
+
+	level, msg := slog.LevelDebug, "query"
+	switch {
+	case err != nil && !expected:
+		level, msg = slog.LevelError, "query failed"
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold:
+		level, msg = slog.LevelWarn, "slow query"
+	}
+	if !log.Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []any{"sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds()}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	log.Log(ctx, level, msg, attrs...)
+}
+
+// ParamsFilter drops the values bound to a statement before it is logged
+func (l gormLogger) ParamsFilter(_ context.Context, sql string, _ ...any) (string, []any) {
+	return sql, nil
+}
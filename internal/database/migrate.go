@@ -0,0 +1,435 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the numbered up/down SQL migrations for each driver under
+// migrations/<driver>, e.g. 0002_add_users_last_login.up.sql and
+// 0002_add_users_last_login.down.sql. Every driver must have the same versions.
+// Statements are separated by a semicolon at the end of a line.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migrationLockName identifies the advisory lock that serialises migrations across replicas
+const migrationLockName = "schema_migrations"
+
+// migrationLockKey is the postgres advisory lock key, which must be an integer
+const migrationLockKey = 727465743
+
+// migrationLockTimeout is how long to wait for another replica to finish migrating
+const migrationLockTimeout = 60 * time.Second
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a numbered schema change with its up and down SQL
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	Applied          bool
+	AppliedAt        *time.Time
+	ChecksumMismatch bool
+}
+
+// appliedMigration is a row of the schema_migrations table
+type appliedMigration struct {
+	Version   int64
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrator applies the embedded SQL migrations and records them in schema_migrations
+type Migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the embedded migrations of driver
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+	switch driver {
+	case DriverPostgres, DriverMySQL, DriverSQLite:
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+
+	migrations, err := loadMigrations(migrationFiles, path.Join("migrations", driver))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, driver: driver, migrations: migrations}, nil
+}
+
+// loadMigrations reads and pairs the up/down files in dir
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+			sum := sha256.Sum256(content)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// LatestVersion returns the version of the newest embedded migration
+func (m *Migrator) LatestVersion() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// CurrentVersion returns the version of the newest applied migration
+func (m *Migrator) CurrentVersion(ctx context.Context) (int64, error) {
+	var version sql.NullInt64
+	err := m.db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version.Int64, nil
+}
+
+// Up applies up to steps pending migrations, or all of them when steps <= 0.
+// It returns the migrations that were applied.
+func (m *Migrator) Up(ctx context.Context, steps int) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verifyChecksums(done); err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if steps > 0 && len(applied) == steps {
+				break
+			}
+
+			if err := m.apply(ctx, conn, migration, migration.Up, true); err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the last steps applied migrations (at least one).
+// It returns the migrations that were rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	var rolledBack []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := m.appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verifyChecksums(done); err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+			}
+
+			if err := m.apply(ctx, conn, migration, migration.Down, false); err != nil {
+				return err
+			}
+			rolledBack = append(rolledBack, migration)
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Status lists every embedded migration and whether it has been applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	done, err := m.appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Migration: migration}
+		if row, ok := done[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+			status.ChecksumMismatch = row.Checksum != migration.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// withLock runs fn on a dedicated connection holding the migration lock, so
+// replicas starting at the same time don't apply migrations concurrently
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// lock acquires the migration lock on conn and returns a function releasing it.
+// SQLite needs no lock since a database file has a single writer.
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	switch m.driver {
+	case DriverPostgres:
+		// pg_advisory_lock has no timeout of its own, so bound the wait with the context
+		lockCtx, cancel := context.WithTimeout(ctx, migrationLockTimeout)
+		defer cancel()
+		if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			if errors.Is(lockCtx.Err(), context.DeadlineExceeded) {
+				return nil, errors.New("timed out waiting for the migration lock held by another instance")
+			}
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		}, nil
+
+	case DriverMySQL:
+		var acquired sql.NullInt64
+		err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, int(migrationLockTimeout.Seconds())).Scan(&acquired)
+		if err != nil {
+			return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		if acquired.Int64 != 1 {
+			return nil, errors.New("timed out waiting for the migration lock held by another instance")
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLockName)
+		}, nil
+	}
+
+	return func() {}, nil
+}
+
+// ensureTable creates the schema_migrations table if it doesn't exist
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	timestampType := "DATETIME(3)"
+	switch m.driver {
+	case DriverPostgres:
+		timestampType = "TIMESTAMPTZ"
+	case DriverSQLite:
+		timestampType = "DATETIME"
+	}
+
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT NOT NULL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    applied_at `+timestampType+` NOT NULL
+)`)
+	return err
+}
+
+// appliedMigrations returns the rows of schema_migrations keyed by version
+func (m *Migrator) appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var row appliedMigration
+		if err := rows.Scan(&row.Version, &row.Checksum, &row.AppliedAt); err != nil {
+			return nil, err
+		}
+		done[row.Version] = row
+	}
+	return done, rows.Err()
+}
+
+// verifyChecksums refuses to continue when an applied migration was edited or removed
+func (m *Migrator) verifyChecksums(done map[int64]appliedMigration) error {
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, row := range done {
+		migration, ok := known[version]
+		if !ok {
+			return fmt.Errorf("migration %d is applied but no longer exists", version)
+		}
+		if row.Checksum != migration.Checksum {
+			return fmt.Errorf("migration %d_%s was modified after it was applied", version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// apply runs a migration's SQL and records (up) or removes (down) its schema_migrations row.
+// Statements run in a transaction; PostgreSQL and SQLite roll back DDL with it, while
+// MySQL commits DDL statements implicitly.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, script string, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, m.rebind("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"),
+			migration.Version, migration.Name, migration.Checksum, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, m.rebind("DELETE FROM schema_migrations WHERE version = ?"), migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// rebind rewrites ? placeholders as $1, $2, ... for postgres
+func (m *Migrator) rebind(query string) string {
+	if m.driver != DriverPostgres {
+		return query
+	}
+
+	var (
+		rebound strings.Builder
+		n       int
+	)
+	for _, r := range query {
+		if r == '?' {
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rebound.WriteRune(r)
+	}
+	return rebound.String()
+}
+
+// splitStatements splits a script on semicolons that end a line, dropping comment lines
+func splitStatements(script string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+	)
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
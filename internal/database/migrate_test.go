@@ -0,0 +1,307 @@
+package database
+
+import (
+	"context"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/repositories"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The models as they were when AutoMigrate created the schema, before migrations
+type (
+	baselineUser struct {
+		ID         uint         `gorm:"primaryKey"`
+		Email      string       `gorm:"unique;not null"`
+		Name       string       `gorm:"not null"`
+		Password   string       `gorm:"not null"`
+		IsVerified bool         `gorm:"default:false"`
+		IsActive   bool         `gorm:"default:true"`
+		RoleID     uint         `gorm:"not null;default:2"`
+		Role       baselineRole `gorm:"foreignKey:RoleID"`
+		CreatedAt  time.Time
+		UpdatedAt  time.Time
+	}
+	baselineRole struct {
+		ID        uint   `gorm:"primaryKey"`
+		RoleType  string `gorm:"not null"`
+		CreatedAt time.Time
+		UserID    uint `gorm:"index"`
+	}
+	baselineAuthLog struct {
+		ID           uint `gorm:"primaryKey"`
+		UserID       *uint
+		Action       string `gorm:"not null"`
+		IPAddress    string
+		UserAgent    string
+		Success      bool `gorm:"not null"`
+		ErrorMessage string
+		CreatedAt    time.Time
+	}
+	baselineTokenBlacklist struct {
+		ID        uint   `gorm:"primaryKey"`
+		TokenJTI  string `gorm:"type:varchar(255);uniqueIndex;not null"`
+		UserID    uint
+		ExpiresAt time.Time `gorm:"not null"`
+		CreatedAt time.Time
+	}
+	baselineEmailVerificationToken struct {
+		ID        uint      `gorm:"primaryKey"`
+		UserID    uint      `gorm:"not null"`
+		Token     string    `gorm:"type:varchar(255);uniqueIndex;not null"`
+		ExpiresAt time.Time `gorm:"not null"`
+		Used      bool      `gorm:"default:false"`
+		CreatedAt time.Time
+	}
+	baselineRefreshToken struct {
+		ID        uint      `gorm:"primaryKey"`
+		UserID    uint      `gorm:"not null"`
+		Token     string    `gorm:"type:varchar(255);uniqueIndex;not null"`
+		ExpiresAt time.Time `gorm:"not null"`
+		Used      bool      `gorm:"default:false"`
+		CreatedAt time.Time
+	}
+)
+
+func (baselineUser) TableName() string                   { return "users" }
+func (baselineRole) TableName() string                   { return "roles" }
+func (baselineAuthLog) TableName() string                { return "auth_logs" }
+func (baselineTokenBlacklist) TableName() string         { return "token_blacklists" }
+func (baselineEmailVerificationToken) TableName() string { return "email_verification_tokens" }
+func (baselineRefreshToken) TableName() string           { return "refresh_tokens" }
+
+// openSQLite opens a new SQLite database in a temporary directory
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	db, err := gorm.Open(sqlite.Open(SQLiteDSN(path)), &gorm.Config{
+		Logger:         logger.Discard,
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+// migrate applies every pending migration to db
+func migrate(t *testing.T, db *gorm.DB) *Migrator {
+	t.Helper()
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := NewMigrator(sqlDB, DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background(), 0); err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+	return migrator
+}
+
+// assertSchemaMatchesModels fails unless db has a column for every field of the models
+func assertSchemaMatchesModels(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	for _, model := range []any{
+		&models.User{}, &models.Role{}, &models.AuthLog{}, &models.TokenBlacklist{},
+		&models.EmailVerificationToken{}, &models.RefreshToken{},
+		&models.WebAuthnCredential{}, &models.WebAuthnSession{},
+	} {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("%s.%s is missing", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+	for _, table := range []string{"user_roles", "rate_limit_counters"} {
+		if !db.Migrator().HasTable(table) {
+			t.Errorf("table %s is missing", table)
+		}
+	}
+}
+
+func TestMigrationsUpgradeAutoMigrateSchema(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	err := db.AutoMigrate(&baselineRole{}, &baselineUser{}, &baselineAuthLog{}, &baselineTokenBlacklist{},
+		&baselineEmailVerificationToken{}, &baselineRefreshToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Data written by a build that predates migrations
+	expires := time.Now().Add(time.Hour)
+	if err := db.Create(&baselineRole{ID: 2, RoleType: "user"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	user := baselineUser{Email: " Alice@Example.com", Name: "Alice", Password: "hash", IsVerified: true}
+	if err := db.Omit("Role").Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&baselineEmailVerificationToken{UserID: user.ID, Token: "verify", ExpiresAt: expires}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&baselineRefreshToken{UserID: user.ID, Token: "refresh", ExpiresAt: expires}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	migrator := migrate(t, db)
+
+	version, err := migrator.CurrentVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != migrator.LatestVersion() {
+		t.Fatalf("version = %d, want %d", version, migrator.LatestVersion())
+	}
+	assertSchemaMatchesModels(t, db)
+
+	// The existing rows are usable through the repository
+	repo := repositories.NewUserRepository(db)
+	found, err := repo.FindByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("FindByEmail: %v", err)
+	}
+	if found.ID != user.ID || found.Email != "alice@example.com" {
+		t.Fatalf("FindByEmail = %d %q, want %d %q", found.ID, found.Email, user.ID, "alice@example.com")
+	}
+	if err := repo.AddRole(ctx, user.ID, "admin"); err != nil {
+		t.Fatalf("AddRole: %v", err)
+	}
+
+	token, err := repo.FindEmailVerificationToken(ctx, "verify", models.TokenPurposeEmailVerification)
+	if err != nil {
+		t.Fatalf("FindEmailVerificationToken: %v", err)
+	}
+	if token.UserID != user.ID {
+		t.Fatalf("email token user = %d, want %d", token.UserID, user.ID)
+	}
+
+	refresh, err := repo.FindRefreshToken(ctx, "refresh")
+	if err != nil {
+		t.Fatalf("FindRefreshToken: %v", err)
+	}
+	if refresh.ClientID != "default" || refresh.SessionID != "" {
+		t.Fatalf("refresh token client %q session %q, want default client and no session", refresh.ClientID, refresh.SessionID)
+	}
+	if err := repo.UpdateRefreshTokenAccessToken(ctx, refresh.ID, "jti", expires); err != nil {
+		t.Fatalf("UpdateRefreshTokenAccessToken: %v", err)
+	}
+
+	credential := &models.WebAuthnCredential{UserID: user.ID, CredentialID: []byte{1, 2, 3}, PublicKey: []byte{4}}
+	if err := repo.CreateWebAuthnCredential(ctx, credential); err != nil {
+		t.Fatalf("CreateWebAuthnCredential: %v", err)
+	}
+}
+
+// appTables are tables the migrations create
+var appTables = []string{
+	"roles", "users", "user_roles", "auth_logs", "token_blacklists", "email_verification_tokens",
+	"refresh_tokens", "web_authn_credentials", "web_authn_sessions", "rate_limit_counters",
+}
+
+func TestMigrationsUpDownUp(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+
+	migrator := migrate(t, db)
+	assertSchemaMatchesModels(t, db)
+
+	rolledBack, err := migrator.Down(ctx, len(migrator.migrations))
+	if err != nil {
+		t.Fatalf("migrating down: %v", err)
+	}
+	if len(rolledBack) != len(migrator.migrations) {
+		t.Fatalf("rolled back %d migrations, want %d", len(rolledBack), len(migrator.migrations))
+	}
+	if version, err := migrator.CurrentVersion(ctx); err != nil || version != 0 {
+		t.Fatalf("version after down = %d, %v, want 0", version, err)
+	}
+	for _, table := range appTables {
+		if db.Migrator().HasTable(table) {
+			t.Errorf("table %s remains after migrating down", table)
+		}
+	}
+
+	migrate(t, db)
+	assertSchemaMatchesModels(t, db)
+}
+
+func TestMigrationsDownAndUpOneAtATime(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	migrator := migrate(t, db)
+
+	// Every migration can be rolled back and reapplied on top of the ones before it
+	for i := len(migrator.migrations) - 1; i >= 0; i-- {
+		migration := migrator.migrations[i]
+		if _, err := migrator.Down(ctx, 1); err != nil {
+			t.Fatalf("rolling back %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		if _, err := migrator.Up(ctx, 1); err != nil {
+			t.Fatalf("reapplying %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		if _, err := migrator.Down(ctx, 1); err != nil {
+			t.Fatalf("rolling back %d_%s again: %v", migration.Version, migration.Name, err)
+		}
+		if version, err := migrator.CurrentVersion(ctx); err != nil || version >= migration.Version {
+			t.Fatalf("version after rolling back %d = %d, %v", migration.Version, version, err)
+		}
+	}
+
+	migrate(t, db)
+	assertSchemaMatchesModels(t, db)
+}
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	var reference *Migrator
+	for _, driver := range []string{DriverSQLite, DriverPostgres, DriverMySQL} {
+		migrator, err := NewMigrator(nil, driver)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		for _, migration := range migrator.migrations {
+			if len(splitStatements(migration.Up)) == 0 {
+				t.Errorf("%s: migration %d_%s has no up statements", driver, migration.Version, migration.Name)
+			}
+			if migration.Down == "" {
+				t.Errorf("%s: migration %d_%s has no down file", driver, migration.Version, migration.Name)
+			}
+		}
+
+		if reference == nil {
+			reference = migrator
+			continue
+		}
+		if len(migrator.migrations) != len(reference.migrations) {
+			t.Fatalf("%s has %d migrations, %s has %d", driver, len(migrator.migrations), reference.driver, len(reference.migrations))
+		}
+		for i, migration := range migrator.migrations {
+			want := reference.migrations[i]
+			if migration.Version != want.Version || migration.Name != want.Name {
+				t.Errorf("%s migration %d_%s, %s has %d_%s", driver, migration.Version, migration.Name,
+					reference.driver, want.Version, want.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+-- Initial schema, exactly as AutoMigrate created it before migrations were introduced.
+-- Tables are created only if missing so databases set up by AutoMigrate can adopt
+-- migrations; everything added since is in the later migrations.
+
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    role_type LONGTEXT NOT NULL,
+    created_at DATETIME(3) NULL,
+    user_id BIGINT UNSIGNED NULL,
+    PRIMARY KEY (id),
+    INDEX idx_roles_user_id (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    email VARCHAR(191) NOT NULL,
+    name LONGTEXT NOT NULL,
+    password LONGTEXT NOT NULL,
+    is_verified BOOLEAN DEFAULT FALSE,
+    is_active BOOLEAN DEFAULT TRUE,
+    role_id BIGINT UNSIGNED NOT NULL DEFAULT 2,
+    created_at DATETIME(3) NULL,
+    updated_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    CONSTRAINT uni_users_email UNIQUE (email),
+    CONSTRAINT fk_users_role FOREIGN KEY (role_id) REFERENCES roles (id)
+);
+
+CREATE TABLE IF NOT EXISTS auth_logs (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED NULL,
+    action LONGTEXT NOT NULL,
+    ip_address LONGTEXT NULL,
+    user_agent LONGTEXT NULL,
+    success BOOLEAN NOT NULL,
+    error_message LONGTEXT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS token_blacklists (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    token_jti VARCHAR(255) NOT NULL,
+    user_id BIGINT UNSIGNED NULL,
+    expires_at DATETIME(3) NOT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_token_blacklists_token_jti (token_jti)
+);
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    expires_at DATETIME(3) NOT NULL,
+    used BOOLEAN DEFAULT FALSE,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_email_verification_tokens_token (token)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    expires_at DATETIME(3) NOT NULL,
+    used BOOLEAN DEFAULT FALSE,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_refresh_tokens_token (token)
+);
@@ -0,0 +1,2 @@
+-- UpdateLastLogin has always written this column, but it never existed.
+ALTER TABLE users ADD COLUMN last_login DATETIME(3) NULL;
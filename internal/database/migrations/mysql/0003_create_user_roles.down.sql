@@ -0,0 +1,2 @@
+-- The seeded roles are kept, users.role_id still refers to them.
+DROP TABLE IF EXISTS user_roles;
@@ -0,0 +1,14 @@
+-- AddRole has always written to this table, but it never existed.
+-- Roles 1 and 2 are the admin and user roles that users.role_id refers to.
+INSERT IGNORE INTO roles (id, role_type, created_at) VALUES
+    (1, 'admin', NOW(3)),
+    (2, 'user', NOW(3));
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id BIGINT UNSIGNED NOT NULL,
+    role_id BIGINT UNSIGNED NOT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (user_id, role_id),
+    CONSTRAINT fk_user_roles_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_user_roles_role FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE
+);
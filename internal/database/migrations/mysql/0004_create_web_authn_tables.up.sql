@@ -0,0 +1,34 @@
+-- Passkeys registered by users and the challenges of registrations and logins in progress.
+
+CREATE TABLE IF NOT EXISTS web_authn_credentials (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    user_id BIGINT UNSIGNED NOT NULL,
+    credential_id VARBINARY(1023) NOT NULL,
+    public_key BLOB NOT NULL,
+    attestation_type VARCHAR(64) NULL,
+    aa_guid VARBINARY(16) NULL,
+    sign_count INT UNSIGNED NOT NULL DEFAULT 0,
+    transports VARCHAR(255) NULL,
+    user_verified BOOLEAN DEFAULT FALSE,
+    backup_eligible BOOLEAN DEFAULT FALSE,
+    backup_state BOOLEAN DEFAULT FALSE,
+    clone_warning BOOLEAN DEFAULT FALSE,
+    last_used_at DATETIME(3) NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_web_authn_credentials_credential_id (credential_id),
+    INDEX idx_web_authn_credentials_user_id (user_id),
+    CONSTRAINT fk_web_authn_credentials_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS web_authn_sessions (
+    id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+    session_id VARCHAR(255) NOT NULL,
+    user_id BIGINT UNSIGNED NULL,
+    purpose VARCHAR(32) NOT NULL,
+    data TEXT NOT NULL,
+    expires_at DATETIME(3) NOT NULL,
+    created_at DATETIME(3) NULL,
+    PRIMARY KEY (id),
+    UNIQUE INDEX idx_web_authn_sessions_session_id (session_id)
+);
@@ -0,0 +1 @@
+ALTER TABLE email_verification_tokens DROP COLUMN new_email, DROP COLUMN purpose;
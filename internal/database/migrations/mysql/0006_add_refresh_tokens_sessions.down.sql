@@ -0,0 +1,5 @@
+ALTER TABLE refresh_tokens
+    DROP INDEX idx_refresh_tokens_session_id,
+    DROP COLUMN access_token_expires_at,
+    DROP COLUMN access_token_jti,
+    DROP COLUMN session_id;
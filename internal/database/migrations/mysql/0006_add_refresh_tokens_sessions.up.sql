@@ -0,0 +1,7 @@
+-- Refresh tokens belong to a session and remember the access token issued with
+-- them, so signing out other sessions can revoke both.
+ALTER TABLE refresh_tokens
+    ADD COLUMN session_id VARCHAR(64) NULL,
+    ADD COLUMN access_token_jti VARCHAR(255) NULL,
+    ADD COLUMN access_token_expires_at DATETIME(3) NULL,
+    ADD INDEX idx_refresh_tokens_session_id (session_id);
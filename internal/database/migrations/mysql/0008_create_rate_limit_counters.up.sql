@@ -0,0 +1,11 @@
+-- Request counters of the database rate limit store, one row per key and
+-- fixed window. counter_key is a SHA-256 of the policy and the client's IP,
+-- email or user, so no personal data is stored.
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    counter_key VARCHAR(64) NOT NULL,
+    window_start BIGINT NOT NULL,
+    hits BIGINT NOT NULL,
+    expires_at DATETIME(3) NOT NULL,
+    PRIMARY KEY (counter_key, window_start),
+    INDEX idx_rate_limit_counters_expires_at (expires_at)
+);
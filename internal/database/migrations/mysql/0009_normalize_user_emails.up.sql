@@ -0,0 +1,20 @@
+-- Email addresses are now stored lowercased and trimmed, the form lookups use.
+-- The comparisons are BINARY: under the column's case-insensitive collation
+-- a mixed-case address equals its lowercase form and would never be updated.
+
+-- Refuse to run, before changing anything, when two accounts would end up with
+-- the same address. The duplicate entry error names the address and the key
+-- below; merge or rename those accounts first.
+DROP TEMPORARY TABLE IF EXISTS normalized_user_emails;
+CREATE TEMPORARY TABLE normalized_user_emails (
+    email VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+    CONSTRAINT accounts_differing_only_in_case_must_be_merged_first UNIQUE (email)
+);
+INSERT INTO normalized_user_emails (email) SELECT LOWER(TRIM(email)) FROM users;
+DROP TEMPORARY TABLE normalized_user_emails;
+
+UPDATE users SET email = LOWER(TRIM(email)) WHERE BINARY email <> BINARY LOWER(TRIM(email));
+UPDATE email_verification_tokens SET new_email = LOWER(TRIM(new_email)) WHERE BINARY new_email <> BINARY LOWER(TRIM(new_email));
+
+-- AutoMigrate made the column 191 characters, short of the 254 an address may have.
+ALTER TABLE users MODIFY email VARCHAR(255) NOT NULL;
@@ -0,0 +1,69 @@
+-- Initial schema, exactly as AutoMigrate created it before migrations were introduced.
+-- Tables are created only if missing so databases set up by AutoMigrate can adopt
+-- migrations; everything added since is in the later migrations.
+
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGSERIAL PRIMARY KEY,
+    role_type TEXT NOT NULL,
+    created_at TIMESTAMPTZ NULL,
+    user_id BIGINT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_roles_user_id ON roles (user_id);
+
+CREATE TABLE IF NOT EXISTS users (
+    id BIGSERIAL PRIMARY KEY,
+    email TEXT NOT NULL,
+    name TEXT NOT NULL,
+    password TEXT NOT NULL,
+    is_verified BOOLEAN DEFAULT FALSE,
+    is_active BOOLEAN DEFAULT TRUE,
+    role_id BIGINT NOT NULL DEFAULT 2,
+    created_at TIMESTAMPTZ NULL,
+    updated_at TIMESTAMPTZ NULL,
+    CONSTRAINT uni_users_email UNIQUE (email),
+    CONSTRAINT fk_users_role FOREIGN KEY (role_id) REFERENCES roles (id)
+);
+
+CREATE TABLE IF NOT EXISTS auth_logs (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NULL,
+    action TEXT NOT NULL,
+    ip_address TEXT NULL,
+    user_agent TEXT NULL,
+    success BOOLEAN NOT NULL,
+    error_message TEXT NULL,
+    created_at TIMESTAMPTZ NULL
+);
+
+CREATE TABLE IF NOT EXISTS token_blacklists (
+    id BIGSERIAL PRIMARY KEY,
+    token_jti VARCHAR(255) NOT NULL,
+    user_id BIGINT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_token_blacklists_token_jti ON token_blacklists (token_jti);
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMPTZ NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_verification_tokens_token ON email_verification_tokens (token);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMPTZ NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token ON refresh_tokens (token);
@@ -0,0 +1,15 @@
+-- AddRole has always written to this table, but it never existed.
+-- Roles 1 and 2 are the admin and user roles that users.role_id refers to.
+INSERT INTO roles (id, role_type, created_at) VALUES
+    (1, 'admin', NOW()),
+    (2, 'user', NOW())
+ON CONFLICT (id) DO NOTHING;
+
+SELECT setval(pg_get_serial_sequence('roles', 'id'), GREATEST((SELECT MAX(id) FROM roles), 1));
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id BIGINT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NULL,
+    PRIMARY KEY (user_id, role_id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE email_verification_tokens DROP COLUMN IF EXISTS new_email;
+ALTER TABLE email_verification_tokens DROP COLUMN IF EXISTS purpose;
@@ -0,0 +1,12 @@
+-- Request counters of the database rate limit store, one row per key and
+-- fixed window. counter_key is a SHA-256 of the policy and the client's IP,
+-- email or user, so no personal data is stored.
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    counter_key VARCHAR(64) NOT NULL,
+    window_start BIGINT NOT NULL,
+    hits BIGINT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (counter_key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_counters_expires_at ON rate_limit_counters (expires_at);
@@ -0,0 +1 @@
+-- The original case of the addresses isn't kept, so there is nothing to undo.
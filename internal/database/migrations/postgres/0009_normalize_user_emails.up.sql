@@ -0,0 +1,4 @@
+-- Email addresses are now stored lowercased and trimmed, the form lookups use.
+-- Fails on accounts whose addresses differ only in case; merge them first.
+UPDATE users SET email = LOWER(TRIM(email)) WHERE email <> LOWER(TRIM(email));
+UPDATE email_verification_tokens SET new_email = LOWER(TRIM(new_email)) WHERE new_email <> LOWER(TRIM(new_email));
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS email_verification_tokens;
+DROP TABLE IF EXISTS token_blacklists;
+DROP TABLE IF EXISTS auth_logs;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,69 @@
+-- Initial schema, exactly as AutoMigrate created it before migrations were introduced.
+-- Tables are created only if missing so databases set up by AutoMigrate can adopt
+-- migrations; everything added since is in the later migrations.
+
+CREATE TABLE IF NOT EXISTS roles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    role_type TEXT NOT NULL,
+    created_at DATETIME,
+    user_id INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_roles_user_id ON roles (user_id);
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email TEXT NOT NULL,
+    name TEXT NOT NULL,
+    password TEXT NOT NULL,
+    is_verified NUMERIC DEFAULT FALSE,
+    is_active NUMERIC DEFAULT TRUE,
+    role_id INTEGER NOT NULL DEFAULT 2,
+    created_at DATETIME,
+    updated_at DATETIME,
+    CONSTRAINT fk_users_role FOREIGN KEY (role_id) REFERENCES roles (id),
+    CONSTRAINT uni_users_email UNIQUE (email)
+);
+
+CREATE TABLE IF NOT EXISTS auth_logs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER,
+    action TEXT NOT NULL,
+    ip_address TEXT,
+    user_agent TEXT,
+    success NUMERIC NOT NULL,
+    error_message TEXT,
+    created_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS token_blacklists (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_jti VARCHAR(255) NOT NULL,
+    user_id INTEGER,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_token_blacklists_token_jti ON token_blacklists (token_jti);
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used NUMERIC DEFAULT FALSE,
+    created_at DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_verification_tokens_token ON email_verification_tokens (token);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token VARCHAR(255) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used NUMERIC DEFAULT FALSE,
+    created_at DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token ON refresh_tokens (token);
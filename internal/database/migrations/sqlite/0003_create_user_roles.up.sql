@@ -0,0 +1,12 @@
+-- AddRole has always written to this table, but it never existed.
+-- Roles 1 and 2 are the admin and user roles that users.role_id refers to.
+INSERT OR IGNORE INTO roles (id, role_type, created_at) VALUES
+    (1, 'admin', CURRENT_TIMESTAMP),
+    (2, 'user', CURRENT_TIMESTAMP);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    created_at DATETIME NULL,
+    PRIMARY KEY (user_id, role_id)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS web_authn_sessions;
+DROP TABLE IF EXISTS web_authn_credentials;
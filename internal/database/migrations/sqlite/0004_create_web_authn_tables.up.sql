@@ -0,0 +1,33 @@
+-- Passkeys registered by users and the challenges of registrations and logins in progress.
+
+CREATE TABLE IF NOT EXISTS web_authn_credentials (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    credential_id BLOB NOT NULL,
+    public_key BLOB NOT NULL,
+    attestation_type VARCHAR(64) NULL,
+    aa_guid BLOB NULL,
+    sign_count INTEGER NOT NULL DEFAULT 0,
+    transports VARCHAR(255) NULL,
+    user_verified BOOLEAN DEFAULT FALSE,
+    backup_eligible BOOLEAN DEFAULT FALSE,
+    backup_state BOOLEAN DEFAULT FALSE,
+    clone_warning BOOLEAN DEFAULT FALSE,
+    last_used_at DATETIME NULL,
+    created_at DATETIME NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_web_authn_credentials_credential_id ON web_authn_credentials (credential_id);
+CREATE INDEX IF NOT EXISTS idx_web_authn_credentials_user_id ON web_authn_credentials (user_id);
+
+CREATE TABLE IF NOT EXISTS web_authn_sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    session_id VARCHAR(255) NOT NULL,
+    user_id INTEGER NULL,
+    purpose VARCHAR(32) NOT NULL,
+    data TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_web_authn_sessions_session_id ON web_authn_sessions (session_id);
@@ -0,0 +1,2 @@
+ALTER TABLE email_verification_tokens DROP COLUMN new_email;
+ALTER TABLE email_verification_tokens DROP COLUMN purpose;
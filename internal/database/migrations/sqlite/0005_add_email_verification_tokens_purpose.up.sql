@@ -0,0 +1,4 @@
+-- Email tokens also confirm email address changes, which carry the new address.
+-- Existing tokens verify email addresses.
+ALTER TABLE email_verification_tokens ADD COLUMN purpose VARCHAR(32) NOT NULL DEFAULT 'email_verification';
+ALTER TABLE email_verification_tokens ADD COLUMN new_email VARCHAR(255) NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_refresh_tokens_session_id;
+ALTER TABLE refresh_tokens DROP COLUMN access_token_expires_at;
+ALTER TABLE refresh_tokens DROP COLUMN access_token_jti;
+ALTER TABLE refresh_tokens DROP COLUMN session_id;
@@ -0,0 +1,7 @@
+-- Refresh tokens belong to a session and remember the access token issued with
+-- them, so signing out other sessions can revoke both.
+ALTER TABLE refresh_tokens ADD COLUMN session_id VARCHAR(64) NULL;
+ALTER TABLE refresh_tokens ADD COLUMN access_token_jti VARCHAR(255) NULL;
+ALTER TABLE refresh_tokens ADD COLUMN access_token_expires_at DATETIME NULL;
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens (session_id);
@@ -0,0 +1,3 @@
+-- Refresh tokens remember the client application they were issued to.
+-- Existing tokens belong to the default client.
+ALTER TABLE refresh_tokens ADD COLUMN client_id VARCHAR(64) NOT NULL DEFAULT 'default';
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// tracingPlugin gives every statement gorm runs a client span, as a child of
+// the span in the statement's context. Statements keep their placeholders, as
+// in the logs.
+type tracingPlugin struct {
+	system string // db.system.name, i.e. the driver
+}
+
+var _ gorm.Plugin = tracingPlugin{}
+
+// Name identifies the plugin to gorm
+func (p tracingPlugin) Name() string {
+	return "tracing"
+}
+
+// Initialize registers the callbacks around each kind of statement
+func (p tracingPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	return errors.Join(
+		callbacks.Create().Before("gorm:create").Register("tracing:before_create", p.before("INSERT")),
+		callbacks.Create().After("gorm:create").Register("tracing:after_create", p.after),
+		callbacks.Query().Before("gorm:query").Register("tracing:before_query", p.before("SELECT")),
+		callbacks.Query().After("gorm:query").Register("tracing:after_query", p.after),
+		callbacks.Update().Before("gorm:update").Register("tracing:before_update", p.before("UPDATE")),
+		callbacks.Update().After("gorm:update").Register("tracing:after_update", p.after),
+		callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", p.before("DELETE")),
+		callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", p.after),
+		callbacks.Row().Before("gorm:row").Register("tracing:before_row", p.before("ROW")),
+		callbacks.Row().After("gorm:row").Register("tracing:after_row", p.after),
+		callbacks.Raw().Before("gorm:raw").Register("tracing:before_raw", p.before("RAW")),
+		callbacks.Raw().After("gorm:raw").Register("tracing:after_raw", p.after),
+	)
+}
+
+// spanContext is the statement's context while its span is open; it keeps the
+// original so the span doesn't become the parent of later statements
+type spanContext struct {
+	context.Context
+	parent context.Context
+}
+
+// before starts the span of a statement
+func (p tracingPlugin) before(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		name := operation
+		if tx.Statement.Table != "" {
+			name += " " + tx.Statement.Table
+		}
+
+		parent := tx.Statement.Context
+		ctx, _ := tracing.StartClient(parent, name,
+			attribute.String("db.system.name", p.system),
+			attribute.String("db.operation.name", operation),
+			attribute.String("db.collection.name", tx.Statement.Table),
+		)
+		tx.Statement.Context = spanContext{Context: ctx, parent: parent}
+	}
+}
+
+// after ends the span of a statement with its SQL, rows and error
+func (p tracingPlugin) after(tx *gorm.DB) {
+	ctx, ok := tx.Statement.Context.(spanContext)
+	if !ok {
+		return
+	}
+	tx.Statement.Context = ctx.parent
+
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+	if !span.IsRecording() {
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("db.query.text", tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	// Missing records and unique violations are expected and handled by the repositories
+	err := tx.Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && !errors.Is(err, gorm.ErrDuplicatedKey) {
+		span.SetStatus(codes.Error, logging.MaskEmails(err.Error()))
+	}
+}
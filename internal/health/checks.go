@@ -0,0 +1,59 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-postgres-api/internal/database"
+	"net"
+	"time"
+)
+
+// DatabaseCheck pings the database
+func DatabaseCheck(db *sql.DB) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	})
+}
+
+// MigrationCheck fails while the schema is behind the migrations embedded in
+// this build. A newer schema passes so instances of the previous release stay
+// ready while a rolling deploy migrates.
+func MigrationCheck(migrator *database.Migrator) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		current, err := migrator.CurrentVersion(ctx)
+		if err != nil {
+			return err
+		}
+		if latest := migrator.LatestVersion(); current < latest {
+			return fmt.Errorf("schema is at version %d, expected %d", current, latest)
+		}
+		return nil
+	})
+}
+
+// TCPCheck checks that a TCP connection to addr can be opened
+func TCPCheck(addr string) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// HeartbeatCheck fails when a background worker's last heartbeat is older than maxAge
+func HeartbeatCheck(lastBeat func() time.Time, maxAge time.Duration) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		last := lastBeat()
+		if last.IsZero() {
+			return fmt.Errorf("worker has not started")
+		}
+		if age := time.Since(last); age > maxAge {
+			return fmt.Errorf("last heartbeat %s ago", age.Round(time.Second))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,174 @@
+// Package health runs dependency checks for the liveness and readiness probes
+package health
+
+import (
+	"context"
+	"go-postgres-api/internal/logging"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Report statuses
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded" // Only non-critical checks failed
+	StatusFail     = "fail"
+	StatusDraining = "draining" // The instance is shutting down
+)
+
+// Checker checks one dependency; a nil error means healthy
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckerFunc adapts a function to a Checker
+type CheckerFunc func(ctx context.Context) error
+
+// Check calls f
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// CheckResult is the outcome of one check. The probes are public, so only the
+// status is served; the error, which may name hosts and ports, is logged.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"-"`
+	Critical  bool    `json:"-"`
+	Error     string  `json:"-"`
+}
+
+// Report is the outcome of every registered check
+type Report struct {
+	Status    string                 `json:"status"`
+	CheckedAt time.Time              `json:"checked_at"`
+	Checks    map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Healthy reports whether the instance should pass the probe
+func (r *Report) Healthy() bool {
+	return r.Status == StatusOK || r.Status == StatusDegraded
+}
+
+type registeredCheck struct {
+	name     string
+	checker  Checker
+	critical bool
+}
+
+// Registry runs a set of named checks concurrently, each with its own
+// timeout. Reports are cached for a short time so frequent probes don't
+// hammer the dependencies; concurrent probes share one run.
+type Registry struct {
+	timeout  time.Duration
+	cacheTTL time.Duration
+
+	mu     sync.Mutex
+	checks []registeredCheck
+	cached *Report
+
+	draining atomic.Bool
+}
+
+// NewRegistry creates an empty registry. timeout bounds every check and
+// reports are reused for cacheTTL.
+func NewRegistry(timeout, cacheTTL time.Duration) *Registry {
+	return &Registry{
+		timeout:  timeout,
+		cacheTTL: cacheTTL,
+	}
+}
+
+// Register adds a check. A failing critical check fails the report; a
+// failing non-critical check only degrades it.
+func (r *Registry) Register(name string, checker Checker, critical bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks = append(r.checks, registeredCheck{name: name, checker: checker, critical: critical})
+	r.cached = nil
+}
+
+// Drain makes every following report fail with StatusDraining without running
+// the checks, so load balancers stop routing here during shutdown
+func (r *Registry) Drain() {
+	r.draining.Store(true)
+}
+
+// Run returns the cached report if it is recent enough and runs the checks otherwise
+func (r *Registry) Run(ctx context.Context) *Report {
+	if r.draining.Load() {
+		return &Report{Status: StatusDraining, CheckedAt: time.Now()}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cached != nil && time.Since(r.cached.CheckedAt) < r.cacheTTL {
+		return r.cached
+	}
+
+	report := &Report{
+		Status:    StatusOK,
+		CheckedAt: time.Now(),
+		Checks:    make(map[string]CheckResult, len(r.checks)),
+	}
+
+	// Checks are bounded by the registry's timeout rather than the caller's
+	// context, so one cancelled probe can't cache failures for everyone
+	ctx = context.WithoutCancel(ctx)
+
+	results := make([]CheckResult, len(r.checks))
+	var wg sync.WaitGroup
+	for i, check := range r.checks {
+		wg.Add(1)
+		go func(i int, check registeredCheck) {
+			defer wg.Done()
+			results[i] = r.runCheck(ctx, check)
+			if results[i].Error != "" {
+				logging.FromContext(ctx).WarnContext(ctx, "health check failed",
+					"check", check.name, "critical", check.critical, "latency_ms", results[i].LatencyMS, "error", results[i].Error)
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	for i, check := range r.checks {
+		result := results[i]
+		report.Checks[check.name] = result
+		if result.Status == StatusOK {
+			continue
+		}
+		if check.critical {
+			report.Status = StatusFail
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+
+	r.cached = report
+	return report
+}
+
+// runCheck runs one check with the registry's timeout
+func (r *Registry) runCheck(ctx context.Context, check registeredCheck) CheckResult {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := check.checker.Check(ctx)
+	result := CheckResult{
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		Critical:  check.critical,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
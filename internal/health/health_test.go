@@ -0,0 +1,95 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"go-postgres-api/internal/logging"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStatuses(t *testing.T) {
+	ok := CheckerFunc(func(ctx context.Context) error { return nil })
+	failing := CheckerFunc(func(ctx context.Context) error { return errors.New("down") })
+
+	tests := []struct {
+		name     string
+		critical bool
+		checker  Checker
+		want     string
+	}{
+		{"all healthy", true, ok, StatusOK},
+		{"non-critical failure", false, failing, StatusDegraded},
+		{"critical failure", true, failing, StatusFail},
+	}
+	for _, tt := range tests {
+		registry := NewRegistry(time.Second, 0)
+		registry.Register("database", ok, true)
+		registry.Register("dependency", tt.checker, tt.critical)
+
+		report := registry.Run(context.Background())
+		if report.Status != tt.want {
+			t.Errorf("%s: Status = %q, want %q", tt.name, report.Status, tt.want)
+		}
+		if report.Healthy() != (tt.want != StatusFail) {
+			t.Errorf("%s: Healthy = %v", tt.name, report.Healthy())
+		}
+	}
+
+	registry := NewRegistry(time.Second, 0)
+	registry.Drain()
+	if report := registry.Run(context.Background()); report.Status != StatusDraining || report.Healthy() {
+		t.Errorf("draining: Status = %q, want a failing %q", report.Status, StatusDraining)
+	}
+}
+
+func TestReportHidesCheckErrors(t *testing.T) {
+	dialErr := errors.New("dial tcp 10.0.4.17:5432: connect: connection refused")
+	registry := NewRegistry(time.Second, 0)
+	registry.Register("database", CheckerFunc(func(ctx context.Context) error { return dialErr }), true)
+
+	var logs bytes.Buffer
+	ctx := logging.NewContext(context.Background(), slog.New(slog.NewTextHandler(&logs, nil)))
+	report := registry.Run(ctx)
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "10.0.4.17") || strings.Contains(string(body), "refused") {
+		t.Errorf("report = %s, want the check error left out", body)
+	}
+	var served struct {
+		Checks map[string]map[string]any `json:"checks"`
+	}
+	if err := json.Unmarshal(body, &served); err != nil {
+		t.Fatal(err)
+	}
+	if check := served.Checks["database"]; len(check) != 1 || check["status"] != StatusFail {
+		t.Errorf("served database check = %v, want only its failed status", check)
+	}
+
+	if !strings.Contains(logs.String(), "health check failed") || !strings.Contains(logs.String(), "check=database") ||
+		!strings.Contains(logs.String(), dialErr.Error()) {
+		t.Errorf("log = %q, want the check name and its error", logs.String())
+	}
+}
+
+func TestRunCachesReports(t *testing.T) {
+	runs := 0
+	registry := NewRegistry(time.Second, time.Hour)
+	registry.Register("database", CheckerFunc(func(ctx context.Context) error {
+		runs++
+		return nil
+	}), true)
+
+	first := registry.Run(context.Background())
+	second := registry.Run(context.Background())
+	if runs != 1 || first != second {
+		t.Errorf("checks ran %d times for two probes within the cache TTL, want 1", runs)
+	}
+}
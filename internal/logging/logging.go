@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// New creates a logger writing to w in the given format at or above level.
+// Every record passes through Redact and carries the trace and span IDs of
+// the context it was logged with.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", level)
+	}
+
+	options := &slog.HandlerOptions{Level: lvl, ReplaceAttr: Redact}
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		return slog.New(traceHandler{slog.NewJSONHandler(w, options)}), nil
+	case FormatText:
+		return slog.New(traceHandler{slog.NewTextHandler(w, options)}), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q: must be json or text", format)
+}
+
+// traceHandler adds the IDs of the span in a record's context, so a log line
+// leads to its trace
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, record slog.Record) error {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// loggerKey is the context key of the request-scoped logger
+type loggerKey struct{}
+
+// NewContext returns a copy of ctx carrying logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, which has the request's
+// attributes such as its ID, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With adds attributes to the logger carried by ctx
+func With(ctx context.Context, args ...any) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(args...))
+}
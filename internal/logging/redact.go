@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RedactedValue replaces secrets in log records
+const RedactedValue = "[REDACTED]"
+
+// emailPattern finds email addresses inside free text such as error messages
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// IsSensitive reports whether a log attribute, header or query parameter of
+// this name holds a secret: passwords, tokens, secrets, cookies and credentials
+func IsSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"password", "token", "secret", "authorization", "cookie", "credential"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact hides secrets and masks email addresses in a log attribute. It is
+// the ReplaceAttr of the handlers created by New.
+func Redact(_ []string, attr slog.Attr) slog.Attr {
+	if IsSensitive(attr.Key) {
+		if attr.Value.Kind() == slog.KindString && attr.Value.String() == "" {
+			return attr
+		}
+		return slog.String(attr.Key, RedactedValue)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, MaskEmails(attr.Value.String()))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, MaskEmails(err.Error()))
+		}
+	}
+	return attr
+}
+
+// MaskEmail keeps the first character of the local part and the domain, so
+// logs can still be correlated without exposing the address
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return email
+	}
+	return local[:1] + "***@" + domain
+}
+
+// MaskEmails masks every email address in s
+func MaskEmails(s string) string {
+	if !strings.Contains(s, "@") {
+		return s
+	}
+	return emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+}
+
+// RedactQuery hides the values of sensitive query parameters, such as the
+// token of an email verification link
+func RedactQuery(rawQuery string) string {
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil && IsSensitive(name) {
+			params[i] = key + "=" + RedactedValue
+		}
+	}
+	return strings.Join(params, "&")
+}
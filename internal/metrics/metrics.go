@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric of the service along with the Go runtime and
+// process collectors
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+var factory = promauto.With(Registry)
+
+// HTTP
+var (
+	// HTTPRequestDuration observes every request by route template, so
+	// /api/v1/users/:id is one series however many users there are. Requests
+	// that match no route use the route "unmatched".
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Time to handle HTTP requests by method, route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// Authentication
+var (
+	// AuthEvents counts authentication outcomes by action, such as login or
+	// register. The reason of a failure is the error message of its auth log
+	// entry, e.g. invalid_password.
+	AuthEvents = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_events_total",
+		Help: "Authentication events by action, outcome and failure reason.",
+	}, []string{"action", "outcome", "reason"})
+
+	// TokenValidationDuration observes access token validation, including
+	// the blacklist lookup
+	TokenValidationDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auth_token_validation_duration_seconds",
+		Help:    "Time to validate access tokens by result.",
+		Buckets: []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"result"})
+
+	// BlacklistCacheLookups counts blacklist checks answered by the cache (hit)
+	// and by the database (miss); the hit ratio is hits over all lookups
+	BlacklistCacheLookups = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_blacklist_cache_lookups_total",
+		Help: "Token blacklist lookups by cache result (hit or miss).",
+	}, []string{"result"})
+)
+
+// Rate limiting
+var (
+	// RateLimitRejections counts requests rejected with 429 by the route and
+	// key of the rate limit they exceeded
+	RateLimitRejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_rate_limit_rejections_total",
+		Help: "Requests rejected by a rate limit, by route and key (ip, email or user).",
+	}, []string{"route", "key"})
+)
+
+// Security headers
+var (
+	// CSPViolations counts the Content Security Policy violations browsers
+	// report, by effective directive and disposition (enforce or report).
+	// Directives outside the CSP specification are counted as "other".
+	CSPViolations = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_csp_violations_total",
+		Help: "Content Security Policy violations reported by browsers, by directive and disposition.",
+	}, []string{"directive", "disposition"})
+)
+
+// Outcome label values
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// RegisterDBStats exports the sql.DBStats of a connection pool, such as open,
+// in-use and idle connections and time spent waiting for one
+func RegisterDBStats(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Reason turns an auth log error message into a label value, e.g.
+// "invalid password" becomes invalid_password
+func Reason(message string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(message)), " ", "_")
+}
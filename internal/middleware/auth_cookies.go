@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/services"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cookies of the clients with cookie token delivery
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFTokenCookie    = "csrf_token" // readable by scripts, which send it back in CSRFTokenHeader
+	CSRFTokenHeader    = "X-CSRF-Token"
+)
+
+// Errors of requests without a usable access token
+var (
+	errMissingAccessToken  = &services.Error{Code: CodeAuthenticationNeeded, Message: "authorization header is required"}
+	errMalformedAuthHeader = &services.Error{Code: CodeInvalidAuthHeader, Message: "invalid authorization header format"}
+)
+
+// RefreshTokenCookiePath scopes the refresh token cookie to the refresh
+// route, so that no other request carries it
+const RefreshTokenCookiePath = "/api/v1/auth/refresh-token"
+
+// AuthCookies writes the token cookies of the clients with cookie token delivery
+type AuthCookies struct {
+	domain   string
+	secure   bool
+	sameSite http.SameSite
+}
+
+// NewAuthCookies creates the token cookie writer configured by cfg
+func NewAuthCookies(cfg *config.Config) *AuthCookies {
+	sameSite := http.SameSiteLaxMode
+	switch cfg.AuthCookieSameSite {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+	return &AuthCookies{domain: cfg.AuthCookieDomain, secure: cfg.AuthCookieSecure, sameSite: sameSite}
+}
+
+// SetTokens moves the tokens of a response to a cookie-delivery client into
+// HttpOnly cookies and issues a new CSRF token. Responses to other clients
+// are left as they are.
+func (a *AuthCookies) SetTokens(c *gin.Context, resp *models.AuthResponse) error {
+	if resp.TokenDelivery != config.TokenDeliveryCookie {
+		return nil
+	}
+
+	csrfToken, err := generateCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	a.set(c, AccessTokenCookie, resp.AccessToken, "/", int(resp.ExpiresIn), true)
+	a.set(c, RefreshTokenCookie, resp.RefreshToken, RefreshTokenCookiePath, int(resp.RefreshTokenExpiresIn), true)
+	a.set(c, CSRFTokenCookie, csrfToken, "/", int(resp.RefreshTokenExpiresIn), false)
+
+	resp.AccessToken = ""
+	resp.RefreshToken = ""
+	resp.CSRFToken = csrfToken
+	return nil
+}
+
+// Clear deletes the token cookies
+func (a *AuthCookies) Clear(c *gin.Context) {
+	a.set(c, AccessTokenCookie, "", "/", -1, true)
+	a.set(c, RefreshTokenCookie, "", RefreshTokenCookiePath, -1, true)
+	a.set(c, CSRFTokenCookie, "", "/", -1, false)
+}
+
+func (a *AuthCookies) set(c *gin.Context, name, value, path string, maxAge int, httpOnly bool) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   a.domain,
+		MaxAge:   maxAge,
+		Secure:   a.secure,
+		HttpOnly: httpOnly,
+		SameSite: a.sameSite,
+	})
+}
+
+// AccessToken returns the access token of a request: the bearer token of the
+// Authorization header or, without one, the access token cookie
+func AccessToken(c *gin.Context) (string, error) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		if token, err := c.Cookie(AccessTokenCookie); err == nil && token != "" {
+			return token, nil
+		}
+		return "", errMissingAccessToken
+	}
+
+	// Extract token from "Bearer <token>"
+	tokenParts := strings.Split(authHeader, " ")
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return "", errMalformedAuthHeader
+	}
+	return tokenParts[1], nil
+}
+
+// generateCSRFToken returns a random URL-safe token
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -1,47 +1,41 @@
 package middleware
 
 import (
+	"context"
+	"go-postgres-api/internal/logging"
 	"go-postgres-api/internal/services"
 	"net/http"
-	"strings"
-	"github.com/gin-contrib/sessions"
 
+	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware is a middleware that validates JWT tokens
-func AuthMiddleware() gin.HandlerFunc {
-	authService := services.NewAuthService()
+// TokenValidator validates access tokens and returns their claims
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, tokenString string) (*services.AccessClaims, error)
+}
 
+// AuthMiddleware is a middleware that validates JWT tokens, taken from the
+// Authorization header or else the access token cookie
+func AuthMiddleware(validator TokenValidator) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Get token from Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization header is required"})
-			c.Abort()
-			return
-		}
-
-		// Extract token from "Bearer <token>"
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization header format"})
-			c.Abort()
+		tokenString, err := AccessToken(c)
+		if err != nil {
+			RespondError(c, err)
 			return
 		}
 
-		tokenString := tokenParts[1]
-
 		// Validate token
-		userID, err := authService.ValidateToken(tokenString)
+		claims, err := validator.ValidateToken(c.Request.Context(), tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-			c.Abort()
+			RespondError(c, err)
 			return
 		}
 
-		// Set user ID in context
-		c.Set("userID", userID)
+		// Set user ID and token claims in context
+		c.Set("userID", claims.UserID)
+		c.Set("tokenClaims", claims)
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "user_id", claims.UserID))
 		c.Next()
 	}
 }
@@ -1,20 +1,66 @@
 package middleware
 
 import (
-	"time"
+	"cmp"
+	"go-postgres-api/internal/config"
+	"slices"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-// CORSMiddleware returns a middleware that enables CORS
-func CORSMiddleware() gin.HandlerFunc {
-	return cors.New(cors.Config{
-		AllowAllOrigins:  true, // Allow all origins
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "Accept", "Cache-Control", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Type"},
-		AllowCredentials: false, // Must be false when AllowAllOrigins is true
-		MaxAge:           12 * time.Hour,
-	})
+// corsAllowedMethods are the methods cross-origin requests may use
+var corsAllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// CORSMiddleware applies the CORS policy of the override with the longest
+// path prefix matching the request, or the default policy. It belongs on the
+// router rather than the route groups, since preflight requests match no
+// route. Requests from origins the policy doesn't allow get 403; same-origin
+// requests and those without an Origin header pass through.
+func CORSMiddleware(policy config.CORSPolicy, overrides []config.CORSOverride) gin.HandlerFunc {
+	type group struct {
+		prefix  string
+		handler gin.HandlerFunc
+	}
+	groups := make([]group, 0, len(overrides))
+	for _, override := range overrides {
+		groups = append(groups, group{override.PathPrefix, corsHandler(override.CORSPolicy)})
+	}
+	slices.SortFunc(groups, func(a, b group) int { return cmp.Compare(len(b.prefix), len(a.prefix)) })
+	defaultHandler := corsHandler(policy)
+
+	return func(c *gin.Context) {
+		for _, g := range groups {
+			if hasPathPrefix(c.Request.URL.Path, g.prefix) {
+				g.handler(c)
+				return
+			}
+		}
+		defaultHandler(c)
+	}
+}
+
+// corsHandler enforces a single CORS policy
+func corsHandler(policy config.CORSPolicy) gin.HandlerFunc {
+	cfg := cors.Config{
+		AllowMethods:     corsAllowedMethods,
+		AllowHeaders:     policy.AllowedHeaders,
+		ExposeHeaders:    policy.ExposedHeaders,
+		AllowCredentials: policy.AllowCredentials,
+		MaxAge:           policy.MaxAge,
+	}
+	if policy.AllowsAllOrigins() {
+		cfg.AllowAllOrigins = true
+	} else {
+		cfg.AllowOriginFunc = policy.AllowsOrigin
+	}
+	return cors.New(cfg)
+}
+
+// hasPathPrefix reports whether path is prefix or below it, so that
+// /api/v1/auth covers /api/v1/auth/login but not /api/v1/authors
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
 }
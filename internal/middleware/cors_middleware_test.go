@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"go-postgres-api/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newCORSRouter returns a router with the CORS middleware and a few routes
+func newCORSRouter(policy config.CORSPolicy, overrides []config.CORSOverride) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CORSMiddleware(policy, overrides))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/api/v1/users/me", ok)
+	router.POST("/api/v1/auth/login", ok)
+	router.GET("/api/v1/authors", ok)
+	return router
+}
+
+// corsRequest sends a request from origin, a preflight when preflightMethod is set
+func corsRequest(router *gin.Engine, method, path, origin, preflightMethod string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://api.example.com"+path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflightMethod != "" {
+		req.Header.Set("Access-Control-Request-Method", preflightMethod)
+		req.Header.Set("Access-Control-Request-Headers", "Content-Type, Authorization")
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// headerHas reports whether a comma-separated header lists value, ignoring case
+func headerHas(header, value string) bool {
+	for _, item := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
+
+var testCORSPolicy = config.CORSPolicy{
+	AllowedOrigins:   []string{"https://app.example.com", "https://*.example.net"},
+	AllowCredentials: true,
+	AllowedHeaders:   []string{"Content-Type", "Authorization"},
+	ExposedHeaders:   []string{"X-Request-ID", "Retry-After"},
+	MaxAge:           10 * time.Minute,
+}
+
+func TestCORSAllowedOrigin(t *testing.T) {
+	router := newCORSRouter(testCORSPolicy, nil)
+
+	w := corsRequest(router, http.MethodGet, "/api/v1/users/me", "https://app.example.com", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	h := w.Header()
+	if got := h.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+	}
+	if got := h.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+	exposed := h.Get("Access-Control-Expose-Headers")
+	if !headerHas(exposed, "X-Request-ID") || !headerHas(exposed, "Retry-After") {
+		t.Errorf("Access-Control-Expose-Headers = %q, want X-Request-ID and Retry-After", exposed)
+	}
+	if !headerHas(strings.Join(h.Values("Vary"), ","), "Origin") {
+		t.Errorf("Vary = %q, want Origin", h.Values("Vary"))
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	router := newCORSRouter(testCORSPolicy, nil)
+
+	w := corsRequest(router, http.MethodOptions, "/api/v1/auth/login", "https://app.example.com", http.MethodPost)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", w.Code)
+	}
+	h := w.Header()
+	if got := h.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+	}
+	if !headerHas(h.Get("Access-Control-Allow-Methods"), http.MethodPost) {
+		t.Errorf("Access-Control-Allow-Methods = %q, want POST", h.Get("Access-Control-Allow-Methods"))
+	}
+	allowed := h.Get("Access-Control-Allow-Headers")
+	if !headerHas(allowed, "Content-Type") || !headerHas(allowed, "Authorization") {
+		t.Errorf("Access-Control-Allow-Headers = %q, want Content-Type and Authorization", allowed)
+	}
+	if got := h.Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Access-Control-Max-Age = %q, want 600", got)
+	}
+	if got := h.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+}
+
+func TestCORSDeniedOrigin(t *testing.T) {
+	router := newCORSRouter(testCORSPolicy, nil)
+
+	for _, origin := range []string{"https://evil.example.com", "http://app.example.com", "https://app.example.com.evil.io"} {
+		for _, preflight := range []string{"", http.MethodPost} {
+			method := http.MethodGet
+			if preflight != "" {
+				method = http.MethodOptions
+			}
+			w := corsRequest(router, method, "/api/v1/users/me", origin, preflight)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s %s: status = %d, want 403", method, origin, w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+				t.Errorf("%s %s: Access-Control-Allow-Origin = %q, want none", method, origin, got)
+			}
+		}
+	}
+}
+
+func TestCORSWithoutOrigin(t *testing.T) {
+	router := newCORSRouter(testCORSPolicy, nil)
+
+	w := corsRequest(router, http.MethodGet, "/api/v1/users/me", "", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want none", got)
+	}
+}
+
+func TestCORSWildcardSubdomains(t *testing.T) {
+	router := newCORSRouter(testCORSPolicy, nil)
+
+	tests := map[string]bool{
+		"https://admin.example.net":         true,
+		"https://eu.admin.example.net":      true,
+		"https://ADMIN.Example.NET":         true,
+		"https://example.net":               false, // the * needs at least one label
+		"http://admin.example.net":          false, // other scheme
+		"https://admin.example.net:8443":    false, // other port
+		"https://evilexample.net":           false,
+		"https://admin.example.net.evil.io": false,
+	}
+	for origin, allowed := range tests {
+		w := corsRequest(router, http.MethodGet, "/api/v1/users/me", origin, "")
+		got := w.Header().Get("Access-Control-Allow-Origin")
+		if allowed && (w.Code != http.StatusOK || got != origin) {
+			t.Errorf("%s: status %d, Access-Control-Allow-Origin %q, want it allowed", origin, w.Code, got)
+		}
+		if !allowed && (w.Code != http.StatusForbidden || got != "") {
+			t.Errorf("%s: status %d, Access-Control-Allow-Origin %q, want it denied", origin, w.Code, got)
+		}
+	}
+}
+
+func TestCORSAllowAllOrigins(t *testing.T) {
+	router := newCORSRouter(config.CORSPolicy{AllowedOrigins: []string{config.CORSAllowAllOrigins}}, nil)
+
+	w := corsRequest(router, http.MethodGet, "/api/v1/users/me", "https://anywhere.example.org", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want none", got)
+	}
+}
+
+func TestCORSRouteGroupOverride(t *testing.T) {
+	overrides := []config.CORSOverride{
+		{
+			PathPrefix: "/api/v1/auth",
+			CORSPolicy: config.CORSPolicy{
+				AllowedOrigins: []string{"https://partner.example.org"},
+				AllowedHeaders: []string{"Content-Type"},
+				ExposedHeaders: []string{"Retry-After"},
+			},
+		},
+		{
+			// The longest matching prefix wins over /api/v1/auth
+			PathPrefix: "/api/v1/auth/login",
+			CORSPolicy: config.CORSPolicy{
+				AllowedOrigins:   []string{"https://login.example.org"},
+				AllowCredentials: true,
+			},
+		},
+	}
+	router := newCORSRouter(testCORSPolicy, overrides)
+
+	tests := []struct {
+		method, path, origin string
+		allowed              bool
+		credentials          bool
+	}{
+		// The override replaces the default origins of its routes
+		{http.MethodOptions, "/api/v1/auth/register", "https://partner.example.org", true, false},
+		{http.MethodOptions, "/api/v1/auth/register", "https://app.example.com", false, false},
+		{http.MethodOptions, "/api/v1/auth/login", "https://login.example.org", true, true},
+		{http.MethodOptions, "/api/v1/auth/login", "https://partner.example.org", false, false},
+		// Routes outside the prefix keep the default policy
+		{http.MethodGet, "/api/v1/users/me", "https://app.example.com", true, true},
+		{http.MethodGet, "/api/v1/users/me", "https://partner.example.org", false, false},
+		{http.MethodGet, "/api/v1/authors", "https://partner.example.org", false, false},
+		{http.MethodGet, "/api/v1/authors", "https://app.example.com", true, true},
+	}
+	for _, tt := range tests {
+		preflight := ""
+		if tt.method == http.MethodOptions {
+			preflight = http.MethodPost
+		}
+		w := corsRequest(router, tt.method, tt.path, tt.origin, preflight)
+		got := w.Header().Get("Access-Control-Allow-Origin")
+
+		if !tt.allowed {
+			if w.Code != http.StatusForbidden || got != "" {
+				t.Errorf("%s %s from %s: status %d, Access-Control-Allow-Origin %q, want it denied", tt.method, tt.path, tt.origin, w.Code, got)
+			}
+			continue
+		}
+		if w.Code >= 400 || got != tt.origin {
+			t.Errorf("%s %s from %s: status %d, Access-Control-Allow-Origin %q, want it allowed", tt.method, tt.path, tt.origin, w.Code, got)
+		}
+		if credentials := w.Header().Get("Access-Control-Allow-Credentials") == "true"; credentials != tt.credentials {
+			t.Errorf("%s %s from %s: credentials allowed = %v, want %v", tt.method, tt.path, tt.origin, credentials, tt.credentials)
+		}
+	}
+
+	// The override's own headers apply to its routes
+	w := corsRequest(router, http.MethodPost, "/api/v1/auth/register", "https://partner.example.org", "")
+	if exposed := w.Header().Get("Access-Control-Expose-Headers"); !headerHas(exposed, "Retry-After") || headerHas(exposed, "X-Request-ID") {
+		t.Errorf("Access-Control-Expose-Headers = %q, want the override's Retry-After only", exposed)
+	}
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"encoding/json"
+	"go-postgres-api/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CSRFMiddleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		router.Handle(method, "/api/v1/users/me", ok)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		bearer bool
+		cookie string // token cookie sent, if any
+		csrf   string // csrf_token cookie
+		header string // X-CSRF-Token header
+		want   int
+	}{
+		{"GET with cookies", http.MethodGet, false, AccessTokenCookie, "", "", http.StatusOK},
+		{"HEAD with cookies", http.MethodHead, false, AccessTokenCookie, "", "", http.StatusOK},
+		{"OPTIONS with cookies", http.MethodOptions, false, AccessTokenCookie, "", "", http.StatusOK},
+		{"POST with matching token", http.MethodPost, false, AccessTokenCookie, "csrf-1", "csrf-1", http.StatusOK},
+		{"DELETE with matching token", http.MethodDelete, false, RefreshTokenCookie, "csrf-1", "csrf-1", http.StatusOK},
+		{"POST with mismatched token", http.MethodPost, false, AccessTokenCookie, "csrf-1", "csrf-2", http.StatusForbidden},
+		{"PUT without header", http.MethodPut, false, AccessTokenCookie, "csrf-1", "", http.StatusForbidden},
+		{"PATCH without CSRF cookie", http.MethodPatch, false, AccessTokenCookie, "", "csrf-1", http.StatusForbidden},
+		{"POST with an empty token on both sides", http.MethodPost, false, AccessTokenCookie, "", "", http.StatusForbidden},
+		{"POST with the refresh cookie only", http.MethodPost, false, RefreshTokenCookie, "csrf-1", "csrf-2", http.StatusForbidden},
+		{"POST with a bearer token", http.MethodPost, true, "", "", "", http.StatusOK},
+		{"POST with a bearer token and a stray CSRF cookie", http.MethodPost, true, "", "csrf-1", "csrf-2", http.StatusOK},
+		{"POST without credentials", http.MethodPost, false, "", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/v1/users/me", nil)
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer access-token")
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: tt.cookie, Value: "token"})
+			}
+			if tt.csrf != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFTokenCookie, Value: tt.csrf})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFTokenHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusForbidden {
+				assertProblem(t, w, http.StatusForbidden, CodeInvalidCSRFToken)
+			}
+		})
+	}
+}
+
+// assertProblem checks that w is a problem response with status and code
+func assertProblem(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d", w.Code, status)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, ProblemContentType) {
+		t.Errorf("Content-Type = %q, want %s", got, ProblemContentType)
+	}
+	var problem models.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Status != status || problem.Code != code {
+		t.Errorf("problem status %d, code %q, want %d and %q", problem.Status, problem.Code, status, code)
+	}
+}
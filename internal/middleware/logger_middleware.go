@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"go-postgres-api/internal/logging"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoggerMiddleware logs every request once it has been handled, with the
+// request's logger so the line carries its ID. Sensitive query parameters
+// are redacted; server errors are logged at error and client errors at warn.
+func LoggerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+			"user_agent", c.Request.UserAgent(),
+		}
+		if query := c.Request.URL.RawQuery; query != "" {
+			attrs = append(attrs, "query", logging.RedactQuery(query))
+		}
+		if route := c.FullPath(); route != "" {
+			attrs = append(attrs, "route", route)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+
+		ctx := c.Request.Context()
+		logging.FromContext(ctx).Log(ctx, level, "request", attrs...)
+	}
+}
+
+// RecoveryMiddleware turns a panic in a handler into a 500 response and logs
+// it with its stack trace
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		ctx := c.Request.Context()
+		logging.FromContext(ctx).ErrorContext(ctx, "panic recovered", "panic", recovered, "stack", string(debug.Stack()))
+		AbortWithProblem(c, http.StatusInternalServerError, CodeInternalError, "internal server error")
+	})
+}
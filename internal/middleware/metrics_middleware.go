@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"go-postgres-api/internal/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware records the duration of every request by method, route
+// template and status
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched" // Unknown paths would otherwise create a series each
+		}
+		metrics.HTTPRequestDuration.
+			WithLabelValues(methodLabel(c.Request.Method), route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// methodLabel keeps the standard methods and folds anything else into OTHER,
+// as clients can send arbitrary methods
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions, http.MethodConnect, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// MetricsTokenMiddleware lets through only requests carrying token as a bearer
+// token, so that the metrics aren't readable by anyone who can reach them
+func MetricsTokenMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			AbortWithProblem(c, http.StatusUnauthorized, CodeAuthenticationNeeded, "missing or invalid metrics token")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsTokenMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/metrics", MetricsTokenMiddleware("scraper-token"), func(c *gin.Context) {
+		c.String(http.StatusOK, "metrics")
+	})
+
+	tests := []struct {
+		authorization string
+		want          int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong-token", http.StatusUnauthorized},
+		{"Bearer scraper-token-and-more", http.StatusUnauthorized},
+		{"Basic scraper-token", http.StatusUnauthorized},
+		{"scraper-token", http.StatusUnauthorized},
+		{"Bearer scraper-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("Authorization %q: status = %d, want %d", tt.authorization, w.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized {
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("Authorization %q: no WWW-Authenticate challenge", tt.authorization)
+			}
+			if w.Body.String() == "metrics" {
+				t.Errorf("Authorization %q: metrics served", tt.authorization)
+			}
+		}
+	}
+}
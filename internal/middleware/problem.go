@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/services"
+	"go-postgres-api/internal/tracing"
+	"go-postgres-api/internal/validation"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// ProblemContentType is the media type of error responses
+const ProblemContentType = "application/problem+json"
+
+// Codes of the errors raised by the HTTP layer rather than the services
+const (
+	CodeValidationFailed     = "validation_failed"
+	CodeInvalidRequest       = "invalid_request"
+	CodeRequestTooLarge      = "request_too_large"
+	CodePasswordPolicy       = "password_policy"
+	CodeAuthenticationNeeded = "authentication_required"
+	CodeInvalidAuthHeader    = "invalid_authorization_header"
+	CodeInvalidCSRFToken     = "invalid_csrf_token"
+	CodeRateLimited          = "rate_limited"
+	CodeNotFound             = "not_found"
+	CodeRequestTimeout       = "request_timeout"
+	CodeRequestCancelled     = "request_cancelled"
+	CodeInternalError        = "internal_error"
+)
+
+// errorStatuses maps the codes of service errors to their HTTP status; codes
+// not listed are sent with 400
+var errorStatuses = map[string]int{
+	services.ErrUnknownClient.Code:            http.StatusUnauthorized,
+	services.ErrGrantNotAllowed.Code:          http.StatusForbidden,
+	services.ErrInvalidCredentials.Code:       http.StatusUnauthorized,
+	services.ErrEmailNotVerified.Code:         http.StatusForbidden,
+	services.ErrUserNotFound.Code:             http.StatusNotFound,
+	services.ErrEmailTaken.Code:               http.StatusConflict,
+	services.ErrEmailAlreadyVerified.Code:     http.StatusConflict,
+	services.ErrInvalidToken.Code:             http.StatusUnauthorized,
+	services.ErrTokenExpired.Code:             http.StatusUnauthorized,
+	services.ErrTokenRevoked.Code:             http.StatusUnauthorized,
+	services.ErrLinkTokenUsed.Code:            http.StatusConflict,
+	services.ErrPasskeyAlreadyRegistered.Code: http.StatusConflict,
+	services.ErrInvalidPasskey.Code:           http.StatusUnauthorized,
+	services.ErrPasskeyDisabled.Code:          http.StatusForbidden,
+	services.ErrPasskeyNotFound.Code:          http.StatusNotFound,
+	CodeAuthenticationNeeded:                  http.StatusUnauthorized,
+	CodeInvalidAuthHeader:                     http.StatusUnauthorized,
+}
+
+// NewProblem builds an error response for the request
+func NewProblem(c *gin.Context, status int, code, detail string) models.Problem {
+	title := http.StatusText(status)
+	if status == StatusClientClosedRequest {
+		title = "Client Closed Request"
+	}
+	return models.Problem{
+		Type:      "about:blank",
+		Title:     title,
+		Status:    status,
+		Detail:    detail,
+		Instance:  c.Request.URL.Path,
+		Code:      code,
+		RequestID: c.GetString("requestID"),
+		TraceID:   tracing.TraceID(c.Request.Context()),
+	}
+}
+
+// AbortWithProblem writes an error response and stops the handler chain
+func AbortWithProblem(c *gin.Context, status int, code, detail string) {
+	WriteProblem(c, NewProblem(c, status, code, detail))
+}
+
+// WriteProblem writes problem as application/problem+json and stops the handler chain
+func WriteProblem(c *gin.Context, problem models.Problem) {
+	c.Header("Content-Type", ProblemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
+
+// AbortWithFieldError writes a validation error for a single field and stops
+// the handler chain
+func AbortWithFieldError(c *gin.Context, field, code, message string) {
+	problem := NewProblem(c, http.StatusBadRequest, CodeValidationFailed, "the request has invalid fields")
+	problem.Errors = []models.FieldError{{Field: field, Code: code, Message: message}}
+	WriteProblem(c, problem)
+}
+
+// RespondError writes the error response for err. Service errors are sent
+// with their code, binding and password policy errors with the fields at
+// fault, and errors of the request context as 499 or 504. Anything else is
+// recorded on the context for the request log and sent as a bare 500, so
+// that database and library messages never reach clients.
+func RespondError(c *gin.Context, err error) {
+	var (
+		serviceErr *services.Error
+		policyErr  *services.PasswordPolicyError
+		validErrs  validator.ValidationErrors
+		syntaxErr  *json.SyntaxError
+		typeErr    *json.UnmarshalTypeError
+		tooLarge   *http.MaxBytesError
+	)
+
+	if status, ok := ContextErrorStatus(err); ok {
+		code := CodeRequestCancelled
+		if status == http.StatusGatewayTimeout {
+			code = CodeRequestTimeout
+		}
+		AbortWithProblem(c, status, code, ContextErrorMessage(status))
+		return
+	}
+
+	switch {
+	case errors.As(err, &serviceErr):
+		status, ok := errorStatuses[serviceErr.Code]
+		if !ok {
+			status = http.StatusBadRequest
+		}
+		AbortWithProblem(c, status, serviceErr.Code, serviceErr.Message)
+
+	case errors.As(err, &policyErr):
+		problem := NewProblem(c, http.StatusBadRequest, CodePasswordPolicy, "password does not meet the password policy")
+		for _, violation := range policyErr.Violations {
+			problem.Errors = append(problem.Errors, models.FieldError{Field: policyErr.Field, Code: violation.Rule, Message: violation.Message})
+		}
+		WriteProblem(c, problem)
+
+	case errors.As(err, &validErrs):
+		problem := NewProblem(c, http.StatusBadRequest, CodeValidationFailed, "the request has invalid fields")
+		problem.Errors = validation.FieldErrors(validErrs)
+		WriteProblem(c, problem)
+
+	case errors.As(err, &typeErr):
+		problem := NewProblem(c, http.StatusBadRequest, CodeValidationFailed, "the request has invalid fields")
+		problem.Errors = []models.FieldError{validation.TypeError(typeErr)}
+		WriteProblem(c, problem)
+
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		AbortWithProblem(c, http.StatusBadRequest, CodeInvalidRequest, "request body is not valid JSON")
+
+	case errors.Is(err, io.EOF):
+		AbortWithProblem(c, http.StatusBadRequest, CodeInvalidRequest, "request body is required")
+
+	case errors.As(err, &tooLarge):
+		AbortWithProblem(c, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "request body is too large")
+
+	default:
+		c.Error(err)
+		AbortWithProblem(c, http.StatusInternalServerError, CodeInternalError, "internal server error")
+	}
+}
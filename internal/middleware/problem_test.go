@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/services"
+	"go-postgres-api/internal/validation"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// bindingErrors returns the errors of binding body to a login request the
+// way the controllers do
+func bindingErrors(t *testing.T) (validationErr, syntaxErr, typeErr, eofErr, tooLargeErr error) {
+	t.Helper()
+	v := validator.New()
+	v.SetTagName("binding") // as gin validates
+	if err := validation.Register(v); err != nil {
+		t.Fatal(err)
+	}
+	validationErr = v.Struct(models.LoginRequest{Email: "not-an-address"})
+
+	decode := func(body string) error {
+		var req models.LoginRequest
+		return json.NewDecoder(strings.NewReader(body)).Decode(&req)
+	}
+	syntaxErr = decode(`{"email": }`)
+	typeErr = decode(`{"email": 42}`)
+	eofErr = decode(``)
+
+	limited := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(strings.NewReader(`{"email":"alice@example.com"}`)), 4)
+	tooLargeErr = json.NewDecoder(limited).Decode(&models.LoginRequest{})
+	return validationErr, syntaxErr, typeErr, eofErr, tooLargeErr
+}
+
+func TestRespondError(t *testing.T) {
+	validationErr, syntaxErr, typeErr, eofErr, tooLargeErr := bindingErrors(t)
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantFields []models.FieldError
+	}{
+		{"mapped service error", services.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials", nil},
+		{"forbidden service error", services.ErrEmailNotVerified, http.StatusForbidden, "email_not_verified", nil},
+		{"conflicting service error", services.ErrEmailTaken, http.StatusConflict, "email_taken", nil},
+		{"not found service error", services.ErrUserNotFound, http.StatusNotFound, "user_not_found", nil},
+		{"unmapped service error", services.ErrPasswordUnchanged, http.StatusBadRequest, "password_unchanged", nil},
+		{"wrapped service error", fmt.Errorf("refresh: %w", services.ErrTokenRevoked), http.StatusUnauthorized, "token_revoked", nil},
+		{"HTTP layer error", errMissingAccessToken, http.StatusUnauthorized, CodeAuthenticationNeeded, nil},
+		{
+			name: "password policy",
+			err: &services.PasswordPolicyError{Field: "new_password", Violations: []models.PasswordViolation{
+				{Rule: "min_length", Message: "must be at least 12 characters"},
+				{Rule: "breached", Message: "has appeared in a data breach"},
+			}},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodePasswordPolicy,
+			wantFields: []models.FieldError{
+				{Field: "new_password", Code: "min_length", Message: "must be at least 12 characters"},
+				{Field: "new_password", Code: "breached", Message: "has appeared in a data breach"},
+			},
+		},
+		{
+			name:       "validation",
+			err:        validationErr,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeValidationFailed,
+			wantFields: []models.FieldError{
+				{Field: "email", Code: "invalid_email", Message: "must be a valid email address"},
+				{Field: "password", Code: "required", Message: "is required"},
+			},
+		},
+		{
+			name:       "JSON type",
+			err:        typeErr,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   CodeValidationFailed,
+			wantFields: []models.FieldError{{Field: "email", Code: "invalid_type", Message: "must be a string"}},
+		},
+		{"JSON syntax", syntaxErr, http.StatusBadRequest, CodeInvalidRequest, nil},
+		{"truncated JSON", io.ErrUnexpectedEOF, http.StatusBadRequest, CodeInvalidRequest, nil},
+		{"empty body", eofErr, http.StatusBadRequest, CodeInvalidRequest, nil},
+		{"body too large", tooLargeErr, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, nil},
+		{"cancelled", context.Canceled, StatusClientClosedRequest, CodeRequestCancelled, nil},
+		{"deadline", fmt.Errorf("query users: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, CodeRequestTimeout, nil},
+		{"other error", errors.New("pq: relation \"users\" does not exist"), http.StatusInternalServerError, CodeInternalError, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+			c.Set("requestID", "request-1")
+
+			RespondError(c, tt.err)
+
+			assertProblem(t, w, tt.wantStatus, tt.wantCode)
+			if !c.IsAborted() {
+				t.Error("RespondError did not abort the handler chain")
+			}
+			var problem models.Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+				t.Fatal(err)
+			}
+			if problem.Instance != "/api/v1/auth/login" || problem.RequestID != "request-1" || problem.Title == "" {
+				t.Errorf("problem instance %q, request ID %q, title %q", problem.Instance, problem.RequestID, problem.Title)
+			}
+			if fmt.Sprint(problem.Errors) != fmt.Sprint(tt.wantFields) {
+				t.Errorf("field errors = %+v, want %+v", problem.Errors, tt.wantFields)
+			}
+
+			// Only unexpected errors are recorded for the log, and their message stays there
+			if tt.wantStatus == http.StatusInternalServerError {
+				if len(c.Errors) != 1 || strings.Contains(w.Body.String(), "relation") {
+					t.Errorf("recorded errors %v, body %s, want the error logged and not sent", c.Errors, w.Body.String())
+				}
+			} else if len(c.Errors) != 0 {
+				t.Errorf("recorded errors %v, want none", c.Errors)
+			}
+		})
+	}
+}
+
+func TestErrorStatuses(t *testing.T) {
+	// Statuses other than 400 must be errors a client can act on, never a 5xx
+	for code, status := range errorStatuses {
+		if status < 400 || status >= 500 || status == http.StatusBadRequest {
+			t.Errorf("errorStatuses[%q] = %d, want a 4xx other than 400, the default", code, status)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/metrics"
+	"go-postgres-api/internal/ratelimit"
+	"go-postgres-api/internal/validation"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRateLimitBodyBytes is how much of a request body is read to find the
+// email address of rate limits by email
+const maxRateLimitBodyBytes = 64 << 10
+
+// rateLimitsAppliedKey holds the rate limits already enforced on a request, as
+// the middleware runs again after authentication for the limits by user
+const rateLimitsAppliedKey = "rateLimitsApplied"
+
+// RateLimitMiddleware enforces the rate limits of the matched route. Limits by
+// user need the middleware to run after AuthMiddleware; limits by email use
+// the email field of the JSON body and are skipped when there is none.
+//
+// Every limited response carries the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers of the tightest limit, and RateLimit-Policy listing
+// them all. Rejected requests get 429 with Retry-After. If the store fails,
+// the request is let through rather than locking everybody out.
+func RateLimitMiddleware(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limits := limiter.Limits(c.FullPath())
+		if len(limits) == 0 {
+			c.Next()
+			return
+		}
+
+		applied, _ := c.Get(rateLimitsAppliedKey)
+		appliedLimits, _ := applied.(map[config.RateLimit]bool)
+		if appliedLimits == nil {
+			appliedLimits = make(map[config.RateLimit]bool)
+			c.Set(rateLimitsAppliedKey, appliedLimits)
+		}
+
+		ctx := c.Request.Context()
+		var tightest, rejected *ratelimit.Result
+		var policies []string
+		for _, limit := range limits {
+			if appliedLimits[limit] {
+				continue
+			}
+			value, ok := rateLimitValue(c, limit.Key)
+			if !ok {
+				continue
+			}
+			appliedLimits[limit] = true
+
+			result, err := limiter.Hit(ctx, limit, value)
+			if err != nil {
+				logging.FromContext(ctx).ErrorContext(ctx, "rate limit store failed, letting the request through",
+					"route", limit.Route, "key", limit.Key, "error", err)
+				continue
+			}
+
+			policies = append(policies, fmt.Sprintf("%d;w=%d", limit.Limit, int(limit.Period.Seconds())))
+			if tightest == nil || result.Remaining < tightest.Remaining {
+				tightest = &result
+			}
+			if !result.Allowed {
+				metrics.RateLimitRejections.WithLabelValues(limit.Route, limit.Key).Inc()
+				if rejected == nil || result.RetryAfter > rejected.RetryAfter {
+					rejected = &result
+				}
+			}
+		}
+
+		if tightest != nil {
+			c.Header("RateLimit-Policy", strings.Join(policies, ", "))
+			c.Header("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+			c.Header("RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+			c.Header("RateLimit-Reset", strconv.Itoa(seconds(tightest.Reset)))
+		}
+		if rejected != nil {
+			c.Header("RateLimit-Limit", strconv.Itoa(rejected.Limit))
+			c.Header("RateLimit-Remaining", "0")
+			c.Header("RateLimit-Reset", strconv.Itoa(seconds(rejected.RetryAfter)))
+			c.Header("Retry-After", strconv.Itoa(seconds(rejected.RetryAfter)))
+			AbortWithProblem(c, http.StatusTooManyRequests, CodeRateLimited, "too many requests, please try again later")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// rateLimitValue returns what a rate limit by key counts the request by, if
+// the request has it
+func rateLimitValue(c *gin.Context, key string) (string, bool) {
+	switch key {
+	case config.RateLimitByIP:
+		return c.ClientIP(), true
+	case config.RateLimitByEmail:
+		email := requestEmail(c)
+		return email, email != ""
+	case config.RateLimitByUser:
+		userID, ok := c.Get("userID")
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprint(userID), true
+	}
+	return "", false
+}
+
+// requestEmail returns the lowercased email field of a JSON body, leaving the
+// body for the handler to read
+func requestEmail(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+
+	prefix, err := io.ReadAll(io.LimitReader(c.Request.Body, maxRateLimitBodyBytes))
+	c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(prefix), c.Request.Body), c.Request.Body}
+	if err != nil {
+		return ""
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(prefix, &body) != nil {
+		return ""
+	}
+	return validation.NormalizeEmail(body.Email)
+}
+
+// readCloser reads from a replacement reader but closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// seconds rounds a duration up to whole seconds, as the headers expect
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/ratelimit"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newRateLimitedRouter returns a router limiting the login and protected
+// routes, echoing the body it reads back
+func newRateLimitedRouter(limiter *ratelimit.Limiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	limited := router.Group("/api/v1", RateLimitMiddleware(limiter))
+
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	}
+	limited.POST("/auth/login", echo)
+
+	// As in the routes: limits by user apply once the user is known, and the
+	// middleware runs again after authentication
+	authenticate := func(c *gin.Context) {
+		c.Set("userID", uint(7))
+		c.Next()
+	}
+	limited.Group("", authenticate, RateLimitMiddleware(limiter)).POST("/users/me/passkeys", echo)
+	return router
+}
+
+// loginRequest posts a login for email from the client IP ip
+func loginRequest(router *gin.Engine, ip, email string) *httptest.ResponseRecorder {
+	body := `{"email":"` + email + `","password":"secret"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = ip + ":12345"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitMiddlewareHeaders(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), []config.RateLimit{
+		{Route: "/api/v1/auth/login", Key: config.RateLimitByIP, Limit: 3, Period: time.Minute},
+		{Route: "/api/v1/auth/login", Key: config.RateLimitByEmail, Limit: 2, Period: time.Hour},
+	})
+	router := newRateLimitedRouter(limiter)
+
+	w := loginRequest(router, "192.0.2.1", "Alice@Example.com")
+	if w.Code != http.StatusOK {
+		t.Fatalf("first login: status %d, want 200", w.Code)
+	}
+	// The handler still reads the whole body
+	if !strings.Contains(w.Body.String(), `"password":"secret"`) {
+		t.Errorf("handler read %q, want the whole body", w.Body.String())
+	}
+	h := w.Header()
+	if got := h.Get("RateLimit-Policy"); got != "3;w=60, 2;w=3600" {
+		t.Errorf("RateLimit-Policy = %q, want both limits", got)
+	}
+	// The tightest limit is the email one, with 1 left
+	if h.Get("RateLimit-Limit") != "2" || h.Get("RateLimit-Remaining") != "1" {
+		t.Errorf("RateLimit-Limit %q, RateLimit-Remaining %q, want 2 and 1", h.Get("RateLimit-Limit"), h.Get("RateLimit-Remaining"))
+	}
+	if reset, err := strconv.Atoi(h.Get("RateLimit-Reset")); err != nil || reset <= 0 || reset > 3600 {
+		t.Errorf("RateLimit-Reset = %q, want seconds until the window ends", h.Get("RateLimit-Reset"))
+	}
+	if h.Get("Retry-After") != "" {
+		t.Errorf("Retry-After = %q on an allowed request", h.Get("Retry-After"))
+	}
+
+	// The email is normalized, so case and spaces don't get around the limit
+	if w := loginRequest(router, "192.0.2.2", " alice@example.COM "); w.Code != http.StatusOK {
+		t.Fatalf("second login: status %d, want 200", w.Code)
+	}
+	w = loginRequest(router, "192.0.2.3", "alice@example.com")
+	assertRateLimited(t, w)
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry <= 0 || retry > 2*3600 {
+		t.Errorf("Retry-After = %q, want a wait within the email limit's period", w.Header().Get("Retry-After"))
+	}
+	if w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Reset") != w.Header().Get("Retry-After") {
+		t.Errorf("RateLimit-Limit %q, RateLimit-Reset %q, want the rejecting limit's", w.Header().Get("RateLimit-Limit"), w.Header().Get("RateLimit-Reset"))
+	}
+
+	// Another address from the first IP is only limited by IP
+	if w := loginRequest(router, "192.0.2.1", "bob@example.com"); w.Code != http.StatusOK {
+		t.Errorf("other email: status %d, want 200", w.Code)
+	}
+	if w := loginRequest(router, "192.0.2.1", "carol@example.com"); w.Code != http.StatusOK {
+		t.Errorf("third login from the IP: status %d, want 200", w.Code)
+	}
+	assertRateLimited(t, loginRequest(router, "192.0.2.1", "dave@example.com"))
+
+	// Without an email in the body only the IP limit applies
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader("not json"))
+	req.RemoteAddr = "192.0.2.9:12345"
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Policy") != "3;w=60" {
+		t.Errorf("body without email: status %d, RateLimit-Policy %q, want 200 with the IP limit only", w.Code, w.Header().Get("RateLimit-Policy"))
+	}
+}
+
+// assertRateLimited checks for a 429 problem response
+func assertRateLimited(t *testing.T, w *httptest.ResponseRecorder) {
+	t.Helper()
+	assertProblem(t, w, http.StatusTooManyRequests, CodeRateLimited)
+	if w.Header().Get("RateLimit-Remaining") != "0" || w.Header().Get("Retry-After") == "" {
+		t.Errorf("RateLimit-Remaining %q, Retry-After %q, want 0 and a wait", w.Header().Get("RateLimit-Remaining"), w.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitMiddlewareAppliesLimitsOnce(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemoryStore(), []config.RateLimit{
+		{Route: "/api/v1/users/me/passkeys", Key: config.RateLimitByIP, Limit: 1, Period: time.Minute},
+		{Route: "/api/v1/users/me/passkeys", Key: config.RateLimitByUser, Limit: 1, Period: time.Minute},
+	})
+	router := newRateLimitedRouter(limiter)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users/me/passkeys", nil)
+		req.RemoteAddr = "192.0.2.1:12345"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The IP limit is counted before authentication only, and the user limit after it
+	w := send()
+	if w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d, want 200 with each limit counted once", w.Code)
+	}
+	if got := w.Header().Get("RateLimit-Policy"); got != "1;w=60" {
+		t.Errorf("RateLimit-Policy = %q, want the user limit set by the second pass", got)
+	}
+	assertRateLimited(t, send())
+}
+
+// failingStore is a rate limit store that is down
+type failingStore struct{}
+
+func (failingStore) Hit(ctx context.Context, key string, window time.Time, period time.Duration) (int64, int64, error) {
+	return 0, 0, errors.New("database is down")
+}
+
+func TestRateLimitMiddlewareStoreFailure(t *testing.T) {
+	limiter := ratelimit.New(failingStore{}, []config.RateLimit{
+		{Route: "/api/v1/auth/login", Key: config.RateLimitByIP, Limit: 1, Period: time.Minute},
+	})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		// Keep the expected error out of the test output
+		c.Request = c.Request.WithContext(logging.NewContext(c.Request.Context(), slog.New(slog.NewTextHandler(io.Discard, nil))))
+	})
+	router.POST("/api/v1/auth/login", RateLimitMiddleware(limiter), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 3; i++ {
+		w := loginRequest(router, "192.0.2.1", "alice@example.com")
+		if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+			t.Errorf("request %d: status %d, RateLimit-Limit %q, want it let through without headers", i+1, w.Code, w.Header().Get("RateLimit-Limit"))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"cmp"
+	"fmt"
+	"go-postgres-api/internal/config"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSPReportPath is where browsers send Content-Security-Policy violation reports
+const CSPReportPath = "/csp-reports"
+
+// cspReportGroup names the endpoint of the report-to directive in Reporting-Endpoints
+const cspReportGroup = "csp-endpoint"
+
+// SecurityHeadersMiddleware sets the security headers of the override with
+// the longest path prefix matching the request, or the default ones. The
+// headers are set before the handlers run, so error responses get them too.
+func SecurityHeadersMiddleware(cfg *config.Config) gin.HandlerFunc {
+	type group struct {
+		prefix  string
+		headers http.Header
+	}
+	groups := make([]group, 0, len(cfg.SecurityHeadersOverrides))
+	for _, override := range cfg.SecurityHeadersOverrides {
+		groups = append(groups, group{override.PathPrefix, securityHeaders(cfg, override.SecurityHeaders)})
+	}
+	slices.SortFunc(groups, func(a, b group) int { return cmp.Compare(len(b.prefix), len(a.prefix)) })
+	defaultHeaders := securityHeaders(cfg, cfg.DefaultSecurityHeaders())
+
+	return func(c *gin.Context) {
+		headers := defaultHeaders
+		for _, g := range groups {
+			if hasPathPrefix(c.Request.URL.Path, g.prefix) {
+				headers = g.headers
+				break
+			}
+		}
+		for name, values := range headers {
+			c.Header(name, values[0])
+		}
+		c.Next()
+	}
+}
+
+// securityHeaders builds the headers of a route group's policies
+func securityHeaders(cfg *config.Config, policy config.SecurityHeaders) http.Header {
+	headers := make(http.Header)
+	headers.Set("X-Content-Type-Options", "nosniff")
+
+	// Browsers ignore HSTS over plain HTTP, so it is safe to send behind a
+	// TLS-terminating proxy as well
+	if cfg.HSTSMaxAge > 0 {
+		hsts := fmt.Sprintf("max-age=%d", int64(cfg.HSTSMaxAge.Seconds()))
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			hsts += "; preload"
+		}
+		headers.Set("Strict-Transport-Security", hsts)
+	}
+
+	var directives []string
+	for _, directive := range strings.Split(policy.ContentSecurityPolicy, ";") {
+		if directive = strings.TrimSpace(directive); directive != "" {
+			directives = append(directives, directive)
+		}
+	}
+	if policy.FrameAncestors != "" {
+		directives = append(directives, "frame-ancestors "+policy.FrameAncestors)
+	}
+	if cfg.CSPReporting && len(directives) > 0 {
+		// report-uri for the browsers that don't support report-to yet
+		directives = append(directives, "report-uri "+CSPReportPath, "report-to "+cspReportGroup)
+		headers.Set("Reporting-Endpoints", fmt.Sprintf("%s=%q", cspReportGroup, CSPReportPath))
+	}
+	if len(directives) > 0 {
+		name := "Content-Security-Policy"
+		if cfg.CSPReportOnly {
+			name = "Content-Security-Policy-Report-Only"
+		}
+		headers.Set(name, strings.Join(directives, "; "))
+	}
+
+	// X-Frame-Options for browsers that predate frame-ancestors; it can't
+	// report, so it is left out while the policy is only reported
+	if !cfg.CSPReportOnly {
+		switch policy.FrameAncestors {
+		case "'none'":
+			headers.Set("X-Frame-Options", "DENY")
+		case "'self'":
+			headers.Set("X-Frame-Options", "SAMEORIGIN")
+		}
+	}
+
+	if policy.ReferrerPolicy != "" {
+		headers.Set("Referrer-Policy", policy.ReferrerPolicy)
+	}
+	if policy.PermissionsPolicy != "" {
+		headers.Set("Permissions-Policy", policy.PermissionsPolicy)
+	}
+	return headers
+}
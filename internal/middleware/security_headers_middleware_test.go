@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"go-postgres-api/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// securityHeadersConfig returns a config with a strict default policy
+func securityHeadersConfig() *config.Config {
+	return &config.Config{
+		ContentSecurityPolicy: "default-src 'none'; ; sandbox",
+		CSPFrameAncestors:     "'none'",
+		ReferrerPolicy:        "no-referrer",
+		PermissionsPolicy:     "camera=()",
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *config.Config)
+		want   map[string]string // "" for headers that must be absent
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{
+				"X-Content-Type-Options":              "nosniff",
+				"Content-Security-Policy":             "default-src 'none'; sandbox; frame-ancestors 'none'",
+				"Content-Security-Policy-Report-Only": "",
+				"X-Frame-Options":                     "DENY",
+				"Referrer-Policy":                     "no-referrer",
+				"Permissions-Policy":                  "camera=()",
+				"Strict-Transport-Security":           "",
+				"Reporting-Endpoints":                 "",
+			},
+		},
+		{
+			name:   "HSTS",
+			modify: func(c *config.Config) { c.HSTSMaxAge = 24 * time.Hour },
+			want:   map[string]string{"Strict-Transport-Security": "max-age=86400"},
+		},
+		{
+			name: "HSTS with subdomains",
+			modify: func(c *config.Config) {
+				c.HSTSMaxAge = 24 * time.Hour
+				c.HSTSIncludeSubdomains = true
+			},
+			want: map[string]string{"Strict-Transport-Security": "max-age=86400; includeSubDomains"},
+		},
+		{
+			name: "HSTS preload",
+			modify: func(c *config.Config) {
+				c.HSTSMaxAge = 365 * 24 * time.Hour
+				c.HSTSIncludeSubdomains = true
+				c.HSTSPreload = true
+			},
+			want: map[string]string{"Strict-Transport-Security": "max-age=31536000; includeSubDomains; preload"},
+		},
+		{
+			name:   "same-origin framing",
+			modify: func(c *config.Config) { c.CSPFrameAncestors = "'self'" },
+			want: map[string]string{
+				"Content-Security-Policy": "default-src 'none'; sandbox; frame-ancestors 'self'",
+				"X-Frame-Options":         "SAMEORIGIN",
+			},
+		},
+		{
+			name:   "framing by other origins",
+			modify: func(c *config.Config) { c.CSPFrameAncestors = "https://app.example.com" },
+			want:   map[string]string{"X-Frame-Options": ""},
+		},
+		{
+			name:   "report only",
+			modify: func(c *config.Config) { c.CSPReportOnly = true },
+			want: map[string]string{
+				"Content-Security-Policy":             "",
+				"Content-Security-Policy-Report-Only": "default-src 'none'; sandbox; frame-ancestors 'none'",
+				"X-Frame-Options":                     "",
+			},
+		},
+		{
+			name:   "reporting",
+			modify: func(c *config.Config) { c.CSPReporting = true },
+			want: map[string]string{
+				"Content-Security-Policy": "default-src 'none'; sandbox; frame-ancestors 'none'; report-uri /csp-reports; report-to csp-endpoint",
+				"Reporting-Endpoints":     `csp-endpoint="/csp-reports"`,
+				"X-Frame-Options":         "DENY",
+			},
+		},
+		{
+			name: "reporting without a policy",
+			modify: func(c *config.Config) {
+				c.ContentSecurityPolicy = ""
+				c.CSPFrameAncestors = ""
+				c.CSPReporting = true
+			},
+			want: map[string]string{
+				"Content-Security-Policy": "",
+				"Reporting-Endpoints":     "",
+				"X-Frame-Options":         "",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := securityHeadersConfig()
+			if tt.modify != nil {
+				tt.modify(cfg)
+			}
+			headers := securityHeaders(cfg, cfg.DefaultSecurityHeaders())
+			for name, want := range tt.want {
+				if got := headers.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSecurityHeadersMiddlewareOverrides(t *testing.T) {
+	cfg := securityHeadersConfig()
+	cfg.HSTSMaxAge = time.Hour
+	cfg.SecurityHeadersOverrides = []config.SecurityHeadersOverride{
+		{PathPrefix: "/docs", SecurityHeaders: config.SecurityHeaders{
+			ContentSecurityPolicy: "default-src 'self'",
+			FrameAncestors:        "'self'",
+			ReferrerPolicy:        "same-origin",
+		}},
+		{PathPrefix: "/docs/embed/", SecurityHeaders: config.SecurityHeaders{
+			ContentSecurityPolicy: "default-src 'self'",
+			FrameAncestors:        "https://app.example.com",
+		}},
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(SecurityHeadersMiddleware(cfg))
+	router.GET("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		path           string
+		wantCSP        string
+		wantFrame      string
+		wantReferrer   string
+		wantPermission string
+	}{
+		{"/api/v1/users/me", "default-src 'none'; sandbox; frame-ancestors 'none'", "DENY", "no-referrer", "camera=()"},
+		{"/docs", "default-src 'self'; frame-ancestors 'self'", "SAMEORIGIN", "same-origin", ""},
+		{"/docs/index.html", "default-src 'self'; frame-ancestors 'self'", "SAMEORIGIN", "same-origin", ""},
+		// The longest matching prefix wins, whatever the order of the overrides
+		{"/docs/embed/widget", "default-src 'self'; frame-ancestors https://app.example.com", "", "", ""},
+		{"/docs/embed", "default-src 'self'; frame-ancestors https://app.example.com", "", "", ""},
+		// Prefixes match whole path segments
+		{"/docsearch", "default-src 'none'; sandbox; frame-ancestors 'none'", "DENY", "no-referrer", "camera=()"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		h := w.Header()
+		if h.Get("Content-Security-Policy") != tt.wantCSP || h.Get("X-Frame-Options") != tt.wantFrame ||
+			h.Get("Referrer-Policy") != tt.wantReferrer || h.Get("Permissions-Policy") != tt.wantPermission {
+			t.Errorf("%s: CSP %q, X-Frame-Options %q, Referrer-Policy %q, Permissions-Policy %q, want %q, %q, %q, %q",
+				tt.path, h.Get("Content-Security-Policy"), h.Get("X-Frame-Options"), h.Get("Referrer-Policy"), h.Get("Permissions-Policy"),
+				tt.wantCSP, tt.wantFrame, tt.wantReferrer, tt.wantPermission)
+		}
+		// Overrides replace the route policies, not the site-wide headers
+		if h.Get("Strict-Transport-Security") != "max-age=3600" || h.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: Strict-Transport-Security %q, X-Content-Type-Options %q", tt.path, h.Get("Strict-Transport-Security"), h.Get("X-Content-Type-Options"))
+		}
+	}
+
+	// Error responses get the headers too
+	router.POST("/api/v1/fail", func(c *gin.Context) { AbortWithProblem(c, http.StatusBadRequest, CodeInvalidRequest, "bad") })
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/fail", nil))
+	if w.Header().Get("X-Frame-Options") != "DENY" {
+		t.Errorf("error response X-Frame-Options = %q, want DENY", w.Header().Get("X-Frame-Options"))
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusClientClosedRequest is the non-standard status (from nginx) recorded when
+// the client disconnects before the response is written
+const StatusClientClosedRequest = 499
+
+// TimeoutMiddleware gives each request a deadline of timeout; zero disables it.
+// Handlers pass the request context down to services and queries, which stop once
+// it is done. If a handler gives up without responding, 504 or 499 is written.
+func TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
+		}
+
+		c.Next()
+
+		if c.Writer.Written() {
+			return
+		}
+		if err := ctx.Err(); err != nil {
+			RespondError(c, err)
+		}
+	}
+}
+
+// ContextErrorStatus maps an error caused by the request context to its status:
+// 504 when the deadline passed and 499 when the client went away
+func ContextErrorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, true
+	case errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest, true
+	}
+	return 0, false
+}
+
+// ContextErrorMessage returns the error message sent with a status from ContextErrorStatus
+func ContextErrorMessage(status int) string {
+	if status == http.StatusGatewayTimeout {
+		return "request timed out"
+	}
+	return "request cancelled"
+}
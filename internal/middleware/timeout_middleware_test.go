@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTimeoutRouter returns a router whose handlers block until the request
+// context is done. /silent then returns without a response, leaving it to
+// the middleware; /respond writes the error itself, as the controllers do.
+func newTimeoutRouter(timeout time.Duration, started chan<- struct{}) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(TimeoutMiddleware(timeout))
+	router.GET("/silent", func(c *gin.Context) {
+		started <- struct{}{}
+		<-c.Request.Context().Done()
+	})
+	router.GET("/respond", func(c *gin.Context) {
+		started <- struct{}{}
+		<-c.Request.Context().Done()
+		RespondError(c, fmt.Errorf("query users: %w", c.Request.Context().Err()))
+	})
+	return router
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		cancel     bool // the client goes away instead of the deadline passing
+		wantStatus int
+		wantCode   string
+	}{
+		{"deadline", "/silent", false, http.StatusGatewayTimeout, CodeRequestTimeout},
+		{"deadline handled by the handler", "/respond", false, http.StatusGatewayTimeout, CodeRequestTimeout},
+		{"client cancelled", "/silent", true, StatusClientClosedRequest, CodeRequestCancelled},
+		{"client cancelled handled by the handler", "/respond", true, StatusClientClosedRequest, CodeRequestCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeout := 20 * time.Millisecond
+			if tt.cancel {
+				timeout = time.Minute
+			}
+			started := make(chan struct{}, 1)
+			router := newTimeoutRouter(timeout, started)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+			served := make(chan struct{})
+			go func() {
+				router.ServeHTTP(w, req)
+				close(served)
+			}()
+
+			<-started
+			if tt.cancel {
+				cancel()
+			}
+			select {
+			case <-served:
+			case <-time.After(5 * time.Second):
+				t.Fatal("handler still running after the request context was done")
+			}
+			assertProblem(t, w, tt.wantStatus, tt.wantCode)
+		})
+	}
+}
+
+func TestTimeoutMiddlewareKeepsResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/fast", TimeoutMiddleware(time.Minute), func(c *gin.Context) {
+		if _, ok := c.Request.Context().Deadline(); !ok {
+			t.Error("request has no deadline")
+		}
+		c.Status(http.StatusOK)
+	})
+	// A response written before the deadline passed is left alone
+	router.GET("/late", TimeoutMiddleware(10*time.Millisecond), func(c *gin.Context) {
+		c.Status(http.StatusAccepted)
+		c.Writer.WriteHeaderNow()
+		<-c.Request.Context().Done()
+	})
+	router.GET("/unlimited", TimeoutMiddleware(0), func(c *gin.Context) {
+		if _, ok := c.Request.Context().Deadline(); ok {
+			t.Error("request has a deadline with the timeout disabled")
+		}
+		c.Status(http.StatusOK)
+	})
+
+	for path, want := range map[string]int{"/fast": http.StatusOK, "/late": http.StatusAccepted, "/unlimited": http.StatusOK} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: status %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestContextErrorStatus(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantOK     bool
+	}{
+		{context.DeadlineExceeded, http.StatusGatewayTimeout, true},
+		{fmt.Errorf("find user: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, true},
+		{context.Canceled, StatusClientClosedRequest, true},
+		{fmt.Errorf("hash password: %w", context.Canceled), StatusClientClosedRequest, true},
+		{errors.New("connection refused"), 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		status, ok := ContextErrorStatus(tt.err)
+		if status != tt.wantStatus || ok != tt.wantOK {
+			t.Errorf("ContextErrorStatus(%v) = %d, %v, want %d, %v", tt.err, status, ok, tt.wantStatus, tt.wantOK)
+		}
+	}
+}
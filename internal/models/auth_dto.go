@@ -1,30 +1,41 @@
 package models
 
+import "encoding/json"
+
 // RegisterRequest represents the request body for user registration
 type RegisterRequest struct {
-	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=8"`
-	FirstName string `json:"first_name" binding:"required"`
-	LastName  string `json:"last_name" binding:"required"`
+	Email     string `json:"email" binding:"required,email_address"`
+	Password  string `json:"password" binding:"required"`
+	FirstName string `json:"first_name" binding:"required,person_name"`
+	LastName  string `json:"last_name" binding:"required,person_name"`
 }
 
 // LoginRequest represents the request body for user login
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
+	Email    string `json:"email" binding:"required,email_address"`
 	Password string `json:"password" binding:"required"`
+	ClientID string `json:"client_id"` // registered client application; the default client when empty
 }
 
-// AuthResponse represents the response for successful authentication
+// AuthResponse represents the response for successful authentication. For
+// clients with cookie token delivery the tokens go in cookies instead, and
+// the body carries the CSRF token to send back with state-changing requests.
 type AuthResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	CSRFToken    string `json:"csrf_token,omitempty"`
 	ExpiresIn    int64  `json:"expires_in"`
 	User         User   `json:"user"`
+
+	// How the client gets the tokens, and the seconds left to the refresh token
+	TokenDelivery         string `json:"-"`
+	RefreshTokenExpiresIn int64  `json:"-"`
 }
 
-// RefreshTokenRequest represents the request body for token refresh
+// RefreshTokenRequest represents the request body for token refresh. The
+// refresh token may come from its cookie instead.
 type RefreshTokenRequest struct {
-	RefreshToken string `json:"refresh_token" binding:"required"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 // EmailVerificationRequest represents the request for email verification
@@ -34,15 +45,69 @@ type EmailVerificationRequest struct {
 
 // ResendVerificationRequest represents the request to resend verification email
 type ResendVerificationRequest struct {
-	Email string `json:"email" binding:"required,email"`
+	Email string `json:"email" binding:"required,email_address"`
 }
 
-// ErrorResponse represents an error response
-type ErrorResponse struct {
-	Error string `json:"error"`
+// ChangeEmailRequest represents the request to change the user's email address
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email_address"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ChangePasswordRequest represents the request to change the password of a logged-in user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// Problem represents an error response in the RFC 7807 problem details format.
+// Code identifies the error and is stable; Detail is for people. RequestID and
+// TraceID identify the request in logs and traces.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	TraceID   string       `json:"trace_id,omitempty"`
+}
+
+// FieldError describes a request field that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordViolation describes a password policy rule that a password failed
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
 
 // SuccessResponse represents a success response
 type SuccessResponse struct {
 	Message string `json:"message"`
 }
+
+// PasskeyLoginBeginRequest represents the request to start a passkey login.
+// Email is optional; without it a discoverable (usernameless) login is started.
+type PasskeyLoginBeginRequest struct {
+	Email string `json:"email" binding:"omitempty,email_address"`
+}
+
+// PasskeyFinishRequest represents the authenticator response for a passkey ceremony
+type PasskeyFinishRequest struct {
+	SessionID  string          `json:"session_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+	ClientID   string          `json:"client_id"` // only used by login; the default client when empty
+}
+
+// PasskeyBeginResponse represents the options handed to navigator.credentials
+type PasskeyBeginResponse struct {
+	SessionID string      `json:"session_id"`
+	Options   interface{} `json:"options"`
+}
@@ -1,23 +1,23 @@
 package models
 
 import (
+	"go-postgres-api/internal/security"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 // User represents a user in the system
 type User struct {
-	ID         uint      `json:"id" gorm:"primaryKey"`
-	Email      string    `json:"email" gorm:"unique;not null"`
-	Name       string    `json:"name" gorm:"not null"`
-	Password   string    `json:"-" gorm:"not null"`
-	IsVerified bool      `json:"is_verified" gorm:"default:false"`
-	IsActive   bool      `json:"is_active" gorm:"default:true"`
-	RoleID     uint      `json:"role_id" gorm:"not null;default:2"`
-	Role       Role      `json:"role" gorm:"foreignKey:RoleID"`
-	CreatedAt  time.Time `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt  time.Time `json:"updated_at" gorm:"autoUpdateTime"`
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Email      string     `json:"email" gorm:"unique;not null"`
+	Name       string     `json:"name" gorm:"not null"`
+	Password   string     `json:"-" gorm:"not null"`
+	IsVerified bool       `json:"is_verified" gorm:"default:false"`
+	IsActive   bool       `json:"is_active" gorm:"default:true"`
+	RoleID     uint       `json:"role_id" gorm:"not null;default:2"`
+	Role       Role       `json:"role" gorm:"foreignKey:RoleID"`
+	LastLogin  *time.Time `json:"last_login"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time  `json:"updated_at" gorm:"autoUpdateTime"`
 }
 
 // Role represents a user role
@@ -49,38 +49,54 @@ type TokenBlacklist struct {
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
-// EmailVerificationToken represents an email verification token
+// Email token purposes
+const (
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeEmailChange       = "email_change"
+	TokenPurposeEmailChangeCancel = "email_change_cancel"
+)
+
+// EmailVerificationToken represents a token sent by email, either to verify a new
+// account or to confirm or cancel a change of email address
 type EmailVerificationToken struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	UserID    uint      `json:"user_id" gorm:"not null"`
 	Token     string    `json:"token" gorm:"type:varchar(255);uniqueIndex;not null"`
+	Purpose   string    `json:"purpose" gorm:"type:varchar(32);not null;default:email_verification"`
+	NewEmail  string    `json:"new_email" gorm:"type:varchar(255)"`
 	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
 	Used      bool      `json:"used" gorm:"default:false"`
 	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
-// RefreshToken represents a refresh token
+// RefreshToken represents a refresh token. Every token issued for the same login
+// shares a SessionID, and records the access token issued alongside it so the
+// session can be revoked as a whole.
 type RefreshToken struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	UserID    uint      `json:"user_id" gorm:"not null"`
-	Token     string    `json:"token" gorm:"type:varchar(255);uniqueIndex;not null"`
-	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
-	Used      bool      `json:"used" gorm:"default:false"`
-	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+	ID                   uint      `json:"id" gorm:"primaryKey"`
+	UserID               uint      `json:"user_id" gorm:"not null"`
+	SessionID            string    `json:"session_id" gorm:"type:varchar(64);index"`
+	ClientID             string    `json:"client_id" gorm:"type:varchar(64);not null"`
+	Token                string    `json:"token" gorm:"type:varchar(255);uniqueIndex;not null"`
+	AccessTokenJTI       string    `json:"-" gorm:"type:varchar(255)"`
+	AccessTokenExpiresAt time.Time `json:"-"`
+	ExpiresAt            time.Time `json:"expires_at" gorm:"not null"`
+	Used                 bool      `json:"used" gorm:"default:false"`
+	CreatedAt            time.Time `json:"created_at" gorm:"autoCreateTime"`
 }
 
 // SetPassword hashes and sets the user's password
-func (u *User) SetPassword(password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func (u *User) SetPassword(hasher security.PasswordHasher, password string) error {
+	hashedPassword, err := hasher.Hash(password)
 	if err != nil {
 		return err
 	}
-	u.Password = string(hashedPassword)
+	u.Password = hashedPassword
 	return nil
 }
 
 // CheckPassword verifies the user's password
-func (u *User) CheckPassword(password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
-	return err == nil
+func (u *User) CheckPassword(hasher security.PasswordHasher, password string) bool {
+	ok, err := hasher.Verify(password, u.Password)
+	return err == nil && ok
 }
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// WebAuthn ceremony purposes stored on a WebAuthnSession
+const (
+	WebAuthnPurposeRegistration = "registration"
+	WebAuthnPurposeLogin        = "login"
+)
+
+// WebAuthnCredential represents a passkey registered by a user
+type WebAuthnCredential struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	UserID          uint       `json:"user_id" gorm:"not null;index"`
+	User            User       `json:"-" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	CredentialID    []byte     `json:"-" gorm:"size:1023;uniqueIndex;not null"`
+	PublicKey       []byte     `json:"-" gorm:"not null"`
+	AttestationType string     `json:"attestation_type"`
+	AAGUID          []byte     `json:"-" gorm:"size:16"`
+	SignCount       uint32     `json:"sign_count" gorm:"not null;default:0"`
+	Transports      string     `json:"transports"`
+	UserVerified    bool       `json:"user_verified" gorm:"default:false"`
+	BackupEligible  bool       `json:"backup_eligible" gorm:"default:false"`
+	BackupState     bool       `json:"backup_state" gorm:"default:false"`
+	CloneWarning    bool       `json:"clone_warning" gorm:"default:false"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	CreatedAt       time.Time  `json:"created_at" gorm:"autoCreateTime"`
+}
+
+// WebAuthnSession holds the server-side state of an in-progress registration or login ceremony
+type WebAuthnSession struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	SessionID string    `json:"session_id" gorm:"type:varchar(255);uniqueIndex;not null"`
+	UserID    uint      `json:"user_id"`
+	Purpose   string    `json:"purpose" gorm:"type:varchar(32);not null"`
+	Data      string    `json:"-" gorm:"type:text;not null"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go-postgres-api/internal/config"
+	"math"
+	"time"
+)
+
+// Store counts the hits of each key in fixed windows. MemoryStore keeps them
+// per instance and SQLStore shares them through the database.
+type Store interface {
+	// Hit counts a request for key in the window starting at window and
+	// returns the hits of that window and of the one before it
+	Hit(ctx context.Context, key string, window time.Time, period time.Duration) (current, previous int64, err error)
+}
+
+// Limiter enforces rate limits with a sliding window: the hits of the current
+// fixed window are added to those of the previous one, weighted by how much
+// of it the sliding window still covers. Rejected requests are counted too,
+// so a client retrying in a loop stays throttled.
+type Limiter struct {
+	store   Store
+	byRoute map[string][]config.RateLimit
+	now     func() time.Time // time.Now, replaced by tests
+}
+
+// New creates a limiter enforcing limits with the counts of store
+func New(store Store, limits []config.RateLimit) *Limiter {
+	byRoute := make(map[string][]config.RateLimit)
+	for _, limit := range limits {
+		byRoute[limit.Route] = append(byRoute[limit.Route], limit)
+	}
+	return &Limiter{store: store, byRoute: byRoute, now: time.Now}
+}
+
+// Limits returns the rate limits of a route template
+func (l *Limiter) Limits(route string) []config.RateLimit {
+	return l.byRoute[route]
+}
+
+// Result is the state of a rate limit after a request
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the quota is next replenished; RetryAfter, set when the
+	// request was rejected, is when the next one would be allowed
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Hit counts a request against limit for value, such as the client's IP,
+// and reports whether it is within the limit
+func (l *Limiter) Hit(ctx context.Context, limit config.RateLimit, value string) (Result, error) {
+	now := l.now()
+	window := now.Truncate(limit.Period)
+	elapsed := now.Sub(window)
+
+	current, previous, err := l.store.Hit(ctx, counterKey(limit, value), window, limit.Period)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// The share of the previous window still inside the sliding window
+	weight := 1 - float64(elapsed)/float64(limit.Period)
+	hits := float64(previous)*weight + float64(current)
+
+	result := Result{
+		Allowed:   hits <= float64(limit.Limit),
+		Limit:     limit.Limit,
+		Remaining: max(0, int(math.Floor(float64(limit.Limit)-hits))),
+		Reset:     limit.Period - elapsed,
+	}
+	if !result.Allowed {
+		result.RetryAfter = retryAfter(limit, elapsed, current, previous)
+		result.Reset = result.RetryAfter
+	}
+	return result, nil
+}
+
+// retryAfter returns how long until one more request fits in the sliding
+// window, assuming no other request comes in meanwhile
+func retryAfter(limit config.RateLimit, elapsed time.Duration, current, previous int64) time.Duration {
+	period := float64(limit.Period)
+	room := float64(limit.Limit - 1) // hits the next request may find
+
+	// Within this window, once enough of the previous one has slid out
+	if float64(current) <= room && previous > 0 {
+		return time.Duration(period*(1-(room-float64(current))/float64(previous))) - elapsed
+	}
+
+	// In the next window, where this one's hits are the previous ones
+	return limit.Period - elapsed + time.Duration(period*(1-room/float64(current)))
+}
+
+// counterKey identifies the counter of a limit and value without storing the
+// value, which may be an email address
+func counterKey(limit config.RateLimit, value string) string {
+	sum := sha256.Sum256([]byte(limit.Route + "\x00" + limit.Key + "\x00" + value))
+	return hex.EncodeToString(sum[:])
+}
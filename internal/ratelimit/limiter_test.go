@@ -0,0 +1,255 @@
+package ratelimit
+
+import (
+	"context"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/database"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newSQLiteStore returns a SQLStore on a new, migrated SQLite database
+func newSQLiteStore(t *testing.T) *SQLStore {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	db, err := gorm.Open(sqlite.Open(database.SQLiteDSN(path)), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	migrator, err := database.NewMigrator(sqlDB, database.DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background(), 0); err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+	return NewSQLStore(db)
+}
+
+// stores returns a fresh store of each kind
+func stores(t *testing.T) map[string]Store {
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"sql":    newSQLiteStore(t),
+	}
+}
+
+// fakeClock is a settable time source for the limiter
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+func (c *fakeClock) Set(t time.Time)         { c.now = t }
+
+// newTestLimiter returns a limiter on store whose clock starts at the
+// beginning of a minute
+func newTestLimiter(store Store, limits ...config.RateLimit) (*Limiter, *fakeClock) {
+	// Future windows, so the stores' sweeps never see the counters as expired
+	clock := &fakeClock{now: time.Now().Add(time.Hour).Truncate(time.Minute)}
+	limiter := New(store, limits)
+	limiter.now = clock.Now
+	return limiter, clock
+}
+
+var loginLimit = config.RateLimit{Route: "/api/v1/auth/login", Key: config.RateLimitByIP, Limit: 10, Period: time.Minute}
+
+// hit sends a request through the limiter and fails the test on store errors
+func hit(t *testing.T, limiter *Limiter, limit config.RateLimit, value string) Result {
+	t.Helper()
+	result, err := limiter.Hit(context.Background(), limit, value)
+	if err != nil {
+		t.Fatalf("Hit: %v", err)
+	}
+	return result
+}
+
+// near reports whether two durations are within a millisecond
+func near(a, b time.Duration) bool {
+	d := a - b
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+func TestLimiterSlidingWindow(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			limiter, clock := newTestLimiter(store, loginLimit)
+			start := clock.Now()
+
+			// The whole limit is available in a fresh window
+			for i := 1; i <= 10; i++ {
+				result := hit(t, limiter, loginLimit, "192.0.2.1")
+				if !result.Allowed || result.Remaining != 10-i || result.Limit != 10 {
+					t.Fatalf("hit %d = %+v, want allowed with %d remaining", i, result, 10-i)
+				}
+				if result.Reset != time.Minute || result.RetryAfter != 0 {
+					t.Errorf("hit %d: Reset %v, RetryAfter %v, want the window's end and none", i, result.Reset, result.RetryAfter)
+				}
+			}
+
+			// The 11th is rejected, and counted. It fits once the next window has
+			// slid far enough: 11 * (1 - x) <= 9 at x = 2/11 of it
+			rejected := hit(t, limiter, loginLimit, "192.0.2.1")
+			wantRetry := time.Minute + time.Minute*2/11
+			if rejected.Allowed || rejected.Remaining != 0 || !near(rejected.RetryAfter, wantRetry) || rejected.Reset != rejected.RetryAfter {
+				t.Fatalf("hit 11 = %+v, want rejected with RetryAfter %v", rejected, wantRetry)
+			}
+
+			// Other clients have their own counters
+			if result := hit(t, limiter, loginLimit, "192.0.2.2"); !result.Allowed || result.Remaining != 9 {
+				t.Errorf("other client = %+v, want allowed with 9 remaining", result)
+			}
+
+			// Half way into the next window half of the previous 11 hits count
+			clock.Set(start.Add(90 * time.Second))
+			for i, wantRemaining := range []int{3, 2, 1, 0} {
+				result := hit(t, limiter, loginLimit, "192.0.2.1")
+				if !result.Allowed || result.Remaining != wantRemaining {
+					t.Fatalf("next window hit %d = %+v, want allowed with %d remaining", i+1, result, wantRemaining)
+				}
+				if result.Reset != 30*time.Second {
+					t.Errorf("next window hit %d: Reset = %v, want 30s", i+1, result.Reset)
+				}
+			}
+			// 5.5 + 5 is over the limit; the next request fits once
+			// 11 * (1 - x) + 6 <= 10, at x = 7/11 of the window
+			rejected = hit(t, limiter, loginLimit, "192.0.2.1")
+			wantRetry = time.Minute*7/11 - 30*time.Second
+			if rejected.Allowed || !near(rejected.RetryAfter, wantRetry) {
+				t.Fatalf("over the sliding limit = %+v, want rejected with RetryAfter %v", rejected, wantRetry)
+			}
+			clock.Advance(rejected.RetryAfter + time.Millisecond)
+			if result := hit(t, limiter, loginLimit, "192.0.2.1"); !result.Allowed {
+				t.Errorf("after RetryAfter = %+v, want allowed", result)
+			}
+
+			// After a whole idle window nothing is left of the earlier ones
+			clock.Set(start.Add(3 * time.Minute))
+			if result := hit(t, limiter, loginLimit, "192.0.2.1"); !result.Allowed || result.Remaining != 9 {
+				t.Errorf("after an idle window = %+v, want allowed with 9 remaining", result)
+			}
+		})
+	}
+}
+
+func TestLimiterSeparatesLimits(t *testing.T) {
+	byEmail := config.RateLimit{Route: loginLimit.Route, Key: config.RateLimitByEmail, Limit: 1, Period: time.Minute}
+	register := config.RateLimit{Route: "/api/v1/auth/register", Key: config.RateLimitByIP, Limit: 1, Period: time.Minute}
+
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			limiter, _ := newTestLimiter(store, loginLimit, byEmail, register)
+
+			if got := len(limiter.Limits(loginLimit.Route)); got != 2 {
+				t.Errorf("Limits(login) has %d limits, want 2", got)
+			}
+			if got := limiter.Limits("/api/v1/users/me"); len(got) != 0 {
+				t.Errorf("Limits(unlimited route) = %v, want none", got)
+			}
+
+			// The same value counts separately per route and key
+			for _, limit := range []config.RateLimit{loginLimit, byEmail, register} {
+				if result := hit(t, limiter, limit, "192.0.2.1"); !result.Allowed {
+					t.Errorf("%s by %s: first hit rejected", limit.Route, limit.Key)
+				}
+			}
+			if result := hit(t, limiter, register, "192.0.2.1"); result.Allowed {
+				t.Error("second register hit allowed, want rejected")
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	limit := config.RateLimit{Limit: 10, Period: time.Minute}
+	tests := []struct {
+		name              string
+		elapsed           time.Duration
+		current, previous int64
+		want              time.Duration
+	}{
+		// room is 9 hits: 11 * (1 - x) + 2 <= 9 at x = 4/11
+		{"previous window sliding out", 10 * time.Second, 2, 11, time.Minute*4/11 - 10*time.Second},
+		// No previous hits: wait for this window's hits to slide: 12 * (1 - x) <= 9 at x = 1/4
+		{"current window full", 20 * time.Second, 12, 0, 40*time.Second + 15*time.Second},
+		// More than the limit in this window alone: the previous one doesn't matter
+		{"both full", 30 * time.Second, 15, 20, 30*time.Second + time.Minute*6/15},
+	}
+	for _, tt := range tests {
+		got := retryAfter(limit, tt.elapsed, tt.current, tt.previous)
+		if !near(got, tt.want) {
+			t.Errorf("%s: retryAfter = %v, want %v", tt.name, got, tt.want)
+		}
+		if got <= 0 {
+			t.Errorf("%s: retryAfter = %v, want a positive wait", tt.name, got)
+		}
+	}
+}
+
+func TestStoreCountsConcurrentHits(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			window := time.Now().Add(time.Hour).Truncate(time.Minute)
+			const requests = 40
+
+			var wg sync.WaitGroup
+			errs := make(chan error, requests)
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, _, err := store.Hit(context.Background(), "key", window, time.Minute)
+					errs <- err
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// No hit is lost, and the window moves on to the next one
+			current, previous, err := store.Hit(context.Background(), "key", window, time.Minute)
+			if err != nil || current != requests+1 || previous != 0 {
+				t.Fatalf("Hit = %d, %d, %v, want %d, 0", current, previous, err, requests+1)
+			}
+			current, previous, err = store.Hit(context.Background(), "key", window.Add(time.Minute), time.Minute)
+			if err != nil || current != 1 || previous != requests+1 {
+				t.Errorf("next window Hit = %d, %d, %v, want 1, %d", current, previous, err, requests+1)
+			}
+			// A window after a gap doesn't count the one before the gap
+			current, previous, err = store.Hit(context.Background(), "key", window.Add(3*time.Minute), time.Minute)
+			if err != nil || current != 1 || previous != 0 {
+				t.Errorf("Hit after a gap = %d, %d, %v, want 1, 0", current, previous, err)
+			}
+		})
+	}
+}
+
+func TestCounterKeyHidesValue(t *testing.T) {
+	key := counterKey(loginLimit, "alice@example.com")
+	if len(key) != 64 || key == counterKey(loginLimit, "bob@example.com") {
+		t.Errorf("counterKey = %q, want a distinct SHA-256 hex digest", key)
+	}
+	other := loginLimit
+	other.Key = config.RateLimitByEmail
+	if counterKey(other, "alice@example.com") == key {
+		t.Error("counterKey is the same for different limit keys")
+	}
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often stores drop counters that have expired
+const sweepInterval = time.Minute
+
+// MemoryStore counts hits in memory. Every instance has its own counts, so
+// behind a load balancer a client gets the limit once per instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*memoryCounter
+	lastSweep time.Time
+}
+
+type memoryCounter struct {
+	window    time.Time
+	current   int64
+	previous  int64
+	expiresAt time.Time
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counters: make(map[string]*memoryCounter), lastSweep: time.Now()}
+}
+
+// Hit counts a request for key in the window starting at window
+func (s *MemoryStore) Hit(ctx context.Context, key string, window time.Time, period time.Duration) (int64, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for k, counter := range s.counters {
+			if now.After(counter.expiresAt) {
+				delete(s.counters, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &memoryCounter{window: window}
+		s.counters[key] = counter
+	}
+	if !counter.window.Equal(window) {
+		// Move on to the new window; the old one only counts if it was the one right before
+		if counter.window.Equal(window.Add(-period)) {
+			counter.previous = counter.current
+		} else {
+			counter.previous = 0
+		}
+		counter.current = 0
+		counter.window = window
+	}
+	counter.current++
+	counter.expiresAt = window.Add(2 * period)
+
+	return counter.current, counter.previous, nil
+}
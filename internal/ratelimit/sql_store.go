@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// counterRow is a row of rate_limit_counters
+type counterRow struct {
+	CounterKey  string    `gorm:"primaryKey;type:varchar(64)"`
+	WindowStart int64     `gorm:"primaryKey;autoIncrement:false"` // Unix milliseconds
+	Hits        int64     `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"not null;index"`
+}
+
+func (counterRow) TableName() string {
+	return "rate_limit_counters"
+}
+
+// SQLStore counts hits in the rate_limit_counters table, so every instance
+// sharing the database enforces the same limits
+type SQLStore struct {
+	db        *gorm.DB
+	lastSweep atomic.Int64 // Unix nanoseconds
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// NewSQLStore creates a store backed by db
+func NewSQLStore(db *gorm.DB) *SQLStore {
+	s := &SQLStore{db: db}
+	s.lastSweep.Store(time.Now().UnixNano())
+	return s
+}
+
+// Hit counts a request for key in the window starting at window. The
+// increment is a single upsert, so concurrent requests can't lose hits.
+func (s *SQLStore) Hit(ctx context.Context, key string, window time.Time, period time.Duration) (int64, int64, error) {
+	db := s.db.WithContext(ctx)
+
+	row := counterRow{CounterKey: key, WindowStart: window.UnixMilli(), Hits: 1, ExpiresAt: window.Add(2 * period)}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "counter_key"}, {Name: "window_start"}},
+		DoUpdates: clause.Assignments(map[string]any{"hits": gorm.Expr("hits + 1")}),
+	}).Create(&row).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var rows []counterRow
+	previousWindow := window.Add(-period).UnixMilli()
+	err = db.Where("counter_key = ? AND window_start IN ?", key, []int64{window.UnixMilli(), previousWindow}).Find(&rows).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var current, previous int64
+	for _, row := range rows {
+		if row.WindowStart == previousWindow {
+			previous = row.Hits
+		} else {
+			current = row.Hits
+		}
+	}
+
+	s.sweep(ctx)
+	return current, previous, nil
+}
+
+// sweep deletes expired counters, at most once per sweepInterval across
+// concurrent requests
+func (s *SQLStore) sweep(ctx context.Context) {
+	now := time.Now()
+	last := s.lastSweep.Load()
+	if now.Sub(time.Unix(0, last)) < sweepInterval || !s.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	// A failed sweep is retried an interval later; expired counters are never read
+	s.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&counterRow{})
+}
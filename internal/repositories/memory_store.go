@@ -0,0 +1,756 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/validation"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultRoleID is the role given to users created without one, as the users.role_id column default
+const defaultRoleID = 2
+
+// MemoryStore is a thread-safe in-memory Store with the same semantics as
+// UserRepository, including ErrDuplicate on unique fields and returning the
+// context's error once it is done. It is meant for tests and local
+// experiments; nothing is persisted.
+type MemoryStore struct {
+	mu *sync.RWMutex
+
+	// inTx is set on the store handed to a Transaction callback, which
+	// already holds mu for the whole transaction
+	inTx bool
+
+	*memoryData
+}
+
+// memoryData is the state of a MemoryStore, copied on Transaction so it can be rolled back
+type memoryData struct {
+	nextID uint
+
+	users            map[uint]models.User
+	roles            map[uint]models.Role
+	userRoles        map[uint]map[uint]bool // user ID -> role IDs
+	authLogs         []models.AuthLog
+	blacklist        map[string]models.TokenBlacklist
+	emailTokens      map[uint]models.EmailVerificationToken
+	refreshTokens    map[uint]models.RefreshToken
+	credentials      map[uint]models.WebAuthnCredential
+	webAuthnSessions map[string]models.WebAuthnSession
+}
+
+// NewMemoryStore creates an empty in-memory store seeded with the admin and user roles
+func NewMemoryStore() *MemoryStore {
+	now := time.Now()
+	return &MemoryStore{
+		mu: &sync.RWMutex{},
+		memoryData: &memoryData{
+			nextID: 2, // After the seeded roles
+			users:  make(map[uint]models.User),
+			roles: map[uint]models.Role{
+				1: {ID: 1, RoleType: "admin", CreatedAt: now},
+				2: {ID: 2, RoleType: "user", CreatedAt: now},
+			},
+			userRoles:        make(map[uint]map[uint]bool),
+			blacklist:        make(map[string]models.TokenBlacklist),
+			emailTokens:      make(map[uint]models.EmailVerificationToken),
+			refreshTokens:    make(map[uint]models.RefreshToken),
+			credentials:      make(map[uint]models.WebAuthnCredential),
+			webAuthnSessions: make(map[string]models.WebAuthnSession),
+		},
+	}
+}
+
+// clone returns a copy of the data that shares no maps or slices with d
+func (d *memoryData) clone() *memoryData {
+	userRoles := make(map[uint]map[uint]bool, len(d.userRoles))
+	for userID, roleIDs := range d.userRoles {
+		userRoles[userID] = maps.Clone(roleIDs)
+	}
+	return &memoryData{
+		nextID:           d.nextID,
+		users:            maps.Clone(d.users),
+		roles:            maps.Clone(d.roles),
+		userRoles:        userRoles,
+		authLogs:         slices.Clone(d.authLogs),
+		blacklist:        maps.Clone(d.blacklist),
+		emailTokens:      maps.Clone(d.emailTokens),
+		refreshTokens:    maps.Clone(d.refreshTokens),
+		credentials:      maps.Clone(d.credentials),
+		webAuthnSessions: maps.Clone(d.webAuthnSessions),
+	}
+}
+
+// Transaction runs fn against a copy of the store while holding the write
+// lock, so transactions are serialized, and keeps the copy only when fn
+// succeeds. Called from inside a transaction, fn joins the outer one.
+func (s *MemoryStore) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.inTx {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := s.memoryData.clone()
+	if err := fn(&MemoryStore{mu: s.mu, inTx: true, memoryData: data}); err != nil {
+		return err
+	}
+	*s.memoryData = *data
+	return nil
+}
+
+// The lock helpers are no-ops inside a transaction, which holds the write lock already
+func (s *MemoryStore) lock() {
+	if !s.inTx {
+		s.mu.Lock()
+	}
+}
+
+func (s *MemoryStore) unlock() {
+	if !s.inTx {
+		s.mu.Unlock()
+	}
+}
+
+func (s *MemoryStore) rlock() {
+	if !s.inTx {
+		s.mu.RLock()
+	}
+}
+
+func (s *MemoryStore) runlock() {
+	if !s.inTx {
+		s.mu.RUnlock()
+	}
+}
+
+// newID returns the next record ID; callers must hold the write lock
+func (s *MemoryStore) newID() uint {
+	s.nextID++
+	return s.nextID
+}
+
+// stamp sets *createdAt to now when it is zero
+func stamp(createdAt *time.Time) {
+	if createdAt.IsZero() {
+		*createdAt = time.Now()
+	}
+}
+
+// FindByEmail finds a user by email, ignoring case and surrounding whitespace
+func (s *MemoryStore) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	email = validation.NormalizeEmail(email)
+	for _, user := range s.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, nil // User not found
+}
+
+// FindByID finds a user by ID along with their role
+func (s *MemoryStore) FindByID(ctx context.Context, id uint) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, nil // User not found
+	}
+	user.Role = s.roles[user.RoleID]
+	return &user, nil
+}
+
+// Create creates a new user, storing the email address normalized
+func (s *MemoryStore) Create(ctx context.Context, user *models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	user.Email = validation.NormalizeEmail(user.Email)
+	for _, existing := range s.users {
+		if existing.Email == user.Email {
+			return ErrDuplicate
+		}
+	}
+
+	if user.RoleID == 0 {
+		user.RoleID = defaultRoleID
+	}
+	stamp(&user.CreatedAt)
+	stamp(&user.UpdatedAt)
+	user.ID = s.newID()
+
+	stored := *user
+	stored.Role = models.Role{}
+	s.users[user.ID] = stored
+	return nil
+}
+
+// AddRole adds a role to a user, creating the role if it doesn't exist
+func (s *MemoryStore) AddRole(ctx context.Context, userID uint, roleType string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	var role *models.Role
+	for _, existing := range s.roles {
+		if existing.RoleType == roleType {
+			role = &existing
+			break
+		}
+	}
+	if role == nil {
+		role = &models.Role{ID: s.newID(), RoleType: roleType, CreatedAt: time.Now()}
+		s.roles[role.ID] = *role
+	}
+
+	if s.userRoles[userID] == nil {
+		s.userRoles[userID] = make(map[uint]bool)
+	}
+	s.userRoles[userID][role.ID] = true
+	return nil
+}
+
+// updateUser applies fn to a stored user; missing users are ignored like an UPDATE matching no rows
+func (s *MemoryStore) updateUser(userID uint, fn func(user *models.User)) error {
+	s.lock()
+	defer s.unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil
+	}
+	fn(&user)
+	user.UpdatedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+// UpdateLastLogin updates the user's last login time
+func (s *MemoryStore) UpdateLastLogin(ctx context.Context, userID uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.updateUser(userID, func(user *models.User) {
+		now := time.Now()
+		user.LastLogin = &now
+	})
+}
+
+// UpdateUserVerification updates user verification status
+func (s *MemoryStore) UpdateUserVerification(ctx context.Context, userID uint, isVerified bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.updateUser(userID, func(user *models.User) {
+		user.IsVerified = isVerified
+	})
+}
+
+// UpdateUserEmail changes a user's email address, storing it normalized
+func (s *MemoryStore) UpdateUserEmail(ctx context.Context, userID uint, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	email = validation.NormalizeEmail(email)
+	for id, existing := range s.users {
+		if id != userID && existing.Email == email {
+			return ErrDuplicate
+		}
+	}
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil
+	}
+	user.Email = email
+	user.UpdatedAt = time.Now()
+	s.users[userID] = user
+	return nil
+}
+
+// UpdatePassword stores a new password hash for a user
+func (s *MemoryStore) UpdatePassword(ctx context.Context, userID uint, passwordHash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.updateUser(userID, func(user *models.User) {
+		user.Password = passwordHash
+	})
+}
+
+// LogAuth logs an authentication attempt
+func (s *MemoryStore) LogAuth(ctx context.Context, log *models.AuthLog) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	stamp(&log.CreatedAt)
+	log.ID = s.newID()
+	s.authLogs = append(s.authLogs, *log)
+	return nil
+}
+
+// AuthLogs returns a copy of the recorded authentication attempts, oldest first
+func (s *MemoryStore) AuthLogs() []models.AuthLog {
+	s.rlock()
+	defer s.runlock()
+
+	return append([]models.AuthLog(nil), s.authLogs...)
+}
+
+// BlacklistToken adds a token to the blacklist
+func (s *MemoryStore) BlacklistToken(ctx context.Context, blacklist *models.TokenBlacklist) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.blacklist[blacklist.TokenJTI]; ok {
+		return ErrDuplicate
+	}
+	stamp(&blacklist.CreatedAt)
+	blacklist.ID = s.newID()
+	s.blacklist[blacklist.TokenJTI] = *blacklist
+	return nil
+}
+
+// IsTokenBlacklisted checks if a token is blacklisted
+func (s *MemoryStore) IsTokenBlacklisted(ctx context.Context, tokenJTI string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	_, ok := s.blacklist[tokenJTI]
+	return ok, nil
+}
+
+// CreateEmailVerificationToken creates an email verification token
+func (s *MemoryStore) CreateEmailVerificationToken(ctx context.Context, token *models.EmailVerificationToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	for _, existing := range s.emailTokens {
+		if existing.Token == token.Token {
+			return ErrDuplicate
+		}
+	}
+	if token.Purpose == "" {
+		token.Purpose = models.TokenPurposeEmailVerification
+	}
+	stamp(&token.CreatedAt)
+	token.ID = s.newID()
+	s.emailTokens[token.ID] = *token
+	return nil
+}
+
+// FindEmailVerificationToken finds an email token issued for the given purpose
+func (s *MemoryStore) FindEmailVerificationToken(ctx context.Context, token, purpose string) (*models.EmailVerificationToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	for _, existing := range s.emailTokens {
+		if existing.Token == token && existing.Purpose == purpose {
+			return &existing, nil
+		}
+	}
+	return nil, ErrTokenNotFound
+}
+
+// MarkEmailTokenAsUsed marks an unused email verification token as used,
+// returning ErrTokenUsed if it was used already or doesn't exist
+func (s *MemoryStore) MarkEmailTokenAsUsed(ctx context.Context, tokenID uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	token, ok := s.emailTokens[tokenID]
+	if !ok || token.Used {
+		return ErrTokenUsed
+	}
+	token.Used = true
+	s.emailTokens[tokenID] = token
+	return nil
+}
+
+// InvalidateEmailChangeTokens marks all pending email change and cancel tokens of a user as used
+func (s *MemoryStore) InvalidateEmailChangeTokens(ctx context.Context, userID uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	for id, token := range s.emailTokens {
+		if token.UserID == userID && !token.Used &&
+			(token.Purpose == models.TokenPurposeEmailChange || token.Purpose == models.TokenPurposeEmailChangeCancel) {
+			token.Used = true
+			s.emailTokens[id] = token
+		}
+	}
+	return nil
+}
+
+// CreateRefreshToken creates a refresh token
+func (s *MemoryStore) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	for _, existing := range s.refreshTokens {
+		if existing.Token == token.Token {
+			return ErrDuplicate
+		}
+	}
+	stamp(&token.CreatedAt)
+	token.ID = s.newID()
+	s.refreshTokens[token.ID] = *token
+	return nil
+}
+
+// FindRefreshToken finds an unused refresh token
+func (s *MemoryStore) FindRefreshToken(ctx context.Context, token string) (*models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	for _, existing := range s.refreshTokens {
+		if existing.Token == token && !existing.Used {
+			return &existing, nil
+		}
+	}
+	return nil, ErrInvalidRefreshToken
+}
+
+// MarkRefreshTokenAsUsed marks an unused refresh token as used, returning
+// ErrInvalidRefreshToken if it was used already or doesn't exist
+func (s *MemoryStore) MarkRefreshTokenAsUsed(ctx context.Context, tokenID uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	token, ok := s.refreshTokens[tokenID]
+	if !ok || token.Used {
+		return ErrInvalidRefreshToken
+	}
+	token.Used = true
+	s.refreshTokens[tokenID] = token
+	return nil
+}
+
+// UpdateRefreshTokenAccessToken records the access token issued with an unused
+// refresh token, returning ErrInvalidRefreshToken if it was used already or doesn't exist
+func (s *MemoryStore) UpdateRefreshTokenAccessToken(ctx context.Context, tokenID uint, jti string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	token, ok := s.refreshTokens[tokenID]
+	if !ok || token.Used {
+		return ErrInvalidRefreshToken
+	}
+	token.AccessTokenJTI = jti
+	token.AccessTokenExpiresAt = expiresAt
+	s.refreshTokens[tokenID] = token
+	return nil
+}
+
+// RevokeOtherSessions marks the refresh tokens of every session but the given one as used
+func (s *MemoryStore) RevokeOtherSessions(ctx context.Context, userID uint, keepSessionID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	for id, token := range s.refreshTokens {
+		if token.UserID == userID && otherSession(token, keepSessionID) && !token.Used {
+			token.Used = true
+			s.refreshTokens[id] = token
+		}
+	}
+	return nil
+}
+
+// FindOtherSessionAccessTokens returns the refresh token records of other sessions whose
+// accompanying access token hasn't expired yet
+func (s *MemoryStore) FindOtherSessionAccessTokens(ctx context.Context, userID uint, keepSessionID string) ([]models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	now := time.Now()
+	var tokens []models.RefreshToken
+	for _, token := range s.refreshTokens {
+		if token.UserID == userID && otherSession(token, keepSessionID) &&
+			token.AccessTokenJTI != "" && token.AccessTokenExpiresAt.After(now) {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+// otherSession reports whether token belongs to a session other than
+// keepSessionID; tokens issued before sessions were tracked have no session ID
+// and never belong to the kept session
+func otherSession(token models.RefreshToken, keepSessionID string) bool {
+	return token.SessionID == "" || token.SessionID != keepSessionID
+}
+
+// RevokeUserRefreshTokens marks every outstanding refresh token of a user as used
+func (s *MemoryStore) RevokeUserRefreshTokens(ctx context.Context, userID uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	for id, token := range s.refreshTokens {
+		if token.UserID == userID && !token.Used {
+			token.Used = true
+			s.refreshTokens[id] = token
+		}
+	}
+	return nil
+}
+
+// CleanupExpiredTokens removes expired tokens
+func (s *MemoryStore) CleanupExpiredTokens(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	now := time.Now()
+	for id, token := range s.emailTokens {
+		if token.ExpiresAt.Before(now) {
+			delete(s.emailTokens, id)
+		}
+	}
+	for id, token := range s.refreshTokens {
+		if token.ExpiresAt.Before(now) {
+			delete(s.refreshTokens, id)
+		}
+	}
+	for jti, blacklisted := range s.blacklist {
+		if blacklisted.ExpiresAt.Before(now) {
+			delete(s.blacklist, jti)
+		}
+	}
+	for sessionID, session := range s.webAuthnSessions {
+		if session.ExpiresAt.Before(now) {
+			delete(s.webAuthnSessions, sessionID)
+		}
+	}
+	return nil
+}
+
+// CreateWebAuthnCredential stores a newly registered passkey
+func (s *MemoryStore) CreateWebAuthnCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	for _, existing := range s.credentials {
+		if bytes.Equal(existing.CredentialID, credential.CredentialID) {
+			return ErrDuplicate
+		}
+	}
+	stamp(&credential.CreatedAt)
+	credential.ID = s.newID()
+
+	stored := *credential
+	stored.User = models.User{}
+	s.credentials[credential.ID] = stored
+	return nil
+}
+
+// FindWebAuthnCredentialsByUserID returns all passkeys registered by a user, oldest first
+func (s *MemoryStore) FindWebAuthnCredentialsByUserID(ctx context.Context, userID uint) ([]models.WebAuthnCredential, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	var credentials []models.WebAuthnCredential
+	for _, credential := range s.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		if !credentials[i].CreatedAt.Equal(credentials[j].CreatedAt) {
+			return credentials[i].CreatedAt.Before(credentials[j].CreatedAt)
+		}
+		return credentials[i].ID < credentials[j].ID
+	})
+	return credentials, nil
+}
+
+// FindWebAuthnCredentialByCredentialID finds a passkey by its authenticator-assigned credential ID
+func (s *MemoryStore) FindWebAuthnCredentialByCredentialID(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	for _, credential := range s.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			return &credential, nil
+		}
+	}
+	return nil, nil // Credential not found
+}
+
+// UpdateWebAuthnCredentialUsage records the sign count and clone warning after an assertion
+func (s *MemoryStore) UpdateWebAuthnCredentialUsage(ctx context.Context, credentialID uint, signCount uint32, cloneWarning bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	credential, ok := s.credentials[credentialID]
+	if !ok {
+		return nil
+	}
+	credential.SignCount = signCount
+	credential.CloneWarning = cloneWarning
+	if !cloneWarning {
+		now := time.Now()
+		credential.LastUsedAt = &now
+	}
+	s.credentials[credentialID] = credential
+	return nil
+}
+
+// DeleteWebAuthnCredential removes a passkey owned by the given user
+func (s *MemoryStore) DeleteWebAuthnCredential(ctx context.Context, userID, credentialID uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	credential, ok := s.credentials[credentialID]
+	if !ok || credential.UserID != userID {
+		return ErrPasskeyNotFound
+	}
+	delete(s.credentials, credentialID)
+	return nil
+}
+
+// CreateWebAuthnSession stores the state of a WebAuthn ceremony
+func (s *MemoryStore) CreateWebAuthnSession(ctx context.Context, session *models.WebAuthnSession) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, ok := s.webAuthnSessions[session.SessionID]; ok {
+		return ErrDuplicate
+	}
+	stamp(&session.CreatedAt)
+	session.ID = s.newID()
+	s.webAuthnSessions[session.SessionID] = *session
+	return nil
+}
+
+// ConsumeWebAuthnSession finds a WebAuthn ceremony by ID and purpose and deletes it so it can only be used once
+func (s *MemoryStore) ConsumeWebAuthnSession(ctx context.Context, sessionID, purpose string) (*models.WebAuthnSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	session, ok := s.webAuthnSessions[sessionID]
+	if !ok || session.Purpose != purpose {
+		return nil, ErrSessionNotFound
+	}
+	delete(s.webAuthnSessions, sessionID)
+	return &session, nil
+}
@@ -0,0 +1,13 @@
+package repositories_test
+
+import (
+	"go-postgres-api/internal/repositories"
+	"go-postgres-api/internal/repositories/repotest"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repositories.Store {
+		return repositories.NewMemoryStore()
+	})
+}
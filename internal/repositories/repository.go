@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"go-postgres-api/internal/models"
+	"time"
+)
+
+// Errors shared by every Store implementation
+var (
+	// ErrDuplicate is returned when a record violates a unique constraint
+	ErrDuplicate = errors.New("record already exists")
+
+	ErrTokenNotFound       = errors.New("token not found")
+	ErrTokenUsed           = errors.New("token already used")
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrPasskeyNotFound     = errors.New("passkey not found")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// UserStore persists users and their roles.
+// Lookups return nil, nil when the user doesn't exist.
+type UserStore interface {
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByID(ctx context.Context, id uint) (*models.User, error)
+	Create(ctx context.Context, user *models.User) error
+	AddRole(ctx context.Context, userID uint, roleType string) error
+	UpdateLastLogin(ctx context.Context, userID uint) error
+	UpdateUserVerification(ctx context.Context, userID uint, isVerified bool) error
+	UpdateUserEmail(ctx context.Context, userID uint, email string) error
+	UpdatePassword(ctx context.Context, userID uint, passwordHash string) error
+}
+
+// TokenStore persists email tokens and refresh tokens
+type TokenStore interface {
+	CreateEmailVerificationToken(ctx context.Context, token *models.EmailVerificationToken) error
+	FindEmailVerificationToken(ctx context.Context, token, purpose string) (*models.EmailVerificationToken, error)
+	// MarkEmailTokenAsUsed claims an unused token; it returns ErrTokenUsed when the token was used already
+	MarkEmailTokenAsUsed(ctx context.Context, tokenID uint) error
+	InvalidateEmailChangeTokens(ctx context.Context, userID uint) error
+
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	FindRefreshToken(ctx context.Context, token string) (*models.RefreshToken, error)
+	// MarkRefreshTokenAsUsed claims an unused token; it returns ErrInvalidRefreshToken when the token was used already
+	MarkRefreshTokenAsUsed(ctx context.Context, tokenID uint) error
+	// UpdateRefreshTokenAccessToken records the access token issued with an unused refresh token;
+	// it returns ErrInvalidRefreshToken when the token was used already
+	UpdateRefreshTokenAccessToken(ctx context.Context, tokenID uint, jti string, expiresAt time.Time) error
+	RevokeOtherSessions(ctx context.Context, userID uint, keepSessionID string) error
+	FindOtherSessionAccessTokens(ctx context.Context, userID uint, keepSessionID string) ([]models.RefreshToken, error)
+	RevokeUserRefreshTokens(ctx context.Context, userID uint) error
+
+	CleanupExpiredTokens(ctx context.Context) error
+}
+
+// AuthLogStore records authentication attempts
+type AuthLogStore interface {
+	LogAuth(ctx context.Context, log *models.AuthLog) error
+}
+
+// BlacklistStore persists revoked access tokens
+type BlacklistStore interface {
+	BlacklistToken(ctx context.Context, blacklist *models.TokenBlacklist) error
+	IsTokenBlacklisted(ctx context.Context, tokenJTI string) (bool, error)
+}
+
+// WebAuthnStore persists passkeys and in-progress WebAuthn ceremonies
+type WebAuthnStore interface {
+	CreateWebAuthnCredential(ctx context.Context, credential *models.WebAuthnCredential) error
+	FindWebAuthnCredentialsByUserID(ctx context.Context, userID uint) ([]models.WebAuthnCredential, error)
+	FindWebAuthnCredentialByCredentialID(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error)
+	UpdateWebAuthnCredentialUsage(ctx context.Context, credentialID uint, signCount uint32, cloneWarning bool) error
+	DeleteWebAuthnCredential(ctx context.Context, userID, credentialID uint) error
+	CreateWebAuthnSession(ctx context.Context, session *models.WebAuthnSession) error
+	ConsumeWebAuthnSession(ctx context.Context, sessionID, purpose string) (*models.WebAuthnSession, error)
+}
+
+// Transactor runs a unit of work atomically. fn receives a Store bound to
+// the transaction and must use it for every call that belongs to the unit;
+// the transaction commits when fn returns nil and rolls back otherwise.
+type Transactor interface {
+	Transaction(ctx context.Context, fn func(tx Store) error) error
+}
+
+// Store is everything the services persist. UserRepository implements it on
+// top of gorm and MemoryStore in memory.
+type Store interface {
+	UserStore
+	TokenStore
+	AuthLogStore
+	BlacklistStore
+	WebAuthnStore
+	Transactor
+}
+
+var (
+	_ Store = (*UserRepository)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
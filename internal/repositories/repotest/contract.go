@@ -0,0 +1,668 @@
+// Package repotest provides the contract every repositories.Store implementation must satisfy.
+//
+// An implementation's tests call Run with a constructor returning an empty store
+// (migrated, with the default roles seeded), e.g.
+//
+//	func TestMemoryStore(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repositories.Store {
+//			return repositories.NewMemoryStore()
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/repositories"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ctx is used for every store call that isn't about cancellation
+var ctx = context.Background()
+
+// Run runs the store contract against stores created by newStore; every
+// subtest gets its own store
+func Run(t *testing.T, newStore func(t *testing.T) repositories.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store repositories.Store)
+	}{
+		{"Users", testUsers},
+		{"UserUniqueEmail", testUserUniqueEmail},
+		{"UserEmailNormalized", testUserEmailNormalized},
+		{"UserUpdates", testUserUpdates},
+		{"AddRole", testAddRole},
+		{"EmailTokens", testEmailTokens},
+		{"RefreshTokens", testRefreshTokens},
+		{"Sessions", testSessions},
+		{"SessionlessRefreshTokens", testSessionlessRefreshTokens},
+		{"Blacklist", testBlacklist},
+		{"AuthLog", testAuthLog},
+		{"CleanupExpiredTokens", testCleanupExpiredTokens},
+		{"WebAuthnCredentials", testWebAuthnCredentials},
+		{"WebAuthnSessions", testWebAuthnSessions},
+		{"ConcurrentSessionConsume", testConcurrentSessionConsume},
+		{"TransactionCommit", testTransactionCommit},
+		{"TransactionRollback", testTransactionRollback},
+		{"ConcurrentRefreshRotation", testConcurrentRefreshRotation},
+		{"CanceledContext", testCanceledContext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// createUser stores a user with the given email and fails the test on error
+func createUser(t *testing.T, store repositories.Store, email string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, Name: "Test User", Password: "hash", IsActive: true, RoleID: 2}
+	if err := store.Create(ctx, user); err != nil {
+		t.Fatalf("Create(%s): %v", email, err)
+	}
+	if user.ID == 0 {
+		t.Fatalf("Create(%s) did not assign an ID", email)
+	}
+	return user
+}
+
+// must fails the test when err is not nil
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testUsers(t *testing.T, store repositories.Store) {
+	user := createUser(t, store, "alice@example.com")
+
+	found, err := store.FindByEmail(ctx, "alice@example.com")
+	must(t, err)
+	if found == nil || found.ID != user.ID {
+		t.Fatalf("FindByEmail = %+v, want user %d", found, user.ID)
+	}
+
+	found, err = store.FindByID(ctx, user.ID)
+	must(t, err)
+	if found == nil || found.Email != user.Email {
+		t.Fatalf("FindByID = %+v, want %s", found, user.Email)
+	}
+	if found.Role.RoleType != "user" {
+		t.Errorf("FindByID role = %q, want the seeded user role", found.Role.RoleType)
+	}
+
+	// Missing users are reported as nil, nil
+	if found, err := store.FindByEmail(ctx, "nobody@example.com"); found != nil || err != nil {
+		t.Errorf("FindByEmail(missing) = %+v, %v, want nil, nil", found, err)
+	}
+	if found, err := store.FindByID(ctx, user.ID+1000); found != nil || err != nil {
+		t.Errorf("FindByID(missing) = %+v, %v, want nil, nil", found, err)
+	}
+}
+
+func testUserUniqueEmail(t *testing.T, store repositories.Store) {
+	createUser(t, store, "alice@example.com")
+	bob := createUser(t, store, "bob@example.com")
+
+	err := store.Create(ctx, &models.User{Email: "alice@example.com", Name: "Other", Password: "hash", RoleID: 2})
+	if !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("Create(duplicate email) = %v, want ErrDuplicate", err)
+	}
+
+	if err := store.UpdateUserEmail(ctx, bob.ID, "alice@example.com"); !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("UpdateUserEmail(taken) = %v, want ErrDuplicate", err)
+	}
+}
+
+func testUserEmailNormalized(t *testing.T, store repositories.Store) {
+	alice := createUser(t, store, " Alice@Example.COM ")
+	if alice.Email != "alice@example.com" {
+		t.Errorf("Create stored email %q, want alice@example.com", alice.Email)
+	}
+
+	for _, email := range []string{"alice@example.com", "ALICE@EXAMPLE.COM", "  Alice@example.com\t"} {
+		found, err := store.FindByEmail(ctx, email)
+		must(t, err)
+		if found == nil || found.ID != alice.ID {
+			t.Errorf("FindByEmail(%q) = %+v, want user %d", email, found, alice.ID)
+		}
+	}
+
+	err := store.Create(ctx, &models.User{Email: "ALICE@example.com ", Name: "Other", Password: "hash", RoleID: 2})
+	if !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("Create(email differing in case) = %v, want ErrDuplicate", err)
+	}
+
+	bob := createUser(t, store, "bob@example.com")
+	if err := store.UpdateUserEmail(ctx, bob.ID, " Alice@EXAMPLE.com"); !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("UpdateUserEmail(taken, differing in case) = %v, want ErrDuplicate", err)
+	}
+	must(t, store.UpdateUserEmail(ctx, bob.ID, " Robert@Example.com "))
+	found, err := store.FindByID(ctx, bob.ID)
+	must(t, err)
+	if found.Email != "robert@example.com" {
+		t.Errorf("UpdateUserEmail stored %q, want robert@example.com", found.Email)
+	}
+}
+
+func testUserUpdates(t *testing.T, store repositories.Store) {
+	user := createUser(t, store, "alice@example.com")
+
+	must(t, store.UpdateUserVerification(ctx, user.ID, true))
+	must(t, store.UpdatePassword(ctx, user.ID, "new-hash"))
+	must(t, store.UpdateUserEmail(ctx, user.ID, "alice@example.org"))
+	must(t, store.UpdateLastLogin(ctx, user.ID))
+
+	found, err := store.FindByID(ctx, user.ID)
+	must(t, err)
+	if !found.IsVerified || found.Password != "new-hash" || found.Email != "alice@example.org" || found.LastLogin == nil {
+		t.Errorf("updates not applied: %+v", found)
+	}
+
+	// Updating a missing user is not an error, like an UPDATE that matches no rows
+	must(t, store.UpdatePassword(ctx, user.ID+1000, "hash"))
+}
+
+func testAddRole(t *testing.T, store repositories.Store) {
+	user := createUser(t, store, "alice@example.com")
+
+	must(t, store.AddRole(ctx, user.ID, "admin"))
+	must(t, store.AddRole(ctx, user.ID, "admin")) // Adding a role twice is a no-op
+	must(t, store.AddRole(ctx, user.ID, "auditor"))
+}
+
+func testEmailTokens(t *testing.T, store repositories.Store) {
+	user := createUser(t, store, "alice@example.com")
+	expiresAt := time.Now().Add(time.Hour)
+
+	verification := &models.EmailVerificationToken{UserID: user.ID, Token: "verify", Purpose: models.TokenPurposeEmailVerification, ExpiresAt: expiresAt}
+	must(t, store.CreateEmailVerificationToken(ctx, verification))
+
+	duplicate := &models.EmailVerificationToken{UserID: user.ID, Token: "verify", Purpose: models.TokenPurposeEmailVerification, ExpiresAt: expiresAt}
+	if err := store.CreateEmailVerificationToken(ctx, duplicate); !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("CreateEmailVerificationToken(duplicate) = %v, want ErrDuplicate", err)
+	}
+
+	found, err := store.FindEmailVerificationToken(ctx, "verify", models.TokenPurposeEmailVerification)
+	must(t, err)
+	if found.ID != verification.ID || found.Used {
+		t.Errorf("FindEmailVerificationToken = %+v", found)
+	}
+
+	// A token is only found for the purpose it was issued for
+	if _, err := store.FindEmailVerificationToken(ctx, "verify", models.TokenPurposeEmailChange); !errors.Is(err, repositories.ErrTokenNotFound) {
+		t.Errorf("FindEmailVerificationToken(wrong purpose) = %v, want ErrTokenNotFound", err)
+	}
+	if _, err := store.FindEmailVerificationToken(ctx, "missing", models.TokenPurposeEmailVerification); !errors.Is(err, repositories.ErrTokenNotFound) {
+		t.Errorf("FindEmailVerificationToken(missing) = %v, want ErrTokenNotFound", err)
+	}
+
+	must(t, store.MarkEmailTokenAsUsed(ctx, verification.ID))
+	found, err = store.FindEmailVerificationToken(ctx, "verify", models.TokenPurposeEmailVerification)
+	must(t, err)
+	if !found.Used {
+		t.Error("MarkEmailTokenAsUsed did not mark the token as used")
+	}
+
+	// A token can only be claimed once
+	if err := store.MarkEmailTokenAsUsed(ctx, verification.ID); !errors.Is(err, repositories.ErrTokenUsed) {
+		t.Errorf("MarkEmailTokenAsUsed(twice) = %v, want ErrTokenUsed", err)
+	}
+
+	// InvalidateEmailChangeTokens only touches pending change and cancel tokens
+	change := &models.EmailVerificationToken{UserID: user.ID, Token: "change", Purpose: models.TokenPurposeEmailChange, NewEmail: "new@example.com", ExpiresAt: expiresAt}
+	cancel := &models.EmailVerificationToken{UserID: user.ID, Token: "cancel", Purpose: models.TokenPurposeEmailChangeCancel, ExpiresAt: expiresAt}
+	other := &models.EmailVerificationToken{UserID: user.ID, Token: "verify-2", Purpose: models.TokenPurposeEmailVerification, ExpiresAt: expiresAt}
+	must(t, store.CreateEmailVerificationToken(ctx, change))
+	must(t, store.CreateEmailVerificationToken(ctx, cancel))
+	must(t, store.CreateEmailVerificationToken(ctx, other))
+	must(t, store.InvalidateEmailChangeTokens(ctx, user.ID))
+
+	for _, tc := range []struct {
+		token, purpose string
+		used           bool
+	}{
+		{"change", models.TokenPurposeEmailChange, true},
+		{"cancel", models.TokenPurposeEmailChangeCancel, true},
+		{"verify-2", models.TokenPurposeEmailVerification, false},
+	} {
+		found, err := store.FindEmailVerificationToken(ctx, tc.token, tc.purpose)
+		must(t, err)
+		if found.Used != tc.used {
+			t.Errorf("after InvalidateEmailChangeTokens, %s used = %v, want %v", tc.token, found.Used, tc.used)
+		}
+	}
+}
+
+func testRefreshTokens(t *testing.T, store repositories.Store) {
+	user := createUser(t, store, "alice@example.com")
+
+	token := &models.RefreshToken{UserID: user.ID, SessionID: "s1", ClientID: "mobile", Token: "refresh", ExpiresAt: time.Now().Add(time.Hour)}
+	must(t, store.CreateRefreshToken(ctx, token))
+
+	duplicate := &models.RefreshToken{UserID: user.ID, SessionID: "s1", Token: "refresh", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.CreateRefreshToken(ctx, duplicate); !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("CreateRefreshToken(duplicate) = %v, want ErrDuplicate", err)
+	}
+
+	found, err := store.FindRefreshToken(ctx, "refresh")
+	must(t, err)
+	if found.ID != token.ID || found.SessionID != "s1" || found.ClientID != "mobile" {
+		t.Errorf("FindRefreshToken = %+v", found)
+	}
+
+	// A reused refresh token tracks the latest access token issued with it
+	accessExpiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+	must(t, store.UpdateRefreshTokenAccessToken(ctx, token.ID, "jti-new", accessExpiresAt))
+	found, err = store.FindRefreshToken(ctx, "refresh")
+	must(t, err)
+	if found.AccessTokenJTI != "jti-new" || !found.AccessTokenExpiresAt.Equal(accessExpiresAt) {
+		t.Errorf("after UpdateRefreshTokenAccessToken, token = %+v", found)
+	}
+
+	// Used tokens can no longer be found
+	must(t, store.MarkRefreshTokenAsUsed(ctx, token.ID))
+	if _, err := store.FindRefreshToken(ctx, "refresh"); !errors.Is(err, repositories.ErrInvalidRefreshToken) {
+		t.Errorf("FindRefreshToken(used) = %v, want ErrInvalidRefreshToken", err)
+	}
+	if err := store.MarkRefreshTokenAsUsed(ctx, token.ID); !errors.Is(err, repositories.ErrInvalidRefreshToken) {
+		t.Errorf("MarkRefreshTokenAsUsed(twice) = %v, want ErrInvalidRefreshToken", err)
+	}
+	if err := store.UpdateRefreshTokenAccessToken(ctx, token.ID, "jti-late", accessExpiresAt); !errors.Is(err, repositories.ErrInvalidRefreshToken) {
+		t.Errorf("UpdateRefreshTokenAccessToken(used) = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := store.FindRefreshToken(ctx, "missing"); !errors.Is(err, repositories.ErrInvalidRefreshToken) {
+		t.Errorf("FindRefreshToken(missing) = %v, want ErrInvalidRefreshToken", err)
+	}
+}
+
+func testSessions(t *testing.T, store repositories.Store) {
+	alice := createUser(t, store, "alice@example.com")
+	bob := createUser(t, store, "bob@example.com")
+	now := time.Now()
+
+	tokens := []*models.RefreshToken{
+		{UserID: alice.ID, SessionID: "current", Token: "t1", AccessTokenJTI: "jti-1", AccessTokenExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{UserID: alice.ID, SessionID: "other", Token: "t2", AccessTokenJTI: "jti-2", AccessTokenExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{UserID: alice.ID, SessionID: "stale", Token: "t3", AccessTokenJTI: "jti-3", AccessTokenExpiresAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{UserID: bob.ID, SessionID: "bob", Token: "t4", AccessTokenJTI: "jti-4", AccessTokenExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, token := range tokens {
+		must(t, store.CreateRefreshToken(ctx, token))
+	}
+
+	// Only other sessions of the same user with a live access token are returned
+	others, err := store.FindOtherSessionAccessTokens(ctx, alice.ID, "current")
+	must(t, err)
+	if len(others) != 1 || others[0].AccessTokenJTI != "jti-2" {
+		t.Errorf("FindOtherSessionAccessTokens = %+v, want only jti-2", others)
+	}
+
+	must(t, store.RevokeOtherSessions(ctx, alice.ID, "current"))
+	assertRefreshTokenValid(t, store, "t1", true)
+	assertRefreshTokenValid(t, store, "t2", false)
+	assertRefreshTokenValid(t, store, "t3", false)
+	assertRefreshTokenValid(t, store, "t4", true)
+
+	must(t, store.RevokeUserRefreshTokens(ctx, alice.ID))
+	assertRefreshTokenValid(t, store, "t1", false)
+	assertRefreshTokenValid(t, store, "t4", true)
+}
+
+// testSessionlessRefreshTokens checks that tokens issued before sessions were
+// tracked, which have no session ID, are signed out with the other sessions
+func testSessionlessRefreshTokens(t *testing.T, store repositories.Store) {
+	alice := createUser(t, store, "alice@example.com")
+	now := time.Now()
+
+	tokens := []*models.RefreshToken{
+		{UserID: alice.ID, SessionID: "current", Token: "t1", AccessTokenJTI: "jti-1", AccessTokenExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)},
+		{UserID: alice.ID, Token: "legacy-1", ExpiresAt: now.Add(time.Hour)},
+		{UserID: alice.ID, Token: "legacy-2", AccessTokenJTI: "jti-2", AccessTokenExpiresAt: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)},
+	}
+	for _, token := range tokens {
+		must(t, store.CreateRefreshToken(ctx, token))
+	}
+
+	others, err := store.FindOtherSessionAccessTokens(ctx, alice.ID, "current")
+	must(t, err)
+	if len(others) != 1 || others[0].AccessTokenJTI != "jti-2" {
+		t.Errorf("FindOtherSessionAccessTokens = %+v, want only jti-2", others)
+	}
+	// No session ID never matches the kept session, even an empty one
+	others, err = store.FindOtherSessionAccessTokens(ctx, alice.ID, "")
+	must(t, err)
+	if len(others) != 2 {
+		t.Errorf("FindOtherSessionAccessTokens(no session) = %+v, want jti-1 and jti-2", others)
+	}
+
+	must(t, store.RevokeOtherSessions(ctx, alice.ID, "current"))
+	assertRefreshTokenValid(t, store, "t1", true)
+	assertRefreshTokenValid(t, store, "legacy-1", false)
+	assertRefreshTokenValid(t, store, "legacy-2", false)
+}
+
+// assertRefreshTokenValid checks whether FindRefreshToken still accepts token
+func assertRefreshTokenValid(t *testing.T, store repositories.Store, token string, valid bool) {
+	t.Helper()
+	_, err := store.FindRefreshToken(ctx, token)
+	if valid && err != nil {
+		t.Errorf("refresh token %s: %v, want valid", token, err)
+	}
+	if !valid && !errors.Is(err, repositories.ErrInvalidRefreshToken) {
+		t.Errorf("refresh token %s: %v, want ErrInvalidRefreshToken", token, err)
+	}
+}
+
+func testBlacklist(t *testing.T, store repositories.Store) {
+	user := createUser(t, store, "alice@example.com")
+
+	blacklisted, err := store.IsTokenBlacklisted(ctx, "jti")
+	must(t, err)
+	if blacklisted {
+		t.Error("IsTokenBlacklisted before blacklisting = true")
+	}
+
+	must(t, store.BlacklistToken(ctx, &models.TokenBlacklist{TokenJTI: "jti", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}))
+
+	blacklisted, err = store.IsTokenBlacklisted(ctx, "jti")
+	must(t, err)
+	if !blacklisted {
+		t.Error("IsTokenBlacklisted after blacklisting = false")
+	}
+
+	err = store.BlacklistToken(ctx, &models.TokenBlacklist{TokenJTI: "jti", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)})
+	if !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("BlacklistToken(duplicate) = %v, want ErrDuplicate", err)
+	}
+}
+
+func testAuthLog(t *testing.T, store repositories.Store) {
+	user := createUser(t, store, "alice@example.com")
+
+	log := &models.AuthLog{UserID: user.ID, Action: "login", IPAddress: "127.0.0.1", Success: true}
+	must(t, store.LogAuth(ctx, log))
+	if log.ID == 0 {
+		t.Error("LogAuth did not assign an ID")
+	}
+}
+
+func testCleanupExpiredTokens(t *testing.T, store repositories.Store) {
+	user := createUser(t, store, "alice@example.com")
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	must(t, store.CreateEmailVerificationToken(ctx, &models.EmailVerificationToken{UserID: user.ID, Token: "expired", Purpose: models.TokenPurposeEmailVerification, ExpiresAt: past}))
+	must(t, store.CreateEmailVerificationToken(ctx, &models.EmailVerificationToken{UserID: user.ID, Token: "live", Purpose: models.TokenPurposeEmailVerification, ExpiresAt: future}))
+	must(t, store.CreateRefreshToken(ctx, &models.RefreshToken{UserID: user.ID, Token: "expired", ExpiresAt: past}))
+	must(t, store.CreateRefreshToken(ctx, &models.RefreshToken{UserID: user.ID, Token: "live", ExpiresAt: future}))
+	must(t, store.BlacklistToken(ctx, &models.TokenBlacklist{TokenJTI: "expired", ExpiresAt: past}))
+	must(t, store.BlacklistToken(ctx, &models.TokenBlacklist{TokenJTI: "live", ExpiresAt: future}))
+	must(t, store.CreateWebAuthnSession(ctx, &models.WebAuthnSession{SessionID: "expired", Purpose: models.WebAuthnPurposeLogin, Data: "{}", ExpiresAt: past}))
+
+	must(t, store.CleanupExpiredTokens(ctx))
+
+	if _, err := store.FindEmailVerificationToken(ctx, "expired", models.TokenPurposeEmailVerification); !errors.Is(err, repositories.ErrTokenNotFound) {
+		t.Errorf("expired email token survived cleanup: %v", err)
+	}
+	if _, err := store.FindEmailVerificationToken(ctx, "live", models.TokenPurposeEmailVerification); err != nil {
+		t.Errorf("live email token removed by cleanup: %v", err)
+	}
+	assertRefreshTokenValid(t, store, "expired", false)
+	assertRefreshTokenValid(t, store, "live", true)
+	if blacklisted, _ := store.IsTokenBlacklisted(ctx, "expired"); blacklisted {
+		t.Error("expired blacklist entry survived cleanup")
+	}
+	if blacklisted, _ := store.IsTokenBlacklisted(ctx, "live"); !blacklisted {
+		t.Error("live blacklist entry removed by cleanup")
+	}
+	if _, err := store.ConsumeWebAuthnSession(ctx, "expired", models.WebAuthnPurposeLogin); !errors.Is(err, repositories.ErrSessionNotFound) {
+		t.Errorf("expired WebAuthn session survived cleanup: %v", err)
+	}
+}
+
+func testWebAuthnCredentials(t *testing.T, store repositories.Store) {
+	alice := createUser(t, store, "alice@example.com")
+	bob := createUser(t, store, "bob@example.com")
+
+	first := &models.WebAuthnCredential{UserID: alice.ID, CredentialID: []byte("cred-1"), PublicKey: []byte("key-1"), SignCount: 1}
+	must(t, store.CreateWebAuthnCredential(ctx, first))
+	second := &models.WebAuthnCredential{UserID: alice.ID, CredentialID: []byte("cred-2"), PublicKey: []byte("key-2"), CreatedAt: first.CreatedAt.Add(time.Second)}
+	must(t, store.CreateWebAuthnCredential(ctx, second))
+
+	duplicate := &models.WebAuthnCredential{UserID: bob.ID, CredentialID: []byte("cred-1"), PublicKey: []byte("key-3")}
+	if err := store.CreateWebAuthnCredential(ctx, duplicate); !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("CreateWebAuthnCredential(duplicate) = %v, want ErrDuplicate", err)
+	}
+
+	credentials, err := store.FindWebAuthnCredentialsByUserID(ctx, alice.ID)
+	must(t, err)
+	if len(credentials) != 2 || credentials[0].ID != first.ID || credentials[1].ID != second.ID {
+		t.Errorf("FindWebAuthnCredentialsByUserID = %+v, want both passkeys oldest first", credentials)
+	}
+
+	found, err := store.FindWebAuthnCredentialByCredentialID(ctx, []byte("cred-1"))
+	must(t, err)
+	if found == nil || found.ID != first.ID || string(found.PublicKey) != "key-1" {
+		t.Errorf("FindWebAuthnCredentialByCredentialID = %+v", found)
+	}
+	if found, err := store.FindWebAuthnCredentialByCredentialID(ctx, []byte("missing")); found != nil || err != nil {
+		t.Errorf("FindWebAuthnCredentialByCredentialID(missing) = %+v, %v, want nil, nil", found, err)
+	}
+
+	must(t, store.UpdateWebAuthnCredentialUsage(ctx, first.ID, 5, false))
+	found, err = store.FindWebAuthnCredentialByCredentialID(ctx, []byte("cred-1"))
+	must(t, err)
+	if found.SignCount != 5 || found.CloneWarning || found.LastUsedAt == nil {
+		t.Errorf("after UpdateWebAuthnCredentialUsage: %+v", found)
+	}
+
+	// Passkeys can only be deleted by their owner
+	if err := store.DeleteWebAuthnCredential(ctx, bob.ID, first.ID); !errors.Is(err, repositories.ErrPasskeyNotFound) {
+		t.Errorf("DeleteWebAuthnCredential(other user) = %v, want ErrPasskeyNotFound", err)
+	}
+	must(t, store.DeleteWebAuthnCredential(ctx, alice.ID, first.ID))
+	if err := store.DeleteWebAuthnCredential(ctx, alice.ID, first.ID); !errors.Is(err, repositories.ErrPasskeyNotFound) {
+		t.Errorf("DeleteWebAuthnCredential(deleted) = %v, want ErrPasskeyNotFound", err)
+	}
+}
+
+func testWebAuthnSessions(t *testing.T, store repositories.Store) {
+	session := &models.WebAuthnSession{SessionID: "ceremony", Purpose: models.WebAuthnPurposeLogin, Data: `{"challenge":"abc"}`, ExpiresAt: time.Now().Add(time.Minute)}
+	must(t, store.CreateWebAuthnSession(ctx, session))
+
+	duplicate := &models.WebAuthnSession{SessionID: "ceremony", Purpose: models.WebAuthnPurposeLogin, Data: "{}", ExpiresAt: time.Now().Add(time.Minute)}
+	if err := store.CreateWebAuthnSession(ctx, duplicate); !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("CreateWebAuthnSession(duplicate) = %v, want ErrDuplicate", err)
+	}
+
+	if _, err := store.ConsumeWebAuthnSession(ctx, "ceremony", models.WebAuthnPurposeRegistration); !errors.Is(err, repositories.ErrSessionNotFound) {
+		t.Errorf("ConsumeWebAuthnSession(wrong purpose) = %v, want ErrSessionNotFound", err)
+	}
+
+	consumed, err := store.ConsumeWebAuthnSession(ctx, "ceremony", models.WebAuthnPurposeLogin)
+	must(t, err)
+	if consumed.Data != session.Data {
+		t.Errorf("ConsumeWebAuthnSession data = %q, want %q", consumed.Data, session.Data)
+	}
+
+	// A ceremony can only be consumed once
+	if _, err := store.ConsumeWebAuthnSession(ctx, "ceremony", models.WebAuthnPurposeLogin); !errors.Is(err, repositories.ErrSessionNotFound) {
+		t.Errorf("ConsumeWebAuthnSession(twice) = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func testConcurrentSessionConsume(t *testing.T, store repositories.Store) {
+	const sessions, workers = 5, 4
+
+	for i := 0; i < sessions; i++ {
+		must(t, store.CreateWebAuthnSession(ctx, &models.WebAuthnSession{
+			SessionID: fmt.Sprintf("ceremony-%d", i),
+			Purpose:   models.WebAuthnPurposeLogin,
+			Data:      "{}",
+			ExpiresAt: time.Now().Add(time.Minute),
+		}))
+	}
+
+	// Every session must be consumed exactly once however many requests race for it
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		consumed = make(map[string]int)
+	)
+	for i := 0; i < sessions; i++ {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(sessionID string) {
+				defer wg.Done()
+				if _, err := store.ConsumeWebAuthnSession(ctx, sessionID, models.WebAuthnPurposeLogin); err == nil {
+					mu.Lock()
+					consumed[sessionID]++
+					mu.Unlock()
+				}
+			}(fmt.Sprintf("ceremony-%d", i))
+		}
+	}
+	wg.Wait()
+
+	for i := 0; i < sessions; i++ {
+		sessionID := fmt.Sprintf("ceremony-%d", i)
+		if consumed[sessionID] != 1 {
+			t.Errorf("session %s consumed %d times, want exactly once", sessionID, consumed[sessionID])
+		}
+	}
+}
+
+func testTransactionCommit(t *testing.T, store repositories.Store) {
+	var user *models.User
+	err := store.Transaction(ctx, func(tx repositories.Store) error {
+		user = createUser(t, tx, "alice@example.com")
+		return tx.CreateEmailVerificationToken(ctx, &models.EmailVerificationToken{
+			UserID:    user.ID,
+			Token:     "verify",
+			Purpose:   models.TokenPurposeEmailVerification,
+			ExpiresAt: time.Now().Add(time.Hour),
+		})
+	})
+	must(t, err)
+
+	if found, err := store.FindByEmail(ctx, "alice@example.com"); err != nil || found == nil {
+		t.Fatalf("user not committed: %+v, %v", found, err)
+	}
+	if _, err := store.FindEmailVerificationToken(ctx, "verify", models.TokenPurposeEmailVerification); err != nil {
+		t.Errorf("token not committed: %v", err)
+	}
+}
+
+func testTransactionRollback(t *testing.T, store repositories.Store) {
+	existing := createUser(t, store, "bob@example.com")
+	errAbort := errors.New("abort")
+
+	// Every write of a failed unit of work is undone
+	err := store.Transaction(ctx, func(tx repositories.Store) error {
+		createUser(t, tx, "alice@example.com")
+		must(t, tx.UpdateUserVerification(ctx, existing.ID, true))
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("Transaction = %v, want the callback's error", err)
+	}
+
+	if found, err := store.FindByEmail(ctx, "alice@example.com"); err != nil || found != nil {
+		t.Errorf("user created in a rolled back transaction: %+v, %v", found, err)
+	}
+	found, err := store.FindByID(ctx, existing.ID)
+	must(t, err)
+	if found.IsVerified {
+		t.Error("update made in a rolled back transaction was kept")
+	}
+
+	// A store error inside the unit rolls it back the same way
+	err = store.Transaction(ctx, func(tx repositories.Store) error {
+		createUser(t, tx, "carol@example.com")
+		return tx.Create(ctx, &models.User{Email: "bob@example.com", Name: "Bob", Password: "hash", RoleID: 2})
+	})
+	if !errors.Is(err, repositories.ErrDuplicate) {
+		t.Errorf("Transaction(duplicate) = %v, want ErrDuplicate", err)
+	}
+	if found, err := store.FindByEmail(ctx, "carol@example.com"); err != nil || found != nil {
+		t.Errorf("user created in a rolled back transaction: %+v, %v", found, err)
+	}
+}
+
+func testConcurrentRefreshRotation(t *testing.T, store repositories.Store) {
+	const workers = 5
+
+	user := createUser(t, store, "alice@example.com")
+	old := &models.RefreshToken{UserID: user.ID, SessionID: "s1", Token: "refresh", ExpiresAt: time.Now().Add(time.Hour)}
+	must(t, store.CreateRefreshToken(ctx, old))
+
+	// Rotate the same token from several requests at once, as AuthService does;
+	// only one of them may end up with a new token
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		rotated int
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			err := store.Transaction(ctx, func(tx repositories.Store) error {
+				if err := tx.MarkRefreshTokenAsUsed(ctx, old.ID); err != nil {
+					return err
+				}
+				return tx.CreateRefreshToken(ctx, &models.RefreshToken{
+					UserID:    user.ID,
+					SessionID: "s1",
+					Token:     fmt.Sprintf("rotated-%d", w),
+					ExpiresAt: time.Now().Add(time.Hour),
+				})
+			})
+			if err == nil {
+				mu.Lock()
+				rotated++
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if rotated != 1 {
+		t.Fatalf("token rotated %d times, want exactly once", rotated)
+	}
+
+	valid := 0
+	for w := 0; w < workers; w++ {
+		if _, err := store.FindRefreshToken(ctx, fmt.Sprintf("rotated-%d", w)); err == nil {
+			valid++
+		}
+	}
+	if valid != 1 {
+		t.Errorf("%d rotated tokens stored, want 1", valid)
+	}
+}
+
+func testCanceledContext(t *testing.T, store repositories.Store) {
+	createUser(t, store, "alice@example.com")
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Calls made with a done context fail with the context's error
+	if _, err := store.FindByEmail(canceled, "alice@example.com"); !errors.Is(err, context.Canceled) {
+		t.Errorf("FindByEmail(canceled) = %v, want context.Canceled", err)
+	}
+	if err := store.Create(canceled, &models.User{Email: "bob@example.com", Name: "Bob", Password: "hash", RoleID: 2}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Create(canceled) = %v, want context.Canceled", err)
+	}
+	if found, err := store.FindByEmail(ctx, "bob@example.com"); err != nil || found != nil {
+		t.Errorf("user created with a canceled context: %+v, %v", found, err)
+	}
+}
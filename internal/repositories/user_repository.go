@@ -1,9 +1,10 @@
 package repositories
 
 import (
+	"context"
 	"errors"
-	"go-postgres-api/internal/database"
 	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/validation"
 	"time"
 
 	"gorm.io/gorm"
@@ -15,16 +16,32 @@ type UserRepository struct {
 }
 
 // NewUserRepository creates a new user repository
-func NewUserRepository() *UserRepository {
+func NewUserRepository(db *gorm.DB) *UserRepository {
 	return &UserRepository{
-		db: database.GetDB(),
+		db: db,
 	}
 }
 
-// FindByEmail finds a user by email
-func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
+// translateError maps gorm's dialect-neutral errors onto the repository errors
+func translateError(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// Transaction runs fn inside a database transaction. The Store passed to fn
+// is bound to the transaction; nested calls become savepoints.
+func (r *UserRepository) Transaction(ctx context.Context, fn func(tx Store) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&UserRepository{db: tx})
+	})
+}
+
+// FindByEmail finds a user by email, ignoring case and surrounding whitespace
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
-	result := r.db.Where("email = ?", email).First(&user)
+	result := r.db.WithContext(ctx).Where("email = ?", validation.NormalizeEmail(email)).First(&user)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, nil // User not found
@@ -35,9 +52,9 @@ func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
 }
 
 // FindByID finds a user by ID
-func (r *UserRepository) FindByID(id uint) (*models.User, error) {
+func (r *UserRepository) FindByID(ctx context.Context, id uint) (*models.User, error) {
 	var user models.User
-	result := r.db.Preload("Roles").Where("id = ?", id).First(&user)
+	result := r.db.WithContext(ctx).Preload("Role").Where("id = ?", id).First(&user)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, nil // User not found
@@ -47,16 +64,17 @@ func (r *UserRepository) FindByID(id uint) (*models.User, error) {
 	return &user, nil
 }
 
-// Create creates a new user
-func (r *UserRepository) Create(user *models.User) error {
-	return r.db.Create(user).Error
+// Create creates a new user, storing the email address normalized
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	user.Email = validation.NormalizeEmail(user.Email)
+	return translateError(r.db.WithContext(ctx).Create(user).Error)
 }
 
 // AddRole adds a role to a user
-func (r *UserRepository) AddRole(userID uint, roleType string) error {
+func (r *UserRepository) AddRole(ctx context.Context, userID uint, roleType string) error {
 	// Check if the role already exists
 	var count int64
-	r.db.Table("user_roles").
+	r.db.WithContext(ctx).Table("user_roles").
 		Joins("JOIN roles ON roles.id = user_roles.role_id").
 		Where("user_roles.user_id = ? AND roles.role_type = ?", userID, roleType).
 		Count(&count)
@@ -67,10 +85,10 @@ func (r *UserRepository) AddRole(userID uint, roleType string) error {
 
 	// Find or create the role
 	var role models.Role
-	result := r.db.Where("role_type = ?", roleType).First(&role)
+	result := r.db.WithContext(ctx).Where("role_type = ?", roleType).First(&role)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		role = models.Role{RoleType: roleType}
-		if err := r.db.Create(&role).Error; err != nil {
+		if err := r.db.WithContext(ctx).Create(&role).Error; err != nil {
 			return err
 		}
 	} else if result.Error != nil {
@@ -78,48 +96,48 @@ func (r *UserRepository) AddRole(userID uint, roleType string) error {
 	}
 
 	// Add the role to the user
-	return r.db.Exec("INSERT INTO user_roles (user_id, role_id, created_at) VALUES (?, ?, ?)",
+	return r.db.WithContext(ctx).Exec("INSERT INTO user_roles (user_id, role_id, created_at) VALUES (?, ?, ?)",
 		userID, role.ID, time.Now()).Error
 }
 
 // UpdateLastLogin updates the user's last login time
-func (r *UserRepository) UpdateLastLogin(userID uint) error {
-	return r.db.Model(&models.User{}).
+func (r *UserRepository) UpdateLastLogin(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).
 		Where("id = ?", userID).
 		Update("last_login", time.Now()).Error
 }
 
 // LogAuth logs an authentication attempt
-func (r *UserRepository) LogAuth(log *models.AuthLog) error {
-	return r.db.Create(log).Error
+func (r *UserRepository) LogAuth(ctx context.Context, log *models.AuthLog) error {
+	return r.db.WithContext(ctx).Create(log).Error
 }
 
 // BlacklistToken adds a token to the blacklist
-func (r *UserRepository) BlacklistToken(blacklist *models.TokenBlacklist) error {
-	return r.db.Create(blacklist).Error
+func (r *UserRepository) BlacklistToken(ctx context.Context, blacklist *models.TokenBlacklist) error {
+	return translateError(r.db.WithContext(ctx).Create(blacklist).Error)
 }
 
 // IsTokenBlacklisted checks if a token is blacklisted
-func (r *UserRepository) IsTokenBlacklisted(tokenJTI string) (bool, error) {
+func (r *UserRepository) IsTokenBlacklisted(ctx context.Context, tokenJTI string) (bool, error) {
 	var count int64
-	result := r.db.Model(&models.TokenBlacklist{}).
+	result := r.db.WithContext(ctx).Model(&models.TokenBlacklist{}).
 		Where("token_jti = ?", tokenJTI).
 		Count(&count)
 	return count > 0, result.Error
 }
 
 // CreateEmailVerificationToken creates an email verification token
-func (r *UserRepository) CreateEmailVerificationToken(token *models.EmailVerificationToken) error {
-	return r.db.Create(token).Error
+func (r *UserRepository) CreateEmailVerificationToken(ctx context.Context, token *models.EmailVerificationToken) error {
+	return translateError(r.db.WithContext(ctx).Create(token).Error)
 }
 
-// FindEmailVerificationToken finds an email verification token
-func (r *UserRepository) FindEmailVerificationToken(token string) (*models.EmailVerificationToken, error) {
+// FindEmailVerificationToken finds an email token issued for the given purpose
+func (r *UserRepository) FindEmailVerificationToken(ctx context.Context, token, purpose string) (*models.EmailVerificationToken, error) {
 	var verificationToken models.EmailVerificationToken
-	result := r.db.Where("token = ?", token).First(&verificationToken)
+	result := r.db.WithContext(ctx).Where("token = ? AND purpose = ?", token, purpose).First(&verificationToken)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.New("token not found")
+			return nil, ErrTokenNotFound
 		}
 		return nil, result.Error
 	}
@@ -127,54 +145,219 @@ func (r *UserRepository) FindEmailVerificationToken(token string) (*models.Email
 }
 
 // UpdateUserVerification updates user verification status
-func (r *UserRepository) UpdateUserVerification(userID uint, isVerified bool) error {
-	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("is_verified", isVerified).Error
+func (r *UserRepository) UpdateUserVerification(ctx context.Context, userID uint, isVerified bool) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Update("is_verified", isVerified).Error
+}
+
+// MarkEmailTokenAsUsed marks an unused email verification token as used. The
+// update is conditional on used = false, so of two concurrent callers only
+// one claims the token and the other gets ErrTokenUsed.
+func (r *UserRepository) MarkEmailTokenAsUsed(ctx context.Context, tokenID uint) error {
+	result := r.db.WithContext(ctx).Model(&models.EmailVerificationToken{}).
+		Where("id = ? AND used = ?", tokenID, false).
+		Update("used", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+// InvalidateEmailChangeTokens marks all pending email change and cancel tokens of a user as used
+func (r *UserRepository) InvalidateEmailChangeTokens(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Model(&models.EmailVerificationToken{}).
+		Where("user_id = ? AND purpose IN ? AND used = ?", userID,
+			[]string{models.TokenPurposeEmailChange, models.TokenPurposeEmailChangeCancel}, false).
+		Update("used", true).Error
 }
 
-// MarkEmailTokenAsUsed marks an email verification token as used
-func (r *UserRepository) MarkEmailTokenAsUsed(tokenID uint) error {
-	return r.db.Model(&models.EmailVerificationToken{}).Where("id = ?", tokenID).Update("used", true).Error
+// UpdateUserEmail changes a user's email address, storing it normalized
+func (r *UserRepository) UpdateUserEmail(ctx context.Context, userID uint, email string) error {
+	email = validation.NormalizeEmail(email)
+	return translateError(r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Update("email", email).Error)
 }
 
 // CreateRefreshToken creates a refresh token
-func (r *UserRepository) CreateRefreshToken(token *models.RefreshToken) error {
-	return r.db.Create(token).Error
+func (r *UserRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	return translateError(r.db.WithContext(ctx).Create(token).Error)
 }
 
 // FindRefreshToken finds a refresh token
-func (r *UserRepository) FindRefreshToken(token string) (*models.RefreshToken, error) {
+func (r *UserRepository) FindRefreshToken(ctx context.Context, token string) (*models.RefreshToken, error) {
 	var refreshToken models.RefreshToken
-	result := r.db.Where("token = ? AND used = false", token).First(&refreshToken)
+	result := r.db.WithContext(ctx).Where("token = ? AND used = ?", token, false).First(&refreshToken)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.New("invalid or expired refresh token")
+			return nil, ErrInvalidRefreshToken
 		}
 		return nil, result.Error
 	}
 	return &refreshToken, nil
 }
 
-// MarkRefreshTokenAsUsed marks a refresh token as used
-func (r *UserRepository) MarkRefreshTokenAsUsed(tokenID uint) error {
-	return r.db.Model(&models.RefreshToken{}).Where("id = ?", tokenID).Update("used", true).Error
+// MarkRefreshTokenAsUsed marks an unused refresh token as used. The update is
+// conditional on used = false, so a token can be rotated only once even
+// under concurrent refreshes; the loser gets ErrInvalidRefreshToken.
+func (r *UserRepository) MarkRefreshTokenAsUsed(ctx context.Context, tokenID uint) error {
+	result := r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("id = ? AND used = ?", tokenID, false).
+		Update("used", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidRefreshToken
+	}
+	return nil
+}
+
+// UpdateRefreshTokenAccessToken records the access token issued with a refresh
+// token that is kept on refresh, so it can still be revoked with its session
+func (r *UserRepository) UpdateRefreshTokenAccessToken(ctx context.Context, tokenID uint, jti string, expiresAt time.Time) error {
+	result := r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("id = ? AND used = ?", tokenID, false).
+		Updates(map[string]any{"access_token_jti": jti, "access_token_expires_at": expiresAt})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidRefreshToken
+	}
+	return nil
+}
+
+// otherSessions matches the refresh tokens of every session but keepSessionID.
+// Tokens issued before sessions were tracked have a NULL or empty session ID
+// and never belong to the kept session.
+const otherSessions = "(session_id IS NULL OR session_id = '' OR session_id <> ?)"
+
+// RevokeOtherSessions marks the refresh tokens of every session but the given one as used
+func (r *UserRepository) RevokeOtherSessions(ctx context.Context, userID uint, keepSessionID string) error {
+	return r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND "+otherSessions+" AND used = ?", userID, keepSessionID, false).
+		Update("used", true).Error
+}
+
+// FindOtherSessionAccessTokens returns the refresh token records of other sessions whose
+// accompanying access token hasn't expired yet
+func (r *UserRepository) FindOtherSessionAccessTokens(ctx context.Context, userID uint, keepSessionID string) ([]models.RefreshToken, error) {
+	var tokens []models.RefreshToken
+	result := r.db.WithContext(ctx).Where("user_id = ? AND "+otherSessions+" AND access_token_jti <> '' AND access_token_expires_at > ?",
+		userID, keepSessionID, time.Now()).Find(&tokens)
+	return tokens, result.Error
+}
+
+// UpdatePassword stores a new password hash for a user
+func (r *UserRepository) UpdatePassword(ctx context.Context, userID uint, passwordHash string) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userID).Update("password", passwordHash).Error
+}
+
+// RevokeUserRefreshTokens marks every outstanding refresh token of a user as used
+func (r *UserRepository) RevokeUserRefreshTokens(ctx context.Context, userID uint) error {
+	return r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("user_id = ? AND used = ?", userID, false).
+		Update("used", true).Error
 }
 
 // CleanupExpiredTokens removes expired tokens from the database
-func (r *UserRepository) CleanupExpiredTokens() error {
+func (r *UserRepository) CleanupExpiredTokens(ctx context.Context) error {
 	// Clean up expired email verification tokens
-	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&models.EmailVerificationToken{}).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.EmailVerificationToken{}).Error; err != nil {
 		return err
 	}
 
 	// Clean up expired refresh tokens
-	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&models.RefreshToken{}).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.RefreshToken{}).Error; err != nil {
 		return err
 	}
 
 	// Clean up expired blacklisted tokens
-	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&models.TokenBlacklist{}).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.TokenBlacklist{}).Error; err != nil {
+		return err
+	}
+
+	// Clean up abandoned WebAuthn ceremonies
+	if err := r.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.WebAuthnSession{}).Error; err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// CreateWebAuthnCredential stores a newly registered passkey
+func (r *UserRepository) CreateWebAuthnCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	return translateError(r.db.WithContext(ctx).Create(credential).Error)
+}
+
+// FindWebAuthnCredentialsByUserID returns all passkeys registered by a user
+func (r *UserRepository) FindWebAuthnCredentialsByUserID(ctx context.Context, userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	result := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&credentials)
+	return credentials, result.Error
+}
+
+// FindWebAuthnCredentialByCredentialID finds a passkey by its authenticator-assigned credential ID
+func (r *UserRepository) FindWebAuthnCredentialByCredentialID(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	result := r.db.WithContext(ctx).Where("credential_id = ?", credentialID).First(&credential)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil // Credential not found
+		}
+		return nil, result.Error
+	}
+	return &credential, nil
+}
+
+// UpdateWebAuthnCredentialUsage records the sign count and clone warning after an assertion
+func (r *UserRepository) UpdateWebAuthnCredentialUsage(ctx context.Context, credentialID uint, signCount uint32, cloneWarning bool) error {
+	updates := map[string]interface{}{
+		"sign_count":    signCount,
+		"clone_warning": cloneWarning,
+	}
+	if !cloneWarning {
+		updates["last_used_at"] = time.Now()
+	}
+	return r.db.WithContext(ctx).Model(&models.WebAuthnCredential{}).Where("id = ?", credentialID).Updates(updates).Error
+}
+
+// DeleteWebAuthnCredential removes a passkey owned by the given user
+func (r *UserRepository) DeleteWebAuthnCredential(ctx context.Context, userID, credentialID uint) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", credentialID, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// CreateWebAuthnSession stores the state of a WebAuthn ceremony
+func (r *UserRepository) CreateWebAuthnSession(ctx context.Context, session *models.WebAuthnSession) error {
+	return translateError(r.db.WithContext(ctx).Create(session).Error)
+}
+
+// ConsumeWebAuthnSession finds a WebAuthn ceremony by ID and purpose and deletes it so it can only be used once
+func (r *UserRepository) ConsumeWebAuthnSession(ctx context.Context, sessionID, purpose string) (*models.WebAuthnSession, error) {
+	var session models.WebAuthnSession
+	result := r.db.WithContext(ctx).Where("session_id = ? AND purpose = ?", sessionID, purpose).First(&session)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, result.Error
+	}
+
+	// Only the request that actually deletes the row may continue the ceremony
+	deleted := r.db.WithContext(ctx).Delete(&models.WebAuthnSession{}, session.ID)
+	if deleted.Error != nil {
+		return nil, deleted.Error
+	}
+	if deleted.RowsAffected == 0 {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
@@ -0,0 +1,88 @@
+package repositories_test
+
+import (
+	"context"
+	"errors"
+	"go-postgres-api/internal/database"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/repositories"
+	"go-postgres-api/internal/repositories/repotest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newSQLiteRepository returns a UserRepository on a new, migrated SQLite database
+func newSQLiteRepository(t *testing.T) repositories.Store {
+	t.Helper()
+	store, _ := newSQLiteRepositoryDB(t)
+	return store
+}
+
+// newSQLiteRepositoryDB is newSQLiteRepository also returning the database
+func newSQLiteRepositoryDB(t *testing.T) (repositories.Store, *gorm.DB) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	db, err := gorm.Open(sqlite.Open(database.SQLiteDSN(path)), &gorm.Config{
+		Logger:         logger.Discard,
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	migrator, err := database.NewMigrator(sqlDB, database.DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background(), 0); err != nil {
+		t.Fatalf("migrating up: %v", err)
+	}
+	return repositories.NewUserRepository(db), db
+}
+
+func TestUserRepositorySQLite(t *testing.T) {
+	repotest.Run(t, newSQLiteRepository)
+}
+
+// TestUserRepositoryNullSessionID covers refresh tokens issued before the
+// session columns were added, which the migration leaves NULL
+func TestUserRepositoryNullSessionID(t *testing.T) {
+	ctx := context.Background()
+	store, db := newSQLiteRepositoryDB(t)
+
+	user := &models.User{Email: "alice@example.com", Name: "Alice", Password: "hash", IsActive: true, RoleID: 2}
+	if err := store.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	// As stored by builds before sessions were tracked
+	err := db.Exec("INSERT INTO refresh_tokens (user_id, client_id, token, expires_at, used, created_at) VALUES (?, 'default', 'legacy', ?, false, ?)",
+		user.ID, time.Now().Add(time.Hour), time.Now()).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := &models.RefreshToken{UserID: user.ID, SessionID: "current", ClientID: "default", Token: "current", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.CreateRefreshToken(ctx, current); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.RevokeOtherSessions(ctx, user.ID, "current"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.FindRefreshToken(ctx, "legacy"); !errors.Is(err, repositories.ErrInvalidRefreshToken) {
+		t.Errorf("FindRefreshToken(legacy) = %v, want it revoked", err)
+	}
+	if _, err := store.FindRefreshToken(ctx, "current"); err != nil {
+		t.Errorf("FindRefreshToken(current) = %v, want it kept", err)
+	}
+}
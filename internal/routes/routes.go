@@ -1,46 +1,89 @@
 package routes
 
 import (
+	"go-postgres-api/internal/app"
 	"go-postgres-api/internal/config"
-	"go-postgres-api/internal/controllers"
+	"go-postgres-api/internal/metrics"
 	"go-postgres-api/internal/middleware"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
 // SetupRoutes configures all the routes for the application
-func SetupRoutes(router *gin.Engine, cfg *config.Config) {
+func SetupRoutes(router *gin.Engine, container *app.Container) {
+	cfg := container.Config
+
+	// Probes
+	router.GET("/livez", container.HealthController.Livez)
+	router.GET("/readyz", container.HealthController.Readyz)
+
+	// Prometheus metrics, unless they have a listener of their own
+	if cfg.MetricsEnabled && cfg.MetricsAddress == "" {
+		SetupMetricsRoutes(router, cfg)
+	}
+
+	// Unknown routes get the same error body as everything else
+	router.NoRoute(func(c *gin.Context) {
+		middleware.AbortWithProblem(c, http.StatusNotFound, middleware.CodeNotFound, "no route matches the request")
+	})
+
+	// Content Security Policy violation reports; outside /api/v1, as browsers
+	// send them with the token cookies but no CSRF token
+	cspReports := router.Group(middleware.CSPReportPath)
+	if container.RateLimiter != nil {
+		cspReports.Use(middleware.RateLimitMiddleware(container.RateLimiter))
+	}
+	cspReports.POST("", container.CSPReportController.Report)
+
 	// API v1 routes group
 	v1 := router.Group("/api/v1")
+	v1.Use(middleware.CSRFMiddleware())
 	{
-		// Health check endpoint
-		v1.GET("/health", func(c *gin.Context) {
-			c.JSON(200, gin.H{
-				"status": "ok",
-			})
-		})
+		// Health check endpoint, kept for existing clients; same report as /readyz
+		v1.GET("/health", container.HealthController.Readyz)
 
 		// Auth routes
-		authController := controllers.NewAuthController(cfg)
+		authController := container.AuthController
+		passkeyController := container.PasskeyController
 		authRoutes := v1.Group("/auth")
+		authRoutes.Use(middleware.TimeoutMiddleware(cfg.AuthRequestTimeout))
+		if container.RateLimiter != nil {
+			authRoutes.Use(middleware.RateLimitMiddleware(container.RateLimiter))
+		}
 		{
 			authRoutes.POST("/register", authController.Register)
 			authRoutes.POST("/login", authController.Login)
 			authRoutes.GET("/verify-email", authController.VerifyEmail)
 			authRoutes.POST("/resend-verification", authController.ResendVerificationEmail)
 			authRoutes.POST("/refresh-token", authController.RefreshToken)
+			authRoutes.GET("/confirm-email-change", authController.ConfirmEmailChange)
+			authRoutes.GET("/cancel-email-change", authController.CancelEmailChange)
+			authRoutes.POST("/passkeys/login/begin", passkeyController.BeginLogin)
+			authRoutes.POST("/passkeys/login/finish", passkeyController.FinishLogin)
 
 			// Protected routes
 			protected := authRoutes.Group("/")
-			protected.Use(middleware.AuthMiddleware())
+			protected.Use(middleware.AuthMiddleware(container.AuthService))
+			if container.RateLimiter != nil {
+				// Again, for the rate limits by user
+				protected.Use(middleware.RateLimitMiddleware(container.RateLimiter))
+			}
 			{
 				protected.POST("/logout", authController.Logout)
 				protected.GET("/profile", authController.GetProfile)
+				protected.POST("/change-email", authController.RequestEmailChange)
+				protected.POST("/change-password", authController.ChangePassword)
+				protected.GET("/passkeys", passkeyController.ListPasskeys)
+				protected.DELETE("/passkeys/:id", passkeyController.DeletePasskey)
+				protected.POST("/passkeys/register/begin", passkeyController.BeginRegistration)
+				protected.POST("/passkeys/register/finish", passkeyController.FinishRegistration)
 			}
 		}
 
 		// User routes
 		userRoutes := v1.Group("/users")
+		userRoutes.Use(middleware.TimeoutMiddleware(cfg.UsersRequestTimeout))
 		{
 			userRoutes.GET("/", func(c *gin.Context) {
 				c.JSON(200, gin.H{"message": "Get all users"})
@@ -63,3 +106,13 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 		}
 	}
 }
+
+// SetupMetricsRoutes serves the Prometheus metrics at /metrics, to holders of
+// the metrics token when one is configured
+func SetupMetricsRoutes(router *gin.Engine, cfg *config.Config) {
+	metricsRoutes := router.Group("/metrics")
+	if cfg.MetricsToken != "" {
+		metricsRoutes.Use(middleware.MetricsTokenMiddleware(cfg.MetricsToken))
+	}
+	metricsRoutes.GET("", gin.WrapH(metrics.Handler()))
+}
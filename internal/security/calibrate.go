@@ -0,0 +1,66 @@
+package security
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// calibrationPassword is hashed while measuring; its value doesn't affect timing
+const calibrationPassword = "correct horse battery staple"
+
+// maxArgon2idIterations bounds the search so a tiny target can't loop forever
+const maxArgon2idIterations = 64
+
+// CalibrateArgon2id returns the smallest number of iterations for which hashing with
+// the given memory (KiB) and parallelism takes at least target on this host, along
+// with the measured duration
+func CalibrateArgon2id(target time.Duration, memory uint32, parallelism uint8) (Argon2idParams, time.Duration) {
+	params := DefaultArgon2idParams
+	params.Memory = memory
+	params.Parallelism = parallelism
+
+	salt := make([]byte, params.SaltLength)
+	var elapsed time.Duration
+	for params.Iterations = 1; params.Iterations < maxArgon2idIterations; params.Iterations++ {
+		elapsed = measure(func() {
+			argon2.IDKey([]byte(calibrationPassword), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		})
+		if elapsed >= target {
+			break
+		}
+	}
+
+	return params, elapsed
+}
+
+// CalibrateBcryptCost returns the smallest bcrypt cost for which hashing takes at
+// least target on this host, along with the measured duration
+func CalibrateBcryptCost(target time.Duration) (int, time.Duration) {
+	var elapsed time.Duration
+	cost := bcrypt.DefaultCost
+	for ; cost < bcrypt.MaxCost; cost++ {
+		elapsed = measure(func() {
+			bcrypt.GenerateFromPassword([]byte(calibrationPassword), cost)
+		})
+		if elapsed >= target {
+			break
+		}
+	}
+
+	return cost, elapsed
+}
+
+// measure returns the median duration of three runs of fn
+func measure(fn func()) time.Duration {
+	durations := make([]time.Duration, 3)
+	for i := range durations {
+		start := time.Now()
+		fn()
+		durations[i] = time.Since(start)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[1]
+}
@@ -0,0 +1,202 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported password hashing algorithms
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// ErrUnknownHashFormat is returned when a stored hash isn't in a supported format
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher hashes and verifies passwords. Hashes are self-describing
+// PHC strings, so stored hashes keep verifying after the parameters change.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password using the current parameters
+	Hash(password string) (string, error)
+	// Verify reports whether password matches the encoded hash
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was produced with another algorithm or outdated parameters
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idParams are the cost parameters of argon2id
+type Argon2idParams struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follow the OWASP recommendation for argon2id
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hasher is the PasswordHasher used by the application. New hashes use the
+// configured algorithm; both argon2id and bcrypt hashes can be verified.
+type Hasher struct {
+	Algorithm  string
+	Argon2id   Argon2idParams
+	BcryptCost int
+}
+
+// NewHasher creates a hasher for the given algorithm and parameters
+func NewHasher(algorithm string, argon2idParams Argon2idParams, bcryptCost int) (*Hasher, error) {
+	if algorithm != AlgorithmArgon2id && algorithm != AlgorithmBcrypt {
+		return nil, fmt.Errorf("unsupported password hashing algorithm %q", algorithm)
+	}
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if argon2idParams.Memory == 0 || argon2idParams.Iterations == 0 || argon2idParams.Parallelism == 0 {
+		return nil, errors.New("argon2id memory, iterations and parallelism must be positive")
+	}
+	if argon2idParams.SaltLength == 0 {
+		argon2idParams.SaltLength = DefaultArgon2idParams.SaltLength
+	}
+	if argon2idParams.KeyLength == 0 {
+		argon2idParams.KeyLength = DefaultArgon2idParams.KeyLength
+	}
+
+	return &Hasher{
+		Algorithm:  algorithm,
+		Argon2id:   argon2idParams,
+		BcryptCost: bcryptCost,
+	}, nil
+}
+
+// Hash implements PasswordHasher
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmBcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hashed), nil
+	}
+
+	salt := make([]byte, h.Argon2id.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Argon2id.Iterations, h.Argon2id.Memory, h.Argon2id.Parallelism, h.Argon2id.KeyLength)
+
+	return encodeArgon2id(h.Argon2id, salt, key), nil
+}
+
+// Verify implements PasswordHasher
+func (h *Hasher) Verify(password, encoded string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+		candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		return subtle.ConstantTimeCompare(key, candidate) == 1, nil
+
+	case isBcryptHash(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	return false, ErrUnknownHashFormat
+}
+
+// NeedsRehash implements PasswordHasher
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		if h.Algorithm != AlgorithmArgon2id {
+			return true
+		}
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return true
+		}
+		return params.Memory != h.Argon2id.Memory ||
+			params.Iterations != h.Argon2id.Iterations ||
+			params.Parallelism != h.Argon2id.Parallelism ||
+			uint32(len(salt)) != h.Argon2id.SaltLength ||
+			uint32(len(key)) != h.Argon2id.KeyLength
+
+	case isBcryptHash(encoded):
+		if h.Algorithm != AlgorithmBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.BcryptCost
+	}
+
+	return true
+}
+
+// isBcryptHash reports whether encoded is a bcrypt modular crypt string ($2a$, $2b$ or $2y$)
+func isBcryptHash(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+// encodeArgon2id formats an argon2id hash as a PHC string:
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<hash>
+func encodeArgon2id(params Argon2idParams, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+}
+
+// decodeArgon2id parses a PHC argon2id string
+func decodeArgon2id(encoded string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
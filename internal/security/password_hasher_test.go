@@ -0,0 +1,251 @@
+package security
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2idParams keep the tests fast; production uses DefaultArgon2idParams
+var testArgon2idParams = Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func newTestHasher(t testing.TB, algorithm string) *Hasher {
+	t.Helper()
+	hasher, err := NewHasher(algorithm, testArgon2idParams, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+func TestNewHasherRejectsInvalidParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		algorithm  string
+		params     Argon2idParams
+		bcryptCost int
+	}{
+		{"unknown algorithm", "md5", testArgon2idParams, bcrypt.MinCost},
+		{"bcrypt cost too low", AlgorithmBcrypt, testArgon2idParams, bcrypt.MinCost - 1},
+		{"bcrypt cost too high", AlgorithmBcrypt, testArgon2idParams, bcrypt.MaxCost + 1},
+		{"no memory", AlgorithmArgon2id, Argon2idParams{Iterations: 1, Parallelism: 1}, bcrypt.MinCost},
+		{"no iterations", AlgorithmArgon2id, Argon2idParams{Memory: 1024, Parallelism: 1}, bcrypt.MinCost},
+		{"no parallelism", AlgorithmArgon2id, Argon2idParams{Memory: 1024, Iterations: 1}, bcrypt.MinCost},
+	}
+	for _, tt := range tests {
+		if _, err := NewHasher(tt.algorithm, tt.params, tt.bcryptCost); err == nil {
+			t.Errorf("%s: NewHasher succeeded", tt.name)
+		}
+	}
+
+	hasher, err := NewHasher(AlgorithmArgon2id, testArgon2idParams, bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasher.Argon2id.SaltLength != DefaultArgon2idParams.SaltLength || hasher.Argon2id.KeyLength != DefaultArgon2idParams.KeyLength {
+		t.Errorf("salt and key lengths = %d, %d, want the defaults", hasher.Argon2id.SaltLength, hasher.Argon2id.KeyLength)
+	}
+}
+
+func TestHashAndVerify(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			hasher := newTestHasher(t, algorithm)
+
+			hash, err := hasher.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if algorithm == AlgorithmArgon2id && !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+				t.Errorf("Hash = %q, want a PHC argon2id string with the parameters", hash)
+			}
+			if algorithm == AlgorithmBcrypt && !isBcryptHash(hash) {
+				t.Errorf("Hash = %q, want a bcrypt hash", hash)
+			}
+
+			if ok, err := hasher.Verify("correct horse battery staple", hash); !ok || err != nil {
+				t.Errorf("Verify(right password) = %v, %v, want true", ok, err)
+			}
+			if ok, err := hasher.Verify("Correct horse battery staple", hash); ok || err != nil {
+				t.Errorf("Verify(wrong password) = %v, %v, want false, nil", ok, err)
+			}
+
+			// Every hash gets its own salt
+			again, err := hasher.Hash("correct horse battery staple")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again == hash {
+				t.Error("hashing the same password twice gave the same hash")
+			}
+		})
+	}
+}
+
+func TestVerifyEitherAlgorithm(t *testing.T) {
+	argon2idHash, err := newTestHasher(t, AlgorithmArgon2id).Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := newTestHasher(t, AlgorithmBcrypt).Hash("secret-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hashes stay verifiable after the configured algorithm changes
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		hasher := newTestHasher(t, algorithm)
+		for _, hash := range []string{argon2idHash, bcryptHash} {
+			if ok, err := hasher.Verify("secret-password", hash); !ok || err != nil {
+				t.Errorf("%s hasher: Verify(%.12s...) = %v, %v, want true", algorithm, hash, ok, err)
+			}
+		}
+	}
+}
+
+func TestVerifyParsesPHCStrings(t *testing.T) {
+	hasher := newTestHasher(t, AlgorithmArgon2id)
+	// From the reference implementation: echo -n password | argon2 somesalt -id -t 2 -m 16 -p 1
+	reference := "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+	if ok, err := hasher.Verify("password", reference); !ok || err != nil {
+		t.Errorf("Verify(reference hash) = %v, %v, want true", ok, err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{"empty", "", ErrUnknownHashFormat},
+		{"plain text", "password", ErrUnknownHashFormat},
+		{"argon2i", "$argon2i$v=19$m=1024,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", ErrUnknownHashFormat},
+		{"missing hash", "$argon2id$v=19$m=1024,t=2,p=1$c29tZXNhbHQ", ErrUnknownHashFormat},
+		{"bad version", "$argon2id$version$m=1024,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", ErrUnknownHashFormat},
+		{"bad parameters", "$argon2id$v=19$m=lots,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", ErrUnknownHashFormat},
+		{"bad salt", "$argon2id$v=19$m=1024,t=2,p=1$not base64!$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", ErrUnknownHashFormat},
+		{"bad hash", "$argon2id$v=19$m=1024,t=2,p=1$c29tZXNhbHQ$not base64!", ErrUnknownHashFormat},
+		{"old version", "$argon2id$v=16$m=1024,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", nil},
+	}
+	for _, tt := range tests {
+		ok, err := hasher.Verify("password", tt.encoded)
+		if ok || err == nil {
+			t.Errorf("%s: Verify = %v, %v, want an error", tt.name, ok, err)
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Verify error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPHCRoundTrip(t *testing.T) {
+	params := Argon2idParams{Memory: 2048, Iterations: 3, Parallelism: 2, SaltLength: 8, KeyLength: 4}
+	salt := []byte("saltsalt")
+	key := []byte{1, 2, 3, 4}
+
+	encoded := encodeArgon2id(params, salt, key)
+	if want := "$argon2id$v=19$m=2048,t=3,p=2$c2FsdHNhbHQ$AQIDBA"; encoded != want {
+		t.Fatalf("encodeArgon2id = %q, want %q", encoded, want)
+	}
+	gotParams, gotSalt, gotKey, err := decodeArgon2id(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotParams != params || string(gotSalt) != string(salt) || string(gotKey) != string(key) {
+		t.Errorf("decodeArgon2id = %+v, %q, %v, want %+v, %q, %v", gotParams, gotSalt, gotKey, params, salt, key)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argon2idHasher := newTestHasher(t, AlgorithmArgon2id)
+	bcryptHasher := newTestHasher(t, AlgorithmBcrypt)
+
+	current, err := argon2idHasher.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcryptHasher.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := *argon2idHasher
+	stronger.Argon2id.Memory *= 2
+	longerKey := *argon2idHasher
+	longerKey.Argon2id.KeyLength = 64
+	costlier := *bcryptHasher
+	costlier.BcryptCost++
+
+	tests := []struct {
+		name    string
+		hasher  *Hasher
+		encoded string
+		want    bool
+	}{
+		{"current argon2id", argon2idHasher, current, false},
+		{"argon2id memory raised", &stronger, current, true},
+		{"argon2id key length changed", &longerKey, current, true},
+		{"bcrypt hash, argon2id configured", argon2idHasher, bcryptHash, true},
+		{"current bcrypt", bcryptHasher, bcryptHash, false},
+		{"bcrypt cost raised", &costlier, bcryptHash, true},
+		{"argon2id hash, bcrypt configured", bcryptHasher, current, true},
+		{"unknown format", argon2idHasher, "plaintext", true},
+		{"corrupt argon2id", argon2idHasher, "$argon2id$v=19$m=1024", true},
+	}
+	for _, tt := range tests {
+		if got := tt.hasher.NeedsRehash(tt.encoded); got != tt.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// BenchmarkHash measures hashing with the default parameters, which is
+// what every registration, password change and rehash costs. Run with
+// -benchmem to see the memory argon2id takes per hash.
+func BenchmarkHash(b *testing.B) {
+	benchmarks := []struct {
+		name      string
+		algorithm string
+	}{
+		{"argon2id", AlgorithmArgon2id},
+		{"bcrypt", AlgorithmBcrypt},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			hasher, err := NewHasher(bm.algorithm, DefaultArgon2idParams, bcrypt.DefaultCost)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := hasher.Hash("correct horse battery staple"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkVerify measures checking a password against a stored hash, the
+// cost of every password login
+func BenchmarkVerify(b *testing.B) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		b.Run(algorithm, func(b *testing.B) {
+			hasher, err := NewHasher(algorithm, DefaultArgon2idParams, bcrypt.DefaultCost)
+			if err != nil {
+				b.Fatal(err)
+			}
+			hash, err := hasher.Hash("correct horse battery staple")
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if ok, err := hasher.Verify("correct horse battery staple", hash); !ok || err != nil {
+					b.Fatalf("Verify = %v, %v", ok, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"go-postgres-api/internal/config"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Server is the HTTP server with the configured timeouts and limits and a
+// graceful shutdown sequence. With TLS configured it serves HTTPS and HTTP/2,
+// optionally alongside a plain HTTP listener that redirects to HTTPS. The
+// metrics can have a plain HTTP listener of their own.
+type Server struct {
+	httpServer      *http.Server
+	redirectServer  *http.Server // nil unless enabled
+	metricsServer   *http.Server // nil unless METRICS_ADDRESS is set
+	certs           *certReloader
+	reloadInterval  time.Duration
+	drainPeriod     time.Duration
+	shutdownTimeout time.Duration
+}
+
+// New creates a server for handler listening on the configured host and port.
+// metricsHandler, when not nil, is served on the metrics address.
+func New(cfg *config.Config, handler http.Handler, metricsHandler http.Handler) (*Server, error) {
+	host := cfg.ServerHost
+	if host == "" {
+		host = "0.0.0.0" // Default to all interfaces
+	}
+
+	s := &Server{
+		httpServer:      newHTTPServer(cfg, net.JoinHostPort(host, cfg.ServerPort), handler),
+		reloadInterval:  cfg.TLSReloadInterval,
+		drainPeriod:     cfg.ShutdownDrainPeriod,
+		shutdownTimeout: cfg.ShutdownTimeout,
+	}
+
+	if metricsHandler != nil {
+		s.metricsServer = newHTTPServer(cfg, cfg.MetricsAddress, metricsHandler)
+	}
+
+	if !cfg.TLSEnabled() {
+		return s, nil
+	}
+
+	certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newTLSConfig(cfg, certs)
+	if err != nil {
+		return nil, err
+	}
+	s.certs = certs
+	s.httpServer.TLSConfig = tlsConfig
+
+	if cfg.HTTPRedirectPort != "" {
+		redirect := redirectToHTTPS(cfg.ServerPort)
+		s.redirectServer = newHTTPServer(cfg, net.JoinHostPort(host, cfg.HTTPRedirectPort), redirect)
+	}
+
+	return s, nil
+}
+
+// newHTTPServer creates an http.Server with the configured timeouts and limits
+func newHTTPServer(cfg *config.Config, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ServerReadTimeout,
+		ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		WriteTimeout:      cfg.ServerWriteTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
+		MaxHeaderBytes:    cfg.ServerMaxHeaderBytes,
+		// Errors such as failed TLS handshakes
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+}
+
+// redirectToHTTPS redirects every request to the same URL over HTTPS on httpsPort
+func redirectToHTTPS(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host // No port in the Host header
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		// 308 keeps the method and body of non-GET requests
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
+
+// Run serves until ctx is done and then shuts down in order: drain is called
+// so readiness starts failing, the server keeps serving for the drain period
+// while load balancers notice, and then stops accepting connections and waits
+// up to the shutdown timeout for in-flight requests. It returns nil after a
+// clean shutdown.
+func (s *Server) Run(ctx context.Context, drain func()) error {
+	serveErr := make(chan error, 3)
+	go func() {
+		if s.certs == nil {
+			slog.Info("server running", "url", "http://"+s.httpServer.Addr)
+			serveErr <- s.httpServer.ListenAndServe()
+			return
+		}
+		slog.Info("server running", "url", "https://"+s.httpServer.Addr)
+		serveErr <- s.httpServer.ListenAndServeTLS("", "") // Certificates come from TLSConfig
+	}()
+
+	if s.redirectServer != nil {
+		go func() {
+			slog.Info("redirecting to HTTPS", "url", "http://"+s.redirectServer.Addr)
+			serveErr <- s.redirectServer.ListenAndServe()
+		}()
+	}
+
+	if s.metricsServer != nil {
+		go func() {
+			slog.Info("serving metrics", "url", "http://"+s.metricsServer.Addr+"/metrics")
+			serveErr <- s.metricsServer.ListenAndServe()
+		}()
+	}
+
+	if s.certs != nil && s.reloadInterval > 0 {
+		watchCtx, stopWatching := context.WithCancel(context.Background())
+		defer stopWatching()
+		go s.certs.watch(watchCtx, s.reloadInterval)
+	}
+
+	select {
+	case err := <-serveErr:
+		s.close()
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down", "drain_period", s.drainPeriod.String())
+	if drain != nil {
+		drain()
+	}
+	select {
+	case <-time.After(s.drainPeriod):
+	case err := <-serveErr:
+		s.close()
+		return err
+	}
+
+	shutdownCtx, cancel := withOptionalTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	if s.redirectServer != nil {
+		if err := s.redirectServer.Shutdown(shutdownCtx); err != nil {
+			s.redirectServer.Close()
+		}
+	}
+	if s.metricsServer != nil {
+		if err := s.metricsServer.Shutdown(shutdownCtx); err != nil {
+			s.metricsServer.Close()
+		}
+	}
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		// Cut off whatever is still running
+		s.httpServer.Close()
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	slog.Info("server stopped")
+	return nil
+}
+
+// close stops every listener immediately after one of them failed
+func (s *Server) close() {
+	s.httpServer.Close()
+	if s.redirectServer != nil {
+		s.redirectServer.Close()
+	}
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
+}
+
+// withOptionalTimeout is context.WithTimeout where a zero timeout means none
+func withOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
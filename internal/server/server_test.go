@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/health"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddress returns a local address nothing listens on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// get fetches url, retrying while the server starts
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp.StatusCode, string(body)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMetricsListener(t *testing.T) {
+	apiHost, apiPort, err := net.SplitHostPort(freeAddress(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{ServerHost: apiHost, ServerPort: apiPort, MetricsAddress: freeAddress(t)}
+
+	handler := func(body string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, body)
+		})
+	}
+	srv, err := New(cfg, handler("api"), handler("metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx, nil) }()
+
+	if _, body := get(t, "http://"+net.JoinHostPort(apiHost, apiPort)+"/metrics"); body != "api" {
+		t.Errorf("API listener served %q, want the API handler", body)
+	}
+	if _, body := get(t, "http://"+cfg.MetricsAddress+"/metrics"); body != "metrics" {
+		t.Errorf("metrics listener served %q, want the metrics handler", body)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run = %v, want a clean shutdown", err)
+	}
+	if conn, err := net.Dial("tcp", cfg.MetricsAddress); err == nil {
+		conn.Close()
+		t.Error("metrics listener still accepts connections after shutdown")
+	}
+}
+
+func TestRunShutdownSequence(t *testing.T) {
+	apiHost, apiPort, err := net.SplitHostPort(freeAddress(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, redirectPort, err := net.SplitHostPort(freeAddress(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeCertPair(t, t.TempDir(), "localhost")
+	const drainPeriod = 300 * time.Millisecond
+	cfg := &config.Config{
+		ServerHost:          apiHost,
+		ServerPort:          apiPort,
+		HTTPRedirectPort:    redirectPort,
+		MetricsAddress:      freeAddress(t),
+		TLSCertFile:         certFile,
+		TLSKeyFile:          keyFile,
+		TLSMinVersion:       "1.2",
+		TLSClientAuth:       "none",
+		ShutdownDrainPeriod: drainPeriod,
+		ShutdownTimeout:     5 * time.Second,
+	}
+
+	readiness := health.NewRegistry(time.Second, 0)
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !readiness.Run(r.Context()).Healthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "metrics") })
+
+	srv, err := New(cfg, mux, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx, readiness.Drain) }()
+	defer cancel()
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		// Report redirects rather than following them
+		CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	apiURL := "https://" + net.JoinHostPort(apiHost, apiPort)
+	redirectAddress := net.JoinHostPort(apiHost, redirectPort)
+	fetch := func(url string) (int, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+
+	// Wait for every listener
+	for _, url := range []string{apiURL + "/readyz", "http://" + redirectAddress + "/readyz", "http://" + cfg.MetricsAddress + "/metrics"} {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, err := fetch(url); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("GET %s: server did not start", url)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if status, _ := fetch(apiURL + "/readyz"); status != http.StatusOK {
+		t.Fatalf("/readyz before shutdown: status %d, want 200", status)
+	}
+	if status, _ := fetch("http://" + redirectAddress + "/readyz"); status != http.StatusMovedPermanently {
+		t.Errorf("redirect listener: status %d, want 301", status)
+	}
+
+	// A request that is still running when the shutdown starts
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := client.Get(apiURL + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		slow <- string(body)
+	}()
+	<-started
+
+	cancel()
+	cancelled := time.Now()
+
+	// During the drain period the server still answers, with readiness failing
+	for {
+		status, err := fetch(apiURL + "/readyz")
+		if err != nil {
+			t.Fatalf("/readyz during the drain: %v", err)
+		}
+		if status == http.StatusServiceUnavailable {
+			break
+		}
+		if time.Since(cancelled) > drainPeriod/2 {
+			t.Fatalf("/readyz during the drain: status %d, want 503", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned %v during the drain period", err)
+	default:
+	}
+
+	// After the drain period the shutdown waits for the in-flight request
+	time.Sleep(drainPeriod)
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned %v before the in-flight request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if body := <-slow; body != "done" {
+		t.Errorf("in-flight request got %q, want it to finish", body)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run = %v, want a clean shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the in-flight request finished")
+	}
+	if elapsed := time.Since(cancelled); elapsed < drainPeriod {
+		t.Errorf("Run returned %s after cancellation, before the drain period ended", elapsed)
+	}
+
+	for name, address := range map[string]string{
+		"API":      net.JoinHostPort(apiHost, apiPort),
+		"redirect": redirectAddress,
+		"metrics":  cfg.MetricsAddress,
+	} {
+		if conn, err := net.Dial("tcp", address); err == nil {
+			conn.Close()
+			t.Errorf("%s listener still accepts connections after shutdown", name)
+		}
+	}
+}
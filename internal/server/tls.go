@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"go-postgres-api/internal/config"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+// newTLSConfig builds the TLS configuration for cfg. Certificates are served
+// by certs so they can be swapped without restarting.
+func newTLSConfig(cfg *config.Config, certs *certReloader) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[cfg.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS_MIN_VERSION %q: must be 1.2 or 1.3", cfg.TLSMinVersion)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		GetCertificate: certs.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	// TLS 1.3 suites aren't configurable, so these only apply to TLS 1.2
+	if len(cfg.TLSCipherSuites) > 0 {
+		suites, err := cipherSuiteIDs(cfg.TLSCipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = suites
+	}
+
+	clientAuth, ok := clientAuthTypes[cfg.TLSClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS_CLIENT_AUTH %q", cfg.TLSClientAuth)
+	}
+	tlsConfig.ClientAuth = clientAuth
+
+	if cfg.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS_CLIENT_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS_CLIENT_CA_FILE %s", cfg.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	} else if clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("TLS_CLIENT_AUTH %s requires TLS_CLIENT_CA_FILE", cfg.TLSClientAuth)
+	}
+
+	return tlsConfig, nil
+}
+
+// cipherSuiteIDs maps cipher suite names such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+// to their IDs, rejecting unknown and insecure suites
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q in TLS_CIPHER_SUITES", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// certReloader serves a certificate and key pair from disk and reloads it
+// when either file changes, so renewed certificates are picked up without a
+// restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// newCertReloader loads the certificate and key, failing if they can't be used
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate for tls.Config
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// reloadIfChanged loads the pair again if either file's modification time
+// changed. On error the previous certificate stays in use.
+func (r *certReloader) reloadIfChanged() (bool, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat TLS certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat TLS key: %w", err)
+	}
+	modTimes := [2]time.Time{certInfo.ModTime(), keyInfo.ModTime()}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTimes == r.modTimes
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return true, nil
+}
+
+// watch checks the files every interval until ctx is done. A pair that fails
+// to load, e.g. because only one of the files has been replaced so far, is
+// retried on the next check.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.reloadIfChanged()
+			if err != nil {
+				slog.Error("failed to reload TLS certificate, keeping the current one", "error", err)
+			} else if reloaded {
+				slog.Info("reloaded TLS certificate", "cert_file", r.certFile)
+			}
+		}
+	}
+}
@@ -1,89 +1,186 @@
 package services
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/metrics"
 	"go-postgres-api/internal/models"
 	"go-postgres-api/internal/repositories"
+	"go-postgres-api/internal/security"
+	"go-postgres-api/internal/tracing"
+	"go-postgres-api/internal/validation"
 	"go-postgres-api/pkg/utilis"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// JWT configuration
-const (
-	accessTokenExpiryTime   = 15 * time.Minute   // Access token valid for 15 minutes
-	refreshTokenExpiryTime  = 7 * 24 * time.Hour // Refresh token valid for 7 days
-	verificationTokenExpiry = 24 * time.Hour     // Email verification token valid for 24 hours
-)
+// EmailSender sends the account emails of the authentication flows
+type EmailSender interface {
+	SendVerificationEmail(ctx context.Context, toEmail, verificationToken string) error
+	SendEmailChangeConfirmation(ctx context.Context, toEmail, confirmationToken string) error
+	SendEmailChangeNotice(ctx context.Context, toEmail, newEmail, cancelToken string) error
+	SendPasswordChangedNotice(ctx context.Context, toEmail string) error
+}
 
 // AuthService handles authentication logic
 type AuthService struct {
-	userRepo     *repositories.UserRepository
-	emailService *EmailService
+	userRepo       repositories.Store
+	emailService   EmailSender
+	passwordPolicy *PasswordPolicy
+	passwordHasher security.PasswordHasher
+	jwtSecret      []byte
+
+	// clients are the registered client applications by ID; they set the
+	// lifetimes of access and refresh tokens
+	clients map[string]config.Client
+
+	blacklist *blacklistCache
+
+	// Email token lifetimes
+	verificationTokenTTL time.Duration
+	emailChangeTokenTTL  time.Duration
 }
 
 // NewAuthService creates a new authentication service
-func NewAuthService() *AuthService {
+func NewAuthService(cfg *config.Config, userRepo repositories.Store, emailService EmailSender, passwordPolicy *PasswordPolicy, passwordHasher security.PasswordHasher) *AuthService {
+	clients := make(map[string]config.Client, len(cfg.Clients))
+	for _, client := range cfg.Clients {
+		clients[client.ID] = client
+	}
+
 	return &AuthService{
-		userRepo:     repositories.NewUserRepository(),
-		emailService: NewEmailService(),
+		userRepo:       userRepo,
+		emailService:   emailService,
+		passwordPolicy: passwordPolicy,
+		passwordHasher: passwordHasher,
+		jwtSecret:      []byte(cfg.JWTSecret),
+		clients:        clients,
+		blacklist:      newBlacklistCache(cfg.BlacklistCacheTTL),
+
+		verificationTokenTTL: cfg.EmailVerificationTokenTTL,
+		emailChangeTokenTTL:  cfg.EmailChangeTokenTTL,
+	}
+}
+
+// clientFor returns the registered client with the given ID, or the default
+// client for an empty ID, if it may use the grant type
+func (s *AuthService) clientFor(clientID, grantType string) (config.Client, error) {
+	if clientID == "" {
+		clientID = config.DefaultClientID
 	}
+
+	client, ok := s.clients[clientID]
+	if !ok {
+		return config.Client{}, ErrUnknownClient
+	}
+	if !client.Allows(grantType) {
+		return config.Client{}, ErrGrantNotAllowed.WithMessage(fmt.Sprintf("client %s is not allowed to use the %s grant", client.ID, grantType))
+	}
+	return client, nil
 }
 
-// getJWTSecret returns the JWT secret from environment variables
-func (s *AuthService) getJWTSecret() string {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		secret = "your-fallback-secret-key" // For development only
+// NewPasswordHasher creates the password hasher configured in cfg
+func NewPasswordHasher(cfg *config.Config) (security.PasswordHasher, error) {
+	params := security.Argon2idParams{
+		Memory:      uint32(cfg.Argon2MemoryKB),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+	}
+
+	hasher, err := security.NewHasher(cfg.PasswordHashAlgorithm, params, cfg.BcryptCost)
+	if err != nil {
+		return nil, fmt.Errorf("invalid password hashing configuration: %w", err)
 	}
-	return secret
+	return hasher, nil
 }
 
 // Register registers a new user and sends verification email
-func (s *AuthService) Register(req *models.RegisterRequest) (*models.SuccessResponse, error) {
+func (s *AuthService) Register(ctx context.Context, req *models.RegisterRequest, ipAddress, userAgent string) (resp *models.SuccessResponse, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Register")
+	defer tracing.End(span, &err)
+
+	authLog := &models.AuthLog{
+		Action:    "register",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   false,
+	}
+
+	email := validation.NormalizeEmail(req.Email)
+
 	// Check if user already exists
-	existingUser, err := s.userRepo.FindByEmail(req.Email)
+	existingUser, err := s.userRepo.FindByEmail(ctx, email)
 	if err != nil {
 		return nil, err
 	}
 	if existingUser != nil {
-		return nil, errors.New("user with this email already exists")
+		authLog.UserID = existingUser.ID
+		authLog.ErrorMessage = "email already registered"
+		s.logAuth(ctx, authLog)
+		return nil, ErrEmailTaken.WithMessage("user with this email already exists")
+	}
+
+	name := strings.TrimSpace(req.FirstName) + " " + strings.TrimSpace(req.LastName)
+
+	// Enforce the password policy
+	if err := s.passwordPolicy.Validate(ctx, req.Password, email, name); err != nil {
+		authLog.ErrorMessage = "password policy violation"
+		s.logAuth(ctx, authLog)
+		return nil, err
 	}
 
 	// Create new user with is_verified = false
 	user := &models.User{
-		Email:      req.Email,
-		Name:       req.FirstName + " " + req.LastName,
+		Email:      email,
+		Name:       name,
 		IsVerified: false,
 		IsActive:   true,
 		RoleID:     2, // Default role
 	}
 
 	// Set password
-	if err := user.SetPassword(req.Password); err != nil {
+	if err := s.setPassword(ctx, user, req.Password); err != nil {
 		return nil, err
 	}
 
-	// Save user to database
-	if err := s.userRepo.Create(user); err != nil {
-		return nil, err
-	}
+	// Save the user and their verification token together, so a failure
+	// can't leave behind an account that can never be verified
+	var verificationToken string
+	err = s.userRepo.Transaction(ctx, func(tx repositories.Store) error {
+		if err := tx.Create(ctx, user); err != nil {
+			return err
+		}
 
-	// Generate email verification token
-	verificationToken, err := s.generateEmailVerificationToken(user.ID)
+		token, err := s.generateEmailToken(ctx, tx, user.ID, models.TokenPurposeEmailVerification, "", s.verificationTokenTTL)
+		verificationToken = token
+		return err
+	})
+	if errors.Is(err, repositories.ErrDuplicate) {
+		authLog.ErrorMessage = "email already registered"
+		s.logAuth(ctx, authLog)
+		return nil, ErrEmailTaken.WithMessage("user with this email already exists")
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	authLog.UserID = user.ID
+	authLog.Success = true
+	s.logAuth(ctx, authLog)
+
 	// Send verification email
-	if err := s.emailService.SendVerificationEmail(user.Email, verificationToken); err != nil {
-		// Log error but don't fail registration
+	if err := s.emailService.SendVerificationEmail(ctx, user.Email, verificationToken); err != nil {
+		// Log error but don't fail registration; the user can ask for the email again
 		// In production, you might want to use a job queue for email sending
-		// log.Printf("Failed to send verification email: %v", err)
+		logging.FromContext(ctx).ErrorContext(ctx, "failed to send verification email", "user_id", user.ID, "error", err)
 	}
 
 	return &models.SuccessResponse{
@@ -91,8 +188,8 @@ func (s *AuthService) Register(req *models.RegisterRequest) (*models.SuccessResp
 	}, nil
 }
 
-// generateEmailVerificationToken generates a secure email verification token
-func (s *AuthService) generateEmailVerificationToken(userID uint) (string, error) {
+// generateEmailToken generates a secure token that is delivered by email and stores it in store
+func (s *AuthService) generateEmailToken(ctx context.Context, store repositories.TokenStore, userID uint, purpose, newEmail string, expiry time.Duration) (string, error) {
 	// Generate secure random token
 	tokenBytes := make([]byte, 32)
 	if _, err := rand.Read(tokenBytes); err != nil {
@@ -104,11 +201,13 @@ func (s *AuthService) generateEmailVerificationToken(userID uint) (string, error
 	verificationToken := &models.EmailVerificationToken{
 		UserID:    userID,
 		Token:     token,
-		ExpiresAt: time.Now().Add(verificationTokenExpiry),
+		Purpose:   purpose,
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(expiry),
 		Used:      false,
 	}
 
-	if err := s.userRepo.CreateEmailVerificationToken(verificationToken); err != nil {
+	if err := store.CreateEmailVerificationToken(ctx, verificationToken); err != nil {
 		return "", err
 	}
 
@@ -116,56 +215,93 @@ func (s *AuthService) generateEmailVerificationToken(userID uint) (string, error
 }
 
 // VerifyEmail verifies a user's email using the verification token
-func (s *AuthService) VerifyEmail(token string) (*models.SuccessResponse, error) {
+func (s *AuthService) VerifyEmail(ctx context.Context, token, ipAddress, userAgent string) (resp *models.SuccessResponse, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.VerifyEmail")
+	defer tracing.End(span, &err)
+
+	authLog := &models.AuthLog{
+		Action:    "verify_email",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   false,
+	}
+
 	// Find and validate token
-	verificationToken, err := s.userRepo.FindEmailVerificationToken(token)
+	verificationToken, err := s.userRepo.FindEmailVerificationToken(ctx, token, models.TokenPurposeEmailVerification)
+	if errors.Is(err, repositories.ErrTokenNotFound) {
+		authLog.ErrorMessage = "invalid token"
+		s.logAuth(ctx, authLog)
+		return nil, ErrInvalidLinkToken.WithMessage("invalid or expired verification token")
+	}
 	if err != nil {
-		return nil, errors.New("invalid or expired verification token")
+		return nil, err
 	}
 
+	authLog.UserID = verificationToken.UserID
+
 	if verificationToken.Used {
-		return nil, errors.New("verification token already used")
+		authLog.ErrorMessage = "token already used"
+		s.logAuth(ctx, authLog)
+		return nil, ErrLinkTokenUsed.WithMessage("verification token already used")
 	}
 
 	if time.Now().After(verificationToken.ExpiresAt) {
-		return nil, errors.New("verification token expired")
+		authLog.ErrorMessage = "token expired"
+		s.logAuth(ctx, authLog)
+		return nil, ErrLinkTokenExpired.WithMessage("verification token expired")
 	}
 
-	// Update user verification status
-	if err := s.userRepo.UpdateUserVerification(verificationToken.UserID, true); err != nil {
-		return nil, err
+	// Claim the token and verify the user atomically; of two concurrent
+	// requests with the same token only one gets to claim it
+	err = s.userRepo.Transaction(ctx, func(tx repositories.Store) error {
+		if err := tx.MarkEmailTokenAsUsed(ctx, verificationToken.ID); err != nil {
+			return err
+		}
+		return tx.UpdateUserVerification(ctx, verificationToken.UserID, true)
+	})
+	if errors.Is(err, repositories.ErrTokenUsed) {
+		authLog.ErrorMessage = "token already used"
+		s.logAuth(ctx, authLog)
+		return nil, ErrLinkTokenUsed.WithMessage("verification token already used")
 	}
-
-	// Mark token as used
-	if err := s.userRepo.MarkEmailTokenAsUsed(verificationToken.ID); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
+	authLog.Success = true
+	s.logAuth(ctx, authLog)
+
 	return &models.SuccessResponse{
 		Message: "Email verified successfully. You can now log in.",
 	}, nil
 }
 
 // ResendVerificationEmail resends verification email
-func (s *AuthService) ResendVerificationEmail(email string) (*models.SuccessResponse, error) {
+func (s *AuthService) ResendVerificationEmail(ctx context.Context, email string) (resp *models.SuccessResponse, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ResendVerificationEmail")
+	defer tracing.End(span, &err)
+
 	// Find user
-	user, err := s.userRepo.FindByEmail(email)
+	user, err := s.userRepo.FindByEmail(ctx, email)
 	if err != nil {
-		return nil, errors.New("user not found")
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
 	}
 
 	if user.IsVerified {
-		return nil, errors.New("email already verified")
+		return nil, ErrEmailAlreadyVerified
 	}
 
 	// Generate new verification token
-	verificationToken, err := s.generateEmailVerificationToken(user.ID)
+	verificationToken, err := s.generateEmailToken(ctx, s.userRepo, user.ID, models.TokenPurposeEmailVerification, "", s.verificationTokenTTL)
 	if err != nil {
 		return nil, err
 	}
 
 	// Send verification email
-	if err := s.emailService.SendVerificationEmail(user.Email, verificationToken); err != nil {
+	if err := s.emailService.SendVerificationEmail(ctx, user.Email, verificationToken); err != nil {
 		return nil, err
 	}
 
@@ -174,10 +310,219 @@ func (s *AuthService) ResendVerificationEmail(email string) (*models.SuccessResp
 	}, nil
 }
 
+// RequestEmailChange starts a change of email address. A confirmation link is sent
+// to the new address and a notice with a cancel link to the current one.
+func (s *AuthService) RequestEmailChange(ctx context.Context, userID uint, req *models.ChangeEmailRequest, ipAddress, userAgent string) (resp *models.SuccessResponse, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.RequestEmailChange", userIDAttr(userID))
+	defer tracing.End(span, &err)
+
+	authLog := &models.AuthLog{
+		UserID:    userID,
+		Action:    "email_change_request",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   false,
+	}
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	// Require the current password so a stolen access token can't take over the account
+	valid, err := s.verifyPassword(ctx, user, req.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		authLog.ErrorMessage = "invalid password"
+		s.logAuth(ctx, authLog)
+		return nil, ErrIncorrectPassword
+	}
+
+	newEmail := validation.NormalizeEmail(req.NewEmail)
+	if strings.EqualFold(user.Email, newEmail) {
+		return nil, ErrEmailUnchanged
+	}
+
+	existingUser, err := s.userRepo.FindByEmail(ctx, newEmail)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		authLog.ErrorMessage = "email already in use"
+		s.logAuth(ctx, authLog)
+		return nil, ErrEmailTaken
+	}
+
+	// Only the most recent request can be confirmed
+	if err := s.userRepo.InvalidateEmailChangeTokens(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	confirmToken, err := s.generateEmailToken(ctx, s.userRepo, user.ID, models.TokenPurposeEmailChange, newEmail, s.emailChangeTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelToken, err := s.generateEmailToken(ctx, s.userRepo, user.ID, models.TokenPurposeEmailChangeCancel, newEmail, s.emailChangeTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.emailService.SendEmailChangeConfirmation(ctx, newEmail, confirmToken); err != nil {
+		return nil, err
+	}
+
+	if err := s.emailService.SendEmailChangeNotice(ctx, user.Email, newEmail, cancelToken); err != nil {
+		return nil, err
+	}
+
+	authLog.Success = true
+	s.logAuth(ctx, authLog)
+
+	return &models.SuccessResponse{
+		Message: "Please check your new email address to confirm the change.",
+	}, nil
+}
+
+// ConfirmEmailChange swaps the user's email address and revokes their existing sessions
+func (s *AuthService) ConfirmEmailChange(ctx context.Context, token, ipAddress, userAgent string) (resp *models.SuccessResponse, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ConfirmEmailChange")
+	defer tracing.End(span, &err)
+
+	changeToken, err := s.userRepo.FindEmailVerificationToken(ctx, token, models.TokenPurposeEmailChange)
+	if errors.Is(err, repositories.ErrTokenNotFound) {
+		return nil, ErrInvalidLinkToken.WithMessage("invalid or expired email change token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if changeToken.Used {
+		return nil, ErrLinkTokenUsed.WithMessage("email change token already used")
+	}
+
+	if time.Now().After(changeToken.ExpiresAt) {
+		return nil, ErrLinkTokenExpired.WithMessage("email change token expired")
+	}
+
+	authLog := &models.AuthLog{
+		UserID:    changeToken.UserID,
+		Action:    "email_change_confirm",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   false,
+	}
+
+	// The address may have been taken since the change was requested
+	existingUser, err := s.userRepo.FindByEmail(ctx, changeToken.NewEmail)
+	if err != nil {
+		return nil, err
+	}
+	if existingUser != nil {
+		authLog.ErrorMessage = "email already in use"
+		s.logAuth(ctx, authLog)
+		return nil, ErrEmailTaken
+	}
+
+	var revoked []models.TokenBlacklist
+	err = s.userRepo.Transaction(ctx, func(tx repositories.Store) error {
+		if err := tx.MarkEmailTokenAsUsed(ctx, changeToken.ID); err != nil {
+			return err
+		}
+
+		if err := tx.UpdateUserEmail(ctx, changeToken.UserID, changeToken.NewEmail); err != nil {
+			return err
+		}
+
+		// Invalidate the cancel token of this request and any other pending one
+		if err := tx.InvalidateEmailChangeTokens(ctx, changeToken.UserID); err != nil {
+			return err
+		}
+
+		// Sign out every existing session, the access tokens they hold included
+		blacklisted, err := blacklistSessionAccessTokens(ctx, tx, changeToken.UserID, "")
+		if err != nil {
+			return err
+		}
+		revoked = blacklisted
+		return tx.RevokeUserRefreshTokens(ctx, changeToken.UserID)
+	})
+	if errors.Is(err, repositories.ErrTokenUsed) {
+		return nil, ErrLinkTokenUsed.WithMessage("email change token already used")
+	}
+	if err != nil {
+		authLog.ErrorMessage = "failed to update email"
+		s.logAuth(ctx, authLog)
+		if errors.Is(err, repositories.ErrDuplicate) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+	s.cacheRevokedTokens(revoked)
+
+	authLog.Success = true
+	s.logAuth(ctx, authLog)
+
+	return &models.SuccessResponse{
+		Message: "Email address changed successfully. Please log in again.",
+	}, nil
+}
+
+// CancelEmailChange cancels a pending change of email address
+func (s *AuthService) CancelEmailChange(ctx context.Context, token, ipAddress, userAgent string) (resp *models.SuccessResponse, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.CancelEmailChange")
+	defer tracing.End(span, &err)
+
+	cancelToken, err := s.userRepo.FindEmailVerificationToken(ctx, token, models.TokenPurposeEmailChangeCancel)
+	if errors.Is(err, repositories.ErrTokenNotFound) {
+		return nil, ErrInvalidLinkToken.WithMessage("invalid or expired cancel token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cancelToken.Used {
+		return nil, ErrLinkTokenUsed.WithMessage("email change already confirmed or cancelled")
+	}
+
+	if time.Now().After(cancelToken.ExpiresAt) {
+		return nil, ErrLinkTokenExpired.WithMessage("cancel token expired")
+	}
+
+	if err := s.userRepo.InvalidateEmailChangeTokens(ctx, cancelToken.UserID); err != nil {
+		return nil, err
+	}
+
+	s.logAuth(ctx, &models.AuthLog{
+		UserID:    cancelToken.UserID,
+		Action:    "email_change_cancel",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   true,
+	})
+
+	return &models.SuccessResponse{
+		Message: "Email change cancelled.",
+	}, nil
+}
+
 // Login authenticates a user and returns JWT tokens
-func (s *AuthService) Login(req *models.LoginRequest, ipAddress, userAgent string) (*models.AuthResponse, error) {
+func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest, ipAddress, userAgent string) (resp *models.AuthResponse, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Login", clientIDAttr(req.ClientID))
+	defer tracing.End(span, &err)
+
+	client, err := s.clientFor(req.ClientID, config.GrantPassword)
+	if err != nil {
+		return nil, err
+	}
+
 	// Find user by email
-	user, err := s.userRepo.FindByEmail(req.Email)
+	user, err := s.userRepo.FindByEmail(ctx, req.Email)
 	if err != nil {
 		return nil, err
 	}
@@ -193,8 +538,8 @@ func (s *AuthService) Login(req *models.LoginRequest, ipAddress, userAgent strin
 	// Check if user exists
 	if user == nil {
 		authLog.ErrorMessage = "user not found"
-		s.userRepo.LogAuth(authLog)
-		return nil, errors.New("invalid email or password")
+		s.logAuth(ctx, authLog)
+		return nil, ErrInvalidCredentials
 	}
 
 	authLog.UserID = user.ID
@@ -202,54 +547,153 @@ func (s *AuthService) Login(req *models.LoginRequest, ipAddress, userAgent strin
 	// Check if email is verified
 	if !user.IsVerified {
 		authLog.ErrorMessage = "email not verified"
-		s.userRepo.LogAuth(authLog)
-		return nil, errors.New("please verify your email address before logging in")
+		s.logAuth(ctx, authLog)
+		return nil, ErrEmailNotVerified
 	}
 
 	// Verify password
-	if !user.CheckPassword(req.Password) {
+	valid, err := s.verifyPassword(ctx, user, req.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
 		authLog.ErrorMessage = "invalid password"
-		s.userRepo.LogAuth(authLog)
-		return nil, errors.New("invalid email or password")
+		s.logAuth(ctx, authLog)
+		return nil, ErrInvalidCredentials
+	}
+
+	// Upgrade the stored hash if it uses an outdated algorithm or parameters
+	if s.passwordHasher.NeedsRehash(user.Password) {
+		err := s.setPassword(ctx, user, req.Password)
+		if err == nil {
+			err = s.userRepo.UpdatePassword(ctx, user.ID, user.Password)
+		}
+		if err != nil {
+			// The login goes ahead; the next one tries again
+			logging.FromContext(ctx).WarnContext(ctx, "failed to upgrade password hash", "user_id", user.ID, "error", err)
+		}
+	}
+
+	return s.issueTokens(ctx, user, client, authLog)
+}
+
+// issueTokens starts a new session of a client for an authenticated user, generates
+// its access and refresh tokens and records the successful login
+func (s *AuthService) issueTokens(ctx context.Context, user *models.User, client config.Client, authLog *models.AuthLog) (*models.AuthResponse, error) {
+	sessionID, err := generateSessionID()
+	if err != nil {
+		authLog.ErrorMessage = "failed to generate session"
+		s.logAuth(ctx, authLog)
+		return nil, err
 	}
 
 	// Generate access token
-	accessToken, err := s.generateAccessToken(user.ID)
+	accessToken, err := s.generateAccessToken(user.ID, sessionID, client)
 	if err != nil {
 		authLog.ErrorMessage = "failed to generate access token"
-		s.userRepo.LogAuth(authLog)
+		s.logAuth(ctx, authLog)
 		return nil, err
 	}
 
 	// Generate refresh token
-	refreshToken, err := s.generateRefreshToken(user.ID)
+	refreshToken, err := s.generateRefreshToken(ctx, s.userRepo, user.ID, sessionID, client, accessToken)
 	if err != nil {
 		authLog.ErrorMessage = "failed to generate refresh token"
-		s.userRepo.LogAuth(authLog)
+		s.logAuth(ctx, authLog)
 		return nil, err
 	}
 
 	// Update last login time
-	s.userRepo.UpdateLastLogin(user.ID)
+	s.userRepo.UpdateLastLogin(ctx, user.ID)
 
 	// Log successful login
 	authLog.Success = true
-	s.userRepo.LogAuth(authLog)
+	s.logAuth(ctx, authLog)
 
 	return &models.AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(accessTokenExpiryTime.Seconds()),
-		User:         *user,
+		AccessToken:           accessToken.Token,
+		RefreshToken:          refreshToken,
+		ExpiresIn:             int64(client.AccessTokenTTL.Seconds()),
+		User:                  *user,
+		TokenDelivery:         client.TokenDelivery,
+		RefreshTokenExpiresIn: int64(client.RefreshTokenTTL.Seconds()),
 	}, nil
 }
 
-// generateAccessToken generates a JWT access token
-func (s *AuthService) generateAccessToken(userID uint) (string, error) {
+// verifyPassword checks a password against the user's stored hash. Hashing can't be
+// interrupted once started, so it is skipped when the request is already cancelled.
+func (s *AuthService) verifyPassword(ctx context.Context, user *models.User, password string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return user.CheckPassword(s.passwordHasher, password), nil
+}
+
+// setPassword hashes a new password for the user unless the request is already cancelled
+func (s *AuthService) setPassword(ctx context.Context, user *models.User, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return user.SetPassword(s.passwordHasher, password)
+}
+
+// userIDAttr annotates a span with the user it acts on
+func userIDAttr(userID uint) attribute.KeyValue {
+	return attribute.Int64("user.id", int64(userID))
+}
+
+// clientIDAttr annotates a span with the client application of the request
+func clientIDAttr(clientID string) attribute.KeyValue {
+	if clientID == "" {
+		clientID = config.DefaultClientID
+	}
+	return attribute.String("auth.client_id", clientID)
+}
+
+// logAuth records an auth log entry. It is written even if the request was
+// cancelled so failed attempts aren't lost from the audit trail.
+func (s *AuthService) logAuth(ctx context.Context, authLog *models.AuthLog) {
+	outcome := metrics.OutcomeSuccess
+	if !authLog.Success {
+		outcome = metrics.OutcomeFailure
+	}
+	metrics.AuthEvents.WithLabelValues(authLog.Action, outcome, metrics.Reason(authLog.ErrorMessage)).Inc()
+
+	s.userRepo.LogAuth(context.WithoutCancel(ctx), authLog)
+}
+
+// generateSessionID generates the identifier shared by all tokens of one login
+func generateSessionID() (string, error) {
+	sessionBytes := make([]byte, 16)
+	if _, err := rand.Read(sessionBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sessionBytes), nil
+}
+
+// issuedAccessToken is a signed access token together with what is needed to revoke it
+type issuedAccessToken struct {
+	Token     string
+	JTI       string
+	ExpiresAt time.Time
+}
+
+// AccessClaims holds the claims of a validated access token
+type AccessClaims struct {
+	UserID    uint
+	SessionID string
+	JTI       string
+	ExpiresAt time.Time
+}
+
+// generateAccessToken generates a JWT access token for a client
+func (s *AuthService) generateAccessToken(userID uint, sessionID string, client config.Client) (*issuedAccessToken, error) {
 	tokenJTI := utilis.GenerateRandomString(36)
-	expirationTime := time.Now().Add(accessTokenExpiryTime)
+	expirationTime := time.Now().Add(client.AccessTokenTTL)
 	claims := jwt.MapClaims{
 		"sub":  userID,
+		"sid":  sessionID,
+		"azp":  client.ID,
 		"exp":  expirationTime.Unix(),
 		"iat":  time.Now().Unix(),
 		"jti":  tokenJTI,
@@ -257,16 +701,21 @@ func (s *AuthService) generateAccessToken(userID uint) (string, error) {
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.getJWTSecret()))
+	tokenString, err := token.SignedString(s.jwtSecret)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return tokenString, nil
+	return &issuedAccessToken{
+		Token:     tokenString,
+		JTI:       tokenJTI,
+		ExpiresAt: time.Unix(expirationTime.Unix(), 0),
+	}, nil
 }
 
-// generateRefreshToken generates a refresh token
-func (s *AuthService) generateRefreshToken(userID uint) (string, error) {
+// generateRefreshToken generates a refresh token for a client's session and stores
+// it in store together with the access token issued alongside it
+func (s *AuthService) generateRefreshToken(ctx context.Context, store repositories.TokenStore, userID uint, sessionID string, client config.Client, accessToken *issuedAccessToken) (string, error) {
 	// Generate secure random token
 	tokenBytes := make([]byte, 32)
 	if _, err := rand.Read(tokenBytes); err != nil {
@@ -276,13 +725,17 @@ func (s *AuthService) generateRefreshToken(userID uint) (string, error) {
 
 	// Store refresh token in database
 	refreshToken := &models.RefreshToken{
-		UserID:    userID,
-		Token:     token,
-		ExpiresAt: time.Now().Add(refreshTokenExpiryTime),
-		Used:      false,
+		UserID:               userID,
+		SessionID:            sessionID,
+		ClientID:             client.ID,
+		Token:                token,
+		AccessTokenJTI:       accessToken.JTI,
+		AccessTokenExpiresAt: accessToken.ExpiresAt,
+		ExpiresAt:            time.Now().Add(client.RefreshTokenTTL),
+		Used:                 false,
 	}
 
-	if err := s.userRepo.CreateRefreshToken(refreshToken); err != nil {
+	if err := store.CreateRefreshToken(ctx, refreshToken); err != nil {
 		return "", err
 	}
 
@@ -290,73 +743,157 @@ func (s *AuthService) generateRefreshToken(userID uint) (string, error) {
 }
 
 // RefreshAccessToken generates a new access token using refresh token
-func (s *AuthService) RefreshAccessToken(refreshTokenString string) (*models.AuthResponse, error) {
+func (s *AuthService) RefreshAccessToken(ctx context.Context, refreshTokenString, ipAddress, userAgent string) (resp *models.AuthResponse, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.RefreshAccessToken")
+	defer tracing.End(span, &err)
+
+	authLog := &models.AuthLog{
+		Action:    "refresh_token",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   false,
+	}
+
 	// Find and validate refresh token
-	refreshToken, err := s.userRepo.FindRefreshToken(refreshTokenString)
+	refreshToken, err := s.userRepo.FindRefreshToken(ctx, refreshTokenString)
+	if errors.Is(err, repositories.ErrInvalidRefreshToken) {
+		authLog.ErrorMessage = "invalid token"
+		s.logAuth(ctx, authLog)
+		return nil, errInvalidRefreshToken
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	authLog.UserID = refreshToken.UserID
+
 	if time.Now().After(refreshToken.ExpiresAt) {
-		return nil, errors.New("refresh token expired")
+		authLog.ErrorMessage = "token expired"
+		s.logAuth(ctx, authLog)
+		return nil, ErrTokenExpired.WithMessage("refresh token expired")
 	}
 
-	// Get user
-	user, err := s.userRepo.FindByID(refreshToken.UserID)
-	if err != nil || user == nil {
-		return nil, errors.New("user not found")
+	// The token keeps the policy of the client it was issued to
+	client, err := s.clientFor(refreshToken.ClientID, config.GrantRefreshToken)
+	if err != nil {
+		authLog.ErrorMessage = "client not allowed"
+		s.logAuth(ctx, authLog)
+		return nil, err
 	}
 
-	// Generate new access token
-	accessToken, err := s.generateAccessToken(user.ID)
+	// Get user
+	user, err := s.userRepo.FindByID(ctx, refreshToken.UserID)
 	if err != nil {
 		return nil, err
 	}
+	if user == nil {
+		authLog.ErrorMessage = "user not found"
+		s.logAuth(ctx, authLog)
+		return nil, ErrUserNotFound
+	}
+
+	// Keep the session of the refresh token being rotated
+	sessionID := refreshToken.SessionID
+	if sessionID == "" {
+		if sessionID, err = generateSessionID(); err != nil {
+			return nil, err
+		}
+	}
 
-	// Generate new refresh token
-	newRefreshToken, err := s.generateRefreshToken(user.ID)
+	// Generate new access token
+	accessToken, err := s.generateAccessToken(user.ID, sessionID, client)
 	if err != nil {
 		return nil, err
 	}
 
-	// Mark old refresh token as used
-	if err := s.userRepo.MarkRefreshTokenAsUsed(refreshToken.ID); err != nil {
+	// Clients that don't rotate keep their refresh token, which now tracks the
+	// new access token so that it is revoked with the session
+	if client.RefreshRotation == config.RotationReuse {
+		if err := s.userRepo.UpdateRefreshTokenAccessToken(ctx, refreshToken.ID, accessToken.JTI, accessToken.ExpiresAt); err != nil {
+			if errors.Is(err, repositories.ErrInvalidRefreshToken) {
+				authLog.ErrorMessage = "token already used"
+				s.logAuth(ctx, authLog)
+				return nil, errInvalidRefreshToken
+			}
+			return nil, err
+		}
+
+		authLog.Success = true
+		s.logAuth(ctx, authLog)
+
+		return &models.AuthResponse{
+			AccessToken:           accessToken.Token,
+			RefreshToken:          refreshTokenString,
+			ExpiresIn:             int64(client.AccessTokenTTL.Seconds()),
+			User:                  *user,
+			TokenDelivery:         client.TokenDelivery,
+			RefreshTokenExpiresIn: int64(time.Until(refreshToken.ExpiresAt).Seconds()),
+		}, nil
+	}
+
+	// Rotate the refresh token atomically. Claiming the old token first means
+	// that of two concurrent refreshes only one gets a new token.
+	var newRefreshToken string
+	err = s.userRepo.Transaction(ctx, func(tx repositories.Store) error {
+		if err := tx.MarkRefreshTokenAsUsed(ctx, refreshToken.ID); err != nil {
+			return err
+		}
+
+		token, err := s.generateRefreshToken(ctx, tx, user.ID, sessionID, client, accessToken)
+		newRefreshToken = token
+		return err
+	})
+	if errors.Is(err, repositories.ErrInvalidRefreshToken) {
+		// A concurrent refresh claimed the token first
+		authLog.ErrorMessage = "token already used"
+		s.logAuth(ctx, authLog)
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
 		return nil, err
 	}
 
+	authLog.Success = true
+	s.logAuth(ctx, authLog)
+
 	return &models.AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: newRefreshToken,
-		ExpiresIn:    int64(accessTokenExpiryTime.Seconds()),
-		User:         *user,
+		AccessToken:           accessToken.Token,
+		RefreshToken:          newRefreshToken,
+		ExpiresIn:             int64(client.AccessTokenTTL.Seconds()),
+		User:                  *user,
+		TokenDelivery:         client.TokenDelivery,
+		RefreshTokenExpiresIn: int64(client.RefreshTokenTTL.Seconds()),
 	}, nil
 }
 
 // Logout blacklists a token
-func (s *AuthService) Logout(tokenString string, userID uint) error {
+func (s *AuthService) Logout(ctx context.Context, tokenString string, userID uint) (err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Logout", userIDAttr(userID))
+	defer tracing.End(span, &err)
+
 	// Parse token to get claims
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.getJWTSecret()), nil
+		return s.jwtSecret, nil
 	})
 	if err != nil {
-		return err
+		return tokenError(err)
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !token.Valid {
-		return errors.New("invalid token")
+		return ErrInvalidToken
 	}
 
 	// Get JTI for blacklisting
 	jti, ok := claims["jti"].(string)
 	if !ok {
-		return errors.New("invalid token JTI")
+		return ErrInvalidToken
 	}
 
 	// Get expiration time
 	exp, ok := claims["exp"].(float64)
 	if !ok {
-		return errors.New("invalid token expiration")
+		return ErrInvalidToken
 	}
 
 	// Add token to blacklist
@@ -366,49 +903,236 @@ func (s *AuthService) Logout(tokenString string, userID uint) error {
 		ExpiresAt: time.Unix(int64(exp), 0),
 	}
 
-	return s.userRepo.BlacklistToken(blacklistedToken)
+	if err := s.userRepo.BlacklistToken(ctx, blacklistedToken); err != nil {
+		return err
+	}
+	s.blacklist.set(jti, true, blacklistedToken.ExpiresAt)
+	return nil
+}
+
+// ValidateToken validates a JWT access token and returns its claims
+func (s *AuthService) ValidateToken(ctx context.Context, tokenString string) (claims *AccessClaims, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ValidateToken")
+	defer tracing.End(span, &err)
+
+	start := time.Now()
+	claims, err = s.validateToken(ctx, tokenString)
+
+	result := "valid"
+	if err != nil {
+		result = "invalid"
+	}
+	metrics.TokenValidationDuration.WithLabelValues(result).Observe(time.Since(start).Seconds())
+
+	return claims, err
 }
 
-// ValidateToken validates a JWT token
-func (s *AuthService) ValidateToken(tokenString string) (uint, error) {
+// validateToken implements ValidateToken
+func (s *AuthService) validateToken(ctx context.Context, tokenString string) (*AccessClaims, error) {
 	// Parse token
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.getJWTSecret()), nil
+		return s.jwtSecret, nil
 	})
 	if err != nil {
-		return 0, err
+		return nil, tokenError(err)
 	}
 
 	// Validate token
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !token.Valid {
-		return 0, errors.New("invalid token")
+		return nil, ErrInvalidToken
 	}
 
 	// Check if token is blacklisted
 	jti, ok := claims["jti"].(string)
 	if !ok {
-		return 0, errors.New("invalid token JTI")
+		return nil, ErrInvalidToken
 	}
 
-	isBlacklisted, err := s.userRepo.IsTokenBlacklisted(jti)
+	exp, _ := claims["exp"].(float64)
+	expiresAt := time.Unix(int64(exp), 0)
+
+	isBlacklisted, err := s.isBlacklisted(ctx, jti, expiresAt)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 	if isBlacklisted {
-		return 0, errors.New("token is blacklisted")
+		return nil, ErrTokenRevoked
 	}
 
 	// Get user ID
 	userID, ok := claims["sub"].(float64)
 	if !ok {
-		return 0, errors.New("invalid user ID in token")
+		return nil, ErrInvalidToken
 	}
 
-	return uint(userID), nil
+	// Tokens issued before sessions were tracked carry no session ID
+	sessionID, _ := claims["sid"].(string)
+
+	return &AccessClaims{
+		UserID:    uint(userID),
+		SessionID: sessionID,
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// isBlacklisted checks whether an access token was revoked, asking the
+// blacklist cache before the database
+func (s *AuthService) isBlacklisted(ctx context.Context, jti string, expiresAt time.Time) (bool, error) {
+	if blacklisted, ok := s.blacklist.get(jti); ok {
+		metrics.BlacklistCacheLookups.WithLabelValues("hit").Inc()
+		return blacklisted, nil
+	}
+	metrics.BlacklistCacheLookups.WithLabelValues("miss").Inc()
+
+	blacklisted, err := s.userRepo.IsTokenBlacklisted(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+	s.blacklist.set(jti, blacklisted, expiresAt)
+	return blacklisted, nil
+}
+
+// blacklistSessionAccessTokens blacklists the unexpired access tokens of every
+// session of the user but keepSessionID, which is empty to include them all,
+// and returns the new blacklist entries
+func blacklistSessionAccessTokens(ctx context.Context, tx repositories.Store, userID uint, keepSessionID string) ([]models.TokenBlacklist, error) {
+	tokens, err := tx.FindOtherSessionAccessTokens(ctx, userID, keepSessionID)
+	if err != nil {
+		return nil, err
+	}
+	var revoked []models.TokenBlacklist
+	for _, token := range tokens {
+		isBlacklisted, err := tx.IsTokenBlacklisted(ctx, token.AccessTokenJTI)
+		if err != nil {
+			return nil, err
+		}
+		if isBlacklisted {
+			continue
+		}
+		blacklisted := models.TokenBlacklist{
+			TokenJTI:  token.AccessTokenJTI,
+			UserID:    userID,
+			ExpiresAt: token.AccessTokenExpiresAt,
+		}
+		if err := tx.BlacklistToken(ctx, &blacklisted); err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, blacklisted)
+	}
+	return revoked, nil
+}
+
+// cacheRevokedTokens records committed blacklist entries in the cache, so this
+// instance rejects the tokens at once
+func (s *AuthService) cacheRevokedTokens(revoked []models.TokenBlacklist) {
+	for _, token := range revoked {
+		s.blacklist.set(token.TokenJTI, true, token.ExpiresAt)
+	}
+}
+
+// ChangePassword changes the password of a logged-in user. Every other session is
+// signed out: its refresh tokens are revoked and its access tokens blacklisted.
+func (s *AuthService) ChangePassword(ctx context.Context, claims *AccessClaims, req *models.ChangePasswordRequest, ipAddress, userAgent string) (resp *models.SuccessResponse, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.ChangePassword", userIDAttr(claims.UserID))
+	defer tracing.End(span, &err)
+
+	authLog := &models.AuthLog{
+		UserID:    claims.UserID,
+		Action:    "password_change",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   false,
+	}
+
+	user, err := s.userRepo.FindByID(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	// Verify current password
+	valid, err := s.verifyPassword(ctx, user, req.CurrentPassword)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		authLog.ErrorMessage = "invalid password"
+		s.logAuth(ctx, authLog)
+		return nil, ErrIncorrectPassword
+	}
+
+	unchanged, err := s.verifyPassword(ctx, user, req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+	if unchanged {
+		authLog.ErrorMessage = "password unchanged"
+		s.logAuth(ctx, authLog)
+		return nil, ErrPasswordUnchanged
+	}
+
+	// Enforce the password policy
+	if err := s.passwordPolicy.Validate(ctx, req.NewPassword, user.Email, user.Name); err != nil {
+		var policyErr *PasswordPolicyError
+		if errors.As(err, &policyErr) {
+			policyErr.Field = "new_password"
+		}
+		authLog.ErrorMessage = "password policy violation"
+		s.logAuth(ctx, authLog)
+		return nil, err
+	}
+
+	// Rehash and store the new password
+	if err := s.setPassword(ctx, user, req.NewPassword); err != nil {
+		return nil, err
+	}
+	// Store the password and sign out other sessions together, so they can't
+	// keep working with the old password's tokens after a partial failure
+	var revoked []models.TokenBlacklist
+	err = s.userRepo.Transaction(ctx, func(tx repositories.Store) error {
+		if err := tx.UpdatePassword(ctx, user.ID, user.Password); err != nil {
+			return err
+		}
+
+		// Blacklist the access tokens other sessions still hold
+		blacklisted, err := blacklistSessionAccessTokens(ctx, tx, user.ID, claims.SessionID)
+		if err != nil {
+			return err
+		}
+		revoked = blacklisted
+
+		// Revoke the refresh tokens of other sessions
+		return tx.RevokeOtherSessions(ctx, user.ID, claims.SessionID)
+	})
+	if err != nil {
+		authLog.ErrorMessage = "failed to update password"
+		s.logAuth(ctx, authLog)
+		return nil, err
+	}
+	s.cacheRevokedTokens(revoked)
+
+	// Let the user know in case the change wasn't theirs
+	if err := s.emailService.SendPasswordChangedNotice(ctx, user.Email); err != nil {
+		// Log error but don't fail the password change
+		logging.FromContext(ctx).ErrorContext(ctx, "failed to send password changed notice", "user_id", user.ID, "error", err)
+	}
+
+	authLog.Success = true
+	s.logAuth(ctx, authLog)
+
+	return &models.SuccessResponse{
+		Message: "Password changed successfully. Other sessions have been signed out.",
+	}, nil
 }
 
 // GetUserByID retrieves a user by ID
-func (s *AuthService) GetUserByID(userID uint) (*models.User, error) {
-	return s.userRepo.FindByID(userID)
+func (s *AuthService) GetUserByID(ctx context.Context, userID uint) (user *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.GetUserByID", userIDAttr(userID))
+	defer tracing.End(span, &err)
+
+	return s.userRepo.FindByID(ctx, userID)
 }
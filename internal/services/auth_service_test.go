@@ -0,0 +1,236 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/repositories"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createVerifiedUser stores a verified user whose password hash is passwordHash
+func createVerifiedUser(t *testing.T, store repositories.Store, email, passwordHash string) *models.User {
+	t.Helper()
+	user := &models.User{Email: email, Name: "Alice", Password: passwordHash, IsVerified: true, IsActive: true}
+	if err := store.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// bcryptHash hashes password as builds before argon2id did
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+// failingPasswordStore fails to update passwords
+type failingPasswordStore struct {
+	*repositories.MemoryStore
+}
+
+var errPasswordNotUpdated = errors.New("password not updated")
+
+func (s failingPasswordStore) UpdatePassword(ctx context.Context, userID uint, passwordHash string) error {
+	return errPasswordNotUpdated
+}
+
+func TestLoginRehashesBcryptPassword(t *testing.T) {
+	ctx := context.Background()
+	store := repositories.NewMemoryStore()
+	service, _ := newTestAuthService(t, testConfig(), store)
+	user := createVerifiedUser(t, store, "alice@example.com", bcryptHash(t, "correct horse"))
+
+	if _, err := service.Login(ctx, &models.LoginRequest{Email: "alice@example.com", Password: "correct horse"}, "", ""); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	stored, err := store.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored.Password, "$argon2id$") {
+		t.Fatalf("stored hash after login = %q, want it upgraded to argon2id", stored.Password)
+	}
+	if service.passwordHasher.NeedsRehash(stored.Password) {
+		t.Error("upgraded hash still needs a rehash")
+	}
+
+	// The upgraded hash logs in, and isn't hashed again
+	if _, err := service.Login(ctx, &models.LoginRequest{Email: "alice@example.com", Password: "correct horse"}, "", ""); err != nil {
+		t.Fatalf("Login after rehash: %v", err)
+	}
+	again, err := store.FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Password != stored.Password {
+		t.Error("a current hash was replaced on login")
+	}
+
+	// A wrong password neither logs in nor touches the hash
+	_, err = service.Login(ctx, &models.LoginRequest{Email: "alice@example.com", Password: "wrong horse"}, "", "")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login(wrong password) = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLoginKeepsGoingWhenRehashFails(t *testing.T) {
+	memory := repositories.NewMemoryStore()
+	service, _ := newTestAuthService(t, testConfig(), failingPasswordStore{memory})
+	user := createVerifiedUser(t, memory, "alice@example.com", bcryptHash(t, "correct horse"))
+
+	var logs bytes.Buffer
+	ctx := logging.NewContext(context.Background(), slog.New(slog.NewTextHandler(&logs, nil)))
+
+	resp, err := service.Login(ctx, &models.LoginRequest{Email: "alice@example.com", Password: "correct horse"}, "", "")
+	if err != nil {
+		t.Fatalf("Login = %v, want the login to succeed with the old hash", err)
+	}
+	if resp.AccessToken == "" {
+		t.Error("Login issued no access token")
+	}
+
+	if !strings.Contains(logs.String(), "failed to upgrade password hash") || !strings.Contains(logs.String(), errPasswordNotUpdated.Error()) {
+		t.Errorf("log = %q, want the failed rehash with its error", logs.String())
+	}
+	stored, err := memory.FindByID(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored.Password, "$2a$") {
+		t.Errorf("stored hash = %q, want the bcrypt hash kept", stored.Password)
+	}
+}
+
+// login signs a user in, starting a new session
+func login(t *testing.T, service *AuthService, email, password string) *models.AuthResponse {
+	t.Helper()
+	resp, err := service.Login(context.Background(), &models.LoginRequest{Email: email, Password: password}, "", "")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return resp
+}
+
+// assertSignedOut checks that the session's access and refresh tokens no
+// longer work, on service and on another instance sharing its store
+func assertSignedOut(t *testing.T, service, other *AuthService, session *models.AuthResponse) {
+	t.Helper()
+	ctx := context.Background()
+	for name, instance := range map[string]*AuthService{"same instance": service, "other instance": other} {
+		if _, err := instance.ValidateToken(ctx, session.AccessToken); !errors.Is(err, ErrTokenRevoked) {
+			t.Errorf("%s: ValidateToken = %v, want ErrTokenRevoked", name, err)
+		}
+	}
+	if _, err := service.RefreshAccessToken(ctx, session.RefreshToken, "", ""); err == nil {
+		t.Error("RefreshAccessToken succeeded, want the refresh token revoked")
+	}
+}
+
+func TestChangePasswordSignsOutOtherSessions(t *testing.T) {
+	ctx := context.Background()
+	store := repositories.NewMemoryStore()
+	service, _ := newTestAuthService(t, testConfig(), store)
+	other, _ := newTestAuthService(t, testConfig(), store)
+	createVerifiedUser(t, store, "alice@example.com", bcryptHash(t, "correct horse"))
+
+	current := login(t, service, "alice@example.com", "correct horse")
+	stale := login(t, service, "alice@example.com", "correct horse")
+
+	// Warm the cache, so the change must overwrite it
+	if _, err := service.ValidateToken(ctx, stale.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := service.ValidateToken(ctx, current.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &models.ChangePasswordRequest{CurrentPassword: "correct horse", NewPassword: "battery staple"}
+	if _, err := service.ChangePassword(ctx, claims, req, "", ""); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	assertSignedOut(t, service, other, stale)
+	if _, err := service.ValidateToken(ctx, current.AccessToken); err != nil {
+		t.Errorf("ValidateToken(current session) = %v, want it kept", err)
+	}
+}
+
+func TestConfirmEmailChangeSignsOutEverySession(t *testing.T) {
+	ctx := context.Background()
+	store := repositories.NewMemoryStore()
+	service, emails := newTestAuthService(t, testConfig(), store)
+	other, _ := newTestAuthService(t, testConfig(), store)
+	user := createVerifiedUser(t, store, "alice@example.com", bcryptHash(t, "correct horse"))
+
+	first := login(t, service, "alice@example.com", "correct horse")
+	second := login(t, service, "alice@example.com", "correct horse")
+	for _, session := range []*models.AuthResponse{first, second} {
+		if _, err := service.ValidateToken(ctx, session.AccessToken); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := &models.ChangeEmailRequest{NewEmail: "alice@example.org", Password: "correct horse"}
+	if _, err := service.RequestEmailChange(ctx, user.ID, req, "", ""); err != nil {
+		t.Fatalf("RequestEmailChange: %v", err)
+	}
+	// Requesting the change signs nobody out yet
+	if _, err := service.ValidateToken(ctx, first.AccessToken); err != nil {
+		t.Fatalf("ValidateToken before the confirmation = %v", err)
+	}
+
+	token := emails.last(t, "email_change", "alice@example.org").Token
+	if _, err := service.ConfirmEmailChange(ctx, token, "", ""); err != nil {
+		t.Fatalf("ConfirmEmailChange: %v", err)
+	}
+
+	assertSignedOut(t, service, other, first)
+	assertSignedOut(t, service, other, second)
+
+	// The new address logs in
+	login(t, service, "alice@example.org", "correct horse")
+}
+
+func TestChangePasswordSignsOutSessionlessTokens(t *testing.T) {
+	ctx := context.Background()
+	store := repositories.NewMemoryStore()
+	service, _ := newTestAuthService(t, testConfig(), store)
+	user := createVerifiedUser(t, store, "alice@example.com", bcryptHash(t, "correct horse"))
+
+	current := login(t, service, "alice@example.com", "correct horse")
+	// A refresh token issued before sessions were tracked
+	legacy := &models.RefreshToken{UserID: user.ID, ClientID: "default", Token: "legacy-refresh-token", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.CreateRefreshToken(ctx, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := service.ValidateToken(ctx, current.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &models.ChangePasswordRequest{CurrentPassword: "correct horse", NewPassword: "battery staple"}
+	if _, err := service.ChangePassword(ctx, claims, req, "", ""); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	if _, err := service.RefreshAccessToken(ctx, legacy.Token, "", ""); err == nil {
+		t.Error("RefreshAccessToken(legacy token) succeeded, want it revoked")
+	}
+	if _, err := service.RefreshAccessToken(ctx, current.RefreshToken, "", ""); err != nil {
+		t.Errorf("RefreshAccessToken(current session) = %v, want it kept", err)
+	}
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// maxBlacklistCacheEntries bounds the memory of the cache; when it is full,
+// expired entries are dropped and, failing that, the cache starts over
+const maxBlacklistCacheEntries = 100_000
+
+// blacklistCache remembers blacklist lookups so validating an access token
+// doesn't hit the database on every request. Blacklisted tokens are kept
+// until they expire, as that can't change. Tokens that aren't blacklisted are
+// kept only for the TTL, which bounds how long a token revoked by another
+// instance keeps working here; a zero TTL doesn't cache them at all.
+type blacklistCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]blacklistCacheEntry
+}
+
+type blacklistCacheEntry struct {
+	blacklisted bool
+	expiresAt   time.Time
+}
+
+func newBlacklistCache(ttl time.Duration) *blacklistCache {
+	return &blacklistCache{ttl: ttl, entries: make(map[string]blacklistCacheEntry)}
+}
+
+// get returns the cached lookup of a JTI, if there is one that hasn't expired
+func (c *blacklistCache) get(jti string) (blacklisted, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[jti]
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, jti)
+		return false, false
+	}
+	return entry.blacklisted, true
+}
+
+// set caches a lookup of a token that expires at tokenExpiresAt
+func (c *blacklistCache) set(jti string, blacklisted bool, tokenExpiresAt time.Time) {
+	expiresAt := tokenExpiresAt
+	if !blacklisted {
+		if c.ttl <= 0 {
+			return
+		}
+		if limit := time.Now().Add(c.ttl); limit.Before(expiresAt) {
+			expiresAt = limit
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxBlacklistCacheEntries {
+		c.evictExpired()
+	}
+	c.entries[jti] = blacklistCacheEntry{blacklisted: blacklisted, expiresAt: expiresAt}
+}
+
+// evictExpired drops expired entries, or everything if none has expired
+func (c *blacklistCache) evictExpired() {
+	now := time.Now()
+	for jti, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, jti)
+		}
+	}
+	if len(c.entries) >= maxBlacklistCacheEntries {
+		clear(c.entries)
+	}
+}
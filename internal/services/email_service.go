@@ -1,9 +1,18 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/tracing"
+	"net"
 	"net/smtp"
-	"os"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // EmailService handles email operations
@@ -13,34 +22,76 @@ type EmailService struct {
 	SMTPUsername string
 	SMTPPassword string
 	FromEmail    string
+
+	// BaseURL is the public address of the API the links point to
+	BaseURL string
+
+	// Lifetimes of the links, as told in the emails
+	VerificationTokenTTL time.Duration
+	EmailChangeTokenTTL  time.Duration
+
+	// logBodies includes the body, and so its links, when an email is
+	// logged instead of sent; it is off in production
+	logBodies bool
 }
 
 // NewEmailService creates a new email service
-func NewEmailService() *EmailService {
+func NewEmailService(cfg *config.Config) *EmailService {
 	return &EmailService{
-		SMTPHost:     os.Getenv("SMTP_HOST"),
-		SMTPPort:     os.Getenv("SMTP_PORT"),
-		SMTPUsername: os.Getenv("SMTP_USERNAME"),
-		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
-		FromEmail:    os.Getenv("FROM_EMAIL"),
+		SMTPHost:     cfg.SMTPHost,
+		SMTPPort:     cfg.SMTPPort,
+		SMTPUsername: cfg.SMTPUsername,
+		SMTPPassword: cfg.SMTPPassword,
+		FromEmail:    cfg.FromEmail,
+
+		BaseURL: strings.TrimSuffix(cfg.PublicBaseURL, "/"),
+
+		VerificationTokenTTL: cfg.EmailVerificationTokenTTL,
+		EmailChangeTokenTTL:  cfg.EmailChangeTokenTTL,
+
+		logBodies: !cfg.IsProduction(),
 	}
 }
 
-// SendVerificationEmail sends an email verification link
-func (s *EmailService) SendVerificationEmail(toEmail, verificationToken string) error {
-	// Email configuration validation
+// link returns the URL of an API route taking a token
+func (s *EmailService) link(path, token string) string {
+	return s.BaseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// formatTTL writes a link lifetime the way the emails tell it, such as
+// "24 hours" or "90 minutes"
+func formatTTL(ttl time.Duration) string {
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case ttl >= 48*time.Hour && ttl%(24*time.Hour) == 0:
+		return plural(int64(ttl/(24*time.Hour)), "day")
+	case ttl >= time.Hour && ttl%time.Hour == 0:
+		return plural(int64(ttl/time.Hour), "hour")
+	case ttl >= time.Minute:
+		return plural(int64(ttl/time.Minute), "minute")
+	default:
+		return plural(int64(ttl/time.Second), "second")
+	}
+}
+
+// SMTPAddress returns the SMTP server's host:port, or "" in development mode
+func (s *EmailService) SMTPAddress() string {
 	if s.SMTPHost == "" || s.SMTPPort == "" || s.SMTPUsername == "" || s.SMTPPassword == "" {
-		// For development, just log the verification token
-		fmt.Printf("\n=== EMAIL VERIFICATION (DEV MODE) ===\n")
-		fmt.Printf("To: %s\n", toEmail)
-		fmt.Printf("Verification Link: http://localhost:8080/api/v1/auth/verify-email?token=%s\n", verificationToken)
-		fmt.Printf("=====================================\n\n")
-		return nil
+		return ""
 	}
+	return net.JoinHostPort(s.SMTPHost, s.SMTPPort)
+}
 
+// SendVerificationEmail sends an email verification link
+func (s *EmailService) SendVerificationEmail(ctx context.Context, toEmail, verificationToken string) error {
 	// Email content
 	subject := "Verify Your Email Address"
-	verificationURL := fmt.Sprintf("http://localhost:8080/api/v1/auth/verify-email?token=%s", verificationToken)
+	verificationURL := s.link("/api/v1/auth/verify-email", verificationToken)
 
 	body := fmt.Sprintf(`
 Hello,
@@ -49,44 +100,123 @@ Thank you for registering! Please click the link below to verify your email addr
 
 %s
 
-This link will expire in 24 hours.
+This link will expire in %s.
 
 If you didn't create an account, please ignore this email.
 
 Best regards,
 Your App Team
-`, verificationURL)
+`, verificationURL, formatTTL(s.VerificationTokenTTL))
+
+	return s.send(ctx, toEmail, subject, body)
+}
+
+// SendPasswordResetEmail sends a password reset email (for future use)
+func (s *EmailService) SendPasswordResetEmail(ctx context.Context, toEmail, resetToken string) error {
+	// Similar implementation for password reset
+	if s.SMTPAddress() == "" {
+		resetURL := s.link("/api/v1/auth/reset-password", resetToken)
+		return s.send(ctx, toEmail, "Reset Your Password", resetURL)
+	}
+
+	// Implementation for production email sending
+	return nil
+}
+
+// SendEmailChangeConfirmation sends the confirmation link for a change of email address to the new address
+func (s *EmailService) SendEmailChangeConfirmation(ctx context.Context, toEmail, confirmationToken string) error {
+	subject := "Confirm Your New Email Address"
+	confirmationURL := s.link("/api/v1/auth/confirm-email-change", confirmationToken)
+
+	body := fmt.Sprintf(`
+Hello,
+
+We received a request to change the email address of your account to this address.
+Please click the link below to confirm the change:
+
+%s
+
+This link will expire in %s.
+
+If you didn't request this change, please ignore this email.
+
+Best regards,
+Your App Team
+`, confirmationURL, formatTTL(s.EmailChangeTokenTTL))
+
+	return s.send(ctx, toEmail, subject, body)
+}
+
+// SendEmailChangeNotice notifies the current address of a pending change and offers a cancel link
+func (s *EmailService) SendEmailChangeNotice(ctx context.Context, toEmail, newEmail, cancelToken string) error {
+	subject := "Your Email Address Is Being Changed"
+	cancelURL := s.link("/api/v1/auth/cancel-email-change", cancelToken)
+
+	body := fmt.Sprintf(`
+Hello,
+
+A request was made to change the email address of your account to %s.
+The change will only take effect once it is confirmed from the new address.
+
+If you didn't request this change, cancel it by clicking the link below and change your password.
+The link will expire in %s:
+
+%s
+
+Best regards,
+Your App Team
+`, newEmail, formatTTL(s.EmailChangeTokenTTL), cancelURL)
+
+	return s.send(ctx, toEmail, subject, body)
+}
+
+// send delivers a plain-text email, or logs it when SMTP isn't configured
+func (s *EmailService) send(ctx context.Context, toEmail, subject, body string) (err error) {
+	if s.SMTPAddress() == "" {
+		attrs := []any{"to", toEmail, "subject", subject}
+		if s.logBodies {
+			attrs = append(attrs, "body", strings.TrimSpace(body))
+		}
+		logging.FromContext(ctx).InfoContext(ctx, "SMTP is not configured, email not sent", attrs...)
+		return nil
+	}
+
+	// The recipient is left out of the span like it is masked in the logs
+	_, span := tracing.StartClient(ctx, "smtp.send",
+		attribute.String("server.address", s.SMTPHost),
+		attribute.String("server.port", s.SMTPPort),
+		attribute.String("email.subject", subject),
+	)
+	defer tracing.End(span, &err)
 
-	// Email message
 	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
 		s.FromEmail, toEmail, subject, body)
 
-	// SMTP authentication
 	auth := smtp.PlainAuth("", s.SMTPUsername, s.SMTPPassword, s.SMTPHost)
 
-	// Send email
-	err := smtp.SendMail(
+	return smtp.SendMail(
 		s.SMTPHost+":"+s.SMTPPort,
 		auth,
 		s.FromEmail,
 		[]string{toEmail},
 		[]byte(message),
 	)
-
-	return err
 }
 
-// SendPasswordResetEmail sends a password reset email (for future use)
-func (s *EmailService) SendPasswordResetEmail(toEmail, resetToken string) error {
-	// Similar implementation for password reset
-	if s.SMTPHost == "" || s.SMTPPort == "" || s.SMTPUsername == "" || s.SMTPPassword == "" {
-		fmt.Printf("\n=== PASSWORD RESET (DEV MODE) ===\n")
-		fmt.Printf("To: %s\n", toEmail)
-		fmt.Printf("Reset Link: http://localhost:8080/api/v1/auth/reset-password?token=%s\n", resetToken)
-		fmt.Printf("=================================\n\n")
-		return nil
-	}
+// SendPasswordChangedNotice tells the user their password was changed
+func (s *EmailService) SendPasswordChangedNotice(ctx context.Context, toEmail string) error {
+	subject := "Your Password Was Changed"
 
-	// Implementation for production email sending
-	return nil
+	body := `
+Hello,
+
+The password of your account was just changed and all other sessions were signed out.
+
+If you didn't make this change, please reset your password immediately and contact support.
+
+Best regards,
+Your App Team
+`
+
+	return s.send(ctx, toEmail, subject, body)
 }
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"go-postgres-api/internal/logging"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatTTL(t *testing.T) {
+	tests := map[time.Duration]string{
+		24 * time.Hour:   "24 hours",
+		72 * time.Hour:   "3 days",
+		time.Hour:        "1 hour",
+		90 * time.Minute: "90 minutes",
+		time.Minute:      "1 minute",
+		30 * time.Second: "30 seconds",
+	}
+	for ttl, want := range tests {
+		if got := formatTTL(ttl); got != want {
+			t.Errorf("formatTTL(%v) = %q, want %q", ttl, got, want)
+		}
+	}
+}
+
+func TestEmailLinks(t *testing.T) {
+	cfg := testConfig()
+	cfg.PublicBaseURL = "https://api.example.com/auth/"
+	cfg.EmailVerificationTokenTTL = 48 * time.Hour
+	cfg.EmailChangeTokenTTL = 2 * time.Hour
+	service := NewEmailService(cfg)
+
+	// Without SMTP the emails are logged, bodies included outside production
+	var logs bytes.Buffer
+	ctx := logging.NewContext(context.Background(), slog.New(slog.NewTextHandler(&logs, nil)))
+
+	tests := []struct {
+		name string
+		send func() error
+		want []string
+	}{
+		{
+			"verification",
+			func() error { return service.SendVerificationEmail(ctx, "alice@example.com", "tok1") },
+			[]string{"https://api.example.com/auth/api/v1/auth/verify-email?token=tok1", "expire in 2 days"},
+		},
+		{
+			"password reset",
+			func() error { return service.SendPasswordResetEmail(ctx, "alice@example.com", "tok2") },
+			[]string{"https://api.example.com/auth/api/v1/auth/reset-password?token=tok2"},
+		},
+		{
+			"email change confirmation",
+			func() error { return service.SendEmailChangeConfirmation(ctx, "new@example.com", "tok3") },
+			[]string{"https://api.example.com/auth/api/v1/auth/confirm-email-change?token=tok3", "expire in 2 hours"},
+		},
+		{
+			"email change notice",
+			func() error {
+				return service.SendEmailChangeNotice(ctx, "alice@example.com", "new@example.com", "tok4")
+			},
+			[]string{"https://api.example.com/auth/api/v1/auth/cancel-email-change?token=tok4", "expire in 2 hours"},
+		},
+	}
+	for _, tt := range tests {
+		logs.Reset()
+		if err := tt.send(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("%s email = %q, want it to contain %q", tt.name, logs.String(), want)
+			}
+		}
+		if strings.Contains(logs.String(), "localhost") || strings.Contains(logs.String(), "24 hours") {
+			t.Errorf("%s email = %q, want no hard-coded host or lifetime", tt.name, logs.String())
+		}
+	}
+}
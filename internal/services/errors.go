@@ -0,0 +1,76 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Error is a failure the caller can act on. Code identifies it and never
+// changes; Message is meant for people and may be reworded.
+type Error struct {
+	Code    string
+	Message string
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is matches errors by code, so errors.Is finds a sentinel whatever its message
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithMessage returns the error with a message more specific than the sentinel's
+func (e *Error) WithMessage(message string) *Error {
+	return &Error{Code: e.Code, Message: message}
+}
+
+// Errors returned by the services; match them with errors.Is
+var (
+	ErrUnknownClient      = &Error{"unknown_client", "unknown client"}
+	ErrGrantNotAllowed    = &Error{"unauthorized_client", "the client is not allowed to use this grant"}
+	ErrInvalidCredentials = &Error{"invalid_credentials", "invalid email or password"}
+	ErrEmailNotVerified   = &Error{"email_not_verified", "please verify your email address before logging in"}
+	ErrUserNotFound       = &Error{"user_not_found", "user not found"}
+
+	ErrEmailTaken           = &Error{"email_taken", "email address is already in use"}
+	ErrEmailAlreadyVerified = &Error{"email_already_verified", "email already verified"}
+	ErrEmailUnchanged       = &Error{"email_unchanged", "new email must be different from the current email"}
+	ErrIncorrectPassword    = &Error{"incorrect_password", "current password is incorrect"}
+	ErrPasswordUnchanged    = &Error{"password_unchanged", "new password must be different from the current password"}
+
+	// Access and refresh tokens
+	ErrInvalidToken = &Error{"invalid_token", "invalid token"}
+	ErrTokenExpired = &Error{"token_expired", "token expired"}
+	ErrTokenRevoked = &Error{"token_revoked", "token has been revoked"}
+
+	// Tokens of the links sent by email
+	ErrInvalidLinkToken = &Error{"invalid_link_token", "invalid or expired link"}
+	ErrLinkTokenExpired = &Error{"link_token_expired", "link expired"}
+	ErrLinkTokenUsed    = &Error{"link_token_used", "link already used"}
+
+	ErrInvalidPasskeySession    = &Error{"invalid_passkey_session", "invalid or expired passkey session"}
+	ErrInvalidPasskeyResponse   = &Error{"invalid_passkey_response", "invalid passkey response"}
+	ErrPasskeyNotVerified       = &Error{"passkey_not_verified", "passkey registration could not be verified"}
+	ErrPasskeyAlreadyRegistered = &Error{"passkey_already_registered", "passkey already registered"}
+	ErrInvalidPasskey           = &Error{"invalid_passkey", "invalid passkey"}
+	ErrPasskeyDisabled          = &Error{"passkey_disabled", "this passkey has been disabled, please register a new one"}
+	ErrPasskeyNotFound          = &Error{"passkey_not_found", "passkey not found"}
+)
+
+// errInvalidRefreshToken is returned for refresh tokens that are unknown,
+// revoked or already rotated
+var errInvalidRefreshToken = ErrInvalidToken.WithMessage("invalid or expired refresh token")
+
+// tokenError maps a failure to parse an access token to ErrTokenExpired or ErrInvalidToken
+func tokenError(err error) error {
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+		return ErrTokenExpired
+	}
+	return ErrInvalidToken
+}
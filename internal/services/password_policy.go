@@ -0,0 +1,296 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/security"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcryptMaxPasswordBytes is the length after which bcrypt silently ignores input
+const bcryptMaxPasswordBytes = 72
+
+// Password policy rule names reported in violations
+const (
+	PasswordRuleMinLength    = "min_length"
+	PasswordRuleMaxLength    = "max_length"
+	PasswordRuleLowercase    = "lowercase"
+	PasswordRuleUppercase    = "uppercase"
+	PasswordRuleDigit        = "digit"
+	PasswordRuleSymbol       = "symbol"
+	PasswordRulePersonalInfo = "personal_info"
+	PasswordRuleBreached     = "breached"
+)
+
+// PasswordPolicyError lists every rule a password failed. Field names the
+// request field holding the password.
+type PasswordPolicyError struct {
+	Field      string
+	Violations []models.PasswordViolation
+}
+
+// Error implements the error interface
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return "password does not meet the password policy: " + strings.Join(messages, "; ")
+}
+
+// PasswordPolicy validates new passwords for registration, reset and change-password
+type PasswordPolicy struct {
+	MinLength          int
+	MaxLength          int
+	RequireLowercase   bool
+	RequireUppercase   bool
+	RequireDigit       bool
+	RequireSymbol      bool
+	RejectPersonalInfo bool
+	BreachedChecker    BreachedPasswordChecker
+}
+
+// NewPasswordPolicy creates the password policy configured in cfg
+func NewPasswordPolicy(cfg *config.Config) *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength:          cfg.PasswordMinLength,
+		MaxLength:          cfg.PasswordMaxLength,
+		RequireLowercase:   cfg.PasswordRequireLowercase,
+		RequireUppercase:   cfg.PasswordRequireUppercase,
+		RequireDigit:       cfg.PasswordRequireDigit,
+		RequireSymbol:      cfg.PasswordRequireSymbol,
+		RejectPersonalInfo: cfg.PasswordRejectPersonalInfo,
+	}
+
+	// bcrypt ignores everything past 72 bytes, so never accept more than that
+	// with it; argon2id hashes passwords of any length
+	if policy.MaxLength <= 0 || (cfg.PasswordHashAlgorithm == security.AlgorithmBcrypt && policy.MaxLength > bcryptMaxPasswordBytes) {
+		policy.MaxLength = bcryptMaxPasswordBytes
+	}
+
+	if cfg.BreachedPasswordsPath != "" {
+		policy.BreachedChecker = NewFileBreachedPasswordChecker(cfg.BreachedPasswordsPath)
+	}
+
+	return policy
+}
+
+// Validate checks a password against every rule and returns a *PasswordPolicyError
+// listing all violations. email and name are used to reject passwords containing them.
+func (p *PasswordPolicy) Validate(ctx context.Context, password, email, name string) error {
+	var violations []models.PasswordViolation
+	addViolation := func(rule, message string) {
+		violations = append(violations, models.PasswordViolation{Rule: rule, Message: message})
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		addViolation(PasswordRuleMinLength, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	if len(password) > p.MaxLength {
+		addViolation(PasswordRuleMaxLength, fmt.Sprintf("must be at most %d bytes long", p.MaxLength))
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireLowercase && !hasLower {
+		addViolation(PasswordRuleLowercase, "must contain a lowercase letter")
+	}
+	if p.RequireUppercase && !hasUpper {
+		addViolation(PasswordRuleUppercase, "must contain an uppercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		addViolation(PasswordRuleDigit, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		addViolation(PasswordRuleSymbol, "must contain a symbol")
+	}
+
+	if p.RejectPersonalInfo && containsPersonalInfo(password, email, name) {
+		addViolation(PasswordRulePersonalInfo, "must not contain your email address or name")
+	}
+
+	if p.BreachedChecker != nil {
+		breached, err := p.BreachedChecker.IsBreached(password)
+		if err != nil {
+			// Don't lock users out because the breach list is unavailable
+			logging.FromContext(ctx).WarnContext(ctx, "breached password check failed", "error", err)
+		} else if breached {
+			addViolation(PasswordRuleBreached, "has appeared in a data breach, please choose a different password")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Field: "password", Violations: violations}
+	}
+	return nil
+}
+
+// containsPersonalInfo reports whether the password contains the email, its local
+// part or any part of the name of at least three characters, ignoring case
+func containsPersonalInfo(password, email, name string) bool {
+	password = strings.ToLower(password)
+
+	candidates := strings.Fields(strings.ToLower(name))
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		candidates = append(candidates, email)
+		if at := strings.LastIndex(email, "@"); at > 0 {
+			candidates = append(candidates, email[:at])
+		}
+	}
+
+	for _, candidate := range candidates {
+		if utf8.RuneCountInString(candidate) >= 3 && strings.Contains(password, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// BreachedPasswordChecker reports whether a password is known to have been breached
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// FileBreachedPasswordChecker checks passwords against a local copy of a breached
+// SHA-1 hash list, as published by Have I Been Pwned.
+//
+// Path may point to a directory of k-anonymity range files, named after the first
+// five hex characters of the hash (e.g. "5BAA6" or "5BAA6.txt") and holding
+// "SUFFIX:COUNT" lines, so only one small file is read per check. It may also point
+// to a single "HASH:COUNT" file sorted by hash, which is binary searched on disk.
+type FileBreachedPasswordChecker struct {
+	path string
+}
+
+// NewFileBreachedPasswordChecker creates a checker for the list at path
+func NewFileBreachedPasswordChecker(path string) *FileBreachedPasswordChecker {
+	return &FileBreachedPasswordChecker{path: path}
+}
+
+// IsBreached implements BreachedPasswordChecker
+func (c *FileBreachedPasswordChecker) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return false, err
+	}
+
+	if info.IsDir() {
+		return c.searchRangeFile(hash[:5], hash[5:])
+	}
+	return c.searchSortedFile(hash)
+}
+
+// searchRangeFile looks for the hash suffix in the range file of its prefix
+func (c *FileBreachedPasswordChecker) searchRangeFile(prefix, suffix string) (bool, error) {
+	file, err := os.Open(filepath.Join(c.path, prefix+".txt"))
+	if os.IsNotExist(err) {
+		file, err = os.Open(filepath.Join(c.path, prefix))
+	}
+	if os.IsNotExist(err) {
+		return false, nil // No breached password shares this prefix
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.EqualFold(hashOfLine(scanner.Text()), suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// searchSortedFile binary searches a file of "HASH:COUNT" lines sorted by hash
+func (c *FileBreachedPasswordChecker) searchSortedFile(hash string) (bool, error) {
+	file, err := os.Open(c.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// Find the first line starting at or after lo whose hash is >= target,
+	// narrowing [lo, hi) by byte offsets
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, err := lineAfter(file, mid)
+		if err != nil {
+			return false, err
+		}
+		if line == "" || strings.ToUpper(hashOfLine(line)) >= hash {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	line, err := lineAfter(file, lo)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(hashOfLine(line), hash), nil
+}
+
+// lineAfter returns the first complete line starting at or after offset.
+// Offset 0 is the start of the first line; any other offset skips the
+// (possibly partial) line it lands in.
+func lineAfter(file *os.File, offset int64) (string, error) {
+	if offset > 0 {
+		offset--
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, offset, 1<<62))
+	if offset > 0 {
+		if _, err := reader.ReadString('\n'); err != nil {
+			if err == io.EOF {
+				return "", nil
+			}
+			return "", err
+		}
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// hashOfLine strips the ":COUNT" part of a breach list line
+func hashOfLine(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return hash
+}
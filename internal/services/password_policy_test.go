@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/security"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// policyRules returns the rules a password policy error lists
+func policyRules(err error) []string {
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return nil
+	}
+	rules := make([]string, 0, len(policyErr.Violations))
+	for _, violation := range policyErr.Violations {
+		rules = append(rules, violation.Rule)
+	}
+	return rules
+}
+
+func TestNewPasswordPolicyMaxLength(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		maxLength int
+		want      int
+	}{
+		{security.AlgorithmArgon2id, 256, 256},
+		{security.AlgorithmArgon2id, 1000, 1000},
+		{security.AlgorithmBcrypt, 72, 72},
+		{security.AlgorithmBcrypt, 256, 72}, // bcrypt would ignore the rest
+		{security.AlgorithmBcrypt, 0, 72},
+		{security.AlgorithmArgon2id, 0, 72},
+	}
+	for _, tt := range tests {
+		cfg := testConfig()
+		cfg.PasswordHashAlgorithm = tt.algorithm
+		cfg.PasswordMaxLength = tt.maxLength
+		if got := NewPasswordPolicy(cfg).MaxLength; got != tt.want {
+			t.Errorf("%s with PASSWORD_MAX_LENGTH %d: MaxLength = %d, want %d", tt.algorithm, tt.maxLength, got, tt.want)
+		}
+	}
+
+	// A long passphrase passes with argon2id
+	cfg := testConfig()
+	cfg.PasswordMaxLength = 256
+	passphrase := strings.Repeat("correct horse battery staple ", 4)
+	if err := NewPasswordPolicy(cfg).Validate(context.Background(), passphrase, "alice@example.com", "Alice"); err != nil {
+		t.Errorf("Validate(%d-byte passphrase) = %v, want it accepted", len(passphrase), err)
+	}
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	strict := &PasswordPolicy{
+		MinLength:          8,
+		MaxLength:          20,
+		RequireLowercase:   true,
+		RequireUppercase:   true,
+		RequireDigit:       true,
+		RequireSymbol:      true,
+		RejectPersonalInfo: true,
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"meets every rule", "Tr0ub4dor&3", nil},
+		{"too short", "Ab1!", []string{PasswordRuleMinLength}},
+		{"too long", "Tr0ub4dor&3Tr0ub4dor&3", []string{PasswordRuleMaxLength}},
+		{"no lowercase", "TR0UB4DOR&3", []string{PasswordRuleLowercase}},
+		{"no uppercase", "tr0ub4dor&3", []string{PasswordRuleUppercase}},
+		{"no digit", "Troubador&x", []string{PasswordRuleDigit}},
+		{"no symbol", "Tr0ub4dor33", []string{PasswordRuleSymbol}},
+		{"space counts as a symbol", "Tr0ub4dor 3", nil},
+		{"contains the name", "Alice&Tr0ub4", []string{PasswordRulePersonalInfo}},
+		{"every violation at once", "aaaa", []string{PasswordRuleMinLength, PasswordRuleUppercase, PasswordRuleDigit, PasswordRuleSymbol}},
+		// Length is counted in characters for the minimum and bytes for the maximum
+		{"multibyte minimum", "Äb1!Äb1!", nil},
+		{"multibyte maximum", "Ää1!Ää1!Ää1!Ää1!", []string{PasswordRuleMaxLength}},
+	}
+	for _, tt := range tests {
+		err := strict.Validate(context.Background(), tt.password, "alice.smith@example.com", "Alice Smith")
+		got := policyRules(err)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: Validate(%q) rules = %v, want %v", tt.name, tt.password, got, tt.want)
+		}
+		if err != nil && got == nil {
+			t.Errorf("%s: Validate = %v, want a *PasswordPolicyError", tt.name, err)
+		}
+	}
+
+	// Rules that aren't required aren't checked
+	lenient := &PasswordPolicy{MinLength: 8, MaxLength: 72}
+	if err := lenient.Validate(context.Background(), "alicesmith", "alice.smith@example.com", "Alice Smith"); err != nil {
+		t.Errorf("lenient Validate = %v, want nil", err)
+	}
+}
+
+func TestContainsPersonalInfo(t *testing.T) {
+	tests := []struct {
+		password, email, name string
+		want                  bool
+	}{
+		{"hunter2-alice.smith@example.com", "alice.smith@example.com", "", true},
+		{"ALICE.SMITH-rocks", "alice.smith@example.com", "", true}, // the local part, ignoring case
+		{"iloveSMITHS", "", "Alice Smith", true},
+		{"my-alice-pass", "", "Alice", true},
+		{"bo-bo-bo-bo", "", "Bo Li", false}, // parts under three characters are ignored
+		{"example.com!", "alice@example.com", "", false},
+		{"correct horse", "alice@example.com", "Alice Smith", false},
+		{"correct horse", "", "", false},
+	}
+	for _, tt := range tests {
+		if got := containsPersonalInfo(tt.password, tt.email, tt.name); got != tt.want {
+			t.Errorf("containsPersonalInfo(%q, %q, %q) = %v, want %v", tt.password, tt.email, tt.name, got, tt.want)
+		}
+	}
+}
+
+// sha1Hex returns the uppercase SHA-1 of password, as the breach lists write it
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// breachedChecker is a BreachedPasswordChecker answering from a set
+type breachedChecker map[string]bool
+
+func (c breachedChecker) IsBreached(password string) (bool, error) {
+	return c[password], nil
+}
+
+// failingChecker is a BreachedPasswordChecker whose list is unavailable
+type failingChecker struct{}
+
+func (failingChecker) IsBreached(password string) (bool, error) {
+	return false, errors.New("list unavailable")
+}
+
+func TestPasswordPolicyBreachedPasswords(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 8, MaxLength: 72, BreachedChecker: breachedChecker{"password123": true}}
+	if rules := policyRules(policy.Validate(context.Background(), "password123", "", "")); fmt.Sprint(rules) != fmt.Sprint([]string{PasswordRuleBreached}) {
+		t.Errorf("Validate(breached) rules = %v, want breached", rules)
+	}
+	if err := policy.Validate(context.Background(), "correct horse", "", ""); err != nil {
+		t.Errorf("Validate(not breached) = %v", err)
+	}
+
+	// An unavailable list doesn't lock users out
+	policy.BreachedChecker = failingChecker{}
+	ctx := logging.NewContext(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := policy.Validate(ctx, "password123", "", ""); err != nil {
+		t.Errorf("Validate with the list unavailable = %v, want nil", err)
+	}
+}
+
+func TestFileBreachedPasswordCheckerRangeFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Range files are named after the prefix, with or without .txt
+	password := sha1Hex("password")
+	write(password[:5]+".txt", "0018A45C4D1DEF81644B54AB7F969B88D65:1\n"+password[5:]+":3861493\n")
+	crlf := sha1Hex("letmein")
+	write(crlf[:5], "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n"+strings.ToLower(crlf[5:])+":9\r\n")
+	sibling := sha1Hex("qwerty")
+	write(sibling[:5]+".txt", "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1\n")
+
+	checker := NewFileBreachedPasswordChecker(dir)
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		{"letmein", true},        // CRLF line endings and a lowercase hash
+		{"qwerty", false},        // prefix file without the suffix
+		{"correct horse", false}, // no file for the prefix
+	}
+	for _, tt := range tests {
+		got, err := checker.IsBreached(tt.password)
+		if err != nil || got != tt.want {
+			t.Errorf("IsBreached(%q) = %v, %v, want %v", tt.password, got, err, tt.want)
+		}
+	}
+}
+
+func TestFileBreachedPasswordCheckerSortedFile(t *testing.T) {
+	var breached []string
+	for i := 0; i < 200; i++ {
+		breached = append(breached, fmt.Sprintf("breached-%d", i))
+	}
+	hashes := make([]string, len(breached))
+	for i, password := range breached {
+		hashes[i] = sha1Hex(password)
+	}
+	sort.Strings(hashes)
+	byHash := make(map[string]string, len(breached))
+	for _, password := range breached {
+		byHash[sha1Hex(password)] = password
+	}
+
+	for _, newline := range []string{"\n", "\r\n"} {
+		var content strings.Builder
+		for i, hash := range hashes {
+			fmt.Fprintf(&content, "%s:%d%s", hash, i+1, newline)
+		}
+		path := filepath.Join(t.TempDir(), "pwned-passwords-sha1-ordered-by-hash.txt")
+		if err := os.WriteFile(path, []byte(content.String()), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		checker := NewFileBreachedPasswordChecker(path)
+
+		// The first and last lines are the edges of the binary search
+		for _, hash := range []string{hashes[0], hashes[len(hashes)-1], hashes[len(hashes)/2]} {
+			if got, err := checker.IsBreached(byHash[hash]); !got || err != nil {
+				t.Errorf("%q line endings: IsBreached(%s) = %v, %v, want true", newline, hash, got, err)
+			}
+		}
+		for _, password := range breached {
+			if got, err := checker.IsBreached(password); !got || err != nil {
+				t.Errorf("%q line endings: IsBreached(%q) = %v, %v, want true", newline, password, got, err)
+			}
+		}
+		for _, password := range []string{"correct horse", "Breached-1", "breached-200", ""} {
+			if got, err := checker.IsBreached(password); got || err != nil {
+				t.Errorf("%q line endings: IsBreached(%q) = %v, %v, want false", newline, password, got, err)
+			}
+		}
+	}
+
+	// A single line, and no trailing newline
+	path := filepath.Join(t.TempDir(), "one.txt")
+	if err := os.WriteFile(path, []byte(sha1Hex("password")+":1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	checker := NewFileBreachedPasswordChecker(path)
+	if got, err := checker.IsBreached("password"); !got || err != nil {
+		t.Errorf("single line: IsBreached = %v, %v, want true", got, err)
+	}
+	if got, err := checker.IsBreached("letmein"); got || err != nil {
+		t.Errorf("single line: IsBreached(other) = %v, %v, want false", got, err)
+	}
+
+	// A missing list is an error, which Validate only logs
+	if _, err := NewFileBreachedPasswordChecker(filepath.Join(t.TempDir(), "missing.txt")).IsBreached("password"); err == nil {
+		t.Error("IsBreached with a missing list succeeded")
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/repositories"
+	"go-postgres-api/internal/security"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testConfig returns the configuration the services are tested with
+func testConfig() *config.Config {
+	return &config.Config{
+		JWTSecret:                 "test-secret-0123456789abcdefghijklmnopqrstuvwxyz",
+		AccessTokenTTL:            15 * time.Minute,
+		RefreshTokenTTL:           24 * time.Hour,
+		EmailVerificationTokenTTL: 24 * time.Hour,
+		EmailChangeTokenTTL:       time.Hour,
+		Clients: []config.Client{{
+			ID:              config.DefaultClientID,
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 24 * time.Hour,
+			GrantTypes:      []string{config.GrantPassword, config.GrantPasskey, config.GrantRefreshToken},
+			RefreshRotation: config.RotationRotate,
+			TokenDelivery:   config.TokenDeliveryBody,
+		}},
+		WebAuthnRPID:          "localhost",
+		WebAuthnRPDisplayName: "Test",
+		WebAuthnRPOrigins:     []string{"https://localhost"},
+		PasswordMinLength:     8,
+		PasswordMaxLength:     72,
+		PasswordHashAlgorithm: security.AlgorithmArgon2id,
+		Argon2MemoryKB:        1024,
+		Argon2Iterations:      1,
+		Argon2Parallelism:     1,
+		BcryptCost:            4,
+	}
+}
+
+// sentEmail is an email recorded by recordingEmailSender
+type sentEmail struct {
+	Kind  string // verification, email_change, email_change_notice or password_changed
+	To    string
+	Token string
+}
+
+// recordingEmailSender records the emails instead of sending them
+type recordingEmailSender struct {
+	mu   sync.Mutex
+	sent []sentEmail
+}
+
+func (s *recordingEmailSender) record(email sentEmail) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, email)
+	return nil
+}
+
+func (s *recordingEmailSender) SendVerificationEmail(ctx context.Context, toEmail, token string) error {
+	return s.record(sentEmail{Kind: "verification", To: toEmail, Token: token})
+}
+
+func (s *recordingEmailSender) SendEmailChangeConfirmation(ctx context.Context, toEmail, token string) error {
+	return s.record(sentEmail{Kind: "email_change", To: toEmail, Token: token})
+}
+
+func (s *recordingEmailSender) SendEmailChangeNotice(ctx context.Context, toEmail, newEmail, token string) error {
+	return s.record(sentEmail{Kind: "email_change_notice", To: toEmail, Token: token})
+}
+
+func (s *recordingEmailSender) SendPasswordChangedNotice(ctx context.Context, toEmail string) error {
+	return s.record(sentEmail{Kind: "password_changed", To: toEmail})
+}
+
+// last returns the last email of the kind sent to toEmail
+func (s *recordingEmailSender) last(t *testing.T, kind, toEmail string) sentEmail {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.sent) - 1; i >= 0; i-- {
+		if s.sent[i].Kind == kind && s.sent[i].To == toEmail {
+			return s.sent[i]
+		}
+	}
+	t.Fatalf("no %s email sent to %s", kind, toEmail)
+	return sentEmail{}
+}
+
+// newTestAuthService returns an AuthService on store, recording the emails it sends
+func newTestAuthService(t *testing.T, cfg *config.Config, store repositories.Store) (*AuthService, *recordingEmailSender) {
+	t.Helper()
+	hasher, err := NewPasswordHasher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emails := &recordingEmailSender{}
+	return NewAuthService(cfg, store, emails, NewPasswordPolicy(cfg), hasher), emails
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"go-postgres-api/internal/repositories"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// TokenCleanupService periodically deletes expired tokens and WebAuthn ceremonies
+type TokenCleanupService struct {
+	store    repositories.TokenStore
+	interval time.Duration
+
+	lastBeat atomic.Int64 // Unix nanoseconds
+}
+
+// NewTokenCleanupService creates a cleanup worker that runs every interval
+func NewTokenCleanupService(store repositories.TokenStore, interval time.Duration) *TokenCleanupService {
+	return &TokenCleanupService{
+		store:    store,
+		interval: interval,
+	}
+}
+
+// Interval returns how often the worker runs
+func (s *TokenCleanupService) Interval() time.Duration {
+	return s.interval
+}
+
+// Heartbeat returns when the worker last started waiting for its next run;
+// it is zero until Run starts
+func (s *TokenCleanupService) Heartbeat() time.Time {
+	nanos := s.lastBeat.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// Run cleans up on every tick until ctx is done. A cleanup in progress is
+// cancelled with ctx, which is safe as each delete is a single statement.
+func (s *TokenCleanupService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.lastBeat.Store(time.Now().UnixNano())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.store.CleanupExpiredTokens(ctx); err != nil && ctx.Err() == nil {
+				slog.ErrorContext(ctx, "failed to clean up expired tokens", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/repositories"
+	"go-postgres-api/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// webAuthnSessionExpiry is how long a registration or login ceremony may take
+const webAuthnSessionExpiry = 5 * time.Minute
+
+// WebAuthnService handles passkey registration and login ceremonies
+type WebAuthnService struct {
+	webAuthn    *webauthn.WebAuthn
+	userRepo    repositories.Store
+	authService *AuthService
+}
+
+// NewWebAuthnService creates a new WebAuthn service for the configured relying party
+func NewWebAuthnService(cfg *config.Config, userRepo repositories.Store, authService *AuthService) (*WebAuthnService, error) {
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPDisplayName,
+		RPOrigins:     cfg.WebAuthnRPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnSessionExpiry},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnSessionExpiry},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnService{
+		webAuthn:    webAuthn,
+		userRepo:    userRepo,
+		authService: authService,
+	}, nil
+}
+
+// webAuthnUser adapts a user and their stored passkeys to the webauthn.User interface
+type webAuthnUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+// WebAuthnID returns the user handle stored on the authenticator
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatUint(uint64(u.user.ID), 10))
+}
+
+// WebAuthnName returns the account name shown by the authenticator
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+// WebAuthnDisplayName returns the human-friendly name shown by the authenticator
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+// WebAuthnCredentials returns the user's registered passkeys
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// loadWebAuthnUser loads a user together with their stored passkeys
+func (s *WebAuthnService) loadWebAuthnUser(ctx context.Context, userID uint) (*webAuthnUser, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	stored, err := s.userRepo.FindWebAuthnCredentialsByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(stored))
+	for _, credential := range stored {
+		credentials = append(credentials, toWebAuthnCredential(credential))
+	}
+
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+// toWebAuthnCredential converts a stored passkey into the library representation
+func toWebAuthnCredential(credential models.WebAuthnCredential) webauthn.Credential {
+	var transports []protocol.AuthenticatorTransport
+	for _, transport := range strings.Split(credential.Transports, ",") {
+		if transport != "" {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+	}
+
+	return webauthn.Credential{
+		ID:              credential.CredentialID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			UserVerified:   credential.UserVerified,
+			BackupEligible: credential.BackupEligible,
+			BackupState:    credential.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       credential.AAGUID,
+			SignCount:    credential.SignCount,
+			CloneWarning: credential.CloneWarning,
+		},
+	}
+}
+
+// saveSession persists the state of a ceremony and returns its opaque ID
+func (s *WebAuthnService) saveSession(ctx context.Context, userID uint, purpose string, data *webauthn.SessionData) (string, error) {
+	sessionBytes := make([]byte, 32)
+	if _, err := rand.Read(sessionBytes); err != nil {
+		return "", err
+	}
+	sessionID := hex.EncodeToString(sessionBytes)
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	session := &models.WebAuthnSession{
+		SessionID: sessionID,
+		UserID:    userID,
+		Purpose:   purpose,
+		Data:      string(encoded),
+		ExpiresAt: time.Now().Add(webAuthnSessionExpiry),
+	}
+
+	if err := s.userRepo.CreateWebAuthnSession(ctx, session); err != nil {
+		return "", err
+	}
+
+	return sessionID, nil
+}
+
+// consumeSession loads a ceremony's state and removes it so it cannot be replayed
+func (s *WebAuthnService) consumeSession(ctx context.Context, sessionID, purpose string) (*models.WebAuthnSession, *webauthn.SessionData, error) {
+	session, err := s.userRepo.ConsumeWebAuthnSession(ctx, sessionID, purpose)
+	if errors.Is(err, repositories.ErrSessionNotFound) {
+		return nil, nil, ErrInvalidPasskeySession
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		return nil, nil, ErrInvalidPasskeySession
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil {
+		return nil, nil, err
+	}
+
+	return session, &data, nil
+}
+
+// BeginRegistration starts registering a new passkey for an authenticated user
+func (s *WebAuthnService) BeginRegistration(ctx context.Context, userID uint) (resp *models.PasskeyBeginResponse, err error) {
+	ctx, span := tracing.Start(ctx, "WebAuthnService.BeginRegistration", userIDAttr(userID))
+	defer tracing.End(span, &err)
+
+	user, err := s.loadWebAuthnUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Exclude passkeys the user already registered so the same authenticator isn't enrolled twice
+	options, sessionData, err := s.webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionID, err := s.saveSession(ctx, userID, models.WebAuthnPurposeRegistration, sessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PasskeyBeginResponse{
+		SessionID: sessionID,
+		Options:   options,
+	}, nil
+}
+
+// FinishRegistration verifies the attestation response and stores the new passkey
+func (s *WebAuthnService) FinishRegistration(ctx context.Context, userID uint, req *models.PasskeyFinishRequest, ipAddress, userAgent string) (stored *models.WebAuthnCredential, err error) {
+	ctx, span := tracing.Start(ctx, "WebAuthnService.FinishRegistration", userIDAttr(userID))
+	defer tracing.End(span, &err)
+
+	authLog := &models.AuthLog{
+		UserID:    userID,
+		Action:    "passkey_register",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   false,
+	}
+
+	session, sessionData, err := s.consumeSession(ctx, req.SessionID, models.WebAuthnPurposeRegistration)
+	if err != nil {
+		authLog.ErrorMessage = "invalid session"
+		s.authService.logAuth(ctx, authLog)
+		return nil, err
+	}
+
+	if session.UserID != userID {
+		authLog.ErrorMessage = "session user mismatch"
+		s.authService.logAuth(ctx, authLog)
+		return nil, ErrInvalidPasskeySession
+	}
+
+	user, err := s.loadWebAuthnUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		authLog.ErrorMessage = "malformed attestation"
+		s.authService.logAuth(ctx, authLog)
+		return nil, ErrInvalidPasskeyResponse.WithMessage("invalid passkey registration response")
+	}
+
+	credential, err := s.webAuthn.CreateCredential(user, *sessionData, parsed)
+	if err != nil {
+		authLog.ErrorMessage = "attestation verification failed"
+		s.authService.logAuth(ctx, authLog)
+		return nil, ErrPasskeyNotVerified
+	}
+
+	// A credential ID is bound to a single account
+	existing, err := s.userRepo.FindWebAuthnCredentialByCredentialID(ctx, credential.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		authLog.ErrorMessage = "credential already registered"
+		s.authService.logAuth(ctx, authLog)
+		return nil, ErrPasskeyAlreadyRegistered
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	stored = &models.WebAuthnCredential{
+		UserID:          userID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      strings.Join(transports, ","),
+		UserVerified:    credential.Flags.UserVerified,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+
+	if err := s.userRepo.CreateWebAuthnCredential(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	authLog.Success = true
+	s.authService.logAuth(ctx, authLog)
+
+	return stored, nil
+}
+
+// BeginLogin starts a passkey login. Without an email, or when the email has no
+// passkeys, a discoverable login is started so account existence isn't revealed.
+func (s *WebAuthnService) BeginLogin(ctx context.Context, email string) (resp *models.PasskeyBeginResponse, err error) {
+	ctx, span := tracing.Start(ctx, "WebAuthnService.BeginLogin")
+	defer tracing.End(span, &err)
+
+	var (
+		options     *protocol.CredentialAssertion
+		sessionData *webauthn.SessionData
+		userID      uint
+	)
+
+	if email != "" {
+		user, err := s.userRepo.FindByEmail(ctx, email)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			webUser, err := s.loadWebAuthnUser(ctx, user.ID)
+			if err != nil {
+				return nil, err
+			}
+			if len(webUser.credentials) > 0 {
+				options, sessionData, err = s.webAuthn.BeginLogin(webUser)
+				if err != nil {
+					return nil, err
+				}
+				userID = user.ID
+			}
+		}
+	}
+
+	if options == nil {
+		var err error
+		options, sessionData, err = s.webAuthn.BeginDiscoverableLogin()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sessionID, err := s.saveSession(ctx, userID, models.WebAuthnPurposeLogin, sessionData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PasskeyBeginResponse{
+		SessionID: sessionID,
+		Options:   options,
+	}, nil
+}
+
+// FinishLogin verifies the assertion response and issues the same tokens as a password login
+func (s *WebAuthnService) FinishLogin(ctx context.Context, req *models.PasskeyFinishRequest, ipAddress, userAgent string) (resp *models.AuthResponse, err error) {
+	ctx, span := tracing.Start(ctx, "WebAuthnService.FinishLogin", clientIDAttr(req.ClientID))
+	defer tracing.End(span, &err)
+
+	// Check the client before consuming the ceremony, so a bad client ID can be retried
+	client, err := s.authService.clientFor(req.ClientID, config.GrantPasskey)
+	if err != nil {
+		return nil, err
+	}
+
+	authLog := &models.AuthLog{
+		Action:    "passkey_login",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Success:   false,
+	}
+
+	session, sessionData, err := s.consumeSession(ctx, req.SessionID, models.WebAuthnPurposeLogin)
+	if err != nil {
+		authLog.ErrorMessage = "invalid session"
+		s.authService.logAuth(ctx, authLog)
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		authLog.ErrorMessage = "malformed assertion"
+		s.authService.logAuth(ctx, authLog)
+		return nil, ErrInvalidPasskeyResponse.WithMessage("invalid passkey login response")
+	}
+
+	var (
+		user       *webAuthnUser
+		credential *webauthn.Credential
+	)
+
+	if session.UserID != 0 {
+		user, err = s.loadWebAuthnUser(ctx, session.UserID)
+		if err != nil {
+			authLog.ErrorMessage = "user not found"
+			s.authService.logAuth(ctx, authLog)
+			return nil, ErrInvalidPasskey
+		}
+		credential, err = s.webAuthn.ValidateLogin(user, *sessionData, parsed)
+	} else {
+		// Discoverable login: the authenticator tells us who the user is via the user handle
+		credential, err = s.webAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			id, parseErr := strconv.ParseUint(string(userHandle), 10, 64)
+			if parseErr != nil {
+				return nil, errors.New("invalid user handle")
+			}
+			user, parseErr = s.loadWebAuthnUser(ctx, uint(id))
+			if parseErr != nil {
+				return nil, parseErr
+			}
+			return user, nil
+		}, *sessionData, parsed)
+	}
+
+	if user != nil {
+		authLog.UserID = user.user.ID
+	}
+
+	if err != nil {
+		authLog.ErrorMessage = "assertion verification failed"
+		s.authService.logAuth(ctx, authLog)
+		return nil, ErrInvalidPasskey
+	}
+
+	stored, err := s.userRepo.FindWebAuthnCredentialByCredentialID(ctx, credential.ID)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || stored.UserID != user.user.ID {
+		authLog.ErrorMessage = "credential not found"
+		s.authService.logAuth(ctx, authLog)
+		return nil, ErrInvalidPasskey
+	}
+
+	// A counter that didn't increase means the authenticator may have been cloned.
+	// Flag the credential and refuse it until the user registers a new passkey.
+	if credential.Authenticator.CloneWarning || stored.CloneWarning {
+		authLog.ErrorMessage = "sign count regression"
+		s.authService.logAuth(ctx, authLog)
+		if err := s.userRepo.UpdateWebAuthnCredentialUsage(ctx, stored.ID, stored.SignCount, true); err != nil {
+			return nil, err
+		}
+		return nil, ErrPasskeyDisabled
+	}
+
+	if err := s.userRepo.UpdateWebAuthnCredentialUsage(ctx, stored.ID, credential.Authenticator.SignCount, false); err != nil {
+		return nil, err
+	}
+
+	// Check if email is verified
+	if !user.user.IsVerified {
+		authLog.ErrorMessage = "email not verified"
+		s.authService.logAuth(ctx, authLog)
+		return nil, ErrEmailNotVerified
+	}
+
+	return s.authService.issueTokens(ctx, user.user, client, authLog)
+}
+
+// ListCredentials returns the passkeys registered by a user
+func (s *WebAuthnService) ListCredentials(ctx context.Context, userID uint) (credentials []models.WebAuthnCredential, err error) {
+	ctx, span := tracing.Start(ctx, "WebAuthnService.ListCredentials", userIDAttr(userID))
+	defer tracing.End(span, &err)
+
+	return s.userRepo.FindWebAuthnCredentialsByUserID(ctx, userID)
+}
+
+// DeleteCredential removes one of the user's passkeys
+func (s *WebAuthnService) DeleteCredential(ctx context.Context, userID, credentialID uint) (err error) {
+	ctx, span := tracing.Start(ctx, "WebAuthnService.DeleteCredential", userIDAttr(userID))
+	defer tracing.End(span, &err)
+
+	err = s.userRepo.DeleteWebAuthnCredential(ctx, userID, credentialID)
+	if errors.Is(err, repositories.ErrPasskeyNotFound) {
+		return ErrPasskeyNotFound
+	}
+	return err
+}
@@ -0,0 +1,427 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"go-postgres-api/internal/models"
+	"go-postgres-api/internal/repositories"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// testOrigin is the origin the software authenticator reports, one of testConfig's RP origins
+const testOrigin = "https://localhost"
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// softAuthenticator is a passkey authenticator in software holding a single
+// ECDSA P-256 credential, with a sign count the test controls
+type softAuthenticator struct {
+	t            *testing.T
+	rpID         string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	SignCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T, rpID string) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{t: t, rpID: rpID, key: key, credentialID: credentialID}
+}
+
+// authenticatorData builds authenticator data with the given flags and the current sign count
+func (a *softAuthenticator) authenticatorData(flags byte, attestedData []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.SignCount)
+	return append(data, attestedData...)
+}
+
+// clientData builds the client data JSON of a ceremony
+func (a *softAuthenticator) clientData(ceremony protocol.CeremonyType, challenge []byte) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      string(ceremony),
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return data
+}
+
+// create answers the options of a registration with a "none" attestation of the credential
+func (a *softAuthenticator) create(options any) json.RawMessage {
+	a.t.Helper()
+	creation, ok := options.(*protocol.CredentialCreation)
+	if !ok {
+		a.t.Fatalf("registration options are %T", options)
+	}
+	a.userHandle = creation.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(flagUserPresent|flagUserVerified|flagAttestedData, attested),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.credential(map[string]any{
+		"clientDataJSON":    encode(a.clientData(protocol.CreateCeremony, creation.Response.Challenge)),
+		"attestationObject": encode(attestation),
+		"transports":        []string{"internal"},
+	})
+}
+
+// get answers the options of a login with an assertion signed by the credential
+func (a *softAuthenticator) get(options any) json.RawMessage {
+	a.t.Helper()
+	assertion, ok := options.(*protocol.CredentialAssertion)
+	if !ok {
+		a.t.Fatalf("login options are %T", options)
+	}
+
+	authData := a.authenticatorData(flagUserPresent|flagUserVerified, nil)
+	clientData := a.clientData(protocol.AssertCeremony, assertion.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return a.credential(map[string]any{
+		"clientDataJSON":    encode(clientData),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+// credential wraps an authenticator response in a PublicKeyCredential
+func (a *softAuthenticator) credential(response map[string]any) json.RawMessage {
+	body, err := json.Marshal(map[string]any{
+		"id":       encode(a.credentialID),
+		"rawId":    encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return body
+}
+
+// encode encodes bytes as WebAuthn does in JSON
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// failingUsageStore fails to record the use of passkeys
+type failingUsageStore struct {
+	*repositories.MemoryStore
+}
+
+var errUsageNotRecorded = errors.New("usage not recorded")
+
+func (s failingUsageStore) UpdateWebAuthnCredentialUsage(ctx context.Context, credentialID uint, signCount uint32, cloneWarning bool) error {
+	return errUsageNotRecorded
+}
+
+// webAuthnFixture is a WebAuthn service on a memory store with a verified user
+type webAuthnFixture struct {
+	service *WebAuthnService
+	store   *repositories.MemoryStore
+	user    *models.User
+}
+
+func newWebAuthnFixture(t *testing.T, wrap func(*repositories.MemoryStore) repositories.Store) *webAuthnFixture {
+	t.Helper()
+	cfg := testConfig()
+	memory := repositories.NewMemoryStore()
+	var store repositories.Store = memory
+	if wrap != nil {
+		store = wrap(memory)
+	}
+
+	authService, _ := newTestAuthService(t, cfg, store)
+	service, err := NewWebAuthnService(cfg, store, authService)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &models.User{Email: "alice@example.com", Name: "Alice", Password: "hash", IsVerified: true, IsActive: true}
+	if err := memory.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return &webAuthnFixture{service: service, store: memory, user: user}
+}
+
+// register registers a new software authenticator for the fixture's user
+func (f *webAuthnFixture) register(t *testing.T) (*softAuthenticator, *models.WebAuthnCredential) {
+	t.Helper()
+	ctx := context.Background()
+	authenticator := newSoftAuthenticator(t, "localhost")
+	authenticator.SignCount = 1
+
+	begin, err := f.service.BeginRegistration(ctx, f.user.ID)
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	credential, err := f.service.FinishRegistration(ctx, f.user.ID, &models.PasskeyFinishRequest{
+		SessionID:  begin.SessionID,
+		Credential: authenticator.create(begin.Options),
+	}, "127.0.0.1", "test")
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	return authenticator, credential
+}
+
+// login runs a passkey login with authenticator, for email or discoverable when empty
+func (f *webAuthnFixture) login(t *testing.T, authenticator *softAuthenticator, email string) (*models.AuthResponse, error) {
+	t.Helper()
+	ctx := context.Background()
+	begin, err := f.service.BeginLogin(ctx, email)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	return f.service.FinishLogin(ctx, &models.PasskeyFinishRequest{
+		SessionID:  begin.SessionID,
+		Credential: authenticator.get(begin.Options),
+	}, "127.0.0.1", "test")
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	f := newWebAuthnFixture(t, nil)
+	authenticator, credential := f.register(t)
+
+	if credential.UserID != f.user.ID || string(credential.CredentialID) != string(authenticator.credentialID) {
+		t.Errorf("stored credential %+v, want the authenticator's credential for user %d", credential, f.user.ID)
+	}
+	if credential.SignCount != 1 || credential.Transports != "internal" || !credential.UserVerified {
+		t.Errorf("stored credential %+v, want sign count 1, internal transport and user verified", credential)
+	}
+
+	credentials, err := f.service.ListCredentials(context.Background(), f.user.ID)
+	if err != nil || len(credentials) != 1 {
+		t.Fatalf("ListCredentials = %d credentials, %v, want 1", len(credentials), err)
+	}
+
+	// The session is consumed by the first attempt
+	begin, err := f.service.BeginRegistration(context.Background(), f.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := newSoftAuthenticator(t, "localhost")
+	req := &models.PasskeyFinishRequest{SessionID: begin.SessionID, Credential: second.create(begin.Options)}
+	if _, err := f.service.FinishRegistration(context.Background(), f.user.ID, req, "", ""); err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if _, err := f.service.FinishRegistration(context.Background(), f.user.ID, req, "", ""); !errors.Is(err, ErrInvalidPasskeySession) {
+		t.Errorf("FinishRegistration(replayed) = %v, want ErrInvalidPasskeySession", err)
+	}
+}
+
+func TestWebAuthnRegistrationRejectsOtherRelyingParty(t *testing.T) {
+	f := newWebAuthnFixture(t, nil)
+	authenticator := newSoftAuthenticator(t, "evil.example")
+
+	begin, err := f.service.BeginRegistration(context.Background(), f.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.service.FinishRegistration(context.Background(), f.user.ID, &models.PasskeyFinishRequest{
+		SessionID:  begin.SessionID,
+		Credential: authenticator.create(begin.Options),
+	}, "", "")
+	if !errors.Is(err, ErrPasskeyNotVerified) {
+		t.Errorf("FinishRegistration(other RP ID) = %v, want ErrPasskeyNotVerified", err)
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	f := newWebAuthnFixture(t, nil)
+	authenticator, credential := f.register(t)
+
+	authenticator.SignCount = 5
+	resp, err := f.login(t, authenticator, " Alice@Example.com")
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if resp.AccessToken == "" || resp.RefreshToken == "" || resp.User.ID != f.user.ID {
+		t.Errorf("FinishLogin = %+v, want tokens for user %d", resp, f.user.ID)
+	}
+
+	stored, err := f.store.FindWebAuthnCredentialByCredentialID(context.Background(), credential.CredentialID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SignCount != 5 || stored.LastUsedAt == nil || stored.CloneWarning {
+		t.Errorf("credential after login %+v, want sign count 5, last used set and no clone warning", stored)
+	}
+}
+
+func TestWebAuthnDiscoverableLogin(t *testing.T) {
+	f := newWebAuthnFixture(t, nil)
+	authenticator, _ := f.register(t)
+
+	authenticator.SignCount = 2
+	resp, err := f.login(t, authenticator, "")
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if resp.User.ID != f.user.ID {
+		t.Errorf("discoverable login signed in user %d, want %d", resp.User.ID, f.user.ID)
+	}
+
+	// An unknown email starts a discoverable login too, so it still works
+	authenticator.SignCount = 3
+	if _, err := f.login(t, authenticator, "nobody@example.com"); err != nil {
+		t.Errorf("FinishLogin(unknown email) = %v, want a discoverable login", err)
+	}
+}
+
+func TestWebAuthnLoginUnverifiedEmail(t *testing.T) {
+	f := newWebAuthnFixture(t, nil)
+	authenticator, _ := f.register(t)
+	if err := f.store.UpdateUserVerification(context.Background(), f.user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator.SignCount = 2
+	if _, err := f.login(t, authenticator, ""); !errors.Is(err, ErrEmailNotVerified) {
+		t.Errorf("FinishLogin(unverified) = %v, want ErrEmailNotVerified", err)
+	}
+}
+
+func TestWebAuthnSignCountRegression(t *testing.T) {
+	f := newWebAuthnFixture(t, nil)
+	authenticator, credential := f.register(t)
+
+	authenticator.SignCount = 10
+	if _, err := f.login(t, authenticator, ""); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+
+	// A clone replaying an older counter is refused and the passkey disabled
+	authenticator.SignCount = 7
+	if _, err := f.login(t, authenticator, ""); !errors.Is(err, ErrPasskeyDisabled) {
+		t.Fatalf("FinishLogin(regressed count) = %v, want ErrPasskeyDisabled", err)
+	}
+	stored, err := f.store.FindWebAuthnCredentialByCredentialID(context.Background(), credential.CredentialID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.CloneWarning || stored.SignCount != 10 {
+		t.Errorf("credential after regression %+v, want clone warning and sign count 10", stored)
+	}
+
+	// It stays disabled even once the counter moves on
+	authenticator.SignCount = 20
+	if _, err := f.login(t, authenticator, "alice@example.com"); !errors.Is(err, ErrPasskeyDisabled) {
+		t.Errorf("FinishLogin(flagged passkey) = %v, want ErrPasskeyDisabled", err)
+	}
+}
+
+func TestWebAuthnSignCountRegressionNotRecorded(t *testing.T) {
+	f := newWebAuthnFixture(t, func(store *repositories.MemoryStore) repositories.Store {
+		return failingUsageStore{store}
+	})
+	authenticator, _ := f.register(t)
+
+	// Flagging the passkey fails, which must not pass as a mere disabled passkey
+	authenticator.SignCount = 0
+	if _, err := f.login(t, authenticator, ""); !errors.Is(err, errUsageNotRecorded) {
+		t.Errorf("FinishLogin = %v, want the store's error", err)
+	}
+}
+
+func TestWebAuthnSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	f := newWebAuthnFixture(t, nil)
+	authenticator, _ := f.register(t)
+	authenticator.SignCount = 2
+	if _, err := f.login(t, authenticator, "alice@example.com"); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	_, err := f.service.FinishLogin(context.Background(), &models.PasskeyFinishRequest{SessionID: "unknown"}, "", "")
+	if !errors.Is(err, ErrInvalidPasskeySession) {
+		t.Fatalf("FinishLogin(unknown session) = %v, want ErrInvalidPasskeySession", err)
+	}
+
+	type span struct {
+		name   string
+		failed bool
+	}
+	var got []span
+	for _, s := range recorder.Ended() {
+		if strings.HasPrefix(s.Name(), "WebAuthnService.") {
+			got = append(got, span{s.Name(), s.Status().Code == codes.Error})
+		}
+	}
+	want := []span{
+		{"WebAuthnService.BeginRegistration", false},
+		{"WebAuthnService.FinishRegistration", false},
+		{"WebAuthnService.BeginLogin", false},
+		{"WebAuthnService.FinishLogin", false},
+		{"WebAuthnService.FinishLogin", true},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("spans = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,141 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-postgres-api/internal/config"
+	"go-postgres-api/internal/logging"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// tracer creates the spans of the application's own code. It is taken from
+// the global provider, so it records once Setup has installed one.
+var tracer = otel.Tracer("go-postgres-api")
+
+// Setup installs the W3C trace context propagator and, unless the exporter is
+// none, a tracer provider exporting spans as configured. The returned function
+// flushes pending spans and stops the provider.
+func Setup(ctx context.Context, cfg *config.Config) (shutdown func(context.Context) error, err error) {
+	// Propagate incoming trace context even without an exporter, so logs
+	// and responses carry the caller's trace ID
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	noop := func(context.Context) error { return nil }
+	if cfg.TracingExporter == ExporterNone {
+		return noop, nil
+	}
+
+	exporter, closeExporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(), // OTEL_RESOURCE_ATTRIBUTES
+		resource.WithAttributes(semconv.ServiceName(cfg.TracingServiceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		return errors.Join(provider.Shutdown(ctx), closeExporter())
+	}, nil
+}
+
+// newExporter creates the configured span exporter and a function releasing
+// what it holds beyond the exporter itself
+func newExporter(ctx context.Context, cfg *config.Config) (sdktrace.SpanExporter, func() error, error) {
+	noop := func() error { return nil }
+
+	switch cfg.TracingExporter {
+	case ExporterOTLP:
+		var options []otlptracehttp.Option
+		if cfg.TracingOTLPEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(cfg.TracingOTLPEndpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, options...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		return exporter, noop, nil
+
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		return exporter, noop, err
+
+	case ExporterFile:
+		file, err := os.OpenFile(cfg.TracingFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return exporter, file.Close, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown tracing exporter %q", cfg.TracingExporter)
+}
+
+// Start starts a span as a child of the one in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartClient starts a span for a call to another service, such as the
+// database or the SMTP server
+func StartClient(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed when *errp is an error. It takes a pointer
+// so it can be deferred with a named result:
+//
+//	ctx, span := tracing.Start(ctx, "AuthService.Login")
+//	defer tracing.End(span, &err)
+func End(span trace.Span, errp *error) {
+	if errp != nil && *errp != nil {
+		// Errors may quote the email address they are about
+		span.SetStatus(codes.Error, logging.MaskEmails((*errp).Error()))
+	}
+	span.End()
+}
+
+// TraceID returns the ID of the trace in ctx, or "" when there is none
+func TraceID(ctx context.Context) string {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
@@ -0,0 +1,163 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-postgres-api/internal/models"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Custom validation tags of the request DTOs
+const (
+	TagEmail      = "email_address" // an email address, checked once normalized
+	TagPersonName = "person_name"   // a first or last name
+)
+
+// MaxEmailLength is the longest email address that can be delivered to (RFC 5321)
+const MaxEmailLength = 254
+
+// MaxNameLength is the longest first or last name accepted, in characters
+const MaxNameLength = 100
+
+// Register makes v name fields by their JSON names and adds the custom validators
+func Register(v *validator.Validate) error {
+	v.RegisterTagNameFunc(jsonFieldName)
+
+	err := v.RegisterValidation(TagEmail, func(fl validator.FieldLevel) bool {
+		email := NormalizeEmail(fl.Field().String())
+		return len(email) <= MaxEmailLength && v.Var(email, "email") == nil
+	})
+	if err != nil {
+		return err
+	}
+	return v.RegisterValidation(TagPersonName, func(fl validator.FieldLevel) bool {
+		name := strings.TrimSpace(fl.Field().String())
+		length := utf8.RuneCountInString(name)
+		return length > 0 && length <= MaxNameLength && validNameCharacters(name)
+	})
+}
+
+// NormalizeEmail returns the form email addresses are stored and looked up in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// FieldErrors converts the failed checks of a request into field errors,
+// naming each field by its JSON path such as "email" or "items[0].name"
+func FieldErrors(errs validator.ValidationErrors) []models.FieldError {
+	fieldErrors := make([]models.FieldError, 0, len(errs))
+	for _, fe := range errs {
+		// The namespace starts with the name of the request type
+		_, path, _ := strings.Cut(fe.Namespace(), ".")
+		code, message := translate(fe)
+		fieldErrors = append(fieldErrors, models.FieldError{Field: path, Code: code, Message: message})
+	}
+	return fieldErrors
+}
+
+// TypeError converts a JSON value of the wrong type into a field error
+func TypeError(err *json.UnmarshalTypeError) models.FieldError {
+	return models.FieldError{Field: err.Field, Code: "invalid_type", Message: "must be " + jsonType(err.Type)}
+}
+
+// translate returns the code and message of a failed check
+func translate(fe validator.FieldError) (code, message string) {
+	switch fe.Tag() {
+	case "required":
+		return "required", "is required"
+	case "email", TagEmail:
+		if len(NormalizeEmail(fmt.Sprint(fe.Value()))) > MaxEmailLength {
+			return "too_long", fmt.Sprintf("must be at most %d characters", MaxEmailLength)
+		}
+		return "invalid_email", "must be a valid email address"
+	case TagPersonName:
+		name := strings.TrimSpace(fmt.Sprint(fe.Value()))
+		switch {
+		case name == "":
+			return "required", "is required"
+		case utf8.RuneCountInString(name) > MaxNameLength:
+			return "too_long", fmt.Sprintf("must be at most %d characters", MaxNameLength)
+		}
+		return "invalid_characters", "may only contain letters, spaces, hyphens, apostrophes and periods"
+	case "min", "max", "len":
+		return lengthError(fe)
+	case "oneof":
+		return "not_allowed", "must be one of " + strings.Join(strings.Fields(fe.Param()), ", ")
+	}
+	return "invalid", "is invalid"
+}
+
+// lengthError translates a failed min, max or len check, which bounds the
+// length of strings and lists and the value of numbers
+func lengthError(fe validator.FieldError) (code, message string) {
+	unit := ""
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = " items"
+	}
+
+	switch fe.Tag() {
+	case "min":
+		if unit == "" {
+			return "too_small", "must be at least " + fe.Param()
+		}
+		return "too_short", "must have at least " + fe.Param() + unit
+	case "max":
+		if unit == "" {
+			return "too_large", "must be at most " + fe.Param()
+		}
+		return "too_long", "must have at most " + fe.Param() + unit
+	}
+	return "invalid_length", "must have exactly " + fe.Param() + unit
+}
+
+// validNameCharacters reports whether a name only has letters, combining
+// marks, spaces, hyphens, apostrophes and periods
+func validNameCharacters(name string) bool {
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r), unicode.Is(unicode.M, r):
+		case r == ' ', r == '-', r == '\'', r == '’', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// jsonType describes a Go type by its JSON type
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// jsonFieldName returns the name of a struct field in JSON
+func jsonFieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	}
+	return name
+}
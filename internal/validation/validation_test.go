@@ -0,0 +1,214 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"go-postgres-api/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+)
+
+type testItem struct {
+	Name string `json:"name" validate:"required"`
+}
+
+type testRequest struct {
+	Email    string     `json:"email" validate:"required,email_address"`
+	Name     string     `json:"first_name" validate:"person_name"`
+	Password string     `json:"password" validate:"min=8,max=72"`
+	Age      int        `json:"age" validate:"min=18"`
+	Role     string     `json:"role" validate:"omitempty,oneof=admin user"`
+	Items    []testItem `json:"items" validate:"max=2,dive"`
+	Internal string     `json:"-" validate:"omitempty,len=2"`
+}
+
+// newValidator returns a validator set up with Register
+func newValidator(t *testing.T) *validator.Validate {
+	t.Helper()
+	v := validator.New()
+	if err := Register(v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// validRequest returns a request that passes every check
+func validRequest() testRequest {
+	return testRequest{Email: "alice@example.com", Name: "Alice", Password: "correct horse", Age: 30}
+}
+
+// fieldErrors validates req and returns its field errors
+func fieldErrors(t *testing.T, v *validator.Validate, req testRequest) []models.FieldError {
+	t.Helper()
+	err := v.Struct(req)
+	if err == nil {
+		return nil
+	}
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Struct() = %v, want validation errors", err)
+	}
+	return FieldErrors(errs)
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := map[string]string{
+		"alice@example.com":         "alice@example.com",
+		"  Alice@Example.COM\t":     "alice@example.com",
+		"\nBOB.SMITH@EXAMPLE.org  ": "bob.smith@example.org",
+		"":                          "",
+	}
+	for in, want := range tests {
+		if got := NormalizeEmail(in); got != want {
+			t.Errorf("NormalizeEmail(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEmailAddressValidator(t *testing.T) {
+	v := newValidator(t)
+	longLocal := strings.Repeat("a", 64)
+	longDomain := strings.Repeat(strings.Repeat("b", 60)+".", 3) + "example.com"
+
+	tests := []struct {
+		email string
+		want  string // code of the field error, empty when valid
+	}{
+		{"alice@example.com", ""},
+		{"  Alice@Example.COM ", ""},
+		{"alice+tag@sub.example.co.uk", ""},
+		{"", "required"},
+		{"alice", "invalid_email"},
+		{"alice@", "invalid_email"},
+		{"@example.com", "invalid_email"},
+		{"alice@@example.com", "invalid_email"},
+		{"alice example@example.com", "invalid_email"},
+		{longLocal + "@" + longDomain + strings.Repeat("c", 70), "too_long"},
+	}
+	for _, tt := range tests {
+		req := validRequest()
+		req.Email = tt.email
+		errs := fieldErrors(t, v, req)
+
+		if tt.want == "" {
+			if len(errs) != 0 {
+				t.Errorf("email %q: errors %+v, want none", tt.email, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].Field != "email" || errs[0].Code != tt.want {
+			t.Errorf("email %q: errors %+v, want one %s error on email", tt.email, errs, tt.want)
+		}
+	}
+}
+
+func TestPersonNameValidator(t *testing.T) {
+	v := newValidator(t)
+
+	tests := []struct {
+		name string
+		want string // code of the field error, empty when valid
+	}{
+		{"Alice", ""},
+		{"Mary-Jane", ""},
+		{"O'Brien", ""},
+		{"O’Brien", ""},
+		{"Jr.", ""},
+		{"José", ""},
+		{"Zoë", ""},
+		{"Nguyễn Văn", ""},
+		{"李", ""},
+		{"Åsa", ""},
+		{"  Alice  ", ""},
+		{strings.Repeat("é", MaxNameLength), ""},
+		{"", "required"},
+		{"   ", "required"},
+		{strings.Repeat("a", MaxNameLength+1), "too_long"},
+		{"Alice1", "invalid_characters"},
+		{"<script>", "invalid_characters"},
+		{"Alice_Smith", "invalid_characters"},
+		{"Alice@", "invalid_characters"},
+	}
+	for _, tt := range tests {
+		req := validRequest()
+		req.Name = tt.name
+		errs := fieldErrors(t, v, req)
+
+		if tt.want == "" {
+			if len(errs) != 0 {
+				t.Errorf("name %q: errors %+v, want none", tt.name, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].Field != "first_name" || errs[0].Code != tt.want {
+			t.Errorf("name %q: errors %+v, want one %s error on first_name", tt.name, errs, tt.want)
+		}
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	v := newValidator(t)
+
+	req := testRequest{
+		Email:    "not-an-email",
+		Name:     "Alice",
+		Password: "short",
+		Age:      12,
+		Role:     "owner",
+		Items:    []testItem{{Name: "a"}, {}},
+		Internal: "abc",
+	}
+	got := fieldErrors(t, v, req)
+	want := []models.FieldError{
+		{Field: "email", Code: "invalid_email", Message: "must be a valid email address"},
+		{Field: "password", Code: "too_short", Message: "must have at least 8 characters"},
+		{Field: "age", Code: "too_small", Message: "must be at least 18"},
+		{Field: "role", Code: "not_allowed", Message: "must be one of admin, user"},
+		{Field: "items[1].name", Code: "required", Message: "is required"},
+		{Field: "Internal", Code: "invalid_length", Message: "must have exactly 2 characters"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldErrors() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	req = validRequest()
+	req.Password = strings.Repeat("x", 73)
+	req.Items = []testItem{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	got = fieldErrors(t, v, req)
+	want = []models.FieldError{
+		{Field: "password", Code: "too_long", Message: "must have at most 72 characters"},
+		{Field: "items", Code: "too_long", Message: "must have at most 2 items"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldErrors(too long) = %+v, want %+v", got, want)
+	}
+
+	if errs := fieldErrors(t, v, validRequest()); len(errs) != 0 {
+		t.Errorf("FieldErrors(valid) = %+v, want none", errs)
+	}
+}
+
+func TestTypeError(t *testing.T) {
+	tests := []struct {
+		body string
+		want models.FieldError
+	}{
+		{`{"email": 42}`, models.FieldError{Field: "email", Code: "invalid_type", Message: "must be a string"}},
+		{`{"age": "old"}`, models.FieldError{Field: "age", Code: "invalid_type", Message: "must be an integer"}},
+		{`{"items": {}}`, models.FieldError{Field: "items", Code: "invalid_type", Message: "must be an array"}},
+	}
+	for _, tt := range tests {
+		var req testRequest
+		err := json.Unmarshal([]byte(tt.body), &req)
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			t.Fatalf("Unmarshal(%s) = %v, want a type error", tt.body, err)
+		}
+		if got := TypeError(typeErr); got != tt.want {
+			t.Errorf("TypeError(%s) = %+v, want %+v", tt.body, got, tt.want)
+		}
+	}
+}
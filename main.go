@@ -1,86 +1,335 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"go-postgres-api/internal/app"
 	"go-postgres-api/internal/config"
 	"go-postgres-api/internal/database"
+	"go-postgres-api/internal/logging"
+	"go-postgres-api/internal/metrics"
 	"go-postgres-api/internal/middleware"
-	"go-postgres-api/internal/models"
 	"go-postgres-api/internal/routes"
+	"go-postgres-api/internal/security"
+	"go-postgres-api/internal/server"
+	"go-postgres-api/internal/tracing"
+	"go-postgres-api/internal/validation"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Subcommands that don't start the server
+	if len(os.Args) > 1 && os.Args[1] == "benchmark-hash" {
+		runHashBenchmark(os.Args[2:])
+		return
+	}
+
+	// Log JSON to stderr until the configuration sets the level and format
+	logger, _ := logging.New(os.Stderr, logging.FormatJSON, "info")
+	slog.SetDefault(logger)
+
 	// Load environment variables with a relative path
 	if err := godotenv.Load(".env"); err != nil {
-		log.Printf("Error loading .env file: %v", err)
-		log.Println("Continuing with environment variables from the system...")
+		slog.Info("no .env file loaded, using the system environment", "error", err)
+	}
+
+	// Print the configuration instead of starting the server
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfig(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fatal("config failed", err)
+		}
+		return
+	}
+
+	// Flags configure the server; the migrate command only uses the config
+	// file and the environment
+	var configArgs []string
+	if len(os.Args) > 1 && os.Args[1] != "migrate" {
+		configArgs = os.Args[1:]
 	}
 
 	// Initialize configuration
-	cfg, err := config.LoadConfig()
+	cfg, err := config.LoadConfig(configArgs)
+	if errors.Is(err, flag.ErrHelp) {
+		return // Usage has been printed
+	}
+	if err != nil {
+		fatal("failed to load configuration", err)
+	}
+
+	logger, err = logging.New(os.Stderr, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fatal("failed to configure logging", err)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("failed to configure tracing", err)
 	}
 
 	// Connect to database
-	db, err := database.Connect()
+	db, err := database.Connect(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
-	}
-
-	// Check if migrations have already been applied
-	var migrationCount int64
-	db.Raw("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = 'public' AND table_name = 'users'").Count(&migrationCount)
-
-	// Only run migrations if the users table doesn't exist
-	if migrationCount == 0 {
-		log.Println("Running database migrations...")
-		// Auto migrate models
-		err = db.AutoMigrate(
-			&models.User{},
-			&models.Role{},
-			&models.AuthLog{},
-			&models.TokenBlacklist{},
-			&models.EmailVerificationToken{},
-			&models.RefreshToken{},
-		)
-		if err != nil {
-			log.Fatalf("Failed to migrate database: %v", err)
-		}
-		log.Println("Database migrations completed successfully")
-	} else {
-		log.Println("Skipping migrations as database schema already exists")
+		fatal("failed to connect to database", err)
 	}
 
 	// Get the underlying SQL DB to set up connection pool parameters
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatalf("Failed to get SQL DB: %v", err)
+		fatal("failed to get SQL DB", err)
+	}
+	defer sqlDB.Close()
+
+	migrator, err := database.NewMigrator(sqlDB, cfg.DBDriver)
+	if err != nil {
+		fatal("failed to load migrations", err)
+	}
+
+	// Run the migrate command instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(migrator, os.Args[2:]); err != nil {
+			fatal("migration failed", err)
+		}
+		return
+	}
+
+	// Apply pending migrations; replicas starting together wait for each other
+	if cfg.AutoMigrate {
+		applied, err := migrator.Up(context.Background(), 0)
+		if err != nil {
+			fatal("failed to migrate database", err)
+		}
+		for _, migration := range applied {
+			slog.Info("applied migration", "version", migration.Version, "name", migration.Name)
+		}
+		slog.Info("database schema is up to date", "version", migrator.LatestVersion())
 	}
 
 	// Set connection pool parameters
 	sqlDB.SetMaxIdleConns(10)
 	sqlDB.SetMaxOpenConns(100)
+	if err := metrics.RegisterDBStats(sqlDB, cfg.DBName); err != nil {
+		fatal("failed to register database metrics", err)
+	}
+
+	// Wire up repositories, services and controllers
+	container, err := app.NewContainer(cfg, db)
+	if err != nil {
+		fatal("failed to initialize application", err)
+	}
+
+	// Route gin's debug output, such as the registered routes, through the logger
+	gin.DebugPrintFunc = func(format string, values ...any) {
+		slog.Debug(strings.TrimSpace(fmt.Sprintf(format, values...)))
+	}
+	if cfg.IsProduction() {
+		gin.SetMode(gin.ReleaseMode)
+	}
 
-	// Initialize Gin router
-	router := gin.Default()
+	// Request binding names fields by their JSON names and knows the custom validators
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		if err := validation.Register(v); err != nil {
+			fatal("failed to register validators", err)
+		}
+	}
 
-	// Add CORS middleware
-	router.Use(middleware.CORSMiddleware())
+	// Initialize Gin router; requests get an ID and a span first so that
+	// every later log line, including panics, carries them
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		fatal("failed to configure trusted proxies", err)
+	}
+	router.Use(
+		middleware.RequestIDMiddleware(),
+		middleware.TracingMiddleware(cfg.TracingServiceName),
+		middleware.LoggerMiddleware(),
+		middleware.MetricsMiddleware(),
+		middleware.RecoveryMiddleware(),
+	)
+
+	// Add security headers and CORS middleware
+	if cfg.SecurityHeadersEnabled {
+		router.Use(middleware.SecurityHeadersMiddleware(cfg))
+	}
+	router.Use(middleware.CORSMiddleware(cfg.DefaultCORSPolicy(), cfg.CORSOverrides))
 
 	// Set up routes
-	routes.SetupRoutes(router, cfg)
+	routes.SetupRoutes(router, container)
+
+	// SIGTERM or SIGINT starts a graceful shutdown; a second one exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	// Start the server
-	serverHost := cfg.ServerHost
-	if serverHost == "" {
-		serverHost = "0.0.0.0" // Default to all interfaces
+	// The metrics get their own router when they have their own listener
+	var metricsHandler http.Handler
+	if cfg.MetricsEnabled && cfg.MetricsAddress != "" {
+		metricsRouter := gin.New()
+		metricsRouter.Use(middleware.RecoveryMiddleware())
+		routes.SetupMetricsRoutes(metricsRouter, cfg)
+		metricsHandler = metricsRouter
+	}
+
+	srv, err := server.New(cfg, router, metricsHandler)
+	if err != nil {
+		fatal("failed to configure server", err)
 	}
-	serverAddress := serverHost + ":" + cfg.ServerPort
-	log.Printf("Server running on http://%s", serverAddress)
-	log.Fatal(router.Run(serverAddress))
+
+	container.StartWorkers()
+
+	// Start the server and block until it has shut down
+	serveErr := srv.Run(ctx, container.Readiness.Drain)
+	if serveErr != nil {
+		slog.Error("server error", "error", serveErr)
+	}
+
+	// Stop background workers and close the database pool
+	shutdownCtx := context.Background()
+	if cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, cfg.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := container.Shutdown(shutdownCtx); err != nil {
+		slog.Error("shutdown error", "error", err)
+	}
+
+	// Flush the spans of the last requests
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+
+	if serveErr != nil {
+		os.Exit(1)
+	}
+}
+
+// fatal logs an error that prevents the program from running and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// runHashBenchmark measures password hashing on this host and prints the
+// parameters that reach the target latency
+func runHashBenchmark(args []string) {
+	flags := flag.NewFlagSet("benchmark-hash", flag.ExitOnError)
+	target := flags.Duration("target", 250*time.Millisecond, "target time to hash one password")
+	memory := flags.Uint("memory", uint(security.DefaultArgon2idParams.Memory), "argon2id memory in KiB")
+	parallelism := flags.Uint("parallelism", uint(security.DefaultArgon2idParams.Parallelism), "argon2id parallelism")
+	flags.Parse(args)
+
+	params, argon2Elapsed := security.CalibrateArgon2id(*target, uint32(*memory), uint8(*parallelism))
+	bcryptCost, bcryptElapsed := security.CalibrateBcryptCost(*target)
+
+	fmt.Printf("Target: %s\n\n", *target)
+	fmt.Printf("argon2id (%s per hash):\n", argon2Elapsed.Round(time.Millisecond))
+	fmt.Printf("  PASSWORD_HASH_ALGORITHM=%s\n", security.AlgorithmArgon2id)
+	fmt.Printf("  ARGON2_MEMORY_KB=%d\n", params.Memory)
+	fmt.Printf("  ARGON2_ITERATIONS=%d\n", params.Iterations)
+	fmt.Printf("  ARGON2_PARALLELISM=%d\n\n", params.Parallelism)
+	fmt.Printf("bcrypt (%s per hash):\n", bcryptElapsed.Round(time.Millisecond))
+	fmt.Printf("  PASSWORD_HASH_ALGORITHM=%s\n", security.AlgorithmBcrypt)
+	fmt.Printf("  BCRYPT_COST=%d\n", bcryptCost)
+}
+
+// runConfig implements `config print [--redacted] [flags]`, which prints the
+// effective configuration as a YAML config file
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return fmt.Errorf("usage: config print [--redacted] [flags]")
+	}
+
+	redacted := false
+	var configArgs []string
+	for _, arg := range args[1:] {
+		if arg == "--redacted" || arg == "-redacted" {
+			redacted = true
+			continue
+		}
+		configArgs = append(configArgs, arg)
+	}
+
+	cfg, err := config.LoadConfig(configArgs)
+	if err != nil {
+		return err
+	}
+	return config.Print(os.Stdout, cfg, redacted)
+}
+
+// runMigrate implements `migrate up [N]`, `migrate down [N]` and `migrate status`
+func runMigrate(migrator *database.Migrator, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up [N] | down [N] | status")
+	}
+
+	steps := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid number of steps %q", args[1])
+		}
+		steps = n
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx, steps)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		return err
+
+	case "down":
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("Rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(rolledBack) == 0 {
+			fmt.Println("No applied migrations")
+		}
+		return err
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.ChecksumMismatch {
+				state += " (checksum mismatch)"
+			}
+			fmt.Printf("%04d_%-40s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown migrate command %q", args[0])
 }
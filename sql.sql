@@ -1,62 +0,0 @@
--- Users table for basic user authentication
-CREATE TABLE users (
-    id SERIAL PRIMARY KEY,
-    email VARCHAR(255) UNIQUE NOT NULL,
-    password_hash VARCHAR(255) NOT NULL,
-    first_name VARCHAR(100),
-    last_name VARCHAR(100),
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    is_active BOOLEAN DEFAULT true,
-    last_login TIMESTAMP WITH TIME ZONE
-);
-
--- User roles table (for user/admin distinction)
-CREATE TABLE user_roles (
-    id SERIAL PRIMARY KEY,
-    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    role_type VARCHAR(50) NOT NULL CHECK (role_type IN ('user', 'admin')),
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    UNIQUE (user_id, role_type)
-);
-
--- User pictures table (referencing Supabase storage)
-CREATE TABLE user_pictures (
-    id SERIAL PRIMARY KEY,
-    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    bucket_path VARCHAR(255) NOT NULL, -- Stores the Supabase bucket file path
-    file_name VARCHAR(255) NOT NULL,
-    file_type VARCHAR(50) NOT NULL,
-    file_size INTEGER NOT NULL,
-    is_profile_picture BOOLEAN DEFAULT false,
-    uploaded_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    status VARCHAR(50) DEFAULT 'active'
-);
-
--- Authentication logs table
-CREATE TABLE auth_logs (
-    id SERIAL PRIMARY KEY,
-    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
-    action VARCHAR(50) NOT NULL, -- 'login', 'logout', 'token_refresh', etc.
-    ip_address VARCHAR(45), -- Supports both IPv4 and IPv6
-    user_agent TEXT,
-    success BOOLEAN NOT NULL,
-    error_message TEXT,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-);
-
--- Token blacklist table (for JWT logout/invalidation)
-CREATE TABLE token_blacklist (
-    id SERIAL PRIMARY KEY,
-    token_jti VARCHAR(36) UNIQUE NOT NULL, -- JWT ID
-    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
-    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
-    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-);
-
--- Add indexes for better performance
-CREATE INDEX idx_users_email ON users(email);
-CREATE INDEX idx_user_roles_user_id ON user_roles(user_id);
-CREATE INDEX idx_user_pictures_user_id ON user_pictures(user_id);
-CREATE INDEX idx_auth_logs_user_id ON auth_logs(user_id);
-CREATE INDEX idx_token_blacklist_token_jti ON token_blacklist(token_jti);
\ No newline at end of file